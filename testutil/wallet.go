@@ -1,7 +1,9 @@
 package testutil
 
 import (
+	"context"
 	"fmt"
+	"iter"
 	"slices"
 	"sort"
 	"sync"
@@ -15,17 +17,79 @@ import (
 // primarily useful for testing or as a reference implementation.
 type (
 	EphemeralWalletStore struct {
-		mu     sync.Mutex
-		tip    types.ChainIndex
-		utxos  map[types.SiacoinOutputID]types.SiacoinElement
-		events []wallet.Event
+		mu      sync.Mutex
+		tip     types.ChainIndex
+		utxos   map[types.SiacoinOutputID]types.SiacoinElement
+		indices map[types.SiacoinOutputID]types.ChainIndex
+		events  []wallet.Event
+		refs    map[types.TransactionID]string
+		addr    *types.Address
 	}
 
 	ephemeralWalletUpdateTxn struct {
-		store *EphemeralWalletStore
+		store    *EphemeralWalletStore
+		onCommit []func()
+		// undo holds the functions that reverse each change made to the
+		// store, in the order the changes were made.
+		undo []func()
 	}
 )
 
+// setUTXO adds or replaces a siacoin element, recording how to undo the
+// change.
+func (et *ephemeralWalletUpdateTxn) setUTXO(se types.SiacoinElement) {
+	prev, ok := et.store.utxos[se.ID]
+	et.undo = append(et.undo, func() {
+		if ok {
+			et.store.utxos[se.ID] = prev
+		} else {
+			delete(et.store.utxos, se.ID)
+		}
+	})
+	et.store.utxos[se.ID] = se
+}
+
+// deleteUTXO removes a siacoin element and, if removeIndex is true, its
+// index, recording how to undo the change.
+func (et *ephemeralWalletUpdateTxn) deleteUTXO(id types.SiacoinOutputID, removeIndex bool) {
+	prev, ok := et.store.utxos[id]
+	prevIndex, indexed := et.store.indices[id]
+	et.undo = append(et.undo, func() {
+		if ok {
+			et.store.utxos[id] = prev
+		}
+		if indexed {
+			et.store.indices[id] = prevIndex
+		}
+	})
+	delete(et.store.utxos, id)
+	if removeIndex {
+		delete(et.store.indices, id)
+	}
+}
+
+// setIndex sets the index of a siacoin element, recording how to undo the
+// change.
+func (et *ephemeralWalletUpdateTxn) setIndex(id types.SiacoinOutputID, index types.ChainIndex) {
+	prev, ok := et.store.indices[id]
+	et.undo = append(et.undo, func() {
+		if ok {
+			et.store.indices[id] = prev
+		} else {
+			delete(et.store.indices, id)
+		}
+	})
+	et.store.indices[id] = index
+}
+
+// setEvents replaces the store's events, recording how to undo the change.
+// The previous slice must not be modified.
+func (et *ephemeralWalletUpdateTxn) setEvents(events []wallet.Event) {
+	prev := et.store.events
+	et.undo = append(et.undo, func() { et.store.events = prev })
+	et.store.events = events
+}
+
 func (et *ephemeralWalletUpdateTxn) WalletStateElements() (elements []types.StateElement, _ error) {
 	for _, se := range et.store.utxos {
 		elements = append(elements, se.StateElement.Copy())
@@ -33,13 +97,22 @@ func (et *ephemeralWalletUpdateTxn) WalletStateElements() (elements []types.Stat
 	return
 }
 
+// OnCommit adds fn to the set of functions called after the update is
+// applied.
+func (et *ephemeralWalletUpdateTxn) OnCommit(fn func()) {
+	et.onCommit = append(et.onCommit, fn)
+}
+
 // UpdateWalletSiacoinElementProofs updates the proofs of all state elements
 // affected by the update. ProofUpdater.UpdateElementProof must be called
 // for each state element in the database.
 func (et *ephemeralWalletUpdateTxn) UpdateWalletSiacoinElementProofs(pu wallet.ProofUpdater) error {
 	for _, se := range et.store.utxos {
+		// proofs are updated in place, so the element is copied to keep
+		// the previous proof intact if the update is rolled back
+		se = se.Copy()
 		pu.UpdateElementProof(&se.StateElement)
-		et.store.utxos[se.ID] = se.Move()
+		et.setUTXO(se.Move())
 	}
 	return nil
 }
@@ -49,51 +122,85 @@ func (et *ephemeralWalletUpdateTxn) WalletApplyIndex(index types.ChainIndex, cre
 		if _, ok := et.store.utxos[se.ID]; !ok {
 			panic(fmt.Sprintf("siacoin element %q does not exist", se.ID))
 		}
-		delete(et.store.utxos, se.ID)
+		// the element's index is kept so it can be restored if the block
+		// is reverted
+		et.deleteUTXO(se.ID, false)
 	}
 	// add siacoin elements
 	for _, se := range created {
 		if _, ok := et.store.utxos[se.ID]; ok {
 			panic("duplicate element")
 		}
-		et.store.utxos[se.ID] = se.Copy()
+		et.setUTXO(se.Copy())
+		et.setIndex(se.ID, index)
 	}
 
-	// add events
-	et.store.events = append(et.store.events, events...)
+	// add events; appending leaves the previous slice's elements unchanged
+	et.setEvents(append(et.store.events, events...))
 	et.store.tip = index
 	return nil
 }
 
 func (et *ephemeralWalletUpdateTxn) WalletRevertIndex(index types.ChainIndex, removed, unspent []types.SiacoinElement, _ time.Time) error {
 	// remove any events that were added in the reverted block
-	filtered := et.store.events[:0]
+	filtered := make([]wallet.Event, 0, len(et.store.events))
 	for i := range et.store.events {
 		if et.store.events[i].Index == index {
 			continue
 		}
 		filtered = append(filtered, et.store.events[i])
 	}
-	et.store.events = filtered
+	et.setEvents(filtered)
 
 	// remove any siacoin elements that were added in the reverted block
 	for _, se := range removed {
-		delete(et.store.utxos, se.ID)
+		et.deleteUTXO(se.ID, true)
 	}
 
 	// readd any siacoin elements that were spent in the reverted block
 	for _, se := range unspent {
-		et.store.utxos[se.ID] = se.Copy()
+		et.setUTXO(se.Copy())
 	}
 	et.store.tip = index
 	return nil
 }
 
-// UpdateChainState applies and reverts chain updates to the wallet.
+// UpdateChainState applies and reverts chain updates to the wallet. Each
+// change made by fn is journaled and undone if fn fails, so a failed update
+// leaves the store unchanged.
 func (es *EphemeralWalletStore) UpdateChainState(fn func(ux wallet.UpdateTx) error) error {
 	es.mu.Lock()
-	defer es.mu.Unlock()
-	return fn(&ephemeralWalletUpdateTxn{store: es})
+	prevTip := es.tip
+	tx := &ephemeralWalletUpdateTxn{store: es}
+	err := fn(tx)
+	if err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+		es.tip = prevTip
+	}
+	es.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, fn := range tx.onCommit {
+		fn()
+	}
+	return nil
+}
+
+// sortedEvents returns a copy of the wallet's events in display order. This
+// method must be called whilst holding the mutex lock.
+func (es *EphemeralWalletStore) sortedEvents() []wallet.Event {
+	// events are inserted in chronological order, reverse the slice to get the
+	// correct display order then sort by maturity height, so
+	// immature events are displayed first.
+	events := append([]wallet.Event(nil), es.events...)
+	slices.Reverse(events)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].MaturityHeight > events[j].MaturityHeight
+	})
+	return events
 }
 
 // WalletEvents returns the wallet's events.
@@ -108,15 +215,48 @@ func (es *EphemeralWalletStore) WalletEvents(offset, limit int) ([]wallet.Event,
 	} else if end > n {
 		end = n
 	}
-	// events are inserted in chronological order, reverse the slice to get the
-	// correct display order then sort by maturity height, so
-	// immature events are displayed first.
-	events := append([]wallet.Event(nil), es.events...)
-	slices.Reverse(events)
-	sort.SliceStable(events, func(i, j int) bool {
-		return events[i].MaturityHeight > events[j].MaturityHeight
-	})
-	return events[start:end], nil
+	return es.sortedEvents()[start:end], nil
+}
+
+// WalletEventsIter returns an iterator over the wallet's events. The
+// ephemeral store iterates over a snapshot of its events.
+func (es *EphemeralWalletStore) WalletEventsIter(ctx context.Context) (iter.Seq2[wallet.Event, error], error) {
+	es.mu.Lock()
+	events := es.sortedEvents()
+	es.mu.Unlock()
+
+	return func(yield func(wallet.Event, error) bool) {
+		for _, ev := range events {
+			if err := ctx.Err(); err != nil {
+				yield(wallet.Event{}, err)
+				return
+			} else if !yield(ev, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+// WalletEventsSince returns an iterator over the events confirmed at or
+// after height, in the order they were applied. The ephemeral store iterates
+// over a snapshot of those events.
+func (es *EphemeralWalletStore) WalletEventsSince(ctx context.Context, height uint64) (iter.Seq2[wallet.Event, error], error) {
+	es.mu.Lock()
+	// events are stored in chain order
+	i := sort.Search(len(es.events), func(i int) bool { return es.events[i].Index.Height >= height })
+	events := slices.Clone(es.events[i:])
+	es.mu.Unlock()
+
+	return func(yield func(wallet.Event, error) bool) {
+		for _, ev := range events {
+			if err := ctx.Err(); err != nil {
+				yield(wallet.Event{}, err)
+				return
+			} else if !yield(ev, nil) {
+				return
+			}
+		}
+	}, nil
 }
 
 // WalletEventCount returns the number of events relevant to the wallet.
@@ -137,6 +277,118 @@ func (es *EphemeralWalletStore) UnspentSiacoinElements() (utxos []types.SiacoinE
 	return utxos, nil
 }
 
+// RemoveWalletSiacoinElements removes the siacoin elements with the given IDs
+// from the store.
+func (es *EphemeralWalletStore) RemoveWalletSiacoinElements(ids []types.SiacoinOutputID) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	for _, id := range ids {
+		delete(es.utxos, id)
+		delete(es.indices, id)
+	}
+	return nil
+}
+
+// SetWalletSiacoinElementProofs replaces the state elements of the store's
+// unspent siacoin elements with those of elements.
+func (es *EphemeralWalletStore) SetWalletSiacoinElementProofs(basis types.ChainIndex, elements []types.SiacoinElement) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.tip != basis {
+		return fmt.Errorf("store tip %v does not match basis %v", es.tip, basis)
+	}
+
+	for _, se := range elements {
+		existing, ok := es.utxos[se.ID]
+		if !ok {
+			continue
+		}
+		existing.StateElement = se.StateElement.Copy()
+		es.utxos[se.ID] = existing
+	}
+	return nil
+}
+
+// WalletSiacoinElementIndex returns the index of the block that created the
+// unspent siacoin element with the given ID.
+func (es *EphemeralWalletStore) WalletSiacoinElementIndex(id types.SiacoinOutputID) (types.ChainIndex, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if _, ok := es.utxos[id]; !ok {
+		return types.ChainIndex{}, wallet.ErrNotFound
+	}
+	return es.indices[id], nil
+}
+
+// SetTransactionReference sets the reference of a transaction.
+func (es *EphemeralWalletStore) SetTransactionReference(id types.TransactionID, reference string) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.refs[id] = reference
+	return nil
+}
+
+// TransactionReference returns the reference of a transaction.
+func (es *EphemeralWalletStore) TransactionReference(id types.TransactionID) (string, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	ref, ok := es.refs[id]
+	if !ok {
+		return "", wallet.ErrNotFound
+	}
+	return ref, nil
+}
+
+// WalletBalance returns the totals of the store's unspent siacoin elements.
+func (es *EphemeralWalletStore) WalletBalance(height uint64, exclude []types.SiacoinOutputID) (bb wallet.BalanceBreakdown, _ error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for _, se := range es.utxos {
+		if se.MaturityHeight > height {
+			bb.Immature = bb.Immature.Add(se.SiacoinOutput.Value)
+			continue
+		}
+		bb.Confirmed = bb.Confirmed.Add(se.SiacoinOutput.Value)
+		if !slices.Contains(exclude, se.ID) {
+			bb.Spendable = bb.Spendable.Add(se.SiacoinOutput.Value)
+		}
+	}
+	bb.UTXOs = len(es.utxos)
+	return
+}
+
+// SpendableSiacoinElementCount returns the number of matured siacoin elements
+// that are not in exclude.
+func (es *EphemeralWalletStore) SpendableSiacoinElementCount(height uint64, exclude []types.SiacoinOutputID) (n int, _ error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for _, se := range es.utxos {
+		if se.MaturityHeight <= height && !slices.Contains(exclude, se.ID) {
+			n++
+		}
+	}
+	return
+}
+
+// WalletAddress returns the address recorded by the store.
+func (es *EphemeralWalletStore) WalletAddress() (types.Address, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.addr == nil {
+		return types.Address{}, wallet.ErrNotFound
+	}
+	return *es.addr, nil
+}
+
+// SetWalletAddress records the address of the wallet.
+func (es *EphemeralWalletStore) SetWalletAddress(addr types.Address) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.addr = &addr
+	return nil
+}
+
 // Tip returns the last indexed tip of the wallet.
 func (es *EphemeralWalletStore) Tip() (types.ChainIndex, error) {
 	es.mu.Lock()
@@ -147,6 +399,8 @@ func (es *EphemeralWalletStore) Tip() (types.ChainIndex, error) {
 // NewEphemeralWalletStore returns a new EphemeralWalletStore.
 func NewEphemeralWalletStore() *EphemeralWalletStore {
 	return &EphemeralWalletStore{
-		utxos: make(map[types.SiacoinOutputID]types.SiacoinElement),
+		utxos:   make(map[types.SiacoinOutputID]types.SiacoinElement),
+		indices: make(map[types.SiacoinOutputID]types.ChainIndex),
+		refs:    make(map[types.TransactionID]string),
 	}
 }
@@ -3,15 +3,46 @@ package wallet
 import (
 	"time"
 
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
 	"go.uber.org/zap"
+	"lukechampine.com/frand"
 )
 
 type (
 	config struct {
-		DefragThreshold     int
-		MaxInputsForDefrag  int
-		MaxDefragUTXOs      int
-		ReservationDuration time.Duration
+		DefragThreshold          int
+		MaxInputsForDefrag       int
+		MaxDefragUTXOs           int
+		ReservationDuration      time.Duration
+		MaxReservations          int
+		ChangePosition           ChangePosition
+		SelectionMode            SelectionMode
+		SignApprover             func(types.Transaction) error
+		SpendableChange          bool
+		CanonicalOutputs         bool
+		ReservationLogSize       int
+		ChainUpdateHook          func(reverted []chain.RevertUpdate, applied []chain.ApplyUpdate) error
+		BlockInterval            time.Duration
+		MaxOutputsPerTxn         int
+		StrictValidation         bool
+		MetricsRecorder          MetricsRecorder
+		ReservationSweepInterval time.Duration
+		StreamBufferSize         int
+		StreamDropOldest         bool
+		DustThreshold            types.Currency
+		AntiFragmentThreshold    int
+		LargeOutputReserve       int
+		LargeOutputMinValue      types.Currency
+		SkipAddressCheck         bool
+		RequireSynced            bool
+		MaxSyncLag               uint64
+		PoolReservations         bool
+		AdditionalKeys           []types.PrivateKey
+		MaxFeeMultiplier         float64
+		ConsolidationChangeRatio float64
+		Clock                    func() time.Time
+		RNG                      *frand.RNG
 
 		Log *zap.Logger
 	}
@@ -43,6 +74,19 @@ func WithMaxDefragUTXOs(n int) Option {
 	}
 }
 
+// WithMaxReservations sets the maximum number of outputs the wallet will
+// hold reservations for at once. Funding calls that would exceed the limit
+// return ErrTooManyReservations. A limit of zero disables the check.
+func WithMaxReservations(n int) Option {
+	if n < 0 {
+		panic("max reservations must not be negative") // developer error
+	}
+
+	return func(c *config) {
+		c.MaxReservations = n
+	}
+}
+
 // WithReservationDuration sets the duration that a reservation will be held
 // on spent utxos
 func WithReservationDuration(d time.Duration) Option {
@@ -55,9 +99,264 @@ func WithReservationDuration(d time.Duration) Option {
 	}
 }
 
+// WithChangePosition sets where the change output is placed when funding
+// transactions or redistributing outputs. Positions past the end of a
+// transaction's outputs place the change output last.
+func WithChangePosition(p ChangePosition) Option {
+	if p < ChangePositionLast {
+		panic("invalid change position") // developer error
+	}
+
+	return func(c *config) {
+		c.ChangePosition = p
+	}
+}
+
+// WithSelectionMode sets the order in which outputs are selected when funding
+// transactions.
+func WithSelectionMode(m SelectionMode) Option {
+	return func(c *config) {
+		c.SelectionMode = m
+	}
+}
+
+// WithAntiFragmentThreshold sets the number of spendable outputs above which
+// SelectionModeAntiFragment prefers the smallest outputs. The default is 20.
+func WithAntiFragmentThreshold(n int) Option {
+	return func(c *config) {
+		c.AntiFragmentThreshold = n
+	}
+}
+
+// WithReserveLargeOutputs keeps the count largest spendable outputs worth at
+// least minValue in reserve for large payments. Reserved outputs are only
+// selected when the remaining outputs cannot fund a transaction on their own,
+// so small payments do not fragment them.
+func WithReserveLargeOutputs(count int, minValue types.Currency) Option {
+	return func(c *config) {
+		c.LargeOutputReserve = count
+		c.LargeOutputMinValue = minValue
+	}
+}
+
+// WithSignApprover sets a function that is called with the transaction before
+// it is signed by SignTransaction. If the function returns an error, the
+// transaction is not signed and the error is returned to the caller. This can
+// be used to enforce policies such as spend limits or allowed destinations.
+// The approver only applies to v1 transactions: SignV2Inputs does not call
+// it, so callers signing v2 transactions must enforce their policies before
+// signing.
+func WithSignApprover(fn func(txn types.Transaction) error) Option {
+	return func(c *config) {
+		c.SignApprover = fn
+	}
+}
+
+// WithSpendableChange sets whether outputs paying the wallet from its own
+// unconfirmed transactions are counted as spendable by Balance instead of
+// unconfirmed. This keeps the spendable balance stable while a self-send or
+// change output is waiting to be confirmed. FundTransaction must still be
+// called with useUnconfirmed to spend them.
+func WithSpendableChange(enabled bool) Option {
+	return func(c *config) {
+		c.SpendableChange = enabled
+	}
+}
+
+// WithCanonicalOutputOrdering sets whether the outputs of funded transactions
+// are sorted into a canonical order after the change output is added. Outputs
+// are sorted by value, ascending, with ties broken by address, ascending.
+// When enabled, ChangePosition is ignored; the index of the change output is
+// reported by FundTransactionDetailed.
+func WithCanonicalOutputOrdering(enabled bool) Option {
+	return func(c *config) {
+		c.CanonicalOutputs = enabled
+	}
+}
+
+// WithReservationLogSize enables the reservation log, which records when
+// outputs are reserved, released, or expire. Only the n most recent events
+// are kept. The log is disabled by default.
+func WithReservationLogSize(n int) Option {
+	return func(c *config) {
+		c.ReservationLogSize = n
+	}
+}
+
+// WithChainUpdateHook sets a function that is called with every batch of chain
+// updates processed by UpdateChainState. If the store implements
+// CommitNotifier, the hook is called once the updates have been committed and
+// is not called if the commit fails; otherwise it is called immediately.
+// Errors returned by the hook are logged and do not affect the wallet's state.
+func WithChainUpdateHook(fn func(reverted []chain.RevertUpdate, applied []chain.ApplyUpdate) error) Option {
+	return func(c *config) {
+		c.ChainUpdateHook = fn
+	}
+}
+
+// WithBlockInterval sets the expected time between blocks used to estimate
+// when funds become spendable. It defaults to the network's target block
+// interval.
+func WithBlockInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.BlockInterval = d
+	}
+}
+
+// WithMaxOutputsPerTransaction sets the maximum number of recipient outputs
+// PayBatch places in a single transaction, keeping batched payments well
+// under the block weight limit. Pay returns an error if it is given more
+// recipients. A value of zero, the default, disables the limit.
+func WithMaxOutputsPerTransaction(n int) Option {
+	return func(c *config) {
+		c.MaxOutputsPerTxn = n
+	}
+}
+
+// WithStrictValidation sets whether the wallet panics when it detects a
+// violated internal invariant, such as a zero-value change output, instead of
+// returning an error. It is intended for tests, where a panic makes the bug
+// impossible to miss.
+func WithStrictValidation(enabled bool) Option {
+	return func(c *config) {
+		c.StrictValidation = enabled
+	}
+}
+
+// WithMetricsRecorder sets a recorder that receives the duration of every
+// call the wallet makes to its store.
+func WithMetricsRecorder(r MetricsRecorder) Option {
+	return func(c *config) {
+		c.MetricsRecorder = r
+	}
+}
+
+// WithReservationSweepInterval sets how often expired reservations are
+// removed in the background. By default, expired reservations are only removed
+// when the output is next considered for funding.
+func WithReservationSweepInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.ReservationSweepInterval = d
+	}
+}
+
+// WithTransactionStreamBuffer sets the buffer size of channels returned by
+// TransactionStream and what happens when a buffer is full. If dropOldest is
+// true, the oldest buffered event is dropped; otherwise the stream is closed.
+// The wallet never waits for a stream to be read. The default is a buffer of
+// 100 events that closes the stream when full.
+func WithTransactionStreamBuffer(size int, dropOldest bool) Option {
+	return func(c *config) {
+		c.StreamBufferSize = size
+		c.StreamDropOldest = dropOldest
+	}
+}
+
+// WithDustThreshold sets the value below which an output is considered dust.
+// The threshold is zero by default, so no output is considered dust unless it
+// is set.
+func WithDustThreshold(threshold types.Currency) Option {
+	return func(c *config) {
+		c.DustThreshold = threshold
+	}
+}
+
+// WithClock sets the function used by the wallet to get the current time
+// when reserving outputs and checking whether reservations have expired. It
+// defaults to time.Now.
+func WithClock(fn func() time.Time) Option {
+	if fn == nil {
+		panic("clock must not be nil") // developer error
+	}
+
+	return func(c *config) {
+		c.Clock = fn
+	}
+}
+
+// WithRNG sets the source of randomness used by the wallet, such as when
+// selecting outputs with SelectionModePrivacy. It is primarily useful for
+// making selection reproducible in tests.
+func WithRNG(rng *frand.RNG) Option {
+	return func(c *config) {
+		c.RNG = rng
+	}
+}
+
 // WithLogger sets the logger for the wallet
 func WithLogger(l *zap.Logger) Option {
 	return func(c *config) {
 		c.Log = l
 	}
 }
+
+// WithAddressCheck sets whether the wallet checks that its address matches
+// the address recorded by the store when it is created. If the check is
+// enabled and the store implements AddressStore, NewSingleAddressWallet
+// returns ErrDifferentSeed on a mismatch. The check is enabled by default.
+func WithAddressCheck(enabled bool) Option {
+	return func(c *config) {
+		c.SkipAddressCheck = !enabled
+	}
+}
+
+// WithRequireSynced makes the wallet refuse to fund transactions when its
+// store's tip is more than maxLag blocks behind the chain manager's tip, or
+// is not on the chain manager's best chain, returning ErrNotSynced instead.
+// Outputs selected against a stale store may already be spent. By default,
+// the wallet funds transactions regardless of the store's tip.
+func WithRequireSynced(maxLag uint64) Option {
+	return func(c *config) {
+		c.RequireSynced = true
+		c.MaxSyncLag = maxLag
+	}
+}
+
+// WithPoolReservations enables tracking of reserved outputs spent by
+// transactions in the pool. Once a funded transaction enters the pool, its
+// inputs stop expiring; if the transaction later leaves the pool without
+// being confirmed, they are released immediately. It is disabled by default.
+// If enabled, NewSingleAddressWallet returns an error unless the chain
+// manager implements PoolNotifier.
+func WithPoolReservations(enabled bool) Option {
+	return func(c *config) {
+		c.PoolReservations = enabled
+	}
+}
+
+// WithAdditionalKeys adds keys whose standard addresses the wallet also
+// spends from, such as the previous key during a key rotation. Outputs sent to
+// these addresses are tracked and used to fund transactions, and each input is
+// signed with the key matching its address. Change is always sent to the
+// wallet's primary address.
+func WithAdditionalKeys(keys ...types.PrivateKey) Option {
+	return func(c *config) {
+		c.AdditionalKeys = append(c.AdditionalKeys, keys...)
+	}
+}
+
+// WithConsolidationAdvice adds FundWarningSuggestConsolidation to the
+// funding result when the change output is worth more than ratio times the
+// amount funded. The warning is advisory and does not change funding. It is
+// disabled by default.
+func WithConsolidationAdvice(ratio float64) Option {
+	if !(ratio > 0) {
+		panic("consolidation change ratio must be positive") // developer error
+	}
+
+	return func(c *config) {
+		c.ConsolidationChangeRatio = ratio
+	}
+}
+
+// WithMaxFeeMultiplier sets the largest multiplier of the recommended fee
+// accepted by SendWithPriority. The default is 10.
+func WithMaxFeeMultiplier(m float64) Option {
+	if !(m >= 1) {
+		panic("max fee multiplier must be at least 1") // developer error
+	}
+
+	return func(c *config) {
+		c.MaxFeeMultiplier = m
+	}
+}
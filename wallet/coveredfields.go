@@ -0,0 +1,101 @@
+package wallet
+
+import (
+	"fmt"
+	"slices"
+
+	"go.sia.tech/core/types"
+)
+
+// A CoveredFieldsBuilder constructs the CoveredFields of a partial v1
+// transaction signature.
+//
+// A partial signature only commits to the fields it covers. Any field that is
+// not covered can be changed, added or removed after signing without
+// invalidating the signature. For example, if the miner fees are not covered,
+// anyone holding the transaction can raise the fee at the expense of the
+// change output, and if an output is not covered its address can be
+// replaced. Callers should cover every field they rely on and only leave
+// fields uncovered that other parties are expected to add or modify.
+type CoveredFieldsBuilder struct {
+	txn types.Transaction
+	cf  types.CoveredFields
+	err error
+}
+
+// NewCoveredFields returns a builder for the CoveredFields of a signature on
+// txn. Initially no fields are covered.
+func NewCoveredFields(txn types.Transaction) *CoveredFieldsBuilder {
+	return &CoveredFieldsBuilder{txn: txn}
+}
+
+// CoverAllInputs covers all of the transaction's siacoin and siafund inputs.
+func (b *CoveredFieldsBuilder) CoverAllInputs() *CoveredFieldsBuilder {
+	b.cf.SiacoinInputs = appendRange(b.cf.SiacoinInputs, len(b.txn.SiacoinInputs))
+	b.cf.SiafundInputs = appendRange(b.cf.SiafundInputs, len(b.txn.SiafundInputs))
+	return b
+}
+
+// CoverOutputs covers the siacoin outputs at the given indices.
+func (b *CoveredFieldsBuilder) CoverOutputs(indices ...int) *CoveredFieldsBuilder {
+	for _, i := range indices {
+		if i < 0 || i >= len(b.txn.SiacoinOutputs) {
+			b.setErr(fmt.Errorf("siacoin output index %d out of range", i))
+			continue
+		}
+		b.cf.SiacoinOutputs = append(b.cf.SiacoinOutputs, uint64(i))
+	}
+	return b
+}
+
+// CoverAllOutputs covers all of the transaction's siacoin and siafund outputs.
+func (b *CoveredFieldsBuilder) CoverAllOutputs() *CoveredFieldsBuilder {
+	b.cf.SiacoinOutputs = appendRange(b.cf.SiacoinOutputs, len(b.txn.SiacoinOutputs))
+	b.cf.SiafundOutputs = appendRange(b.cf.SiafundOutputs, len(b.txn.SiafundOutputs))
+	return b
+}
+
+// CoverMinerFees covers all of the transaction's miner fees.
+func (b *CoveredFieldsBuilder) CoverMinerFees() *CoveredFieldsBuilder {
+	b.cf.MinerFees = appendRange(b.cf.MinerFees, len(b.txn.MinerFees))
+	return b
+}
+
+// CoverAllSignatures covers all of the signatures already present in the
+// transaction.
+func (b *CoveredFieldsBuilder) CoverAllSignatures() *CoveredFieldsBuilder {
+	b.cf.Signatures = appendRange(b.cf.Signatures, len(b.txn.Signatures))
+	return b
+}
+
+// Build returns the CoveredFields. The indices of each field are sorted and
+// deduplicated. An error is returned if any index was out of range.
+func (b *CoveredFieldsBuilder) Build() (types.CoveredFields, error) {
+	if b.err != nil {
+		return types.CoveredFields{}, b.err
+	}
+	cf := b.cf
+	for _, s := range []*[]uint64{
+		&cf.SiacoinInputs, &cf.SiacoinOutputs, &cf.FileContracts,
+		&cf.FileContractRevisions, &cf.StorageProofs, &cf.SiafundInputs,
+		&cf.SiafundOutputs, &cf.MinerFees, &cf.ArbitraryData, &cf.Signatures,
+	} {
+		slices.Sort(*s)
+		*s = slices.Compact(*s)
+	}
+	return cf, nil
+}
+
+func (b *CoveredFieldsBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// appendRange appends the indices [0, n) to s.
+func appendRange(s []uint64, n int) []uint64 {
+	for i := 0; i < n; i++ {
+		s = append(s, uint64(i))
+	}
+	return s
+}
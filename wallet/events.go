@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -23,6 +24,19 @@ const (
 	EventTypeV2ContractResolution = "v2ContractResolution"
 )
 
+// Versions of the versioned binary encoding of an Event. Each version is a
+// superset of the previous one, and decoders accept every version up to
+// the latest.
+const (
+	// EventEncodingV1 is the event's binary encoding, as written by
+	// Event.EncodeTo.
+	EventEncodingV1 = 1
+	// EventEncodingV2 extends EventEncodingV1 with the event's reference.
+	EventEncodingV2 = 2
+
+	eventEncodingLatest = EventEncodingV2
+)
+
 type (
 	// An EventPayout represents a miner payout, siafund claim, or foundation
 	// subsidy.
@@ -75,6 +89,18 @@ type (
 		MaturityHeight uint64           `json:"maturityHeight"`
 		Timestamp      time.Time        `json:"timestamp"`
 		Relevant       []types.Address  `json:"relevant,omitempty"`
+
+		// SelfTransfer is true if the event is a transaction whose siacoin
+		// and siafund inputs and outputs all belong to addresses the wallet
+		// can spend from, such as a consolidation. Watched addresses do not
+		// count. It is populated by the wallet and is not part of the event's
+		// binary encoding.
+		SelfTransfer bool `json:"selfTransfer,omitempty"`
+
+		// Reference is the client-supplied reference of the event's
+		// transaction, if any. It is populated by the wallet and is not
+		// part of the event's binary encoding.
+		Reference string `json:"reference,omitempty"`
 	}
 )
 
@@ -84,6 +110,71 @@ func (EventV1ContractResolution) isEvent() bool { return true }
 func (EventV2Transaction) isEvent() bool        { return true }
 func (EventV2ContractResolution) isEvent() bool { return true }
 
+// isSelfTransfer returns true if the event is a transaction that only moves
+// funds between addresses for which owned returns true.
+func (e *Event) isSelfTransfer(owned func(types.Address) bool) bool {
+	relevant := make(map[types.Address]bool)
+	for _, addr := range e.Relevant {
+		relevant[addr] = owned(addr)
+	}
+
+	switch data := e.Data.(type) {
+	case EventV1Transaction:
+		txn := data.Transaction
+		if len(txn.SiacoinInputs) == 0 || len(txn.FileContracts) != 0 {
+			return false
+		}
+		for _, sci := range txn.SiacoinInputs {
+			if !relevant[sci.UnlockConditions.UnlockHash()] {
+				return false
+			}
+		}
+		for _, sfi := range txn.SiafundInputs {
+			if !relevant[sfi.UnlockConditions.UnlockHash()] {
+				return false
+			}
+		}
+		for _, sco := range txn.SiacoinOutputs {
+			if !relevant[sco.Address] {
+				return false
+			}
+		}
+		for _, sfo := range txn.SiafundOutputs {
+			if !relevant[sfo.Address] {
+				return false
+			}
+		}
+		return true
+	case EventV2Transaction:
+		if len(data.SiacoinInputs) == 0 || len(data.FileContracts) != 0 {
+			return false
+		}
+		for _, sci := range data.SiacoinInputs {
+			if !relevant[sci.Parent.SiacoinOutput.Address] {
+				return false
+			}
+		}
+		for _, sfi := range data.SiafundInputs {
+			if !relevant[sfi.Parent.SiafundOutput.Address] {
+				return false
+			}
+		}
+		for _, sco := range data.SiacoinOutputs {
+			if !relevant[sco.Address] {
+				return false
+			}
+		}
+		for _, sfo := range data.SiafundOutputs {
+			if !relevant[sfo.Address] {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
 // SiacoinOutflow calculates the sum of Siacoins that were spent by relevant
 // addresses
 func (e *Event) SiacoinOutflow() types.Currency {
@@ -227,6 +318,37 @@ func (e *Event) SiafundInflow() uint64 {
 	}
 }
 
+// MarshalJSON implements the json.Marshaler interface. The JSON encoding of
+// an event is stable: existing fields are never renamed, removed or change
+// type, and fields added to Event are omitted until they are explicitly
+// added here. Currency values within the event's data are encoded as
+// decimal strings of hastings.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ID             types.Hash256    `json:"id"`
+		Index          types.ChainIndex `json:"index"`
+		Confirmations  uint64           `json:"confirmations"`
+		Type           string           `json:"type"`
+		Data           EventData        `json:"data"`
+		MaturityHeight uint64           `json:"maturityHeight"`
+		Timestamp      time.Time        `json:"timestamp"`
+		Relevant       []types.Address  `json:"relevant,omitempty"`
+		SelfTransfer   bool             `json:"selfTransfer,omitempty"`
+		Reference      string           `json:"reference,omitempty"`
+	}{
+		ID:             e.ID,
+		Index:          e.Index,
+		Confirmations:  e.Confirmations,
+		Type:           e.Type,
+		Data:           e.Data,
+		MaturityHeight: e.MaturityHeight,
+		Timestamp:      e.Timestamp,
+		Relevant:       e.Relevant,
+		SelfTransfer:   e.SelfTransfer,
+		Reference:      e.Reference,
+	})
+}
+
 // UnmarshalJSON implements the json.Unmarshaler interface.
 func (e *Event) UnmarshalJSON(b []byte) error {
 	var je struct {
@@ -238,6 +360,8 @@ func (e *Event) UnmarshalJSON(b []byte) error {
 		Type           string           `json:"type"`
 		Data           json.RawMessage  `json:"data"`
 		Relevant       []types.Address  `json:"relevant,omitempty"`
+		SelfTransfer   bool             `json:"selfTransfer,omitempty"`
+		Reference      string           `json:"reference,omitempty"`
 	}
 	if err := json.Unmarshal(b, &je); err != nil {
 		return err
@@ -250,6 +374,8 @@ func (e *Event) UnmarshalJSON(b []byte) error {
 	e.MaturityHeight = je.MaturityHeight
 	e.Type = je.Type
 	e.Relevant = je.Relevant
+	e.SelfTransfer = je.SelfTransfer
+	e.Reference = je.Reference
 
 	var err error
 	switch je.Type {
@@ -401,3 +527,39 @@ func (ev *Event) DecodeFrom(d *types.Decoder) {
 	ev.Timestamp = d.ReadTime()
 	types.DecodeSlice(d, &ev.Relevant)
 }
+
+// MarshalBinaryVersioned returns the event's binary encoding prefixed with a
+// version byte. The latest version is always written.
+func (ev *Event) MarshalBinaryVersioned() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(eventEncodingLatest)
+	e := types.NewEncoder(&buf)
+	ev.EncodeTo(e)
+	e.WriteString(ev.Reference)
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinaryVersioned decodes an event written by
+// MarshalBinaryVersioned. Fields that are not part of the encoded version
+// are left empty. An error is returned for versions newer than the latest
+// known version.
+func (ev *Event) UnmarshalBinaryVersioned(b []byte) error {
+	if len(b) == 0 {
+		return fmt.Errorf("missing event encoding version")
+	}
+	version := b[0]
+	if version < EventEncodingV1 || version > eventEncodingLatest {
+		return fmt.Errorf("unsupported event encoding version %d", version)
+	}
+
+	*ev = Event{}
+	d := types.NewBufDecoder(b[1:])
+	ev.DecodeFrom(d)
+	if version >= EventEncodingV2 {
+		ev.Reference = d.ReadString()
+	}
+	return d.Err()
+}
@@ -49,3 +49,107 @@ func TestEventsJSONRoundTrip(t *testing.T) {
 		t.Fatal("round-trip failed")
 	}
 }
+
+func TestEventBinaryVersioned(t *testing.T) {
+	ev := Event{
+		ID:   frand.Entropy256(),
+		Type: EventTypeMinerPayout,
+		Data: EventPayout{
+			SiacoinElement: types.SiacoinElement{
+				ID:            frand.Entropy256(),
+				SiacoinOutput: types.SiacoinOutput{Address: frand.Entropy256(), Value: types.Siacoins(100)},
+			},
+		},
+		Index:          types.ChainIndex{ID: frand.Entropy256(), Height: 10},
+		Relevant:       []types.Address{frand.Entropy256()},
+		MaturityHeight: 154,
+		Timestamp:      time.Unix(int64(frand.Intn(math.MaxInt32)), 0),
+		Reference:      "invoice-1",
+	}
+
+	// the latest version round-trips every encoded field
+	b, err := ev.MarshalBinaryVersioned()
+	if err != nil {
+		t.Fatal(err)
+	} else if b[0] != EventEncodingV2 {
+		t.Fatalf("expected version %v, got %v", EventEncodingV2, b[0])
+	}
+	var decoded Event
+	if err := decoded.UnmarshalBinaryVersioned(b); err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := json.Marshal(ev)
+	got, _ := json.Marshal(decoded)
+	if !bytes.Equal(expected, got) {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+
+	// a v1 encoding decodes without the reference
+	var buf bytes.Buffer
+	buf.WriteByte(EventEncodingV1)
+	e := types.NewEncoder(&buf)
+	ev.EncodeTo(e)
+	e.Flush()
+	decoded = Event{Reference: "stale"}
+	if err := decoded.UnmarshalBinaryVersioned(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	v1 := ev
+	v1.Reference = ""
+	expected, _ = json.Marshal(v1)
+	got, _ = json.Marshal(decoded)
+	if !bytes.Equal(expected, got) {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+
+	// unknown versions are rejected
+	b[0] = EventEncodingV2 + 1
+	if err := decoded.UnmarshalBinaryVersioned(b); err == nil {
+		t.Fatal("expected error for unknown version")
+	} else if err := decoded.UnmarshalBinaryVersioned(nil); err == nil {
+		t.Fatal("expected error for empty encoding")
+	}
+}
+
+func TestEventJSONStable(t *testing.T) {
+	ev := Event{
+		Type: EventTypeMinerPayout,
+		Data: EventPayout{
+			SiacoinElement: types.SiacoinElement{
+				SiacoinOutput: types.SiacoinOutput{Value: types.Siacoins(1)},
+			},
+		},
+		Timestamp: time.Unix(0, 0).UTC(),
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"id", "index", "confirmations", "type", "data", "maturityHeight", "timestamp"} {
+		if _, ok := fields[key]; !ok {
+			t.Fatalf("expected field %q in %s", key, b)
+		}
+	}
+	if len(fields) != 7 {
+		t.Fatalf("expected 7 fields, got %s", b)
+	}
+
+	// currency values are encoded as strings of hastings
+	var data struct {
+		SiacoinElement struct {
+			SiacoinOutput struct {
+				Value json.RawMessage `json:"value"`
+			} `json:"siacoinOutput"`
+		} `json:"siacoinElement"`
+	}
+	if err := json.Unmarshal(fields["data"], &data); err != nil {
+		t.Fatal(err)
+	} else if value := string(data.SiacoinElement.SiacoinOutput.Value); value != `"1000000000000000000000000"` {
+		t.Fatalf("expected hastings string, got %v", value)
+	}
+}
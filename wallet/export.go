@@ -0,0 +1,59 @@
+package wallet
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// csvHeader is the header row written by ExportCSV.
+var csvHeader = []string{"timestamp", "id", "type", "height", "inflow", "outflow", "fee"}
+
+// ExportCSV writes the wallet's events to w as CSV, newest first, preceded by
+// a header row. Each row contains the event's timestamp in RFC 3339 format
+// (UTC), ID, type, confirmation height, siacoin inflow and outflow, and the
+// miner fee paid by the wallet, if any. Currency values are written in
+// hastings, so they are exact and independent of any display unit.
+//
+// The events are streamed from the store, so the history is never held in
+// memory at once.
+func (sw *SingleAddressWallet) ExportCSV(ctx context.Context, w io.Writer) error {
+	events, err := sw.eventsIter(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get events: %w", err)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	for ev, err := range events {
+		if err != nil {
+			return err
+		}
+
+		fee := types.ZeroCurrency
+		if fp, ok := EventFee(ev); ok {
+			fee = fp.Fee
+		}
+		record := []string{
+			ev.Timestamp.UTC().Format(time.RFC3339),
+			ev.ID.String(),
+			ev.Type,
+			strconv.FormatUint(ev.Index.Height, 10),
+			ev.SiacoinInflow().ExactString(),
+			ev.SiacoinOutflow().ExactString(),
+			fee.ExactString(),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write event %v: %w", ev.ID, err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,94 @@
+package wallet
+
+import (
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// Names of the store calls reported to a MetricsRecorder.
+const (
+	StoreCallTip                    = "Tip"
+	StoreCallUnspentSiacoinElements = "UnspentSiacoinElements"
+	StoreCallWalletEvents           = "WalletEvents"
+	StoreCallWalletEventCount       = "WalletEventCount"
+	StoreCallWalletBalance          = "WalletBalance"
+)
+
+// A MetricsRecorder receives instrumentation from the wallet.
+type MetricsRecorder interface {
+	// RecordStoreCall is called after each call the wallet makes to its
+	// store with the name of the method and how long the call took.
+	RecordStoreCall(method string, d time.Duration)
+}
+
+// A BalanceRecorder is a MetricsRecorder that also receives the wallet's
+// balance after each chain update. The balance is only recorded for stores
+// whose UpdateTx implements CommitNotifier; otherwise the update may still be
+// rolled back, and no balance is recorded.
+type BalanceRecorder interface {
+	MetricsRecorder
+	// RecordBalance is called with the wallet's balance at the height of
+	// each committed chain update.
+	RecordBalance(b BalanceBreakdown)
+}
+
+// recordStoreCall reports the duration of a store call started at start. It
+// must only be called when a recorder is configured.
+func (sw *SingleAddressWallet) recordStoreCall(method string, start time.Time) {
+	sw.cfg.MetricsRecorder.RecordStoreCall(method, time.Since(start))
+}
+
+// storeTip returns the store's tip, recording the call's duration if a
+// metrics recorder is configured.
+func (sw *SingleAddressWallet) storeTip() (types.ChainIndex, error) {
+	if sw.cfg.MetricsRecorder == nil {
+		return sw.store.Tip()
+	}
+	defer sw.recordStoreCall(StoreCallTip, time.Now())
+	return sw.store.Tip()
+}
+
+// unspentSiacoinElements returns the store's unspent siacoin elements,
+// recording the call's duration if a metrics recorder is configured.
+func (sw *SingleAddressWallet) unspentSiacoinElements() ([]types.SiacoinElement, error) {
+	if sw.cfg.MetricsRecorder == nil {
+		return sw.store.UnspentSiacoinElements()
+	}
+	defer sw.recordStoreCall(StoreCallUnspentSiacoinElements, time.Now())
+	return sw.store.UnspentSiacoinElements()
+}
+
+// walletEvents returns a page of the store's events, recording the call's
+// duration if a metrics recorder is configured. Since stores do not persist
+// it, each event's SelfTransfer flag is derived again.
+func (sw *SingleAddressWallet) walletEvents(offset, limit int) (events []Event, err error) {
+	if sw.cfg.MetricsRecorder != nil {
+		defer sw.recordStoreCall(StoreCallWalletEvents, time.Now())
+	}
+	events, err = sw.store.WalletEvents(offset, limit)
+	for i := range events {
+		events[i].SelfTransfer = events[i].isSelfTransfer(sw.canSpend)
+	}
+	return events, err
+}
+
+// walletEventCount returns the store's event count, recording the call's
+// duration if a metrics recorder is configured.
+func (sw *SingleAddressWallet) walletEventCount() (uint64, error) {
+	if sw.cfg.MetricsRecorder == nil {
+		return sw.store.WalletEventCount()
+	}
+	defer sw.recordStoreCall(StoreCallWalletEventCount, time.Now())
+	return sw.store.WalletEventCount()
+}
+
+// walletBalance returns the store's balance totals, recording the call's
+// duration if a metrics recorder is configured.
+func (sw *SingleAddressWallet) walletBalance(bs BalanceStore, height uint64, exclude []types.SiacoinOutputID) (BalanceBreakdown, error) {
+	if sw.cfg.MetricsRecorder == nil {
+		return bs.WalletBalance(height, exclude)
+	}
+	defer sw.recordStoreCall(StoreCallWalletBalance, time.Now())
+	return bs.WalletBalance(height, exclude)
+}
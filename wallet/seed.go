@@ -43,6 +43,23 @@ func SeedFromPhrase(seed *[32]byte, phrase string) error {
 	return nil
 }
 
+// KeyFromPhrase returns the first Ed25519 key derived from the supplied
+// phrase. It is equivalent to calling SeedFromPhrase followed by KeyFromSeed
+// with index 0.
+//
+// NOTE: keys are derived from the phrase by hashing, so the phrase cannot be
+// recovered from a key. Applications offering seed backups must retain the
+// phrase itself.
+func KeyFromPhrase(phrase string) (types.PrivateKey, error) {
+	var seed [32]byte
+	if err := SeedFromPhrase(&seed, phrase); err != nil {
+		return nil, err
+	}
+	key := KeyFromSeed(&seed, 0)
+	memclr(seed[:])
+	return key, nil
+}
+
 // KeyFromSeed returns the Ed25519 key derived from the supplied seed and index.
 func KeyFromSeed(seed *[32]byte, index uint64) types.PrivateKey {
 	buf := make([]byte, 32+8)
@@ -85,7 +102,7 @@ func decodeBIP39Phrase(entropy *[16]byte, phrase string) error {
 	// are present in the word list
 	words := strings.Fields(phrase)
 	if n := len(words); n != 12 {
-		return errors.New("wrong number of words in seed phrase")
+		return fmt.Errorf("wrong number of words in seed phrase: expected 12, got %d", n)
 	}
 	for _, word := range words {
 		if _, ok := wordMap[word]; !ok {
@@ -112,7 +129,7 @@ func decodeBIP39Phrase(entropy *[16]byte, phrase string) error {
 
 	// validate checksum
 	if bip39checksum(entropy) != checksum {
-		return errors.New("invalid checksum")
+		return errors.New("invalid seed phrase checksum")
 	}
 	return nil
 }
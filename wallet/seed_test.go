@@ -0,0 +1,98 @@
+package wallet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBIP39Vectors(t *testing.T) {
+	// test vectors from the BIP39 specification
+	tests := []struct {
+		entropy [16]byte
+		phrase  string
+	}{
+		{
+			[16]byte{},
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		},
+		{
+			[16]byte{0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f},
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		},
+		{
+			[16]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80},
+			"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		},
+		{
+			[16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		},
+	}
+
+	for _, test := range tests {
+		if phrase := encodeBIP39Phrase(&test.entropy); phrase != test.phrase {
+			t.Fatalf("expected phrase %q, got %q", test.phrase, phrase)
+		}
+
+		var entropy [16]byte
+		if err := decodeBIP39Phrase(&entropy, test.phrase); err != nil {
+			t.Fatal(err)
+		} else if entropy != test.entropy {
+			t.Fatalf("expected entropy %x, got %x", test.entropy, entropy)
+		}
+	}
+}
+
+func TestKeyFromPhrase(t *testing.T) {
+	tests := []struct {
+		phrase    string
+		publicKey string
+	}{
+		{
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"ed25519:c3064a3568fc5a38edcd37231f5e1fc016942e74d6ad63570e566c1e6c02c224",
+		},
+		{
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"ed25519:236b8374a981bffd9efd48cfbb0609fed1424110bfdf5b72371121602cc38217",
+		},
+	}
+
+	for _, test := range tests {
+		key, err := KeyFromPhrase(test.phrase)
+		if err != nil {
+			t.Fatal(err)
+		} else if pk := key.PublicKey().String(); pk != test.publicKey {
+			t.Fatalf("expected public key %q, got %q", test.publicKey, pk)
+		}
+
+		// the key should match the manual derivation
+		var seed [32]byte
+		if err := SeedFromPhrase(&seed, test.phrase); err != nil {
+			t.Fatal(err)
+		} else if KeyFromSeed(&seed, 0).PublicKey() != key.PublicKey() {
+			t.Fatal("expected KeyFromPhrase to match KeyFromSeed")
+		}
+	}
+
+	// random phrases should round-trip
+	phrase := NewSeedPhrase()
+	if _, err := KeyFromPhrase(phrase); err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid phrases should be rejected
+	invalid := []struct {
+		phrase string
+		err    string
+	}{
+		{"abandon abandon abandon", "wrong number of words"},
+		{"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", "checksum"},
+		{"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon siacoin", "unrecognized word"},
+	}
+	for _, test := range invalid {
+		if _, err := KeyFromPhrase(test.phrase); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("expected error containing %q, got %v", test.err, err)
+		}
+	}
+}
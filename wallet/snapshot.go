@@ -0,0 +1,226 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+)
+
+type (
+	// snapshotStore is a SingleAddressStore backed by an exported UTXO
+	// snapshot. It is read-only.
+	snapshotStore struct {
+		tip      types.ChainIndex
+		elements []types.SiacoinElement
+	}
+
+	// snapshotChain is a ChainManager that reports the state of an exported
+	// UTXO snapshot and an empty transaction pool.
+	snapshotChain struct {
+		state consensus.State
+	}
+)
+
+// Tip implements SingleAddressStore.
+func (ss *snapshotStore) Tip() (types.ChainIndex, error) {
+	return ss.tip, nil
+}
+
+// UnspentSiacoinElements implements SingleAddressStore.
+func (ss *snapshotStore) UnspentSiacoinElements() ([]types.SiacoinElement, error) {
+	elements := make([]types.SiacoinElement, 0, len(ss.elements))
+	for _, sce := range ss.elements {
+		elements = append(elements, sce.Copy())
+	}
+	return elements, nil
+}
+
+// WalletEvents implements SingleAddressStore. Snapshots do not contain
+// events.
+func (ss *snapshotStore) WalletEvents(offset, limit int) ([]Event, error) {
+	return nil, nil
+}
+
+// WalletEventCount implements SingleAddressStore.
+func (ss *snapshotStore) WalletEventCount() (uint64, error) {
+	return 0, nil
+}
+
+// TipState implements ChainManager.
+func (sc *snapshotChain) TipState() consensus.State {
+	return sc.state
+}
+
+// BestIndex implements ChainManager. Only the snapshot's index is known.
+func (sc *snapshotChain) BestIndex(height uint64) (types.ChainIndex, bool) {
+	if height != sc.state.Index.Height {
+		return types.ChainIndex{}, false
+	}
+	return sc.state.Index, true
+}
+
+// PoolTransactions implements ChainManager.
+func (sc *snapshotChain) PoolTransactions() []types.Transaction { return nil }
+
+// V2PoolTransactions implements ChainManager.
+func (sc *snapshotChain) V2PoolTransactions() []types.V2Transaction { return nil }
+
+// OnReorg implements ChainManager. The snapshot's state never changes.
+func (sc *snapshotChain) OnReorg(func(types.ChainIndex)) func() { return func() {} }
+
+// UpdatesSince implements UpdateProvider. A snapshot has no updates.
+func (sc *snapshotChain) UpdatesSince(index types.ChainIndex, maxBlocks int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error) {
+	if index != sc.state.Index {
+		return nil, nil, errors.New("snapshot does not contain chain updates")
+	}
+	return nil, nil, nil
+}
+
+// RecommendedFee implements FeeEstimator. A snapshot has no fee information.
+func (sc *snapshotChain) RecommendedFee() types.Currency { return types.ZeroCurrency }
+
+// ExportUTXOs returns the wallet's spendable outputs along with the consensus
+// state their proofs are valid for. The snapshot can be passed to ImportUTXOs
+// to build and sign transactions without a connection to the chain.
+func (sw *SingleAddressWallet) ExportUTXOs() ([]types.SiacoinElement, consensus.State, error) {
+	tip, err := sw.storeTip()
+	if err != nil {
+		return nil, consensus.State{}, fmt.Errorf("failed to get wallet tip: %w", err)
+	}
+
+	spendable, err := sw.SpendableOutputs()
+	if err != nil {
+		return nil, consensus.State{}, fmt.Errorf("failed to get spendable outputs: %w", err)
+	}
+
+	// the proofs must match the tip they are updated from
+	if current, err := sw.storeTip(); err != nil {
+		return nil, consensus.State{}, fmt.Errorf("failed to get wallet tip: %w", err)
+	} else if current != tip {
+		return nil, consensus.State{}, fmt.Errorf("wallet tip changed from %v to %v during export", tip, current)
+	}
+
+	owned := spendable[:0]
+	for _, sce := range spendable {
+		if sce.SiacoinOutput.Address == sw.addr {
+			owned = append(owned, sce)
+		}
+	}
+	return sw.UpdateProofs(tip, owned)
+}
+
+// ImportUTXOs returns a wallet that funds and signs transactions using an
+// exported UTXO snapshot instead of a chain manager and store. The wallet
+// sees an empty transaction pool and never receives chain updates.
+//
+// The snapshot's proofs are only valid for its state. As the chain advances,
+// v2 transactions built from the snapshot become invalid and outputs in the
+// snapshot may be spent elsewhere; a new snapshot should be exported
+// regularly.
+func ImportUTXOs(priv types.PrivateKey, elements []types.SiacoinElement, state consensus.State, opts ...Option) (*SingleAddressWallet, error) {
+	ss, err := newSnapshotStore(AddressFromPublicKey(priv.PublicKey()), elements, state)
+	if err != nil {
+		return nil, err
+	}
+	return NewSingleAddressWallet(priv, &snapshotChain{state: state}, ss, opts...)
+}
+
+// newSnapshotStore returns a store containing the snapshot's elements. An
+// error is returned if an element does not belong to addr.
+func newSnapshotStore(addr types.Address, elements []types.SiacoinElement, state consensus.State) (*snapshotStore, error) {
+	ss := &snapshotStore{tip: state.Index}
+	for _, sce := range elements {
+		if sce.SiacoinOutput.Address != addr {
+			return nil, fmt.Errorf("element %v does not belong to wallet address %v", sce.ID, addr)
+		}
+		ss.elements = append(ss.elements, sce.Copy())
+	}
+	return ss, nil
+}
+
+// An OfflineWallet funds v1 transactions from an exported UTXO snapshot
+// without a private key or chain manager, so funding and signing can happen
+// on separate machines. Transactions funded by an OfflineWallet are signed
+// with SignExported.
+//
+// The snapshot is a point-in-time view of the wallet. Outputs in the
+// snapshot may have been spent since it was exported, in which case the
+// funded transaction is rejected, and the signatures commit to the
+// snapshot's state, so they are only valid until the next hardfork. A new
+// snapshot should be exported regularly.
+type OfflineWallet struct {
+	sw    *SingleAddressWallet
+	state consensus.State
+}
+
+// NewOfflineWallet returns an OfflineWallet for the wallet with the given
+// public key, using the elements and state returned by ExportUTXOs.
+func NewOfflineWallet(pk types.PublicKey, elements []types.SiacoinElement, state consensus.State, opts ...Option) (*OfflineWallet, error) {
+	uc := UnlockConditionsFromPublicKey(pk)
+	ss, err := newSnapshotStore(uc.UnlockHash(), elements, state)
+	if err != nil {
+		return nil, err
+	}
+	sw, err := newSingleAddressWallet(nil, uc, &snapshotChain{state: state}, ss, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &OfflineWallet{sw: sw, state: state}, nil
+}
+
+// Address returns the address of the wallet.
+func (ow *OfflineWallet) Address() types.Address {
+	return ow.sw.Address()
+}
+
+// State returns the consensus state of the snapshot.
+func (ow *OfflineWallet) State() consensus.State {
+	return ow.state
+}
+
+// Balance returns the balance of the snapshot.
+func (ow *OfflineWallet) Balance() (Balance, error) {
+	return ow.sw.Balance()
+}
+
+// FundTransaction adds siacoin inputs worth at least amount plus the
+// transaction's miner fees, and a change output if necessary. It returns
+// the IDs of the inputs that must be signed with SignExported. The inputs are
+// reserved until they are released with ReleaseInputs.
+func (ow *OfflineWallet) FundTransaction(txn *types.Transaction, amount types.Currency) ([]types.Hash256, error) {
+	return ow.sw.FundTransaction(txn, amount, false)
+}
+
+// ReleaseInputs releases the inputs of txns so they can be used to fund
+// other transactions.
+func (ow *OfflineWallet) ReleaseInputs(txns []types.Transaction) {
+	ow.sw.ReleaseInputs(txns, nil)
+}
+
+// SignExported signs the inputs of txn listed in toSign with priv, covering
+// the whole transaction. The signature hashes are computed from the
+// snapshot's state rather than a chain manager. An error is returned if priv
+// does not belong to the wallet.
+func (ow *OfflineWallet) SignExported(txn *types.Transaction, toSign []types.Hash256, priv types.PrivateKey) error {
+	if addr := AddressFromPublicKey(priv.PublicKey()); addr != ow.Address() {
+		return fmt.Errorf("key for address %v does not match wallet address %v", addr, ow.Address())
+	}
+	for _, id := range toSign {
+		sig := priv.SignHash(ow.state.WholeSigHash(*txn, id, 0, 0, nil))
+		txn.Signatures = append(txn.Signatures, types.TransactionSignature{
+			ParentID:       id,
+			CoveredFields:  types.CoveredFields{WholeTransaction: true},
+			PublicKeyIndex: 0,
+			Signature:      sig[:],
+		})
+	}
+	return nil
+}
+
+// Close closes the wallet.
+func (ow *OfflineWallet) Close() error {
+	return ow.sw.Close()
+}
@@ -0,0 +1,160 @@
+package wallet
+
+import (
+	"sync"
+
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// A PoolNotifier is a ChainManager that can notify the wallet when its
+// transaction pool changes. If the wallet's chain manager implements it,
+// transaction streams also receive unconfirmed transactions.
+type PoolNotifier interface {
+	OnPoolChange(fn func()) (cancel func())
+}
+
+// streamSeenLimit is the number of transaction IDs each stream remembers
+// to avoid delivering a transaction twice.
+const streamSeenLimit = 10000
+
+// A txnStream is a subscriber to the wallet's transaction stream.
+type txnStream struct {
+	ch        chan Event
+	closeOnce sync.Once
+	// seen is the set of transaction IDs already sent on the stream, and
+	// seenOrder the order they were sent in. Only the most recent
+	// streamSeenLimit IDs are kept. Both are only accessed whilst holding the
+	// wallet's stream lock.
+	seen      map[types.Hash256]bool
+	seenOrder []types.Hash256
+}
+
+// markSeen records that id was sent on the stream, forgetting the oldest ID
+// if the limit is exceeded.
+func (s *txnStream) markSeen(id types.Hash256) {
+	s.seen[id] = true
+	s.seenOrder = append(s.seenOrder, id)
+	if len(s.seenOrder) > streamSeenLimit {
+		delete(s.seen, s.seenOrder[0])
+		s.seenOrder = s.seenOrder[1:]
+	}
+}
+
+// send sends ev on the stream without blocking. If the buffer is full and
+// dropOldest is true, the oldest buffered event is dropped to make room;
+// otherwise send returns false and the stream should be closed.
+func (s *txnStream) send(ev Event, dropOldest bool) bool {
+	for {
+		select {
+		case s.ch <- ev:
+			return true
+		default:
+		}
+		if !dropOldest {
+			return false
+		} else if cap(s.ch) == 0 {
+			return true // nothing is buffered, so the event is dropped
+		}
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
+
+// isTransactionEvent returns true if the event represents a v1 or v2
+// transaction.
+func isTransactionEvent(ev Event) bool {
+	return ev.Type == EventTypeV1Transaction || ev.Type == EventTypeV2Transaction
+}
+
+// TransactionStream returns a channel that receives each transaction event
+// relevant to the wallet the first time it is seen, either when it is
+// confirmed in a block or, if the chain manager implements PoolNotifier, when
+// it enters the transaction pool. Each transaction is delivered at most once
+// per stream.
+//
+// The channel is buffered according to WithTransactionStreamBuffer. The wallet
+// never waits for a stream: when the buffer is full, it either drops the
+// oldest event or closes the channel. The returned function closes the
+// stream; it must be called once the stream is no longer needed.
+func (sw *SingleAddressWallet) TransactionStream() (<-chan Event, func()) {
+	s := &txnStream{
+		ch:   make(chan Event, sw.cfg.StreamBufferSize),
+		seen: make(map[types.Hash256]bool),
+	}
+
+	sw.streamMu.Lock()
+	sw.streams[s] = struct{}{}
+	if pn, ok := sw.cm.(PoolNotifier); ok && sw.cancelPoolWatch == nil {
+		sw.cancelPoolWatch = pn.OnPoolChange(sw.publishPool)
+	}
+	sw.streamMu.Unlock()
+
+	return s.ch, func() { sw.closeStream(s) }
+}
+
+// closeStream unsubscribes and closes the stream.
+func (sw *SingleAddressWallet) closeStream(s *txnStream) {
+	s.closeOnce.Do(func() {
+		sw.streamMu.Lock()
+		defer sw.streamMu.Unlock()
+		delete(sw.streams, s)
+		close(s.ch)
+		if len(sw.streams) == 0 && sw.cancelPoolWatch != nil {
+			sw.cancelPoolWatch()
+			sw.cancelPoolWatch = nil
+		}
+	})
+}
+
+// closeStreams closes all open streams.
+func (sw *SingleAddressWallet) closeStreams() {
+	sw.streamMu.Lock()
+	streams := make([]*txnStream, 0, len(sw.streams))
+	for s := range sw.streams {
+		streams = append(streams, s)
+	}
+	sw.streamMu.Unlock()
+
+	for _, s := range streams {
+		sw.closeStream(s)
+	}
+}
+
+// publishPool publishes the transactions currently in the pool that are
+// relevant to the wallet.
+func (sw *SingleAddressWallet) publishPool() {
+	events, err := sw.UnconfirmedEvents()
+	if err != nil {
+		sw.log.Warn("failed to get unconfirmed events", zap.Error(err))
+		return
+	}
+	sw.publishTransactions(events)
+}
+
+// publishTransactions sends the transaction events to every stream that has
+// not seen them yet. Streams that cannot keep up are closed.
+func (sw *SingleAddressWallet) publishTransactions(events []Event) {
+	var slow []*txnStream
+	sw.streamMu.Lock()
+	for s := range sw.streams {
+		for _, ev := range events {
+			if !isTransactionEvent(ev) || s.seen[ev.ID] {
+				continue
+			}
+			s.markSeen(ev.ID)
+			if !s.send(ev, sw.cfg.StreamDropOldest) {
+				slow = append(slow, s)
+				break
+			}
+		}
+	}
+	sw.streamMu.Unlock()
+
+	for _, s := range slow {
+		sw.log.Debug("closing transaction stream with a full buffer")
+		sw.closeStream(s)
+	}
+}
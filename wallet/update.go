@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"time"
 
+	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
 	"go.sia.tech/coreutils/chain"
+	"go.uber.org/zap"
 )
 
 type (
@@ -23,8 +25,25 @@ type (
 		UpdateElementProof(e *types.StateElement)
 	}
 
+	// A CommitNotifier is an UpdateTx that can run a function after it is
+	// committed. The wallet uses it to read the store's updated state, for
+	// example to report its balance, once the update is visible.
+	CommitNotifier interface {
+		// OnCommit adds fn to the set of functions that are called after
+		// the update is committed. If the update fails, they are not called.
+		OnCommit(fn func())
+	}
+
 	// UpdateTx is an interface for atomically applying chain updates to a
 	// single address wallet.
+	//
+	// All changes made through an UpdateTx during a single call to
+	// UpdateChainState, including the tip recorded by WalletApplyIndex and
+	// WalletRevertIndex, must be committed together: either every change is
+	// visible after the update or none are. A store must never persist its
+	// siacoin elements or events without the matching tip. If the update
+	// fails or the process exits before it is committed, the wallet resumes
+	// from the previous tip and reprocesses the same blocks.
 	UpdateTx interface {
 		// UpdateWalletSiacoinElementProofs updates the proofs of all state elements
 		// affected by the update. ProofUpdater.UpdateElementProof must be called
@@ -53,38 +72,88 @@ type (
 	}
 )
 
-// relevantV1Txn returns true if the transaction is relevant to the provided address
-func relevantV1Txn(txn types.Transaction, addr types.Address) bool {
+// relevantV1Txn returns true if the transaction is relevant to any of the
+// tracked addresses
+func relevantV1Txn(txn types.Transaction, tracked map[types.Address]bool) bool {
 	for _, so := range txn.SiacoinOutputs {
-		if so.Address == addr {
+		if tracked[so.Address] {
 			return true
 		}
 	}
 	for _, si := range txn.SiacoinInputs {
-		if si.UnlockConditions.UnlockHash() == addr {
+		if tracked[si.UnlockConditions.UnlockHash()] {
 			return true
 		}
 	}
 	return false
 }
 
-func relevantV2Txn(txn types.V2Transaction, addr types.Address) bool {
+func relevantV2Txn(txn types.V2Transaction, tracked map[types.Address]bool) bool {
 	for _, so := range txn.SiacoinOutputs {
-		if so.Address == addr {
+		if tracked[so.Address] {
 			return true
 		}
 	}
 	for _, si := range txn.SiacoinInputs {
-		if si.Parent.SiacoinOutput.Address == addr {
+		if tracked[si.Parent.SiacoinOutput.Address] {
 			return true
 		}
 	}
 	return false
 }
 
-// appliedEvents returns a slice of events that are relevant to the wallet
-// in the chain update.
-func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events []Event) {
+// relevantAddresses returns the tracked addresses that are involved in the
+// event data.
+func relevantAddresses(data EventData, tracked map[types.Address]bool) (relevant []types.Address) {
+	seen := make(map[types.Address]bool)
+	add := func(addr types.Address) {
+		if tracked[addr] && !seen[addr] {
+			seen[addr] = true
+			relevant = append(relevant, addr)
+		}
+	}
+
+	switch data := data.(type) {
+	case EventPayout:
+		add(data.SiacoinElement.SiacoinOutput.Address)
+	case EventV1ContractResolution:
+		add(data.SiacoinElement.SiacoinOutput.Address)
+	case EventV2ContractResolution:
+		add(data.SiacoinElement.SiacoinOutput.Address)
+	case EventV1Transaction:
+		for _, sce := range data.SpentSiacoinElements {
+			add(sce.SiacoinOutput.Address)
+		}
+		for _, sco := range data.Transaction.SiacoinOutputs {
+			add(sco.Address)
+		}
+		for _, sfe := range data.SpentSiafundElements {
+			add(sfe.SiafundOutput.Address)
+		}
+		for _, sfo := range data.Transaction.SiafundOutputs {
+			add(sfo.Address)
+		}
+	case EventV2Transaction:
+		for _, sci := range data.SiacoinInputs {
+			add(sci.Parent.SiacoinOutput.Address)
+		}
+		for _, sco := range data.SiacoinOutputs {
+			add(sco.Address)
+		}
+		for _, sfi := range data.SiafundInputs {
+			add(sfi.Parent.SiafundOutput.Address)
+		}
+		for _, sfo := range data.SiafundOutputs {
+			add(sfo.Address)
+		}
+	}
+	return
+}
+
+// appliedEvents returns a slice of events that are relevant to any of the
+// tracked addresses in the chain update. Transactions that only move funds
+// between addresses for which owned returns true are flagged as self-transfers.
+func appliedEvents(cau chain.ApplyUpdate, tracked map[types.Address]bool, owned func(types.Address) bool) (events []Event) {
 	cs := cau.State
 	block := cau.Block
 	index := cs.Index
@@ -104,10 +173,13 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 			Type:           eventType,
 			Timestamp:      block.Timestamp,
 			MaturityHeight: maturityHeight,
-			Relevant:       []types.Address{walletAddress},
+			Relevant:       relevantAddresses(data, tracked),
 		}
+		ev.SelfTransfer = ev.isSelfTransfer(owned)
 
-		if ev.SiacoinInflow().Equals(ev.SiacoinOutflow()) {
+		// a self-transfer without a fee leaves the balance unchanged, but is
+		// still recorded
+		if !ev.SelfTransfer && ev.SiacoinInflow().Equals(ev.SiacoinOutflow()) {
 			// skip events that don't affect the wallet
 			return
 		}
@@ -115,11 +187,11 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 	}
 
 	for _, txn := range block.Transactions {
-		if !relevantV1Txn(txn, walletAddress) {
+		if !relevantV1Txn(txn, tracked) {
 			continue
 		}
 		for _, si := range txn.SiafundInputs {
-			if si.UnlockConditions.UnlockHash() == walletAddress {
+			if tracked[si.UnlockConditions.UnlockHash()] {
 				outputID := si.ParentID.ClaimOutputID()
 				sce, ok := siacoinElements[outputID]
 				if !ok {
@@ -140,7 +212,7 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 			se, ok := siacoinElements[types.SiacoinOutputID(si.ParentID)]
 			if !ok {
 				panic("missing transaction siacoin element")
-			} else if se.SiacoinOutput.Address != walletAddress {
+			} else if !tracked[se.SiacoinOutput.Address] {
 				continue
 			}
 			event.SpentSiacoinElements = append(event.SpentSiacoinElements, se.Copy())
@@ -149,11 +221,11 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 	}
 
 	for _, txn := range block.V2Transactions() {
-		if !relevantV2Txn(txn, walletAddress) {
+		if !relevantV2Txn(txn, tracked) {
 			continue
 		}
 		for _, si := range txn.SiafundInputs {
-			if si.Parent.SiafundOutput.Address == walletAddress {
+			if tracked[si.Parent.SiafundOutput.Address] {
 				outputID := types.SiafundOutputID(si.Parent.ID).V2ClaimOutputID()
 				sce, ok := siacoinElements[outputID]
 				if !ok {
@@ -179,7 +251,7 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 
 		if fced.Valid {
 			for i, so := range fce.FileContract.ValidProofOutputs {
-				if so.Address != walletAddress {
+				if !tracked[so.Address] {
 					continue
 				}
 
@@ -197,7 +269,7 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 			}
 		} else {
 			for i, so := range fce.FileContract.MissedProofOutputs {
-				if so.Address != walletAddress {
+				if !tracked[so.Address] {
 					continue
 				}
 
@@ -224,7 +296,7 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 		fce := fced.V2FileContractElement.Move()
 
 		_, missed := fced.Resolution.(*types.V2FileContractExpiration)
-		if fce.V2FileContract.HostOutput.Address == walletAddress {
+		if tracked[fce.V2FileContract.HostOutput.Address] {
 			outputID := fce.ID.V2HostOutputID()
 			sce, ok := siacoinElements[outputID]
 			if !ok {
@@ -241,7 +313,7 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 			}, sce.MaturityHeight)
 		}
 
-		if fce.V2FileContract.RenterOutput.Address == walletAddress {
+		if tracked[fce.V2FileContract.RenterOutput.Address] {
 			outputID := fce.ID.V2RenterOutputID()
 			sce, ok := siacoinElements[outputID]
 			if !ok {
@@ -261,7 +333,7 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 
 	blockID := block.ID()
 	for i, so := range block.MinerPayouts {
-		if so.Address != walletAddress {
+		if !tracked[so.Address] {
 			continue
 		}
 
@@ -276,7 +348,7 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 	}
 
 	outputID := blockID.FoundationOutputID()
-	if sce, ok := siacoinElements[outputID]; ok && sce.SiacoinOutput.Address == walletAddress {
+	if sce, ok := siacoinElements[outputID]; ok && tracked[sce.SiacoinOutput.Address] {
 		addEvent(types.Hash256(outputID), EventTypeFoundationSubsidy, EventPayout{
 			SiacoinElement: sce.Copy(),
 		}, sce.MaturityHeight)
@@ -284,11 +356,12 @@ func appliedEvents(cau chain.ApplyUpdate, walletAddress types.Address) (events [
 	return
 }
 
-// applyChainUpdate atomically applies a chain update
-func (sw *SingleAddressWallet) applyChainUpdate(tx UpdateTx, address types.Address, cau chain.ApplyUpdate) error {
+// applyChainUpdate atomically applies a chain update and returns the events
+// that were added.
+func (sw *SingleAddressWallet) applyChainUpdate(tx UpdateTx, tracked map[types.Address]bool, cau chain.ApplyUpdate) ([]Event, error) {
 	// update current state elements
 	if err := tx.UpdateWalletSiacoinElementProofs(cau); err != nil {
-		return fmt.Errorf("failed to update state elements: %w", err)
+		return nil, fmt.Errorf("failed to update state elements: %w", err)
 	}
 
 	var createdUTXOs, spentUTXOs []types.SiacoinElement
@@ -296,7 +369,7 @@ func (sw *SingleAddressWallet) applyChainUpdate(tx UpdateTx, address types.Addre
 		switch {
 		case sced.Created && sced.Spent:
 			continue // ignore ephemeral elements
-		case sced.SiacoinElement.SiacoinOutput.Address != address:
+		case !tracked[sced.SiacoinElement.SiacoinOutput.Address]:
 			continue // ignore elements that are not related to the wallet
 		case sced.Created:
 			createdUTXOs = append(createdUTXOs, sced.SiacoinElement.Share())
@@ -307,23 +380,31 @@ func (sw *SingleAddressWallet) applyChainUpdate(tx UpdateTx, address types.Addre
 		}
 	}
 
-	if err := tx.WalletApplyIndex(cau.State.Index, createdUTXOs, spentUTXOs, appliedEvents(cau, address), cau.Block.Timestamp); err != nil {
-		return fmt.Errorf("failed to apply index: %w", err)
+	events := appliedEvents(cau, tracked, sw.canSpend)
+	if err := tx.WalletApplyIndex(cau.State.Index, createdUTXOs, spentUTXOs, events, cau.Block.Timestamp); err != nil {
+		return nil, fmt.Errorf("failed to apply index: %w", err)
 	}
-	sw.mu.Lock()
-	sw.tip = cau.State.Index
-	sw.mu.Unlock()
-	return nil
+
+	// pending reservations are confirmed once the update is committed
+	afterCommit(tx, func() {
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+		for _, sce := range spentUTXOs {
+			delete(sw.pending, sce.ID)
+			delete(sw.tags, sce.ID)
+		}
+	})
+	return events, nil
 }
 
 // revertChainUpdate atomically reverts a chain update from a wallet
-func (sw *SingleAddressWallet) revertChainUpdate(tx UpdateTx, revertedIndex types.ChainIndex, address types.Address, cru chain.RevertUpdate) error {
+func (sw *SingleAddressWallet) revertChainUpdate(tx UpdateTx, revertedIndex types.ChainIndex, tracked map[types.Address]bool, cru chain.RevertUpdate) error {
 	var removedUTXOs, unspentUTXOs []types.SiacoinElement
 	for _, sced := range cru.SiacoinElementDiffs() {
 		switch {
 		case sced.Created && sced.Spent:
 			continue // ignore ephemeral elements
-		case sced.SiacoinElement.SiacoinOutput.Address != address:
+		case !tracked[sced.SiacoinElement.SiacoinOutput.Address]:
 			continue // ignore elements that are not related to the wallet
 		case sced.Spent:
 			unspentUTXOs = append(unspentUTXOs, sced.SiacoinElement.Share())
@@ -343,31 +424,88 @@ func (sw *SingleAddressWallet) revertChainUpdate(tx UpdateTx, revertedIndex type
 	if err := tx.UpdateWalletSiacoinElementProofs(cru); err != nil {
 		return fmt.Errorf("failed to update state elements: %w", err)
 	}
-	sw.mu.Lock()
-	sw.tip = revertedIndex
-	sw.mu.Unlock()
 	return nil
 }
 
+// afterCommit calls fn once tx is committed. If tx does not implement
+// CommitNotifier, fn is called immediately.
+func afterCommit(tx UpdateTx, fn func()) {
+	if cn, ok := tx.(CommitNotifier); ok {
+		cn.OnCommit(fn)
+	} else {
+		fn()
+	}
+}
+
 // UpdateChainState atomically applies and reverts chain updates to a single
-// wallet store.
+// wallet store. If tx is a CommitNotifier, the wallet's in-memory state,
+// including its tip, only changes once the store commits the update.
 func (sw *SingleAddressWallet) UpdateChainState(tx UpdateTx, reverted []chain.RevertUpdate, applied []chain.ApplyUpdate) error {
+	tip := sw.Tip()
+	tracked := sw.trackedAddresses()
 	for _, cru := range reverted {
 		revertedIndex := types.ChainIndex{
 			ID:     cru.Block.ID(),
 			Height: cru.State.Index.Height + 1,
 		}
-		err := sw.revertChainUpdate(tx, revertedIndex, sw.addr, cru)
+		err := sw.revertChainUpdate(tx, revertedIndex, tracked, cru)
 		if err != nil {
 			return fmt.Errorf("failed to revert chain update %q: %w", cru.State.Index, err)
 		}
+		tip = revertedIndex
 	}
 
+	var events []Event
 	for _, cau := range applied {
-		err := sw.applyChainUpdate(tx, sw.addr, cau)
+		added, err := sw.applyChainUpdate(tx, tracked, cau)
 		if err != nil {
 			return fmt.Errorf("failed to apply chain update %q: %w", cau.State.Index, err)
 		}
+		events = append(events, added...)
+		tip = cau.State.Index
+	}
+
+	// the store can still fail to commit the update, so the tip is held
+	// back until it does
+	afterCommit(tx, func() {
+		sw.mu.Lock()
+		sw.tip = tip
+		sw.mu.Unlock()
+	})
+	afterCommit(tx, func() { sw.publishTransactions(events) })
+
+	if sw.cancelPoolReservations != nil {
+		// reservations are only released once the update is visible, so
+		// that outputs spent by a confirmed transaction are not released
+		// while the store still reports them as unspent
+		afterCommit(tx, sw.reconcilePoolReservations)
+	}
+	// pool ages are measured from the committed tip
+	afterCommit(tx, sw.trackPoolAges)
+	afterCommit(tx, func() { sw.recordReorg(uint64(len(reverted)), len(applied) > 0) })
+
+	if br, ok := sw.cfg.MetricsRecorder.(BalanceRecorder); ok && (len(reverted) > 0 || len(applied) > 0) {
+		// the balance is recorded at the height of the update, which may
+		// trail the chain manager's tip while the store is syncing
+		var cs consensus.State
+		if len(applied) > 0 {
+			cs = applied[len(applied)-1].State
+		} else {
+			cs = reverted[len(reverted)-1].State
+		}
+		if cn, ok := tx.(CommitNotifier); ok {
+			cn.OnCommit(func() { sw.recordBalance(br, cs) })
+		} else {
+			sw.log.Debug("not recording balance, store does not notify on commit")
+		}
+	}
+
+	if sw.cfg.ChainUpdateHook != nil {
+		afterCommit(tx, func() {
+			if err := sw.cfg.ChainUpdateHook(reverted, applied); err != nil {
+				sw.log.Warn("chain update hook failed", zap.Error(err))
+			}
+		})
 	}
 	return nil
 }
@@ -1,15 +1,25 @@
 package wallet
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"maps"
+	"slices"
 	"sort"
 	"sync"
 	"time"
 
 	"go.sia.tech/core/consensus"
 	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
 	"go.uber.org/zap"
+	"lukechampine.com/frand"
 )
 
 const (
@@ -20,21 +30,401 @@ const (
 	// redistributeBatchSize is the number of outputs to redistribute per txn to
 	// avoid creating a txn that is too large.
 	redistributeBatchSize = 10
+
+	// maxFeeIterations is the maximum number of times input selection is
+	// repeated while converging on a transaction fee.
+	maxFeeIterations = 10
+
+	// maxConflictRetries is the maximum number of times input selection is
+	// repeated when a selected output is spent by the transaction pool before
+	// it can be reserved.
+	maxConflictRetries = 3
+
+	// privacyMaxExtraInputs is the maximum number of inputs the privacy
+	// selection mode will use beyond the minimum required to fund a
+	// transaction.
+	privacyMaxExtraInputs = 3
+	// privacyShuffleAttempts is the number of random orderings the privacy
+	// selection mode tries before falling back to largest-first selection.
+	privacyShuffleAttempts = 10
+
+	// proofUpdateBatchSize is the number of chain updates requested at a
+	// time when updating element proofs.
+	proofUpdateBatchSize = 100
+
+	// changelessToleranceDivisor bounds how far a changeless amount may be
+	// from the requested amount, as a fraction of the requested amount.
+	changelessToleranceDivisor = 20
+
+	// feeMultiplierPrecision is the number of fractional steps per unit used
+	// when applying a fee multiplier to a fee rate.
+	feeMultiplierPrecision = 1000
+
+	// overviewAttempts is the maximum number of times Overview is assembled
+	// when the store's tip changes while it is being assembled.
+	overviewAttempts = 3
+
+	// eventsPageSize is the number of events requested per call when
+	// paginating through a store's events.
+	eventsPageSize = 1000
+
+	// defaultMaxReservations is the default maximum number of outputs that
+	// may be reserved at once. It is far above what a well-behaved caller
+	// needs and only guards against reservations that are never released.
+	defaultMaxReservations = 100_000
+)
+
+// Reservation event types.
+const (
+	ReservationEventReserved = "reserved"
+	ReservationEventReleased = "released"
+	ReservationEventExpired  = "expired"
+	// ReservationEventPending is recorded when reserved outputs are spent by
+	// a transaction in the pool and no longer expire.
+	ReservationEventPending = "pending"
+)
+
+// Fund warnings.
+const (
+	// FundWarningLargeChange indicates that the change output is worth more
+	// than the amount funded. Consolidating or splitting the wallet's outputs
+	// may produce better matches.
+	FundWarningLargeChange FundWarning = "largeChange"
+	// FundWarningUsedDust indicates that an input is worth less than the
+	// dust threshold, so it costs nearly as much to spend as it is worth.
+	FundWarningUsedDust FundWarning = "usedDust"
+	// FundWarningDefragged indicates that inputs beyond those needed to fund
+	// the transaction were added to consolidate small outputs.
+	FundWarningDefragged FundWarning = "defragged"
+	// FundWarningUsedUnconfirmed indicates that an input was created by an
+	// unconfirmed transaction, so the transaction cannot be confirmed before
+	// its parent.
+	FundWarningUsedUnconfirmed FundWarning = "usedUnconfirmed"
+	// FundWarningSuggestConsolidation indicates that the change output is
+	// worth more than the ratio set with WithConsolidationAdvice times the
+	// amount funded, a sign that the wallet should consolidate or split its
+	// outputs.
+	FundWarningSuggestConsolidation FundWarning = "suggestConsolidation"
+)
+
+// Stuck transaction remediations.
+const (
+	// RemediationCPFP suggests spending the transaction's change in a child
+	// transaction whose fee raises the fee rate of both transactions to the
+	// recommended fee rate.
+	RemediationCPFP = "cpfp"
+	// RemediationReplace suggests replacing the transaction with a
+	// conflicting transaction that pays a higher fee.
+	RemediationReplace = "replace"
+)
+
+const (
+	// ChangePositionLast places the change output after all other outputs.
+	ChangePositionLast ChangePosition = -1
+	// ChangePositionFirst places the change output before all other outputs.
+	ChangePositionFirst ChangePosition = 0
+)
+
+const (
+	// SelectionModeLargestFirst selects the largest outputs first, minimizing
+	// the number of inputs.
+	SelectionModeLargestFirst SelectionMode = iota
+	// SelectionModeOldestFirst selects the oldest confirmed outputs first,
+	// spending coins in the order they were received.
+	SelectionModeOldestFirst
+	// SelectionModePrivacy selects a random set of outputs, so the number of
+	// inputs varies between transactions and amounts are harder to
+	// correlate. Transactions may use up to three more inputs than necessary,
+	// which increases fees.
+	SelectionModePrivacy
+	// SelectionModeAntiFragment selects the smallest outputs first once the
+	// number of spendable outputs exceeds the configured high-water mark,
+	// consolidating the wallet as part of regular spending. At most
+	// MaxDefragUTXOs small outputs are preferred; the rest of the amount is
+	// covered by the largest outputs. This raises per-transaction fees
+	// slightly in exchange for fewer explicit defrag runs. Below the
+	// high-water mark, it behaves like SelectionModeLargestFirst.
+	SelectionModeAntiFragment
+)
+
+const (
+	// UnconfirmedPolicyNever funds transactions using only confirmed
+	// outputs.
+	UnconfirmedPolicyNever UnconfirmedPolicy = iota
+	// UnconfirmedPolicyOnlyIfNeeded funds transactions using confirmed
+	// outputs first, adding unconfirmed outputs only if the confirmed outputs
+	// are insufficient. A transaction that spends an unconfirmed output
+	// cannot be confirmed before its parent.
+	UnconfirmedPolicyOnlyIfNeeded
+	// UnconfirmedPolicyIsolated funds transactions entirely from confirmed
+	// outputs or, if they are insufficient, entirely from unconfirmed
+	// outputs. Transactions funded from confirmed outputs never depend on an
+	// unconfirmed parent, at the cost of failing when only a mix of confirmed
+	// and unconfirmed outputs would cover the amount.
+	UnconfirmedPolicyIsolated
 )
 
 var (
 	// ErrNotEnoughFunds is returned when there are not enough unspent outputs
 	// to fund a transaction.
 	ErrNotEnoughFunds = errors.New("not enough funds")
+
+	// ErrChainNotReady is returned when the chain manager has not yet loaded
+	// a chain state. Callers should wait for the chain manager to finish
+	// initializing and syncing before using the wallet.
+	ErrChainNotReady = errors.New("chain manager not ready")
+
+	// ErrStoreUnsupported is returned when the wallet's store does not
+	// implement the optional interface required by an operation.
+	ErrStoreUnsupported = errors.New("operation not supported by store")
+
+	// ErrChainUnsupported is returned when the wallet's chain manager does
+	// not implement the optional interface required by an operation.
+	ErrChainUnsupported = errors.New("operation not supported by chain manager")
+
+	// ErrNotFound is returned when a requested item does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrOutputConflict is returned when the outputs selected to fund a
+	// transaction are repeatedly spent by transactions entering the pool
+	// before they can be reserved.
+	ErrOutputConflict = errors.New("selected outputs conflict with pool transactions")
+
+	// ErrOutputLocked is returned when a specific output was requested but it
+	// is reserved by another transaction or already spent in the pool.
+	ErrOutputLocked = errors.New("output is locked")
+
+	// ErrBelowDustThreshold is returned when a redistribution would create
+	// outputs worth less than the wallet's dust threshold.
+	ErrBelowDustThreshold = errors.New("amount is below the dust threshold")
+
+	// ErrNotSynced is returned when WithRequireSynced is set and the
+	// wallet's store is too far behind the chain to build a transaction.
+	ErrNotSynced = errors.New("wallet is not synced")
+	// ErrTooManyReservations is returned when funding a transaction would
+	// exceed the maximum number of reserved outputs.
+	ErrTooManyReservations = errors.New("too many reserved outputs")
+
+	// ErrTipMismatch is returned when the store's tip is not on the chain
+	// manager's best chain, indicating the store must process a reorg.
+	ErrTipMismatch = errors.New("store tip is not on the best chain")
 )
 
 type (
-	// Balance is the balance of a wallet.
+	// Balance is the balance of a wallet. Outputs paying watched addresses
+	// cannot be spent by the wallet, so their value is only reported in
+	// Watched.
 	Balance struct {
 		Spendable   types.Currency `json:"spendable"`
 		Confirmed   types.Currency `json:"confirmed"`
 		Unconfirmed types.Currency `json:"unconfirmed"`
 		Immature    types.Currency `json:"immature"`
+		Watched     types.Currency `json:"watched"`
+	}
+
+	// A BalanceBreakdown is the balance of a wallet and the number of
+	// unspent outputs it holds.
+	BalanceBreakdown struct {
+		Balance
+		UTXOs int `json:"utxos"`
+	}
+
+	// A ChangePosition determines where the wallet places the change output
+	// when funding a transaction. Non-negative values place the change output
+	// at that index of the transaction's siacoin outputs.
+	ChangePosition int
+
+	// A SelectionMode determines the order in which the wallet selects
+	// outputs when funding a transaction.
+	SelectionMode int
+
+	// An UnconfirmedPolicy determines whether the wallet spends outputs
+	// created by unconfirmed transactions when funding a transaction.
+	UnconfirmedPolicy int
+
+	// A FundResult describes the changes made to a transaction when it was
+	// funded.
+	FundResult struct {
+		// ToSign contains the IDs of the inputs added to the transaction.
+		ToSign []types.Hash256 `json:"toSign"`
+		// ChangeIndex is the index of the change output in the transaction's
+		// siacoin outputs, or -1 if no change output was added.
+		ChangeIndex int `json:"changeIndex"`
+		// Warnings describe aspects of the selection that may be worth
+		// surfacing to the user. They do not indicate an error.
+		Warnings []FundWarning `json:"warnings,omitempty"`
+	}
+
+	// A FundWarning indicates that funding a transaction produced a
+	// suboptimal result.
+	FundWarning string
+
+	// A ReservationEvent records a change to the set of outputs reserved by
+	// the wallet.
+	ReservationEvent struct {
+		Type      string                  `json:"type"`
+		Timestamp time.Time               `json:"timestamp"`
+		IDs       []types.SiacoinOutputID `json:"ids"`
+		// Tag is the tag supplied by the caller that reserved the outputs,
+		// if any. It is only set for reserved events.
+		Tag string `json:"tag,omitempty"`
+	}
+
+	// A SpendableTranche is an amount of siacoins that becomes spendable at a
+	// given height.
+	SpendableTranche struct {
+		Amount types.Currency `json:"amount"`
+		Height uint64         `json:"height"`
+		// EstimatedTime is the estimated time at which the chain will reach
+		// Height, based on the wallet's block interval.
+		EstimatedTime time.Time `json:"estimatedTime"`
+	}
+
+	// A FeePoint is the miner fee paid by a confirmed transaction funded by
+	// the wallet.
+	FeePoint struct {
+		ID     types.TransactionID `json:"id"`
+		Height uint64              `json:"height"`
+		Fee    types.Currency      `json:"fee"`
+		// FeePerByte is Fee divided by the weight of the transaction.
+		FeePerByte types.Currency `json:"feePerByte"`
+	}
+
+	// FragMetrics describes how fragmented the wallet's spendable outputs
+	// are.
+	FragMetrics struct {
+		SpendableOutputs int `json:"spendableOutputs"`
+		// DustOutputs is the number of spendable outputs worth less than the
+		// dust threshold.
+		DustOutputs int            `json:"dustOutputs"`
+		MedianValue types.Currency `json:"medianValue"`
+		// DustRatio is DustOutputs divided by SpendableOutputs, or zero if
+		// the wallet has no spendable outputs.
+		DustRatio float64 `json:"dustRatio"`
+	}
+
+	// A PaymentBatch is one of the transactions created by PayBatch.
+	PaymentBatch struct {
+		Transaction types.Transaction `json:"transaction"`
+		// ToSign contains the IDs of the wallet's inputs, so they can be
+		// released if the transaction is not broadcast.
+		ToSign []types.Hash256 `json:"toSign"`
+		// Recipients are the addresses paid by the transaction.
+		Recipients []types.Address `json:"recipients"`
+	}
+
+	// SweepOptions configures the behavior of Sweep.
+	SweepOptions struct {
+		// IncludeUneconomical includes outputs that are worth less than the
+		// fee required to spend them. The sweep still fails if the total
+		// value of the outputs does not cover the transaction fee.
+		IncludeUneconomical bool `json:"includeUneconomical"`
+	}
+
+	// An EventPage is a page of events along with pagination metadata.
+	EventPage struct {
+		Events []Event `json:"events"`
+		// Total is the total number of events relevant to the wallet.
+		Total uint64 `json:"total"`
+		// HasMore is true if there are more events after this page.
+		HasMore bool `json:"hasMore"`
+	}
+
+	// A StuckTxn is a wallet transaction that has been in the pool for
+	// longer than expected, along with a suggested remediation.
+	StuckTxn struct {
+		ID types.TransactionID `json:"id"`
+		// PendingBlocks is the number of blocks since the wallet first saw
+		// the transaction in the pool.
+		PendingBlocks uint64         `json:"pendingBlocks"`
+		FeePerByte    types.Currency `json:"feePerByte"`
+		// Remediation is RemediationCPFP or RemediationReplace.
+		Remediation string `json:"remediation"`
+		// Cost is the estimated miner fee of the remediation: the fee of
+		// the child transaction for CPFP, or the total fee of the
+		// replacement.
+		Cost types.Currency `json:"cost"`
+	}
+
+	// A FundV2Result describes the changes made to a v2 transaction when it
+	// was funded.
+	FundV2Result struct {
+		// Basis is the chain index the inputs' proofs are valid for.
+		Basis types.ChainIndex `json:"basis"`
+		// ToSign contains the indices of the inputs added to the transaction.
+		ToSign []int `json:"toSign"`
+		// ChangeIndex is the index of the change output in the transaction's
+		// siacoin outputs, or -1 if no change output was added.
+		ChangeIndex int `json:"changeIndex"`
+	}
+
+	// A RedistributeResult describes the transactions created by a
+	// redistribution.
+	RedistributeResult struct {
+		Transactions []types.Transaction `json:"transactions"`
+		// ToSign contains the IDs of the inputs to sign for each transaction.
+		ToSign [][]types.Hash256 `json:"toSign"`
+		// ChangeIndices contains the index of the change output in each
+		// transaction's siacoin outputs, or -1 if it has no change output.
+		ChangeIndices []int `json:"changeIndices"`
+	}
+
+	// A RedistributeV2Result describes the transactions created by a v2
+	// redistribution.
+	RedistributeV2Result struct {
+		Transactions []types.V2Transaction `json:"transactions"`
+		// ToSign contains the indices of the inputs to sign for each
+		// transaction.
+		ToSign [][]int `json:"toSign"`
+		// ChangeIndices contains the index of the change output in each
+		// transaction's siacoin outputs, or -1 if it has no change output.
+		ChangeIndices []int `json:"changeIndices"`
+	}
+
+	// A RedistributePlan describes the transactions Redistribute would
+	// create, as returned by SimulateRedistribute.
+	RedistributePlan struct {
+		// Transactions are the planned transactions. They are unsigned and
+		// their inputs are not reserved.
+		Transactions []types.Transaction `json:"transactions"`
+		// ChangeIndices contains the index of the change output in each
+		// transaction's siacoin outputs, or -1 if it has no change output.
+		ChangeIndices []int `json:"changeIndices"`
+		// Inputs is the total number of inputs consumed by the transactions.
+		Inputs int `json:"inputs"`
+		// TotalFee is the sum of the transactions' miner fees.
+		TotalFee types.Currency `json:"totalFee"`
+	}
+
+	// A WalletOverview summarizes the state of a wallet.
+	WalletOverview struct {
+		Address types.Address    `json:"address"`
+		Tip     types.ChainIndex `json:"tip"`
+		Balance Balance          `json:"balance"`
+		UTXOs   int              `json:"utxos"`
+		// Events contains the wallet's most recent events, newest first.
+		Events []Event `json:"events"`
+	}
+
+	// ReorgStatistics summarizes the chain reorganizations observed by the
+	// wallet since it was created. The depth of a reorg is the number of
+	// blocks it reverted.
+	ReorgStatistics struct {
+		Count     uint64  `json:"count"`
+		MaxDepth  uint64  `json:"maxDepth"`
+		MeanDepth float64 `json:"meanDepth"`
+		// DeepestAt is the time the deepest reorg was observed. It is zero
+		// if no reorg has been observed.
+		DeepestAt time.Time `json:"deepestAt"`
+	}
+
+	// A MaintenanceReport summarizes the work done by Maintain.
+	MaintenanceReport struct {
+		ReservationsCleared int `json:"reservationsCleared"`
+		ProofsRefreshed     int `json:"proofsRefreshed"`
+		// Inconsistency is the error returned by ConsistencyCheck, if any.
+		// Proofs are not refreshed while the store is inconsistent.
+		Inconsistency error `json:"-"`
 	}
 
 	// A ChainManager manages the current state of the blockchain.
@@ -46,8 +436,28 @@ type (
 		OnReorg(func(types.ChainIndex)) func()
 	}
 
+	// An UpdateProvider is a ChainManager that returns the chain updates
+	// since an index. UpdateProofs and RefreshProofs require it.
+	UpdateProvider interface {
+		UpdatesSince(index types.ChainIndex, maxBlocks int) (rus []chain.RevertUpdate, aus []chain.ApplyUpdate, err error)
+	}
+
+	// A FeeEstimator is a ChainManager that recommends a transaction fee
+	// rate. If the wallet's chain manager does not implement it, the
+	// recommended fee rate is treated as zero.
+	FeeEstimator interface {
+		RecommendedFee() types.Currency
+	}
+
 	// A SingleAddressStore stores the state of a single-address wallet.
 	// Implementations are assumed to be thread safe.
+	//
+	// The wallet does not hold a lock across its calls to the store, since
+	// chain updates are applied by the store, which would invert the lock
+	// order. Instead, each method must return a consistent view of the store:
+	// the changes made by a call to UpdateChainState must not be partially
+	// visible to a concurrent read. The wallet tolerates the store advancing
+	// between two calls.
 	SingleAddressStore interface {
 		// Tip returns the consensus change ID and block height of
 		// the last wallet change.
@@ -64,11 +474,143 @@ type (
 		WalletEventCount() (uint64, error)
 	}
 
+	// An EventIterStore is a SingleAddressStore that can stream the wallet's
+	// events without loading them into memory. Stores that do not implement
+	// it are paginated using WalletEvents instead.
+	EventIterStore interface {
+		// WalletEventsIter returns an iterator over all events relevant to the
+		// wallet, in the same order as WalletEvents. The iterator should yield
+		// an error and stop if ctx is canceled.
+		WalletEventsIter(ctx context.Context) (iter.Seq2[Event, error], error)
+	}
+
+	// A ChainOrderEventStore is a SingleAddressStore that can stream the
+	// wallet's events in the order they were confirmed. ReplayEvents
+	// requires it.
+	ChainOrderEventStore interface {
+		// WalletEventsSince returns an iterator over the events confirmed at
+		// or after height, in the order their blocks were applied. Events
+		// confirmed in the same block must always be yielded in the same
+		// order, and the iterator must not be affected by chain updates
+		// applied while it is in use. The iterator should yield an error
+		// and stop if ctx is canceled.
+		WalletEventsSince(ctx context.Context, height uint64) (iter.Seq2[Event, error], error)
+	}
+
+	// An OutputRemoverStore is a SingleAddressStore that can remove
+	// unspent siacoin elements outside of a chain update. It is used to
+	// repair stores that contain outputs unknown to the chain.
+	OutputRemoverStore interface {
+		// RemoveWalletSiacoinElements removes the siacoin elements with the
+		// given IDs from the store.
+		RemoveWalletSiacoinElements(ids []types.SiacoinOutputID) error
+	}
+
+	// A ProofStore is a SingleAddressStore that can replace the Merkle
+	// proofs of its unspent siacoin elements outside of a chain update. It
+	// is used to refresh proofs that no longer match the chain.
+	ProofStore interface {
+		// SetWalletSiacoinElementProofs replaces the state elements of the
+		// store's unspent siacoin elements with those of elements. The
+		// proofs are valid for basis; if the store's tip is not basis, an
+		// error should be returned without modifying the store. Elements
+		// that are not in the store should be ignored.
+		SetWalletSiacoinElementProofs(basis types.ChainIndex, elements []types.SiacoinElement) error
+	}
+
+	// A TransactionReferenceStore is a SingleAddressStore that can associate
+	// client-supplied references with transactions. References are keyed by
+	// transaction ID and are not affected by reorgs.
+	TransactionReferenceStore interface {
+		// SetTransactionReference sets the reference of a transaction.
+		SetTransactionReference(id types.TransactionID, reference string) error
+		// TransactionReference returns the reference of a transaction. If
+		// the transaction has no reference, ErrNotFound should be returned.
+		TransactionReference(id types.TransactionID) (string, error)
+	}
+
+	// An OutputIndexStore is a SingleAddressStore that records the chain
+	// index of the block that created each unspent siacoin element.
+	OutputIndexStore interface {
+		// WalletSiacoinElementIndex returns the index of the block that
+		// created the unspent siacoin element with the given ID. If the
+		// element is not in the store, ErrNotFound should be returned.
+		WalletSiacoinElementIndex(id types.SiacoinOutputID) (types.ChainIndex, error)
+	}
+
+	// A CounterpartyStore is a SingleAddressStore that can efficiently
+	// determine whether an address appears in the wallet's events, such as
+	// with an index on event addresses.
+	CounterpartyStore interface {
+		// WalletHasTransactedWith returns true if addr appears in the
+		// inputs or outputs of any of the wallet's events.
+		WalletHasTransactedWith(addr types.Address) (bool, error)
+	}
+
+	// An AddressStore is a SingleAddressStore that records the address of
+	// the wallet it was created for. It is used to detect a wallet being
+	// opened with a different key than the one its store was created with.
+	AddressStore interface {
+		// WalletAddress returns the address recorded by the store. If no
+		// address has been recorded, ErrNotFound should be returned.
+		WalletAddress() (types.Address, error)
+		// SetWalletAddress records the address of the wallet.
+		SetWalletAddress(addr types.Address) error
+	}
+
+	// A BalanceStore is a SingleAddressStore that can total its unspent
+	// siacoin elements without loading them, such as with an aggregate
+	// query. It is used to report the wallet's balance to a MetricsRecorder
+	// after each chain update.
+	BalanceStore interface {
+		// WalletBalance returns the totals of the store's unspent siacoin
+		// elements. Elements with a maturity height greater than height are
+		// immature; the rest are confirmed. Confirmed elements not in
+		// exclude are also spendable. The Unconfirmed field is ignored.
+		WalletBalance(height uint64, exclude []types.SiacoinOutputID) (BalanceBreakdown, error)
+	}
+
+	// A SpendableCountStore is a SingleAddressStore that can count its
+	// matured siacoin elements without loading them, such as with an
+	// aggregate query. It is used by SpendableOutputCount.
+	SpendableCountStore interface {
+		// SpendableSiacoinElementCount returns the number of unspent siacoin
+		// elements with a maturity height at or below height, excluding any
+		// element in exclude.
+		SpendableSiacoinElementCount(height uint64, exclude []types.SiacoinOutputID) (int, error)
+	}
+
+	// A FeeStore is a SingleAddressStore that indexes the fees paid by the
+	// wallet's transactions, such as by storing the result of EventFee for
+	// each applied event.
+	FeeStore interface {
+		// WalletFeeHistory returns a paginated list of the fees paid by the
+		// wallet's confirmed transactions, in the same order as
+		// WalletEvents.
+		WalletFeeHistory(offset, limit int) ([]FeePoint, error)
+	}
+
+	// An OutputSourceStore is a SingleAddressStore that records the type of
+	// the event that created each siacoin element, such as by storing the
+	// result of EventOutputIDs for each applied event.
+	OutputSourceStore interface {
+		// WalletSiacoinElementSource returns the type of the event that
+		// created the siacoin element with the given ID. If the element is
+		// unknown, ErrNotFound should be returned.
+		WalletSiacoinElementSource(id types.SiacoinOutputID) (string, error)
+	}
+
 	// A SingleAddressWallet is a hot wallet that manages the outputs controlled
 	// by a single address.
 	SingleAddressWallet struct {
 		priv types.PrivateKey
+		// addr and uc are derived from priv when the wallet is created and
+		// never modified
 		addr types.Address
+		uc   types.UnlockConditions
+		// keys maps the addresses of any additional keys to the keys. It is
+		// set when the wallet is created and never modified.
+		keys map[types.Address]types.PrivateKey
 
 		cm    ChainManager
 		store SingleAddressStore
@@ -76,12 +618,48 @@ type (
 
 		cfg config
 
+		closed    chan struct{}
+		closeOnce sync.Once
+		wg        sync.WaitGroup
+
+		streamMu        sync.Mutex // protects the following fields
+		streams         map[*txnStream]struct{}
+		cancelPoolWatch func()
+
+		// cancelPoolReservations unsubscribes from pool changes when
+		// WithPoolReservations is set
+		cancelPoolReservations func()
+
 		mu  sync.Mutex // protects the following fields
 		tip types.ChainIndex
 		// locked is a set of siacoin output IDs locked by FundTransaction. They
 		// will be released either by calling Release for unused transactions or
 		// being confirmed in a block.
 		locked map[types.SiacoinOutputID]time.Time
+		// pending is a set of reserved outputs spent by a transaction in the
+		// pool. They are released if the transaction leaves the pool without
+		// being confirmed. Only used if WithPoolReservations is set.
+		pending map[types.SiacoinOutputID]bool
+		// tags is the tag of each locked or pending output that was
+		// reserved with a non-empty tag
+		tags map[types.SiacoinOutputID]string
+		// poolSeen is the wallet height at which each of the wallet's pool
+		// transactions was first seen
+		poolSeen map[types.TransactionID]uint64
+		// reservationLog is a ring buffer of the most recent reservation
+		// events. reservationLogNext is the index of the next event to be
+		// overwritten once the buffer is full.
+		reservationLog     []ReservationEvent
+		reservationLogNext int
+		// reorgs and reorgDepthSum record the reorgs observed by
+		// UpdateChainState. reorgDepth is the number of blocks reverted
+		// since the last applied block.
+		reorgs        ReorgStatistics
+		reorgDepthSum uint64
+		reorgDepth    uint64
+		// watched is a set of additional addresses whose outputs and events
+		// are tracked by the wallet. The wallet cannot spend their outputs.
+		watched map[types.Address]bool
 	}
 )
 
@@ -91,6 +669,14 @@ var ErrDifferentSeed = errors.New("seed differs from wallet seed")
 
 // Close closes the wallet
 func (sw *SingleAddressWallet) Close() error {
+	sw.closeOnce.Do(func() {
+		close(sw.closed)
+		if sw.cancelPoolReservations != nil {
+			sw.cancelPoolReservations()
+		}
+	})
+	sw.wg.Wait()
+	sw.closeStreams()
 	return nil
 }
 
@@ -99,32 +685,411 @@ func (sw *SingleAddressWallet) Address() types.Address {
 	return sw.addr
 }
 
-// UnlockConditions returns the unlock conditions of the wallet.
+// AddWatchAddress adds an address to the set of addresses tracked by the
+// wallet. Outputs and events relevant to watched addresses are included in
+// the wallet's events and in the Watched field of its balance, but the wallet
+// will never spend them. Only chain updates applied after the address is
+// added are considered; the wallet does not rescan for existing outputs. The
+// set of watched addresses is only held in memory, so it must be restored
+// after the wallet is restarted.
+func (sw *SingleAddressWallet) AddWatchAddress(addr types.Address) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if addr != sw.addr {
+		sw.watched[addr] = true
+	}
+}
+
+// RemoveWatchAddress removes an address from the set of addresses tracked by
+// the wallet. Outputs previously recorded in the store are not removed.
+func (sw *SingleAddressWallet) RemoveWatchAddress(addr types.Address) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	delete(sw.watched, addr)
+}
+
+// trackedAddresses returns the set of addresses tracked by the wallet,
+// including the wallet's own address.
+func (sw *SingleAddressWallet) trackedAddresses() map[types.Address]bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	tracked := make(map[types.Address]bool, len(sw.watched)+1)
+	tracked[sw.addr] = true
+	for addr := range sw.keys {
+		tracked[addr] = true
+	}
+	for addr := range sw.watched {
+		tracked[addr] = true
+	}
+	return tracked
+}
+
+// canSpend returns true if the wallet holds the key for addr.
+func (sw *SingleAddressWallet) canSpend(addr types.Address) bool {
+	_, ok := sw.keys[addr]
+	return ok || addr == sw.addr
+}
+
+// keyFor returns the key for addr, defaulting to the wallet's primary key.
+func (sw *SingleAddressWallet) keyFor(addr types.Address) types.PrivateKey {
+	if key, ok := sw.keys[addr]; ok {
+		return key
+	}
+	return sw.priv
+}
+
+// unlockConditionsFor returns the unlock conditions of addr, defaulting to the
+// wallet's primary unlock conditions. The returned value is a copy, so it can
+// be placed in a transaction without aliasing the wallet's cached keys.
+func (sw *SingleAddressWallet) unlockConditionsFor(addr types.Address) types.UnlockConditions {
+	if key, ok := sw.keys[addr]; ok {
+		return UnlockConditionsFromPublicKey(key.PublicKey())
+	}
+	uc := sw.uc
+	uc.PublicKeys = make([]types.UnlockKey, len(sw.uc.PublicKeys))
+	for i, pk := range sw.uc.PublicKeys {
+		uc.PublicKeys[i] = types.UnlockKey{Algorithm: pk.Algorithm, Key: slices.Clone(pk.Key)}
+	}
+	return uc
+}
+
+// Addresses returns every address the wallet considers its own: the wallet's
+// address followed by the addresses of any additional keys and any watched
+// addresses, sorted.
+func (sw *SingleAddressWallet) Addresses() []types.Address {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	watched := make([]types.Address, 0, len(sw.keys)+len(sw.watched))
+	for addr := range sw.keys {
+		watched = append(watched, addr)
+	}
+	for addr := range sw.watched {
+		watched = append(watched, addr)
+	}
+	slices.SortFunc(watched, func(a, b types.Address) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	return append([]types.Address{sw.addr}, watched...)
+}
+
+// UnlockConditions returns the unlock conditions of the wallet. The returned
+// value is shared and must not be modified.
 func (sw *SingleAddressWallet) UnlockConditions() types.UnlockConditions {
-	return types.StandardUnlockConditions(sw.priv.PublicKey())
+	return sw.uc
+}
+
+// Confirmations returns the number of blocks that have confirmed the unspent
+// siacoin output with the given ID, including the block that created it.
+// Outputs created by transactions in the pool have zero confirmations. The
+// store must implement OutputIndexStore.
+func (sw *SingleAddressWallet) Confirmations(id types.SiacoinOutputID) (uint64, error) {
+	is, ok := sw.store.(OutputIndexStore)
+	if !ok {
+		return 0, ErrStoreUnsupported
+	}
+
+	index, err := is.WalletSiacoinElementIndex(id)
+	if errors.Is(err, ErrNotFound) {
+		for _, txn := range sw.cm.PoolTransactions() {
+			for i := range txn.SiacoinOutputs {
+				if txn.SiacoinOutputID(i) == id {
+					return 0, nil
+				}
+			}
+		}
+		for _, txn := range sw.cm.V2PoolTransactions() {
+			for i := range txn.SiacoinOutputs {
+				if txn.SiacoinOutputID(txn.ID(), i) == id {
+					return 0, nil
+				}
+			}
+		}
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get element index: %w", err)
+	}
+
+	tip, err := sw.storeTip()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get wallet tip: %w", err)
+	} else if tip.Height < index.Height {
+		return 0, nil
+	}
+	return tip.Height - index.Height + 1, nil
+}
+
+// ConfirmationIndex returns the index of the block that confirmed the
+// wallet transaction with the given ID. ok is false if the transaction is
+// unconfirmed or not relevant to the wallet. The wallet's events are scanned
+// until the transaction is found.
+func (sw *SingleAddressWallet) ConfirmationIndex(id types.TransactionID) (index types.ChainIndex, ok bool, err error) {
+	events, err := sw.eventsIter(context.Background())
+	if err != nil {
+		return types.ChainIndex{}, false, fmt.Errorf("failed to get events: %w", err)
+	}
+	for ev, err := range events {
+		if err != nil {
+			return types.ChainIndex{}, false, err
+		} else if ev.ID != types.Hash256(id) {
+			continue
+		}
+		switch ev.Type {
+		case EventTypeV1Transaction, EventTypeV2Transaction:
+			return ev.Index, true, nil
+		}
+	}
+	return types.ChainIndex{}, false, nil
+}
+
+// SuspiciousOutputs returns the unspent outputs whose maturity height is more
+// than maxFutureBlocks beyond the wallet's tip. Such outputs are unlikely to
+// ever mature on the current chain and usually indicate a corrupted store.
+func (sw *SingleAddressWallet) SuspiciousOutputs(maxFutureBlocks uint64) ([]types.SiacoinElement, error) {
+	tip, err := sw.storeTip()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallet tip: %w", err)
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+
+	var suspicious []types.SiacoinElement
+	for _, sce := range elements {
+		if sce.MaturityHeight > tip.Height && sce.MaturityHeight-tip.Height > maxFutureBlocks {
+			suspicious = append(suspicious, sce)
+		}
+	}
+	return suspicious, nil
 }
 
 // UnspentSiacoinElements returns the wallet's unspent siacoin outputs.
 func (sw *SingleAddressWallet) UnspentSiacoinElements() ([]types.SiacoinElement, error) {
-	return sw.store.UnspentSiacoinElements()
+	return sw.unspentSiacoinElements()
+}
+
+// tipState returns the chain manager's current tip state. ErrChainNotReady is
+// returned if the chain manager has not loaded a chain state yet.
+func (sw *SingleAddressWallet) tipState() (consensus.State, error) {
+	cs := sw.cm.TipState()
+	if cs.Index == (types.ChainIndex{}) {
+		return consensus.State{}, ErrChainNotReady
+	}
+	return cs, nil
+}
+
+// fundingState returns the chain manager's current tip state for building a
+// transaction. If WithRequireSynced is set, ErrNotSynced is returned when
+// the store's tip lags the chain manager's by more than the allowed number
+// of blocks.
+func (sw *SingleAddressWallet) fundingState() (consensus.State, error) {
+	cs, err := sw.tipState()
+	if err != nil || !sw.cfg.RequireSynced {
+		return cs, err
+	}
+	tip, err := sw.storeTip()
+	if err != nil {
+		return consensus.State{}, fmt.Errorf("failed to get wallet tip: %w", err)
+	} else if tip.Height+sw.cfg.MaxSyncLag < cs.Index.Height {
+		return consensus.State{}, fmt.Errorf("wallet is at height %d, chain is at height %d: %w", tip.Height, cs.Index.Height, ErrNotSynced)
+	} else if err := sw.checkTip(tip); err != nil {
+		return consensus.State{}, fmt.Errorf("%w: %w", ErrNotSynced, err)
+	}
+	return cs, nil
+}
+
+// ConsistencyCheck checks that the store's tip is on the chain manager's best
+// chain by comparing both the height and the block ID. A height-only
+// comparison would miss a reorg the store has not yet processed, where the
+// store's tip has the same height as a block on the best chain but a
+// different ID. ErrTipMismatch is returned if the store must process a reorg.
+func (sw *SingleAddressWallet) ConsistencyCheck() error {
+	tip, err := sw.storeTip()
+	if err != nil {
+		return fmt.Errorf("failed to get wallet tip: %w", err)
+	}
+	return sw.checkTip(tip)
+}
+
+// checkTip returns ErrTipMismatch if tip is not on the chain manager's best
+// chain. A store that has not processed any blocks is always consistent.
+func (sw *SingleAddressWallet) checkTip(tip types.ChainIndex) error {
+	if tip == (types.ChainIndex{}) {
+		return nil
+	}
+	index, ok := sw.cm.BestIndex(tip.Height)
+	if !ok {
+		return fmt.Errorf("store tip %v is above the best chain's tip %v: %w", tip, sw.cm.TipState().Index, ErrTipMismatch)
+	} else if index != tip {
+		return fmt.Errorf("store tip %v differs from best chain block %v: %w", tip, index, ErrTipMismatch)
+	}
+	return nil
 }
 
 // Balance returns the balance of the wallet.
-func (sw *SingleAddressWallet) Balance() (balance Balance, err error) {
-	outputs, err := sw.store.UnspentSiacoinElements()
+func (sw *SingleAddressWallet) Balance() (Balance, error) {
+	bb, err := sw.balanceBreakdown()
+	return bb.Balance, err
+}
+
+// Overview returns the wallet's address, tip, balance, number of unspent
+// outputs and up to recentEventLimit of its most recent events. The overview
+// is a consistent snapshot of the store at the returned tip: if the store's
+// tip changes while the overview is assembled, it is assembled again, and an
+// error is returned if the tip keeps changing.
+func (sw *SingleAddressWallet) Overview(recentEventLimit int) (WalletOverview, error) {
+	for i := 0; i < overviewAttempts; i++ {
+		tip, err := sw.storeTip()
+		if err != nil {
+			return WalletOverview{}, fmt.Errorf("failed to get wallet tip: %w", err)
+		}
+		bb, err := sw.balanceBreakdown()
+		if err != nil {
+			return WalletOverview{}, fmt.Errorf("failed to get balance: %w", err)
+		}
+		events, err := sw.Events(0, recentEventLimit)
+		if err != nil {
+			return WalletOverview{}, fmt.Errorf("failed to get events: %w", err)
+		}
+
+		if current, err := sw.storeTip(); err != nil {
+			return WalletOverview{}, fmt.Errorf("failed to get wallet tip: %w", err)
+		} else if current != tip {
+			continue // synced while the overview was assembled
+		}
+		return WalletOverview{
+			Address: sw.addr,
+			Tip:     tip,
+			Balance: bb.Balance,
+			UTXOs:   bb.UTXOs,
+			Events:  events,
+		}, nil
+	}
+	return WalletOverview{}, fmt.Errorf("wallet tip changed during each of %d attempts to assemble the overview", overviewAttempts)
+}
+
+// UnconfirmedDependentBalance returns the value of the wallet's unreserved
+// outputs that were created by transactions in the pool. These outputs can
+// be spent by funding with unconfirmed outputs, but they depend on their
+// parent transactions, and any unconfirmed ancestors of those, being
+// confirmed.
+func (sw *SingleAddressWallet) UnconfirmedDependentBalance() (types.Currency, error) {
+	if _, err := sw.tipState(); err != nil {
+		return types.ZeroCurrency, err
+	}
+	_, tpoolUtxos, _ := sw.poolOutputs()
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	var sum types.Currency
+	for _, sce := range tpoolUtxos {
+		if !sw.canSpend(sce.SiacoinOutput.Address) || sw.isLocked(sce.ID) {
+			continue
+		}
+		sum = sum.Add(sce.SiacoinOutput.Value)
+	}
+	return sum, nil
+}
+
+// ReorgStats returns statistics on the depth of the chain reorganizations the
+// wallet has observed since it was created. A reorg is counted when the first
+// block after the fork point is applied, so a reorg whose blocks are reverted
+// and applied across several calls to UpdateChainState counts once.
+func (sw *SingleAddressWallet) ReorgStats() ReorgStatistics {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	stats := sw.reorgs
+	if stats.Count > 0 {
+		stats.MeanDepth = float64(sw.reorgDepthSum) / float64(stats.Count)
+	}
+	return stats
+}
+
+// recordReorg tracks the blocks reverted by a chain update. Once a block is
+// applied, the blocks reverted since the previous applied block are recorded
+// as a single reorg.
+func (sw *SingleAddressWallet) recordReorg(reverted uint64, applied bool) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.reorgDepth += reverted
+	if !applied || sw.reorgDepth == 0 {
+		return
+	}
+	depth := sw.reorgDepth
+	sw.reorgDepth = 0
+	sw.reorgs.Count++
+	sw.reorgDepthSum += depth
+	if depth > sw.reorgs.MaxDepth {
+		sw.reorgs.MaxDepth = depth
+		sw.reorgs.DeepestAt = sw.cfg.Clock()
+	}
+}
+
+// UnconfirmedTotals returns the total value flowing into and out of the
+// wallet across all pool transactions. Outputs created and spent within the
+// pool are intermediate and counted in neither total, so a chain of
+// transactions is treated as a single transfer: inflow is the value of the
+// pool outputs paying the wallet that are not spent by another pool
+// transaction, and outflow is the value of the wallet's confirmed outputs
+// spent by the pool. Like the spendable part of Balance, the totals exclude
+// watch-only addresses.
+func (sw *SingleAddressWallet) UnconfirmedTotals() (inflow, outflow types.Currency, err error) {
+	if _, err := sw.tipState(); err != nil {
+		return types.ZeroCurrency, types.ZeroCurrency, err
+	}
+	confirmed, err := sw.unspentSiacoinElements()
 	if err != nil {
-		return Balance{}, fmt.Errorf("failed to get unspent outputs: %w", err)
+		return types.ZeroCurrency, types.ZeroCurrency, fmt.Errorf("failed to get unspent outputs: %w", err)
 	}
+	tpoolSpent, tpoolUtxos, _ := sw.poolOutputs()
 
-	tpoolSpent := make(map[types.SiacoinOutputID]bool)
-	tpoolUtxos := make(map[types.SiacoinOutputID]types.SiacoinElement)
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	for _, sce := range tpoolUtxos {
+		if sw.canSpend(sce.SiacoinOutput.Address) {
+			inflow = inflow.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	for _, sce := range confirmed {
+		if tpoolSpent[sce.ID] && sw.canSpend(sce.SiacoinOutput.Address) {
+			outflow = outflow.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	return inflow, outflow, nil
+}
+
+// SpendableAfterFeeBudget returns the wallet's spendable balance less
+// feeBudget, the amount set aside to pay for future transaction fees. If the
+// budget exceeds the spendable balance, zero is returned.
+func (sw *SingleAddressWallet) SpendableAfterFeeBudget(feeBudget types.Currency) (types.Currency, error) {
+	balance, err := sw.Balance()
+	if err != nil {
+		return types.ZeroCurrency, err
+	} else if balance.Spendable.Cmp(feeBudget) <= 0 {
+		return types.ZeroCurrency, nil
+	}
+	return balance.Spendable.Sub(feeBudget), nil
+}
+
+// poolOutputs returns the outputs spent by the transaction pool, the pool
+// outputs paying tracked addresses, and the subset of those outputs that are
+// change from transactions spending only the wallet's outputs. Pool outputs
+// spent by another pool transaction are excluded regardless of the order of
+// the pool's transactions.
+func (sw *SingleAddressWallet) poolOutputs() (tpoolSpent map[types.SiacoinOutputID]bool, tpoolUtxos map[types.SiacoinOutputID]types.SiacoinElement, ownChange map[types.SiacoinOutputID]bool) {
+	tracked := sw.trackedAddresses()
+	tpoolSpent = make(map[types.SiacoinOutputID]bool)
+	tpoolUtxos = make(map[types.SiacoinOutputID]types.SiacoinElement)
+	ownChange = make(map[types.SiacoinOutputID]bool)
 	for _, txn := range sw.cm.PoolTransactions() {
+		own := len(txn.SiacoinInputs) > 0
 		for _, sci := range txn.SiacoinInputs {
 			tpoolSpent[sci.ParentID] = true
-			delete(tpoolUtxos, sci.ParentID)
+			own = own && sw.canSpend(sci.UnlockConditions.UnlockHash())
 		}
 		for i, sco := range txn.SiacoinOutputs {
-			if sco.Address != sw.addr {
+			if !tracked[sco.Address] {
 				continue
 			}
 
@@ -134,276 +1099,2915 @@ func (sw *SingleAddressWallet) Balance() (balance Balance, err error) {
 				StateElement:  types.StateElement{LeafIndex: types.UnassignedLeafIndex},
 				SiacoinOutput: sco,
 			}
+			ownChange[outputID] = own && sw.canSpend(sco.Address)
 		}
 	}
 
 	for _, txn := range sw.cm.V2PoolTransactions() {
+		own := len(txn.SiacoinInputs) > 0
 		for _, si := range txn.SiacoinInputs {
 			tpoolSpent[si.Parent.ID] = true
-			delete(tpoolUtxos, si.Parent.ID)
+			own = own && sw.canSpend(si.Parent.SiacoinOutput.Address)
 		}
 		for i, sco := range txn.SiacoinOutputs {
-			if sco.Address != sw.addr {
+			if !tracked[sco.Address] {
 				continue
 			}
 			sce := txn.EphemeralSiacoinOutput(i)
+			ownChange[sce.ID] = own && sw.canSpend(sco.Address)
 			tpoolUtxos[sce.ID] = sce.Move()
 		}
 	}
 
-	sw.mu.Lock()
-	defer sw.mu.Unlock()
-	bh := sw.cm.TipState().Index.Height
-	for _, sco := range outputs {
-		if sco.MaturityHeight > bh {
-			balance.Immature = balance.Immature.Add(sco.SiacoinOutput.Value)
-		} else {
-			balance.Confirmed = balance.Confirmed.Add(sco.SiacoinOutput.Value)
-			if !sw.isLocked(sco.ID) && !tpoolSpent[sco.ID] {
-				balance.Spendable = balance.Spendable.Add(sco.SiacoinOutput.Value)
-			}
+	// a transaction may appear in the pool before its parent
+	for id := range tpoolUtxos {
+		if tpoolSpent[id] {
+			delete(tpoolUtxos, id)
 		}
 	}
+	return
+}
 
+// addPoolBalance adds the value of the pool outputs that are not in
+// confirmed to the balance. This method must be called whilst holding the
+// mutex lock.
+func (sw *SingleAddressWallet) addPoolBalance(balance *Balance, tpoolUtxos map[types.SiacoinOutputID]types.SiacoinElement, ownChange, confirmed map[types.SiacoinOutputID]bool) {
 	for _, sco := range tpoolUtxos {
+		if confirmed[sco.ID] {
+			continue
+		} else if !sw.canSpend(sco.SiacoinOutput.Address) {
+			balance.Watched = balance.Watched.Add(sco.SiacoinOutput.Value)
+			continue
+		} else if sw.cfg.SpendableChange && ownChange[sco.ID] && !sw.isLocked(sco.ID) {
+			// change from the wallet's own transactions can only be
+			// invalidated by the wallet, so it is counted as spendable
+			balance.Spendable = balance.Spendable.Add(sco.SiacoinOutput.Value)
+			continue
+		}
 		balance.Unconfirmed = balance.Unconfirmed.Add(sco.SiacoinOutput.Value)
 	}
+}
+
+// balanceBreakdown returns the balance of the wallet and the number of
+// unspent outputs in the store.
+func (sw *SingleAddressWallet) balanceBreakdown() (bb BalanceBreakdown, err error) {
+	cs, err := sw.tipState()
+	if err != nil {
+		return BalanceBreakdown{}, err
+	}
+
+	outputs, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return BalanceBreakdown{}, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+	return sw.outputsBalanceBreakdown(cs, outputs), nil
+}
+
+// outputsBalanceBreakdown returns the balance of the wallet given its unspent
+// outputs.
+func (sw *SingleAddressWallet) outputsBalanceBreakdown(cs consensus.State, outputs []types.SiacoinElement) (bb BalanceBreakdown) {
+	tpoolSpent, tpoolUtxos, ownChange := sw.poolOutputs()
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	bh := cs.Index.Height
+	for _, sco := range outputs {
+		if !sw.canSpend(sco.SiacoinOutput.Address) {
+			bb.Watched = bb.Watched.Add(sco.SiacoinOutput.Value)
+		} else if sco.MaturityHeight > bh {
+			bb.Immature = bb.Immature.Add(sco.SiacoinOutput.Value)
+		} else {
+			bb.Confirmed = bb.Confirmed.Add(sco.SiacoinOutput.Value)
+			if !sw.isLocked(sco.ID) && !tpoolSpent[sco.ID] {
+				bb.Spendable = bb.Spendable.Add(sco.SiacoinOutput.Value)
+			}
+		}
+	}
+	bb.UTXOs = len(outputs)
+
+	// the store and the pool are read separately, so a transaction the store
+	// has already confirmed may still appear in the pool. Its outputs are
+	// already counted, so they are skipped to avoid counting them twice.
+	confirmed := make(map[types.SiacoinOutputID]bool, len(outputs))
+	for _, sce := range outputs {
+		confirmed[sce.ID] = true
+	}
+	sw.addPoolBalance(&bb.Balance, tpoolUtxos, ownChange, confirmed)
 	return
 }
 
-// Events returns a paginated list of events, ordered by maturity height, descending.
-// If no more events are available, (nil, nil) is returned.
-func (sw *SingleAddressWallet) Events(offset, limit int) ([]Event, error) {
-	return sw.store.WalletEvents(offset, limit)
+// storeBalanceBreakdown returns the balance of the wallet using the store's
+// totals at the height of cs instead of loading its unspent outputs. Unlike
+// balanceBreakdown, pool outputs the store has already confirmed cannot be
+// detected, so they may be counted as unconfirmed until the pool is updated.
+func (sw *SingleAddressWallet) storeBalanceBreakdown(bs BalanceStore, cs consensus.State) (BalanceBreakdown, error) {
+	tpoolSpent, tpoolUtxos, ownChange := sw.poolOutputs()
+
+	// the outputs that are confirmed but not spendable
+	sw.mu.Lock()
+	exclude := make([]types.SiacoinOutputID, 0, len(sw.locked)+len(tpoolSpent))
+	for id := range tpoolSpent {
+		exclude = append(exclude, id)
+	}
+	for id := range sw.locked {
+		if sw.isLocked(id) && !tpoolSpent[id] {
+			exclude = append(exclude, id)
+		}
+	}
+	for id := range sw.pending {
+		if !tpoolSpent[id] {
+			exclude = append(exclude, id)
+		}
+	}
+	sw.mu.Unlock()
+
+	bb, err := sw.walletBalance(bs, cs.Index.Height, exclude)
+	if err != nil {
+		return BalanceBreakdown{}, fmt.Errorf("failed to get store balance: %w", err)
+	}
+	bb.Unconfirmed = types.ZeroCurrency
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.addPoolBalance(&bb.Balance, tpoolUtxos, ownChange, nil)
+	return bb, nil
+}
+
+// recordBalance reports the wallet's balance at the height of cs to br.
+func (sw *SingleAddressWallet) recordBalance(br BalanceRecorder, cs consensus.State) {
+	// the store's totals include the outputs of watched addresses, so they
+	// are only used if there are none
+	sw.mu.Lock()
+	watching := len(sw.watched) > 0
+	sw.mu.Unlock()
+
+	var bb BalanceBreakdown
+	if bs, ok := sw.store.(BalanceStore); ok && !watching {
+		var err error
+		bb, err = sw.storeBalanceBreakdown(bs, cs)
+		if err != nil {
+			sw.log.Warn("failed to compute balance", zap.Error(err))
+			return
+		}
+	} else {
+		outputs, err := sw.unspentSiacoinElements()
+		if err != nil {
+			sw.log.Warn("failed to compute balance", zap.Error(err))
+			return
+		}
+		bb = sw.outputsBalanceBreakdown(cs, outputs)
+	}
+	br.RecordBalance(bb)
+}
+
+// SpendableSchedule returns the wallet's funds grouped by the height at which
+// they become spendable, ordered by height. The first tranche, if any, is at
+// the current height and contains the spendable balance. Each following
+// tranche contains immature outputs that mature at its height. Estimated
+// times assume blocks are found at the wallet's block interval.
+func (sw *SingleAddressWallet) SpendableSchedule() ([]SpendableTranche, error) {
+	cs, err := sw.tipState()
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+	// the spendable and immature tranches are computed from the same outputs
+	// and state, so a payout is counted in exactly one of them
+	balance := sw.outputsBalanceBreakdown(cs, outputs).Balance
+
+	interval := sw.cfg.BlockInterval
+	if interval <= 0 {
+		interval = cs.BlockInterval()
+	}
+	now := sw.cfg.Clock()
+	bh := cs.Index.Height
+
+	var tranches []SpendableTranche
+	if !balance.Spendable.IsZero() {
+		tranches = append(tranches, SpendableTranche{
+			Amount:        balance.Spendable,
+			Height:        bh,
+			EstimatedTime: now,
+		})
+	}
+
+	immature := make(map[uint64]types.Currency)
+	sw.mu.Lock()
+	for _, sce := range outputs {
+		if sce.MaturityHeight > bh && sw.canSpend(sce.SiacoinOutput.Address) {
+			immature[sce.MaturityHeight] = immature[sce.MaturityHeight].Add(sce.SiacoinOutput.Value)
+		}
+	}
+	sw.mu.Unlock()
+	heights := make([]uint64, 0, len(immature))
+	for height := range immature {
+		heights = append(heights, height)
+	}
+	slices.Sort(heights)
+	for _, height := range heights {
+		tranches = append(tranches, SpendableTranche{
+			Amount:        immature[height],
+			Height:        height,
+			EstimatedTime: now.Add(time.Duration(height-bh) * interval),
+		})
+	}
+	return tranches, nil
+}
+
+// Liquidity returns the wallet's spendable balance and the value of the
+// immature outputs that mature next, along with the height at which they
+// mature. If no outputs are immature, availableSoon and soonAt are zero.
+func (sw *SingleAddressWallet) Liquidity() (availableNow, availableSoon types.Currency, soonAt uint64, err error) {
+	cs, err := sw.tipState()
+	if err != nil {
+		return types.ZeroCurrency, types.ZeroCurrency, 0, err
+	}
+	outputs, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return types.ZeroCurrency, types.ZeroCurrency, 0, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+
+	for _, sce := range outputs {
+		switch height := sce.MaturityHeight; {
+		case height <= cs.Index.Height, !sw.canSpend(sce.SiacoinOutput.Address):
+			continue
+		case soonAt == 0 || height < soonAt:
+			soonAt, availableSoon = height, sce.SiacoinOutput.Value
+		case height == soonAt:
+			availableSoon = availableSoon.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	return sw.outputsBalanceBreakdown(cs, outputs).Spendable, availableSoon, soonAt, nil
+}
+
+// Events returns a paginated list of events, ordered by maturity height, descending.
+// If no more events are available, (nil, nil) is returned. If the store
+// implements TransactionReferenceStore, the events of referenced transactions
+// include their reference.
+func (sw *SingleAddressWallet) Events(offset, limit int) ([]Event, error) {
+	events, err := sw.walletEvents(offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	return events, sw.addReferences(events)
+}
+
+// addReferences sets the reference of each transaction event, if the store
+// supports references.
+func (sw *SingleAddressWallet) addReferences(events []Event) error {
+	rs, ok := sw.store.(TransactionReferenceStore)
+	if !ok {
+		return nil
+	}
+	for i, ev := range events {
+		switch ev.Type {
+		case EventTypeV1Transaction, EventTypeV2Transaction:
+		default:
+			continue
+		}
+		ref, err := rs.TransactionReference(types.TransactionID(ev.ID))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to get reference for transaction %v: %w", ev.ID, err)
+		}
+		events[i].Reference = ref
+	}
+	return nil
+}
+
+// SetTransactionReference associates a client-supplied reference, such as
+// an order or invoice ID, with a transaction. The store must implement
+// TransactionReferenceStore.
+func (sw *SingleAddressWallet) SetTransactionReference(id types.TransactionID, reference string) error {
+	rs, ok := sw.store.(TransactionReferenceStore)
+	if !ok {
+		return ErrStoreUnsupported
+	}
+	return rs.SetTransactionReference(id, reference)
+}
+
+// TransactionReference returns the reference associated with a transaction.
+// ErrNotFound is returned if the transaction has no reference.
+func (sw *SingleAddressWallet) TransactionReference(id types.TransactionID) (string, error) {
+	rs, ok := sw.store.(TransactionReferenceStore)
+	if !ok {
+		return "", ErrStoreUnsupported
+	}
+	return rs.TransactionReference(id)
+}
+
+// EventCount returns the total number of events relevant to the wallet.
+func (sw *SingleAddressWallet) EventCount() (uint64, error) {
+	return sw.walletEventCount()
+}
+
+// EventsPage returns a page of events, ordered in the same manner as Events,
+// along with the total number of events and whether more events are available
+// after the page.
+func (sw *SingleAddressWallet) EventsPage(offset, limit int) (EventPage, error) {
+	events, err := sw.Events(offset, limit)
+	if err != nil {
+		return EventPage{}, fmt.Errorf("failed to get events: %w", err)
+	}
+	total, err := sw.walletEventCount()
+	if err != nil {
+		return EventPage{}, fmt.Errorf("failed to get event count: %w", err)
+	}
+	return EventPage{
+		Events:  events,
+		Total:   total,
+		HasMore: uint64(offset+len(events)) < total,
+	}, nil
+}
+
+// eventsIter returns an iterator over all of the wallet's events. If the store
+// does not implement EventIterStore, the events are paginated instead.
+func (sw *SingleAddressWallet) eventsIter(ctx context.Context) (iter.Seq2[Event, error], error) {
+	if es, ok := sw.store.(EventIterStore); ok {
+		seq, err := es.WalletEventsIter(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// the store does not persist the SelfTransfer flag
+		return func(yield func(Event, error) bool) {
+			for ev, err := range seq {
+				if err == nil {
+					ev.SelfTransfer = ev.isSelfTransfer(sw.canSpend)
+				}
+				if !yield(ev, err) {
+					return
+				}
+			}
+		}, nil
+	}
+
+	return func(yield func(Event, error) bool) {
+		for offset := 0; ; {
+			if err := ctx.Err(); err != nil {
+				yield(Event{}, err)
+				return
+			}
+
+			events, err := sw.walletEvents(offset, eventsPageSize)
+			if err != nil {
+				yield(Event{}, fmt.Errorf("failed to get events: %w", err))
+				return
+			}
+			for _, ev := range events {
+				if !yield(ev, nil) {
+					return
+				}
+			}
+			if len(events) < eventsPageSize {
+				return
+			}
+			offset += len(events)
+		}
+	}, nil
+}
+
+// ReplayEvents calls handler for each of the wallet's events confirmed at or
+// after the height of from, in chain order. Events confirmed in the same block
+// are replayed in the order the store applied them, so the order is
+// deterministic for a given store. The events are streamed from the store,
+// which must implement ChainOrderEventStore. If from has a non-zero ID, it
+// must be on the best chain. Replay stops at the first error returned by
+// handler or if ctx is canceled.
+func (sw *SingleAddressWallet) ReplayEvents(ctx context.Context, from types.ChainIndex, handler func(Event) error) error {
+	cs, ok := sw.store.(ChainOrderEventStore)
+	if !ok {
+		return ErrStoreUnsupported
+	} else if from.ID != (types.BlockID{}) {
+		if index, ok := sw.cm.BestIndex(from.Height); !ok || index != from {
+			return fmt.Errorf("index %v is not on the best chain", from)
+		}
+	}
+
+	events, err := cs.WalletEventsSince(ctx, from.Height)
+	if err != nil {
+		return fmt.Errorf("failed to get events: %w", err)
+	}
+	for ev, err := range events {
+		if err != nil {
+			return err
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		// the store does not persist the SelfTransfer flag
+		ev.SelfTransfer = ev.isSelfTransfer(sw.canSpend)
+		if err := handler(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EventFee returns the fee paid by the transaction in ev. ok is false if ev
+// is not a transaction that spent the wallet's outputs, such as a payment
+// received by the wallet.
+func EventFee(ev Event) (fp FeePoint, ok bool) {
+	if ev.SiacoinOutflow().IsZero() {
+		return FeePoint{}, false
+	}
+	// transaction weights do not depend on the consensus state
+	var cs consensus.State
+	var weight uint64
+	switch data := ev.Data.(type) {
+	case EventV1Transaction:
+		fp.Fee = minerFees(data.Transaction)
+		weight = cs.TransactionWeight(data.Transaction)
+	case EventV2Transaction:
+		fp.Fee = data.MinerFee
+		weight = cs.V2TransactionWeight(types.V2Transaction(data))
+	default:
+		return FeePoint{}, false
+	}
+	fp.ID = types.TransactionID(ev.ID)
+	fp.Height = ev.Index.Height
+	if weight > 0 {
+		fp.FeePerByte = fp.Fee.Div64(weight)
+	}
+	return fp, true
+}
+
+// FeeHistory returns a paginated list of the fees paid by the wallet's
+// confirmed transactions, most recent first. Transactions that only paid the
+// wallet are omitted.
+func (sw *SingleAddressWallet) FeeHistory(offset, limit int) ([]FeePoint, error) {
+	if fs, ok := sw.store.(FeeStore); ok {
+		return fs.WalletFeeHistory(offset, limit)
+	}
+
+	events, err := sw.eventsIter(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get events: %w", err)
+	}
+	var points []FeePoint
+	for ev, err := range events {
+		if err != nil {
+			return nil, err
+		} else if len(points) >= limit {
+			break
+		}
+		fp, ok := EventFee(ev)
+		if !ok {
+			continue
+		} else if offset > 0 {
+			offset--
+			continue
+		}
+		points = append(points, fp)
+	}
+	return points, nil
+}
+
+// EventOutputIDs returns the IDs of the siacoin outputs created by the
+// event, including outputs that are not relevant to the wallet.
+func EventOutputIDs(ev Event) []types.SiacoinOutputID {
+	switch data := ev.Data.(type) {
+	case EventPayout:
+		return []types.SiacoinOutputID{data.SiacoinElement.ID}
+	case EventV1ContractResolution:
+		return []types.SiacoinOutputID{data.SiacoinElement.ID}
+	case EventV2ContractResolution:
+		return []types.SiacoinOutputID{data.SiacoinElement.ID}
+	case EventV1Transaction:
+		ids := make([]types.SiacoinOutputID, len(data.Transaction.SiacoinOutputs))
+		for i := range ids {
+			ids[i] = data.Transaction.SiacoinOutputID(i)
+		}
+		return ids
+	case EventV2Transaction:
+		txn := types.V2Transaction(data)
+		ids := make([]types.SiacoinOutputID, len(txn.SiacoinOutputs))
+		for i := range ids {
+			ids[i] = txn.EphemeralSiacoinOutput(i).ID
+		}
+		return ids
+	}
+	return nil
+}
+
+// OutputSource returns the type of the event that created the siacoin output
+// with the given ID, such as EventTypeMinerPayout for a miner payout or
+// EventTypeV1Transaction for a payment. ErrNotFound is returned if none of
+// the wallet's confirmed events created the output.
+func (sw *SingleAddressWallet) OutputSource(id types.SiacoinOutputID) (string, error) {
+	if ss, ok := sw.store.(OutputSourceStore); ok {
+		return ss.WalletSiacoinElementSource(id)
+	}
+
+	events, err := sw.eventsIter(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("failed to get events: %w", err)
+	}
+	for ev, err := range events {
+		if err != nil {
+			return "", err
+		} else if slices.Contains(EventOutputIDs(ev), id) {
+			return ev.Type, nil
+		}
+	}
+	return "", fmt.Errorf("output %v: %w", id, ErrNotFound)
+}
+
+// HasTransactedWith returns true if addr appears in the inputs or outputs of
+// any of the wallet's events. If the store implements CounterpartyStore, the
+// query is delegated to the store; otherwise, every event is scanned.
+func (sw *SingleAddressWallet) HasTransactedWith(addr types.Address) (bool, error) {
+	if cs, ok := sw.store.(CounterpartyStore); ok {
+		return cs.WalletHasTransactedWith(addr)
+	}
+
+	events, err := sw.eventsIter(context.Background())
+	if err != nil {
+		return false, fmt.Errorf("failed to get events: %w", err)
+	}
+	for ev, err := range events {
+		if err != nil {
+			return false, err
+		} else if involvesAddress(ev.Data, addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TransactionEffect returns the effect of the transaction with the given ID on
+// the wallet: the value of its outputs paid to the wallet, the value of the
+// wallet's outputs it spends, and its miner fee. The transaction pool is
+// checked first, followed by the wallet's events. ErrNotFound is returned if
+// the transaction is unknown.
+func (sw *SingleAddressWallet) TransactionEffect(id types.TransactionID) (inflow, outflow, fee types.Currency, err error) {
+	effect := func(ev Event) (types.Currency, types.Currency, types.Currency, bool) {
+		if ev.ID != types.Hash256(id) {
+			return types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency, false
+		}
+		switch data := ev.Data.(type) {
+		case EventV1Transaction:
+			return ev.SiacoinInflow(), ev.SiacoinOutflow(), minerFees(data.Transaction), true
+		case EventV2Transaction:
+			return ev.SiacoinInflow(), ev.SiacoinOutflow(), data.MinerFee, true
+		}
+		return types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency, false
+	}
+
+	unconfirmed, err := sw.UnconfirmedEvents()
+	if err != nil {
+		return types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency, fmt.Errorf("failed to get unconfirmed events: %w", err)
+	}
+	for _, ev := range unconfirmed {
+		if inflow, outflow, fee, ok := effect(ev); ok {
+			return inflow, outflow, fee, nil
+		}
+	}
+
+	events, err := sw.eventsIter(context.Background())
+	if err != nil {
+		return types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency, fmt.Errorf("failed to get events: %w", err)
+	}
+	for ev, err := range events {
+		if err != nil {
+			return types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency, err
+		} else if inflow, outflow, fee, ok := effect(ev); ok {
+			return inflow, outflow, fee, nil
+		}
+	}
+	return types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency, fmt.Errorf("transaction %v: %w", id, ErrNotFound)
+}
+
+// involvesAddress returns true if addr appears in the inputs or outputs of the
+// event data.
+func involvesAddress(data EventData, addr types.Address) bool {
+	if len(relevantAddresses(data, map[types.Address]bool{addr: true})) > 0 {
+		return true
+	}
+
+	// v1 events only include the wallet's spent elements, so the other
+	// inputs are identified by their unlock conditions
+	if data, ok := data.(EventV1Transaction); ok {
+		for _, sci := range data.Transaction.SiacoinInputs {
+			if sci.UnlockConditions.UnlockHash() == addr {
+				return true
+			}
+		}
+		for _, sfi := range data.Transaction.SiafundInputs {
+			if sfi.UnlockConditions.UnlockHash() == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ExportEvents writes all of the wallet's events to w as newline-delimited
+// JSON, in the same order as Events. Events are streamed from the store, so
+// the full history is never held in memory if the store implements
+// EventIterStore.
+func (sw *SingleAddressWallet) ExportEvents(ctx context.Context, w io.Writer) error {
+	events, err := sw.eventsIter(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get events: %w", err)
+	}
+
+	enc := json.NewEncoder(w)
+	for ev, err := range events {
+		if err != nil {
+			return err
+		}
+		annotated := []Event{ev}
+		if err := sw.addReferences(annotated); err != nil {
+			return err
+		} else if err := enc.Encode(annotated[0]); err != nil {
+			return fmt.Errorf("failed to encode event %q: %w", ev.ID, err)
+		}
+	}
+	return nil
+}
+
+// FragmentationMetrics returns metrics describing the fragmentation of the
+// wallet's spendable outputs. The metrics are informational and can be used
+// to decide when to consolidate the wallet's outputs.
+func (sw *SingleAddressWallet) FragmentationMetrics() (FragMetrics, error) {
+	utxos, err := sw.SpendableOutputs()
+	if err != nil {
+		return FragMetrics{}, err
+	}
+	if len(utxos) == 0 {
+		return FragMetrics{}, nil
+	}
+
+	threshold := sw.cfg.DustThreshold
+	values := make([]types.Currency, 0, len(utxos))
+	var m FragMetrics
+	for _, sce := range utxos {
+		if sce.SiacoinOutput.Value.Cmp(threshold) < 0 {
+			m.DustOutputs++
+		}
+		values = append(values, sce.SiacoinOutput.Value)
+	}
+	slices.SortFunc(values, func(a, b types.Currency) int { return a.Cmp(b) })
+
+	m.SpendableOutputs = len(utxos)
+	m.DustRatio = float64(m.DustOutputs) / float64(m.SpendableOutputs)
+	if mid := len(values) / 2; len(values)%2 == 1 {
+		m.MedianValue = values[mid]
+	} else {
+		m.MedianValue = values[mid-1].Add(values[mid]).Div64(2)
+	}
+	return m, nil
+}
+
+// SpendableExcludingTag returns the value of the wallet's spendable outputs
+// from the perspective of the caller that reserves outputs with tag: outputs
+// reserved with tag are counted as spendable, while outputs reserved with any
+// other tag, or without a tag, are not. An empty tag matches untagged
+// reservations. Outputs spent by pool transactions are never spendable.
+func (sw *SingleAddressWallet) SpendableExcludingTag(tag string) (types.Currency, error) {
+	cs, err := sw.tipState()
+	if err != nil {
+		return types.ZeroCurrency, err
+	}
+	utxos, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return types.ZeroCurrency, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+	spent := sw.poolSpent()
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	var sum types.Currency
+	for _, sce := range utxos {
+		switch {
+		case !sw.canSpend(sce.SiacoinOutput.Address):
+			continue // watch-only outputs cannot be spent
+		case cs.Index.Height < sce.MaturityHeight, spent[sce.ID], sw.pending[sce.ID]:
+			continue
+		case sw.isLocked(sce.ID) && sw.tags[sce.ID] != tag:
+			continue
+		}
+		sum = sum.Add(sce.SiacoinOutput.Value)
+	}
+	return sum, nil
+}
+
+// SpendableOutputs returns a list of spendable siacoin outputs, a spendable
+// output is an unspent output that's not locked, not currently in the
+// transaction pool, that has matured and that the wallet holds the key for.
+func (sw *SingleAddressWallet) SpendableOutputs() ([]types.SiacoinElement, error) {
+	// grab current height
+	state, err := sw.tipState()
+	if err != nil {
+		return nil, err
+	}
+	bh := state.Index.Height
+
+	// fetch outputs from the store
+	utxos, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, err
+	}
+
+	// fetch outputs currently in the pool
+	inPool := make(map[types.SiacoinOutputID]bool)
+	for _, txn := range sw.cm.PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			inPool[sci.ParentID] = true
+		}
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	// filter outputs that are either locked, in the pool, have not yet matured
+	// or pay a watched address
+	unspent := utxos[:0]
+	for _, sce := range utxos {
+		if sw.isLocked(sce.ID) || inPool[sce.ID] || bh < sce.MaturityHeight || !sw.canSpend(sce.SiacoinOutput.Address) {
+			continue
+		}
+		unspent = append(unspent, sce.Copy())
+	}
+	return unspent, nil
+}
+
+// SpendableOutputCount returns the number of outputs SpendableOutputs would
+// return. If the store implements SpendableCountStore, the outputs are
+// counted by the store instead of being loaded.
+func (sw *SingleAddressWallet) SpendableOutputCount() (int, error) {
+	// the store's count includes the outputs of watched addresses, so it is
+	// only used if there are none
+	sw.mu.Lock()
+	watching := len(sw.watched) > 0
+	sw.mu.Unlock()
+
+	cs, ok := sw.store.(SpendableCountStore)
+	if !ok || watching {
+		utxos, err := sw.SpendableOutputs()
+		return len(utxos), err
+	}
+
+	state, err := sw.tipState()
+	if err != nil {
+		return 0, err
+	}
+
+	// outputs spent in the pool or locked are not spendable
+	var exclude []types.SiacoinOutputID
+	for _, txn := range sw.cm.PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			exclude = append(exclude, sci.ParentID)
+		}
+	}
+	sw.mu.Lock()
+	for id := range sw.locked {
+		if sw.isLocked(id) {
+			exclude = append(exclude, id)
+		}
+	}
+	for id := range sw.pending {
+		exclude = append(exclude, id)
+	}
+	sw.mu.Unlock()
+
+	n, err := cs.SpendableSiacoinElementCount(state.Index.Height, exclude)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count spendable outputs: %w", err)
+	}
+	return n, nil
+}
+
+// unconfirmedPolicy returns the policy corresponding to FundTransaction's
+// useUnconfirmed parameter.
+func unconfirmedPolicy(useUnconfirmed bool) UnconfirmedPolicy {
+	if useUnconfirmed {
+		return UnconfirmedPolicyOnlyIfNeeded
+	}
+	return UnconfirmedPolicyNever
+}
+
+func (sw *SingleAddressWallet) selectUTXOs(amount types.Currency, inputs int, policy UnconfirmedPolicy, maxUnconfirmed, minValue types.Currency, elements []types.SiacoinElement) ([]types.SiacoinElement, types.Currency, error) {
+	if amount.IsZero() {
+		return nil, types.ZeroCurrency, nil
+	}
+
+	tpoolSpent := make(map[types.SiacoinOutputID]bool)
+	tpoolUtxos := make(map[types.SiacoinOutputID]types.SiacoinElement)
+	for _, txn := range sw.cm.PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			tpoolSpent[sci.ParentID] = true
+			delete(tpoolUtxos, sci.ParentID)
+		}
+		for i, sco := range txn.SiacoinOutputs {
+			tpoolUtxos[txn.SiacoinOutputID(i)] = types.SiacoinElement{
+				ID:            txn.SiacoinOutputID(i),
+				StateElement:  types.StateElement{LeafIndex: types.UnassignedLeafIndex},
+				SiacoinOutput: sco,
+			}
+		}
+	}
+	for _, txn := range sw.cm.V2PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			tpoolSpent[sci.Parent.ID] = true
+			delete(tpoolUtxos, sci.Parent.ID)
+		}
+		for i := range txn.SiacoinOutputs {
+			sce := txn.EphemeralSiacoinOutput(i)
+			tpoolUtxos[sce.ID] = sce.Move()
+		}
+	}
+
+	// remove immature, locked and spent outputs
+	cs := sw.cm.TipState()
+	utxos := make([]types.SiacoinElement, 0, len(elements))
+	var usedSum types.Currency
+	var immatureSum types.Currency
+	for _, sce := range elements {
+		if !sw.canSpend(sce.SiacoinOutput.Address) {
+			continue // watch-only outputs cannot be spent
+		} else if used := sw.isLocked(sce.ID) || tpoolSpent[sce.ID]; used {
+			usedSum = usedSum.Add(sce.SiacoinOutput.Value)
+			continue
+		} else if immature := cs.Index.Height < sce.MaturityHeight; immature {
+			immatureSum = immatureSum.Add(sce.SiacoinOutput.Value)
+			continue
+		} else if sce.SiacoinOutput.Value.Cmp(minValue) < 0 {
+			continue
+		}
+		utxos = append(utxos, sce.Share())
+	}
+
+	// minSelected is the minimum number of utxos to select, even if fewer
+	// would cover the amount
+	var minSelected int
+	switch sw.cfg.SelectionMode {
+	case SelectionModePrivacy:
+		minSelected = sw.privacyOrder(utxos, amount)
+	case SelectionModeOldestFirst:
+		// leaf indices are assigned in the order elements are created, so
+		// sorting by leaf index orders the outputs by age.
+		sort.Slice(utxos, func(i, j int) bool {
+			return utxos[i].StateElement.LeafIndex < utxos[j].StateElement.LeafIndex
+		})
+	case SelectionModeAntiFragment:
+		sw.antiFragmentOrder(utxos)
+	default:
+		// sort by value, descending
+		sort.Slice(utxos, func(i, j int) bool {
+			return utxos[i].SiacoinOutput.Value.Cmp(utxos[j].SiacoinOutput.Value) > 0
+		})
+	}
+
+	sw.reserveLargeOutputs(utxos, amount)
+
+	var unconfirmedUTXOs []types.SiacoinElement
+	var unconfirmedSum types.Currency
+	if policy != UnconfirmedPolicyNever {
+		for _, sce := range tpoolUtxos {
+			if !sw.canSpend(sce.SiacoinOutput.Address) || sw.isLocked(sce.ID) || sce.SiacoinOutput.Value.Cmp(minValue) < 0 {
+				continue
+			}
+			unconfirmedUTXOs = append(unconfirmedUTXOs, sce.Share())
+			unconfirmedSum = unconfirmedSum.Add(sce.SiacoinOutput.Value)
+		}
+	}
+
+	// sort by value, descending
+	sort.Slice(unconfirmedUTXOs, func(i, j int) bool {
+		return unconfirmedUTXOs[i].SiacoinOutput.Value.Cmp(unconfirmedUTXOs[j].SiacoinOutput.Value) > 0
+	})
+
+	// fund the transaction using the utxos in order of preference
+	var selected []types.SiacoinElement
+	var inputSum types.Currency
+	for _, sce := range utxos {
+		if inputSum.Cmp(amount) >= 0 && len(selected) >= minSelected {
+			break
+		}
+		selected = append(selected, sce.Share())
+		inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+	}
+	utxos = utxos[len(selected):]
+
+	if inputSum.Cmp(amount) < 0 && policy == UnconfirmedPolicyIsolated {
+		// fund the transaction entirely from unconfirmed utxos. The
+		// remaining confirmed utxos are not defragged, since that would mix
+		// confirmed and unconfirmed inputs.
+		selected, inputSum = nil, types.ZeroCurrency
+		for _, sce := range unconfirmedUTXOs {
+			if v, overflow := inputSum.AddWithOverflow(sce.SiacoinOutput.Value); overflow || v.Cmp(maxUnconfirmed) > 0 {
+				continue
+			}
+			selected = append(selected, sce.Share())
+			inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+			if inputSum.Cmp(amount) >= 0 {
+				return selected, inputSum, nil
+			}
+		}
+		return nil, types.ZeroCurrency, fmt.Errorf("%w: neither confirmed nor unconfirmed inputs cover needed %v (used: %v immature: %v unconfirmed: %v)", ErrNotEnoughFunds, amount.String(), usedSum.String(), immatureSum.String(), unconfirmedSum.String())
+	} else if inputSum.Cmp(amount) < 0 && policy == UnconfirmedPolicyOnlyIfNeeded {
+		// try adding unconfirmed utxos, skipping any that would exceed
+		// maxUnconfirmed
+		var unconfirmedUsed types.Currency
+		for _, sce := range unconfirmedUTXOs {
+			if v, overflow := unconfirmedUsed.AddWithOverflow(sce.SiacoinOutput.Value); overflow || v.Cmp(maxUnconfirmed) > 0 {
+				continue
+			}
+			unconfirmedUsed = unconfirmedUsed.Add(sce.SiacoinOutput.Value)
+			selected = append(selected, sce.Share())
+			inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+			if inputSum.Cmp(amount) >= 0 {
+				break
+			}
+		}
+
+		if inputSum.Cmp(amount) < 0 {
+			// still not enough funds
+			return nil, types.ZeroCurrency, fmt.Errorf("%w: inputs %v < needed %v (used: %v immature: %v unconfirmed: %v)", ErrNotEnoughFunds, inputSum.String(), amount.String(), usedSum.String(), immatureSum.String(), unconfirmedSum.String())
+		}
+	} else if inputSum.Cmp(amount) < 0 {
+		return nil, types.ZeroCurrency, fmt.Errorf("%w: inputs %v < needed %v (used: %v immature: %v", ErrNotEnoughFunds, inputSum.String(), amount.String(), usedSum.String(), immatureSum.String())
+	}
+
+	// check if remaining utxos should be defragged
+	txnInputs := inputs + len(selected)
+	if len(utxos) > sw.cfg.DefragThreshold && txnInputs < sw.cfg.MaxInputsForDefrag {
+		// sort by value, descending, so the smallest utxos are defragged
+		sort.Slice(utxos, func(i, j int) bool {
+			return utxos[i].SiacoinOutput.Value.Cmp(utxos[j].SiacoinOutput.Value) > 0
+		})
+		// add the smallest utxos to the transaction
+		defraggable := utxos
+		if len(defraggable) > sw.cfg.MaxDefragUTXOs {
+			defraggable = defraggable[len(defraggable)-sw.cfg.MaxDefragUTXOs:]
+		}
+		for i := len(defraggable) - 1; i >= 0; i-- {
+			if txnInputs >= sw.cfg.MaxInputsForDefrag {
+				break
+			}
+
+			sce := &defraggable[i]
+			selected = append(selected, sce.Share())
+			inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+			txnInputs++
+		}
+	}
+	return selected, inputSum, nil
+}
+
+// poolSpent returns the set of outputs spent by transactions in the pool.
+func (sw *SingleAddressWallet) poolSpent() map[types.SiacoinOutputID]bool {
+	spent := make(map[types.SiacoinOutputID]bool)
+	for _, txn := range sw.cm.PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			spent[sci.ParentID] = true
+		}
+	}
+	for _, txn := range sw.cm.V2PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			spent[sci.Parent.ID] = true
+		}
+	}
+	return spent
+}
+
+// selectUnconflictedUTXOs selects utxos like selectUTXOs, then checks the
+// transaction pool again immediately before the caller reserves them. A pool
+// transaction may have spent a selected output after selection began; if so,
+// the conflicting outputs are excluded and selection is repeated. ErrOutputConflict
+// is returned if the conflict persists after maxConflictRetries attempts.
+// This method must be called whilst holding the mutex lock.
+func (sw *SingleAddressWallet) selectUnconflictedUTXOs(amount types.Currency, inputs int, policy UnconfirmedPolicy, maxUnconfirmed, minValue types.Currency, elements []types.SiacoinElement) ([]types.SiacoinElement, types.Currency, error) {
+	for i := 0; i < maxConflictRetries; i++ {
+		selected, inputSum, err := sw.selectUTXOs(amount, inputs, policy, maxUnconfirmed, minValue, elements)
+		if err != nil {
+			return nil, types.ZeroCurrency, err
+		}
+		spent := sw.poolSpent()
+		conflicts := make(map[types.SiacoinOutputID]bool)
+		for _, sce := range selected {
+			if spent[sce.ID] {
+				conflicts[sce.ID] = true
+			}
+		}
+		if len(conflicts) == 0 {
+			return selected, inputSum, nil
+		}
+		elements = slices.DeleteFunc(slices.Clone(elements), func(sce types.SiacoinElement) bool { return conflicts[sce.ID] })
+	}
+	return nil, types.ZeroCurrency, ErrOutputConflict
+}
+
+// reserveLargeOutputs moves the outputs kept in reserve by
+// WithReserveLargeOutputs to the end of utxos, preserving the order of the
+// other outputs, if the other outputs are sufficient to cover amount.
+// Otherwise, the order is left unchanged so the reserve is spent as
+// efficiently as the selection mode allows.
+func (sw *SingleAddressWallet) reserveLargeOutputs(utxos []types.SiacoinElement, amount types.Currency) {
+	if sw.cfg.LargeOutputReserve <= 0 {
+		return
+	}
+
+	large := make([]types.SiacoinElement, 0, len(utxos))
+	for _, sce := range utxos {
+		if sce.SiacoinOutput.Value.Cmp(sw.cfg.LargeOutputMinValue) >= 0 {
+			large = append(large, sce.Share())
+		}
+	}
+	sort.Slice(large, func(i, j int) bool {
+		return large[i].SiacoinOutput.Value.Cmp(large[j].SiacoinOutput.Value) > 0
+	})
+	if len(large) > sw.cfg.LargeOutputReserve {
+		large = large[:sw.cfg.LargeOutputReserve]
+	}
+	reserved := make(map[types.SiacoinOutputID]bool, len(large))
+	for _, sce := range large {
+		reserved[sce.ID] = true
+	}
+
+	var available types.Currency
+	for _, sce := range utxos {
+		if !reserved[sce.ID] {
+			available = available.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	if available.Cmp(amount) < 0 {
+		return
+	}
+	slices.SortStableFunc(utxos, func(a, b types.SiacoinElement) int {
+		switch {
+		case reserved[a.ID] == reserved[b.ID]:
+			return 0
+		case reserved[a.ID]:
+			return 1
+		default:
+			return -1
+		}
+	})
+}
+
+// inputsNeeded returns the number of elements, taken in order, needed to
+// reach amount, or -1 if the elements are insufficient.
+func inputsNeeded(utxos []types.SiacoinElement, amount types.Currency) int {
+	var sum types.Currency
+	for i, sce := range utxos {
+		sum = sum.Add(sce.SiacoinOutput.Value)
+		if sum.Cmp(amount) >= 0 {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// antiFragmentOrder orders utxos for SelectionModeAntiFragment. If the number
+// of utxos exceeds the high-water mark, up to MaxDefragUTXOs of the smallest
+// utxos are placed first, in ascending order, followed by the remaining utxos
+// in descending order. Otherwise, the utxos are sorted in descending order.
+func (sw *SingleAddressWallet) antiFragmentOrder(utxos []types.SiacoinElement) {
+	// sort by value, descending
+	sort.Slice(utxos, func(i, j int) bool {
+		return utxos[i].SiacoinOutput.Value.Cmp(utxos[j].SiacoinOutput.Value) > 0
+	})
+	if len(utxos) <= sw.cfg.AntiFragmentThreshold {
+		return
+	}
+
+	// move the smallest utxos to the front, smallest first
+	n := min(sw.cfg.MaxDefragUTXOs, len(utxos))
+	small := slices.Clone(utxos[len(utxos)-n:])
+	slices.Reverse(small)
+	copy(utxos[n:], utxos[:len(utxos)-n])
+	copy(utxos, small)
+}
+
+// privacyOrder orders utxos randomly for SelectionModePrivacy and returns the
+// number of utxos that should be selected. The count is chosen randomly
+// between the minimum number of utxos required to reach amount and
+// privacyMaxExtraInputs more than that. If no random ordering reaches amount
+// within that count, utxos are sorted by value, descending. This method must
+// be called whilst holding the mutex lock.
+func (sw *SingleAddressWallet) privacyOrder(utxos []types.SiacoinElement, amount types.Currency) int {
+	sortDesc := func() {
+		sort.Slice(utxos, func(i, j int) bool {
+			return utxos[i].SiacoinOutput.Value.Cmp(utxos[j].SiacoinOutput.Value) > 0
+		})
+	}
+
+	sortDesc()
+	minInputs := inputsNeeded(utxos, amount)
+	if minInputs == -1 {
+		return 0 // not enough funds, selection will fail
+	}
+	target := min(minInputs+sw.cfg.RNG.Intn(privacyMaxExtraInputs+1), len(utxos))
+	for attempt := 0; attempt < privacyShuffleAttempts; attempt++ {
+		sw.cfg.RNG.Shuffle(len(utxos), func(i, j int) {
+			utxos[i], utxos[j] = utxos[j], utxos[i]
+		})
+		if n := inputsNeeded(utxos, amount); n != -1 && n <= target {
+			return target
+		}
+	}
+	sortDesc()
+	return minInputs
+}
+
+// FundTransaction adds siacoin inputs worth at least amount plus any miner fees
+// already present in the transaction. If necessary, a change output will also
+// be added. The inputs will not be available to future calls to
+// FundTransaction unless ReleaseInputs is called.
+func (sw *SingleAddressWallet) FundTransaction(txn *types.Transaction, amount types.Currency, useUnconfirmed bool) ([]types.Hash256, error) {
+	res, err := sw.FundTransactionDetailed(txn, amount, useUnconfirmed)
+	return res.ToSign, err
+}
+
+// FundTransactionDetailed funds the transaction in the same manner as
+// FundTransaction. The returned result includes the index of the change
+// output, if one was added, which is determined by the wallet's configured
+// ChangePosition or canonical output ordering.
+func (sw *SingleAddressWallet) FundTransactionDetailed(txn *types.Transaction, amount types.Currency, useUnconfirmed bool) (FundResult, error) {
+	return sw.fundTransaction(txn, amount, unconfirmedPolicy(useUnconfirmed), types.MaxCurrency, types.ZeroCurrency, "")
+}
+
+// FundTransactionWithPolicy funds the transaction in the same manner as
+// FundTransaction, using policy to determine whether outputs created by
+// unconfirmed transactions may be spent.
+func (sw *SingleAddressWallet) FundTransactionWithPolicy(txn *types.Transaction, amount types.Currency, policy UnconfirmedPolicy) ([]types.Hash256, error) {
+	res, err := sw.fundTransaction(txn, amount, policy, types.MaxCurrency, types.ZeroCurrency, "")
+	return res.ToSign, err
+}
+
+// FundTransactionWithTag funds the transaction in the same manner as
+// FundTransaction. The tag is recorded in the reservation log alongside the
+// reserved outputs, making it possible to trace which caller reserved them.
+func (sw *SingleAddressWallet) FundTransactionWithTag(txn *types.Transaction, amount types.Currency, useUnconfirmed bool, tag string) ([]types.Hash256, error) {
+	res, err := sw.fundTransaction(txn, amount, unconfirmedPolicy(useUnconfirmed), types.MaxCurrency, types.ZeroCurrency, tag)
+	return res.ToSign, err
+}
+
+// FundTransactionWithUnconfirmedCap funds the transaction from confirmed
+// outputs first, covering any shortfall with unconfirmed outputs worth at
+// most maxUnconfirmed in total. ErrNotEnoughFunds is returned if the amount
+// cannot be reached within the cap.
+func (sw *SingleAddressWallet) FundTransactionWithUnconfirmedCap(txn *types.Transaction, amount, maxUnconfirmed types.Currency) ([]types.Hash256, error) {
+	res, err := sw.fundTransaction(txn, amount, UnconfirmedPolicyOnlyIfNeeded, maxUnconfirmed, types.ZeroCurrency, "")
+	return res.ToSign, err
+}
+
+// FundTransactionMinValue funds the transaction in the same manner as
+// FundTransaction, but never selects outputs worth less than minOutputValue,
+// including for defragmentation. ErrNotEnoughFunds is returned if the
+// remaining outputs cannot cover the amount.
+func (sw *SingleAddressWallet) FundTransactionMinValue(txn *types.Transaction, amount types.Currency, useUnconfirmed bool, minOutputValue types.Currency) ([]types.Hash256, error) {
+	res, err := sw.fundTransaction(txn, amount, unconfirmedPolicy(useUnconfirmed), types.MaxCurrency, minOutputValue, "")
+	if errors.Is(err, ErrNotEnoughFunds) {
+		return nil, fmt.Errorf("excluding outputs below %v: %w", minOutputValue, err)
+	}
+	return res.ToSign, err
+}
+
+// FundTransactionInclude funds the transaction in the same manner as
+// FundTransaction, but always spends the outputs in mustInclude. If their
+// value does not cover the amount, additional inputs are selected as usual.
+// ErrNotFound is returned if a listed output is not a spendable output of the
+// wallet and ErrOutputLocked if it is already reserved or spent in the pool.
+func (sw *SingleAddressWallet) FundTransactionInclude(txn *types.Transaction, amount types.Currency, useUnconfirmed bool, mustInclude []types.SiacoinOutputID) ([]types.Hash256, error) {
+	amount = amount.Add(minerFees(*txn))
+	state, err := sw.fundingState()
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, err
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	included, includedSum, rest, err := sw.requiredElements(state, elements, mustInclude)
+	if err != nil {
+		return nil, err
+	}
+
+	// top up from the remaining outputs if necessary
+	selected, inputSum := included, includedSum
+	if includedSum.Cmp(amount) < 0 {
+		extra, extraSum, err := sw.selectUnconflictedUTXOs(amount.Sub(includedSum), len(txn.SiacoinInputs)+len(included), unconfirmedPolicy(useUnconfirmed), types.MaxCurrency, types.ZeroCurrency, rest)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, extra...)
+		inputSum = inputSum.Add(extraSum)
+	}
+	res, err := sw.addSiacoinInputs(txn, amount, selected, inputSum, "")
+	return res.ToSign, err
+}
+
+// FundTransactionPreferSource funds the transaction in the same manner as
+// FundTransaction, but spends outputs the wallet received from preferFrom
+// first, largest first, e.g. to refund a customer with the outputs they
+// sent. An output was received from preferFrom if the transaction that
+// created it spent one of preferFrom's outputs. If those outputs do not
+// cover the amount, the remainder is selected as usual.
+func (sw *SingleAddressWallet) FundTransactionPreferSource(txn *types.Transaction, amount types.Currency, preferFrom types.Address, useUnconfirmed bool) ([]types.Hash256, error) {
+	amount = amount.Add(minerFees(*txn))
+	if amount.IsZero() {
+		return nil, nil
+	}
+	state, err := sw.fundingState()
+	if err != nil {
+		return nil, err
+	}
+
+	received, err := sw.outputsReceivedFrom(preferFrom)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, err
+	}
+	tpoolSpent, _, _ := sw.poolOutputs()
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	var preferred, rest []types.SiacoinElement
+	for _, sce := range elements {
+		if received[sce.ID] && sw.canSpend(sce.SiacoinOutput.Address) && !sw.isLocked(sce.ID) && !tpoolSpent[sce.ID] && state.Index.Height >= sce.MaturityHeight {
+			preferred = append(preferred, sce.Share())
+		} else {
+			rest = append(rest, sce.Share())
+		}
+	}
+	slices.SortFunc(preferred, func(a, b types.SiacoinElement) int {
+		return b.SiacoinOutput.Value.Cmp(a.SiacoinOutput.Value)
+	})
+
+	var selected []types.SiacoinElement
+	var inputSum types.Currency
+	for _, sce := range preferred {
+		if inputSum.Cmp(amount) >= 0 {
+			break
+		}
+		selected = append(selected, sce)
+		inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+	}
+
+	// top up from the remaining outputs if necessary
+	if inputSum.Cmp(amount) < 0 {
+		extra, extraSum, err := sw.selectUnconflictedUTXOs(amount.Sub(inputSum), len(txn.SiacoinInputs)+len(selected), unconfirmedPolicy(useUnconfirmed), types.MaxCurrency, types.ZeroCurrency, rest)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, extra...)
+		inputSum = inputSum.Add(extraSum)
+	}
+	res, err := sw.addSiacoinInputs(txn, amount, selected, inputSum, "")
+	return res.ToSign, err
+}
+
+// outputsReceivedFrom returns the IDs of the outputs created by the wallet's
+// transaction events that spent one of addr's siacoin outputs.
+func (sw *SingleAddressWallet) outputsReceivedFrom(addr types.Address) (map[types.SiacoinOutputID]bool, error) {
+	events, err := sw.eventsIter(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get events: %w", err)
+	}
+	received := make(map[types.SiacoinOutputID]bool)
+	for ev, err := range events {
+		if err != nil {
+			return nil, err
+		}
+
+		var spent bool
+		switch data := ev.Data.(type) {
+		case EventV1Transaction:
+			spent = slices.ContainsFunc(data.Transaction.SiacoinInputs, func(sci types.SiacoinInput) bool {
+				return sci.UnlockConditions.UnlockHash() == addr
+			})
+		case EventV2Transaction:
+			spent = slices.ContainsFunc(data.SiacoinInputs, func(sci types.V2SiacoinInput) bool {
+				return sci.Parent.SiacoinOutput.Address == addr
+			})
+		}
+		if !spent {
+			continue
+		}
+		for _, id := range EventOutputIDs(ev) {
+			received[id] = true
+		}
+	}
+	return received, nil
+}
+
+// FundTransactionExact funds the transaction using exactly the outputs in
+// inputs, in the order given, so the resulting transaction is deterministic.
+// The amount to fund is the sum of the transaction's siacoin outputs, file
+// contract payouts and miner fees, less the value of any inputs already
+// present, which must spend outputs known to the wallet. Any excess is sent to
+// changeTo, or to the wallet's address if changeTo is the void address; the
+// change output is placed according to the wallet's change position.
+// ErrNotFound is returned if a listed output is not a spendable output of the
+// wallet, ErrOutputLocked if it is already reserved, spent in the pool or
+// spent by the transaction, and ErrNotEnoughFunds if the outputs do not cover
+// the amount.
+func (sw *SingleAddressWallet) FundTransactionExact(txn *types.Transaction, inputs []types.SiacoinOutputID, changeTo types.Address) ([]types.Hash256, error) {
+	amount := minerFees(*txn)
+	for _, sco := range txn.SiacoinOutputs {
+		amount = amount.Add(sco.Value)
+	}
+	for _, fc := range txn.FileContracts {
+		amount = amount.Add(fc.Payout)
+	}
+	if changeTo == types.VoidAddress {
+		changeTo = sw.addr
+	}
+
+	state, err := sw.fundingState()
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, err
+	}
+
+	// the inputs already present are credited against the amount and cannot
+	// be listed again
+	values := make(map[types.SiacoinOutputID]types.Currency, len(elements))
+	for _, sce := range elements {
+		values[sce.ID] = sce.SiacoinOutput.Value
+	}
+	var credit types.Currency
+	present := make(map[types.SiacoinOutputID]bool, len(txn.SiacoinInputs))
+	for _, sci := range txn.SiacoinInputs {
+		value, ok := values[sci.ParentID]
+		if !ok {
+			return nil, fmt.Errorf("input %v: %w", sci.ParentID, ErrNotFound)
+		}
+		present[sci.ParentID] = true
+		credit = credit.Add(value)
+	}
+	for _, id := range inputs {
+		if present[id] {
+			return nil, fmt.Errorf("output %v is already spent by the transaction: %w", id, ErrOutputLocked)
+		}
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	selected, inputSum, _, err := sw.requiredElements(state, elements, inputs)
+	if err != nil {
+		return nil, err
+	}
+	inputSum = inputSum.Add(credit)
+	if inputSum.Cmp(amount) < 0 {
+		return nil, fmt.Errorf("%w: inputs %v < needed %v", ErrNotEnoughFunds, inputSum, amount)
+	} else if err := sw.checkReservationLimit(len(selected)); err != nil {
+		return nil, err
+	}
+
+	if inputSum.Cmp(amount) > 0 {
+		outputs, _, err := sw.insertChange(txn.SiacoinOutputs, types.SiacoinOutput{
+			Value:   inputSum.Sub(amount),
+			Address: changeTo,
+		})
+		if err != nil {
+			return nil, err
+		}
+		txn.SiacoinOutputs = outputs
+	}
+	toSign := make([]types.Hash256, 0, len(selected))
+	for _, sce := range selected {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			ParentID:         sce.ID,
+			UnlockConditions: sw.unlockConditionsFor(sce.SiacoinOutput.Address),
+		})
+		toSign = append(toSign, types.Hash256(sce.ID))
+	}
+	sw.reserve(selected, "")
+	return toSign, nil
+}
+
+// requiredElements returns the elements with the given IDs, in the order
+// given, along with their total value and the remaining elements. Duplicate
+// IDs are included once. ErrNotFound is returned if an ID is not a spendable
+// output of the wallet and ErrOutputLocked if it is reserved or spent in the
+// pool. This method must be called whilst holding the mutex lock.
+func (sw *SingleAddressWallet) requiredElements(state consensus.State, elements []types.SiacoinElement, ids []types.SiacoinOutputID) (required []types.SiacoinElement, sum types.Currency, rest []types.SiacoinElement, err error) {
+	inPool := sw.poolSpent()
+	wanted := make(map[types.SiacoinOutputID]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	found := make(map[types.SiacoinOutputID]types.SiacoinElement, len(ids))
+	rest = make([]types.SiacoinElement, 0, len(elements))
+	for _, sce := range elements {
+		if !wanted[sce.ID] {
+			rest = append(rest, sce)
+			continue
+		} else if !sw.canSpend(sce.SiacoinOutput.Address) || state.Index.Height < sce.MaturityHeight {
+			continue // reported as not found below
+		} else if sw.isLocked(sce.ID) || inPool[sce.ID] {
+			return nil, types.ZeroCurrency, nil, fmt.Errorf("output %v: %w", sce.ID, ErrOutputLocked)
+		}
+		found[sce.ID] = sce
+	}
+	for _, id := range ids {
+		sce, ok := found[id]
+		if !ok {
+			if !wanted[id] {
+				continue // duplicate
+			}
+			return nil, types.ZeroCurrency, nil, fmt.Errorf("output %v: %w", id, ErrNotFound)
+		}
+		delete(found, id)
+		delete(wanted, id)
+		required = append(required, sce.Share())
+		sum = sum.Add(sce.SiacoinOutput.Value)
+	}
+	return required, sum, rest, nil
+}
+
+// fundTransaction funds the transaction, recording tag in the reservation log.
+// At most maxUnconfirmed worth of unconfirmed outputs are selected, and
+// outputs worth less than minValue are never selected.
+func (sw *SingleAddressWallet) fundTransaction(txn *types.Transaction, amount types.Currency, policy UnconfirmedPolicy, maxUnconfirmed, minValue types.Currency, tag string) (FundResult, error) {
+	amount = amount.Add(minerFees(*txn))
+	if amount.IsZero() {
+		return FundResult{ChangeIndex: -1}, nil
+	} else if _, err := sw.fundingState(); err != nil {
+		return FundResult{}, err
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return FundResult{}, err
+	}
+
+	// the elements are fetched outside of the lock, so concurrent calls may
+	// share the same snapshot. Selection checks the reservations while
+	// holding the lock and the selected outputs are reserved before it is
+	// released, so an output reserved by another call is always skipped.
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	selected, inputSum, err := sw.selectUnconflictedUTXOs(amount, len(txn.SiacoinInputs), policy, maxUnconfirmed, minValue, elements)
+	if err != nil {
+		return FundResult{}, err
+	}
+	return sw.addSiacoinInputs(txn, amount, selected, inputSum, tag)
+}
+
+// minerFees returns the sum of the transaction's miner fees.
+func minerFees(txn types.Transaction) (sum types.Currency) {
+	for _, fee := range txn.MinerFees {
+		sum = sum.Add(fee)
+	}
+	return
+}
+
+// sortOutputs sorts outputs into canonical order: by value, ascending, then
+// by address, ascending.
+func sortOutputs(outputs []types.SiacoinOutput) {
+	slices.SortStableFunc(outputs, func(a, b types.SiacoinOutput) int {
+		if c := a.Value.Cmp(b.Value); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.Address[:], b.Address[:])
+	})
+}
+
+// insertChange inserts the change output into outputs at the configured
+// position and returns the updated outputs and the index of the change
+// output. If canonical ordering is enabled, the outputs are sorted instead.
+//
+// A zero-value change output is never valid. Callers are expected to omit
+// the change output instead; if one is passed, an error is returned, or, with
+// strict validation enabled, insertChange panics so the bug is caught in
+// tests.
+func (sw *SingleAddressWallet) insertChange(outputs []types.SiacoinOutput, change types.SiacoinOutput) ([]types.SiacoinOutput, int, error) {
+	if change.Value.IsZero() {
+		if sw.cfg.StrictValidation {
+			panic("wallet: zero-value change output") // developer error
+		}
+		return nil, -1, errors.New("invariant violated: zero-value change output")
+	}
+
+	if sw.cfg.CanonicalOutputs {
+		outputs = append(outputs, change)
+		sortOutputs(outputs)
+		return outputs, slices.Index(outputs, change), nil
+	}
+
+	i := int(sw.cfg.ChangePosition)
+	if i < 0 || i > len(outputs) {
+		i = len(outputs)
+	}
+	return slices.Insert(outputs, i, change), i, nil
+}
+
+// addSiacoinInputs adds the selected elements to the transaction as inputs,
+// adds a change output for any value exceeding amount, and locks the selected
+// elements under tag. This method must be called whilst holding the mutex
+// lock.
+func (sw *SingleAddressWallet) addSiacoinInputs(txn *types.Transaction, amount types.Currency, selected []types.SiacoinElement, inputSum types.Currency, tag string) (FundResult, error) {
+	if err := sw.checkReservationLimit(len(selected)); err != nil {
+		return FundResult{}, err
+	}
+	res := FundResult{ChangeIndex: -1}
+
+	// add a change output if necessary
+	if inputSum.Cmp(amount) > 0 {
+		outputs, i, err := sw.insertChange(txn.SiacoinOutputs, types.SiacoinOutput{
+			Value:   inputSum.Sub(amount),
+			Address: sw.addr,
+		})
+		if err != nil {
+			return FundResult{}, err
+		}
+		txn.SiacoinOutputs, res.ChangeIndex = outputs, i
+	} else if sw.cfg.CanonicalOutputs {
+		sortOutputs(txn.SiacoinOutputs)
+	}
+
+	res.ToSign = make([]types.Hash256, len(selected))
+	for i, sce := range selected {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			ParentID:         sce.ID,
+			UnlockConditions: sw.unlockConditionsFor(sce.SiacoinOutput.Address),
+		})
+		res.ToSign[i] = types.Hash256(sce.ID)
+	}
+	res.Warnings = sw.fundWarnings(amount, selected, inputSum)
+	sw.reserve(selected, tag)
+	return res, nil
+}
+
+// fundWarnings returns the warnings for funding amount with the selected
+// elements.
+func (sw *SingleAddressWallet) fundWarnings(amount types.Currency, selected []types.SiacoinElement, inputSum types.Currency) (warnings []FundWarning) {
+	if inputSum.Cmp(amount) > 0 && inputSum.Sub(amount).Cmp(amount) > 0 {
+		warnings = append(warnings, FundWarningLargeChange)
+	}
+	dust := sw.cfg.DustThreshold
+	if slices.ContainsFunc(selected, func(sce types.SiacoinElement) bool { return sce.SiacoinOutput.Value.Cmp(dust) < 0 }) {
+		warnings = append(warnings, FundWarningUsedDust)
+	}
+	// the privacy selection mode adds extra inputs deliberately
+	if n := inputsNeeded(selected, amount); sw.cfg.SelectionMode != SelectionModePrivacy && n >= 0 && n < len(selected) {
+		warnings = append(warnings, FundWarningDefragged)
+	}
+	if slices.ContainsFunc(selected, func(sce types.SiacoinElement) bool { return sce.StateElement.LeafIndex == types.UnassignedLeafIndex }) {
+		warnings = append(warnings, FundWarningUsedUnconfirmed)
+	}
+	if ratio := sw.cfg.ConsolidationChangeRatio; ratio > 0 && !amount.IsZero() && inputSum.Cmp(amount) > 0 {
+		// the ratio is advisory, so float precision is sufficient
+		if change := inputSum.Sub(amount); change.Siacoins()/amount.Siacoins() > ratio {
+			warnings = append(warnings, FundWarningSuggestConsolidation)
+		}
+	}
+	return
+}
+
+// fundedWeight returns the weight txn would have after adding the selected
+// elements as signed inputs, a miner fee, and a change output. Placeholder
+// values are used for the fee and change so the estimate is never short.
+func (sw *SingleAddressWallet) fundedWeight(state consensus.State, txn types.Transaction, selected []types.SiacoinElement) uint64 {
+	return sw.weightWith(state, txn, selected, types.MaxCurrency, types.MaxCurrency)
+}
+
+// weightWith returns the weight txn would have after adding the selected
+// elements as signed inputs, fee as a miner fee, and a change output worth
+// change. The fee and change are omitted if they are zero.
+func (sw *SingleAddressWallet) weightWith(state consensus.State, txn types.Transaction, selected []types.SiacoinElement, fee, change types.Currency) uint64 {
+	if !fee.IsZero() {
+		txn.MinerFees = append(append([]types.Currency(nil), txn.MinerFees...), fee)
+	}
+	if !change.IsZero() {
+		txn.SiacoinOutputs = append(append([]types.SiacoinOutput(nil), txn.SiacoinOutputs...), types.SiacoinOutput{
+			Value:   change,
+			Address: sw.addr,
+		})
+	}
+	txn.SiacoinInputs = append([]types.SiacoinInput(nil), txn.SiacoinInputs...)
+	txn.Signatures = append([]types.TransactionSignature(nil), txn.Signatures...)
+	for _, sce := range selected {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			ParentID:         sce.ID,
+			UnlockConditions: sw.uc,
+		})
+		txn.Signatures = append(txn.Signatures, types.TransactionSignature{
+			ParentID:      types.Hash256(sce.ID),
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+			Signature:     make([]byte, len(types.Signature{})),
+		})
+	}
+	return state.TransactionWeight(txn)
+}
+
+// EncodedSize returns the size of the transaction's binary encoding, which is
+// also its weight. Unlike estimates based on a fixed size per input, it
+// accounts for arbitrary data, multiple signatures and non-standard unlock
+// conditions.
+func EncodedSize(txn types.Transaction) int {
+	// the weight of a v1 transaction does not depend on the consensus state
+	return int(consensus.State{}.TransactionWeight(txn))
+}
+
+// FeeShare returns the portion of the transaction's miner fees attributable
+// to the inputs in ownedInputs, in proportion to the weight of those inputs
+// and their signatures. The rest of the weight, including the outputs and
+// the transaction's fixed overhead, is attributed to the other parties. An
+// error is returned if an ID in ownedInputs is not a siacoin input of txn.
+func FeeShare(txn types.Transaction, ownedInputs []types.Hash256) (types.Currency, error) {
+	owned := make(map[types.Hash256]bool, len(ownedInputs))
+	for _, id := range ownedInputs {
+		owned[id] = true
+	}
+
+	var ownedTxn types.Transaction
+	for _, sci := range txn.SiacoinInputs {
+		if owned[types.Hash256(sci.ParentID)] {
+			ownedTxn.SiacoinInputs = append(ownedTxn.SiacoinInputs, sci)
+			delete(owned, types.Hash256(sci.ParentID))
+		}
+	}
+	for id := range owned {
+		return types.ZeroCurrency, fmt.Errorf("input %v: %w", id, ErrNotFound)
+	}
+	for _, sig := range txn.Signatures {
+		if slices.Contains(ownedInputs, sig.ParentID) {
+			ownedTxn.Signatures = append(ownedTxn.Signatures, sig)
+		}
+	}
+
+	// transaction weights do not depend on the consensus state
+	var cs consensus.State
+	total := cs.TransactionWeight(txn)
+	weight := cs.TransactionWeight(ownedTxn) - cs.TransactionWeight(types.Transaction{})
+	return minerFees(txn).Mul64(weight).Div64(total), nil
+}
+
+// FundTransactionWithFee adds siacoin inputs worth at least amount plus the
+// fee required to pay for the transaction at the given fee rate. The fee is
+// added to the transaction's miner fees. Any miner fees already present in the
+// transaction are funded in addition to the new fee. If necessary, a change
+// output will also be added. The inputs will not be available to future calls to
+// FundTransaction unless ReleaseInputs is called.
+func (sw *SingleAddressWallet) FundTransactionWithFee(txn *types.Transaction, amount, feePerByte types.Currency, useUnconfirmed bool) ([]types.Hash256, error) {
+	res, err := sw.FundTransactionWithFeeDetailed(txn, amount, feePerByte, useUnconfirmed)
+	return res.ToSign, err
+}
+
+// FundTransactionWithFeeDetailed funds the transaction in the same manner as
+// FundTransactionWithFee. The returned result includes the index of the change
+// output, if one was added.
+func (sw *SingleAddressWallet) FundTransactionWithFeeDetailed(txn *types.Transaction, amount, feePerByte types.Currency, useUnconfirmed bool) (FundResult, error) {
+	state, err := sw.fundingState()
+	if err != nil {
+		return FundResult{}, err
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return FundResult{}, err
+	}
+
+	// fund any fees the caller already added to the transaction. The new fee
+	// is added to amount separately, so it is not counted twice.
+	amount = amount.Add(minerFees(*txn))
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	res, _, err := sw.fundWithFee(state, elements, txn, amount, types.ZeroCurrency, feePerByte, useUnconfirmed)
+	return res, err
+}
+
+// FundOutputs funds the siacoin outputs already present in the transaction.
+// The amount to fund is inferred from the sum of the transaction's outputs and
+// miner fees plus the fee required to pay for the transaction at the given fee
+// rate, less the value of any inputs already present. The existing inputs must
+// spend outputs known to the wallet. The fee is added to the transaction's
+// miner fees and, if necessary, a change output is added.
+func (sw *SingleAddressWallet) FundOutputs(txn *types.Transaction, feePerByte types.Currency, useUnconfirmed bool) ([]types.Hash256, error) {
+	toSign, _, err := sw.FundAndFee(txn, feePerByte, useUnconfirmed)
+	return toSign, err
+}
+
+// FundAndFee is like FundOutputs, but also returns the fee that was added to
+// the transaction's miner fees. Callers populate txn.SiacoinOutputs with the
+// intended recipients and FundAndFee funds their sum plus a fee matching the
+// weight of the funded transaction, adding change if necessary.
+func (sw *SingleAddressWallet) FundAndFee(txn *types.Transaction, feePerByte types.Currency, useUnconfirmed bool) ([]types.Hash256, types.Currency, error) {
+	state, err := sw.fundingState()
+	if err != nil {
+		return nil, types.ZeroCurrency, err
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, types.ZeroCurrency, err
+	}
+
+	amount := minerFees(*txn)
+	for _, sco := range txn.SiacoinOutputs {
+		amount = amount.Add(sco.Value)
+	}
+
+	// the inputs already present are credited against the amount
+	values := make(map[types.SiacoinOutputID]types.Currency, len(elements))
+	for _, sce := range elements {
+		values[sce.ID] = sce.SiacoinOutput.Value
+	}
+	var credit types.Currency
+	for _, sci := range txn.SiacoinInputs {
+		value, ok := values[sci.ParentID]
+		if !ok {
+			return nil, types.ZeroCurrency, fmt.Errorf("input %v: %w", sci.ParentID, ErrNotFound)
+		}
+		credit = credit.Add(value)
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	res, fee, err := sw.fundWithFee(state, elements, txn, amount, credit, feePerByte, useUnconfirmed)
+	return res.ToSign, fee, err
+}
+
+// fundWithFee adds inputs worth at least amount plus the fee required at the
+// given fee rate, less credit, the value of the inputs already present in the
+// transaction. It returns the result of funding the transaction and the fee
+// that was added. This method must be called whilst holding the mutex lock.
+func (sw *SingleAddressWallet) fundWithFee(state consensus.State, elements []types.SiacoinElement, txn *types.Transaction, amount, credit, feePerByte types.Currency, useUnconfirmed bool) (FundResult, types.Currency, error) {
+	// the inputs already present must not be selected again
+	present := make(map[types.SiacoinOutputID]bool, len(txn.SiacoinInputs))
+	for _, sci := range txn.SiacoinInputs {
+		present[sci.ParentID] = true
+	}
+	elements = slices.DeleteFunc(slices.Clone(elements), func(sce types.SiacoinElement) bool {
+		return present[sce.ID]
+	})
+
+	// the fee depends on the final weight of the transaction, which depends
+	// on the selected inputs, the fee itself, and the change output. Repeat
+	// selection until the fee covers the exact weight of the funded
+	// transaction. The fee only increases between iterations, so the final
+	// fee rate is at most a few bytes' worth above feePerByte.
+	var fee types.Currency
+	var selected []types.SiacoinElement
+	var inputSum types.Currency
+	for i := 0; ; i++ {
+		if i == maxFeeIterations {
+			return FundResult{}, types.ZeroCurrency, fmt.Errorf("fee did not converge after %d iterations", maxFeeIterations)
+		}
+		var target types.Currency
+		if total := amount.Add(fee); total.Cmp(credit) > 0 {
+			target = total.Sub(credit)
+		}
+		var err error
+		selected, inputSum, err = sw.selectUnconflictedUTXOs(target, len(txn.SiacoinInputs), unconfirmedPolicy(useUnconfirmed), types.MaxCurrency, types.ZeroCurrency, elements)
+		if err != nil {
+			return FundResult{}, types.ZeroCurrency, err
+		}
+		inputSum = inputSum.Add(credit)
+		change := inputSum.Sub(amount.Add(fee))
+		required := feePerByte.Mul64(sw.weightWith(state, *txn, selected, fee, change))
+		if required.Cmp(fee) <= 0 {
+			break
+		}
+		fee = required
+	}
+
+	res, err := sw.addSiacoinInputs(txn, amount.Add(fee), selected, inputSum, "")
+	if err != nil {
+		return FundResult{}, types.ZeroCurrency, err
+	} else if !fee.IsZero() {
+		txn.MinerFees = append(txn.MinerFees, fee)
+	}
+	return res, fee, nil
+}
+
+// SetClaimAddresses sets the ClaimAddress of the transaction's siafund
+// inputs. If override is the void address, inputs without a claim address
+// claim to the wallet's address and inputs that already have one are left
+// unchanged. Otherwise, every input claims to override.
+func (sw *SingleAddressWallet) SetClaimAddresses(txn *types.Transaction, override types.Address) {
+	for i := range txn.SiafundInputs {
+		sfi := &txn.SiafundInputs[i]
+		if override != types.VoidAddress {
+			sfi.ClaimAddress = override
+		} else if sfi.ClaimAddress == types.VoidAddress {
+			sfi.ClaimAddress = sw.addr
+		}
+	}
+}
+
+// SignTransaction adds a signature to each of the specified inputs. If a sign
+// approver is configured, it is called first and signing is aborted if it
+// returns an error. Signing a siafund input without a claim address is
+// rejected, since its claim would be lost.
+func (sw *SingleAddressWallet) SignTransaction(txn *types.Transaction, toSign []types.Hash256, cf types.CoveredFields) error {
+	for _, sfi := range txn.SiafundInputs {
+		if sfi.ClaimAddress == types.VoidAddress && slices.Contains(toSign, types.Hash256(sfi.ParentID)) {
+			return fmt.Errorf("siafund input %v has no claim address", sfi.ParentID)
+		}
+	}
+
+	if sw.cfg.SignApprover != nil {
+		if err := sw.cfg.SignApprover(*txn); err != nil {
+			return fmt.Errorf("transaction rejected by approver: %w", err)
+		}
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	state := sw.cm.TipState()
+
+	// each siacoin input is signed with the key matching its address
+	inputAddrs := make(map[types.Hash256]types.Address, len(txn.SiacoinInputs))
+	for _, sci := range txn.SiacoinInputs {
+		inputAddrs[types.Hash256(sci.ParentID)] = sci.UnlockConditions.UnlockHash()
+	}
+
+	for _, id := range toSign {
+		var h types.Hash256
+		if cf.WholeTransaction {
+			h = state.WholeSigHash(*txn, id, 0, 0, cf.Signatures)
+		} else {
+			h = state.PartialSigHash(*txn, cf)
+		}
+		sig := sw.keyFor(inputAddrs[id]).SignHash(h)
+		txn.Signatures = append(txn.Signatures, types.TransactionSignature{
+			ParentID:       id,
+			CoveredFields:  cf,
+			PublicKeyIndex: 0,
+			Signature:      sig[:],
+		})
+	}
+	return nil
+}
+
+// HasValidSignatures returns the IDs of the wallet's inputs in txn that
+// already have a valid signature from the wallet. Inputs not owned by the
+// wallet are ignored. An error is returned if any signature for one of the
+// wallet's inputs is invalid.
+func (sw *SingleAddressWallet) HasValidSignatures(txn types.Transaction) (signedInputs []types.Hash256, err error) {
+	owned := make(map[types.Hash256]types.PublicKey)
+	for _, sci := range txn.SiacoinInputs {
+		if addr := sci.UnlockConditions.UnlockHash(); sw.canSpend(addr) {
+			owned[types.Hash256(sci.ParentID)] = sw.keyFor(addr).PublicKey()
+		}
+	}
+
+	state := sw.cm.TipState()
+	seen := make(map[types.Hash256]bool)
+	for i, sig := range txn.Signatures {
+		pk, ok := owned[sig.ParentID]
+		if !ok {
+			continue
+		} else if sig.PublicKeyIndex != 0 {
+			return nil, fmt.Errorf("signature %d for input %v has invalid public key index %d", i, sig.ParentID, sig.PublicKeyIndex)
+		} else if len(sig.Signature) != len(types.Signature{}) {
+			return nil, fmt.Errorf("signature %d for input %v has invalid length %d", i, sig.ParentID, len(sig.Signature))
+		}
+
+		var h types.Hash256
+		if sig.CoveredFields.WholeTransaction {
+			h = state.WholeSigHash(txn, sig.ParentID, sig.PublicKeyIndex, sig.Timelock, sig.CoveredFields.Signatures)
+		} else {
+			h = state.PartialSigHash(txn, sig.CoveredFields)
+		}
+		if !pk.VerifyHash(h, types.Signature(sig.Signature)) {
+			return nil, fmt.Errorf("signature %d for input %v is invalid", i, sig.ParentID)
+		} else if !seen[sig.ParentID] {
+			seen[sig.ParentID] = true
+			signedInputs = append(signedInputs, sig.ParentID)
+		}
+	}
+	return signedInputs, nil
+}
+
+// CheckSignatures checks that the signatures in txn are well formed before
+// it is broadcast, e.g. when it was signed by an external signer. Every
+// signature must belong to an input or revision of txn, reference one of its
+// ed25519 public keys, have the length of an ed25519 signature, and use a
+// nonzero nonce that is not reused by another signature in txn, since a
+// reused nonce can expose the signing key. Every siacoin input owned by the
+// wallet must also have a signature that verifies against the expected
+// sighash.
+func (sw *SingleAddressWallet) CheckSignatures(txn types.Transaction) error {
+	ucs := make(map[types.Hash256]types.UnlockConditions)
+	for _, sci := range txn.SiacoinInputs {
+		ucs[types.Hash256(sci.ParentID)] = sci.UnlockConditions
+	}
+	for _, sfi := range txn.SiafundInputs {
+		ucs[types.Hash256(sfi.ParentID)] = sfi.UnlockConditions
+	}
+	for _, fcr := range txn.FileContractRevisions {
+		ucs[types.Hash256(fcr.ParentID)] = fcr.UnlockConditions
+	}
+
+	nonces := make(map[[32]byte]int)
+	for i, sig := range txn.Signatures {
+		uc, ok := ucs[sig.ParentID]
+		if !ok {
+			return fmt.Errorf("signature %d references unknown parent %v", i, sig.ParentID)
+		} else if sig.PublicKeyIndex >= uint64(len(uc.PublicKeys)) {
+			return fmt.Errorf("signature %d for %v has invalid public key index %d", i, sig.ParentID, sig.PublicKeyIndex)
+		}
+		uk := uc.PublicKeys[sig.PublicKeyIndex]
+		if uk.Algorithm != types.SpecifierEd25519 || len(uk.Key) != len(types.PublicKey{}) {
+			return fmt.Errorf("signature %d for %v references a non-ed25519 public key", i, sig.ParentID)
+		} else if len(sig.Signature) != len(types.Signature{}) {
+			return fmt.Errorf("signature %d for %v has invalid length %d", i, sig.ParentID, len(sig.Signature))
+		}
+
+		// the first half of an ed25519 signature is the nonce commitment
+		nonce := [32]byte(sig.Signature[:32])
+		if nonce == ([32]byte{}) {
+			return fmt.Errorf("signature %d for %v has a zero nonce", i, sig.ParentID)
+		} else if j, ok := nonces[nonce]; ok {
+			return fmt.Errorf("signature %d for %v reuses the nonce of signature %d", i, sig.ParentID, j)
+		}
+		nonces[nonce] = i
+	}
+
+	signed, err := sw.HasValidSignatures(txn)
+	if err != nil {
+		return err
+	}
+	for _, sci := range txn.SiacoinInputs {
+		if sw.canSpend(sci.UnlockConditions.UnlockHash()) && !slices.Contains(signed, types.Hash256(sci.ParentID)) {
+			return fmt.Errorf("input %v is not signed", sci.ParentID)
+		}
+	}
+	return nil
+}
+
+// BuildTransaction returns a signed transaction paying the recipients and
+// including the arbitrary data. The transaction is funded from confirmed
+// outputs, including a fee at the given fee rate, and is ready to be broadcast.
+// If the transaction is never broadcast, ReleaseInputs should be called to
+// release its inputs.
+func (sw *SingleAddressWallet) BuildTransaction(recipients []types.SiacoinOutput, arbitraryData [][]byte, feePerByte types.Currency) (_ types.Transaction, err error) {
+	txn := types.Transaction{
+		SiacoinOutputs: append([]types.SiacoinOutput(nil), recipients...),
+		ArbitraryData:  arbitraryData,
+	}
+
+	var amount types.Currency
+	for _, sco := range recipients {
+		amount = amount.Add(sco.Value)
+	}
+
+	toSign, err := sw.FundTransactionWithFee(&txn, amount, feePerByte, false)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("failed to fund transaction: %w", err)
+	}
+	// release the inputs if the transaction cannot be completed
+	defer func() {
+		if err != nil {
+			sw.ReleaseInputs([]types.Transaction{txn}, nil)
+		}
+	}()
+
+	if err := sw.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		return types.Transaction{}, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	return txn, nil
+}
+
+// SendWithPriority returns a signed transaction paying the outputs, funded in
+// the same manner as BuildTransaction at multiplier times the recommended fee
+// rate. The multiplier must be at least 1 and at most the cap set with
+// WithMaxFeeMultiplier. The fee rate is never less than one hasting per byte.
+func (sw *SingleAddressWallet) SendWithPriority(outputs []types.SiacoinOutput, multiplier float64) (types.Transaction, error) {
+	if !(multiplier >= 1) || multiplier > sw.cfg.MaxFeeMultiplier {
+		return types.Transaction{}, fmt.Errorf("fee multiplier %v must be between 1 and %v", multiplier, sw.cfg.MaxFeeMultiplier)
+	}
+	feePerByte := sw.recommendedFee().Mul64(uint64(multiplier * feeMultiplierPrecision)).Div64(feeMultiplierPrecision)
+	if feePerByte.IsZero() {
+		feePerByte = types.NewCurrency64(1)
+	}
+	return sw.BuildTransaction(outputs, nil, feePerByte)
+}
+
+// SendFeeFromRecipient returns a signed transaction paying amount to dest,
+// less the fee required at the given fee rate. The fee is deducted from the
+// recipient's output rather than added to the inputs, so the wallet's net
+// outflow is exactly amount. The transaction is funded from confirmed
+// outputs. An error is returned if the fee is not less than amount.
+func (sw *SingleAddressWallet) SendFeeFromRecipient(dest types.Address, amount, feePerByte types.Currency) (_ types.Transaction, err error) {
+	if amount.IsZero() {
+		return types.Transaction{}, errors.New("amount must be greater than zero")
+	}
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: dest, Value: amount}},
+	}
+	res, err := sw.FundTransactionDetailed(&txn, amount, false)
+	if err != nil {
+		return types.Transaction{}, fmt.Errorf("failed to fund transaction: %w", err)
+	}
+	toSign := res.ToSign
+	// the change output may be placed before the recipient's output
+	recipient := 0
+	if res.ChangeIndex == 0 {
+		recipient = 1
+	}
+	// release the inputs if the transaction cannot be completed
+	defer func() {
+		if err != nil {
+			sw.ReleaseInputs([]types.Transaction{txn}, nil)
+		}
+	}()
+
+	// estimate the weight of the signed transaction, repeating until the fee
+	// covers the weight of its own encoding
+	signed := txn
+	for _, id := range toSign {
+		signed.Signatures = append(signed.Signatures, types.TransactionSignature{
+			ParentID:      id,
+			CoveredFields: types.CoveredFields{WholeTransaction: true},
+			Signature:     make([]byte, len(types.Signature{})),
+		})
+	}
+	cs := sw.cm.TipState()
+	var fee types.Currency
+	for i := 0; ; i++ {
+		if i == maxFeeIterations {
+			return types.Transaction{}, fmt.Errorf("fee did not converge after %d iterations", maxFeeIterations)
+		}
+		required := feePerByte.Mul64(sw.weightWith(cs, signed, nil, fee, types.ZeroCurrency))
+		if required.Cmp(fee) <= 0 {
+			break
+		}
+		fee = required
+	}
+	if fee.Cmp(amount) >= 0 {
+		return types.Transaction{}, fmt.Errorf("fee %v leaves nothing for the recipient of %v", fee, amount)
+	}
+
+	txn.SiacoinOutputs[recipient].Value = amount.Sub(fee)
+	if sw.cfg.CanonicalOutputs {
+		sortOutputs(txn.SiacoinOutputs)
+	}
+	if !fee.IsZero() {
+		txn.MinerFees = append(txn.MinerFees, fee)
+	}
+	if err := sw.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		return types.Transaction{}, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	return txn, nil
+}
+
+// Burn returns a signed transaction that provably destroys amount by paying it
+// to types.VoidAddress. The transaction is funded from confirmed outputs,
+// including a fee at the given fee rate, and its inputs are reserved like any
+// other funded transaction. The IDs of the wallet's inputs are also returned
+// so they can be released if the transaction is not broadcast.
+func (sw *SingleAddressWallet) Burn(amount, feePerByte types.Currency) (types.Transaction, []types.Hash256, error) {
+	if amount.IsZero() {
+		return types.Transaction{}, nil, errors.New("burn amount must be greater than zero")
+	}
+
+	txn, err := sw.BuildTransaction([]types.SiacoinOutput{
+		{Address: types.VoidAddress, Value: amount},
+	}, nil, feePerByte)
+	if err != nil {
+		return types.Transaction{}, nil, err
+	}
+	return txn, inputIDs(txn), nil
+}
+
+// BuildTransactionWithReference builds a transaction in the same manner as
+// BuildTransaction and associates the reference with it. The store must
+// implement TransactionReferenceStore.
+func (sw *SingleAddressWallet) BuildTransactionWithReference(recipients []types.SiacoinOutput, arbitraryData [][]byte, feePerByte types.Currency, reference string) (types.Transaction, error) {
+	rs, ok := sw.store.(TransactionReferenceStore)
+	if !ok {
+		return types.Transaction{}, ErrStoreUnsupported
+	}
+
+	txn, err := sw.BuildTransaction(recipients, arbitraryData, feePerByte)
+	if err != nil {
+		return types.Transaction{}, err
+	} else if err := rs.SetTransactionReference(txn.ID(), reference); err != nil {
+		sw.ReleaseInputs([]types.Transaction{txn}, nil)
+		return types.Transaction{}, fmt.Errorf("failed to set transaction reference: %w", err)
+	}
+	return txn, nil
+}
+
+// Pay returns a signed transaction paying each recipient the specified amount.
+// Recipients are keyed by address, so each address receives a single output;
+// callers paying the same address more than once should sum the amounts. The
+// outputs are ordered by address. The transaction is funded from confirmed
+// outputs, including a fee at the given fee rate. The IDs of the wallet's
+// inputs are also returned so they can be released if the transaction is not
+// broadcast. An error is returned if there are more recipients than the
+// configured maximum outputs per transaction; use PayBatch instead.
+func (sw *SingleAddressWallet) Pay(recipients map[types.Address]types.Currency, feePerByte types.Currency) (types.Transaction, []types.Hash256, error) {
+	outputs, err := paymentOutputs(recipients)
+	if err != nil {
+		return types.Transaction{}, nil, err
+	} else if sw.cfg.MaxOutputsPerTxn > 0 && len(outputs) > sw.cfg.MaxOutputsPerTxn {
+		return types.Transaction{}, nil, fmt.Errorf("%d recipients exceeds the maximum of %d outputs per transaction", len(outputs), sw.cfg.MaxOutputsPerTxn)
+	}
+
+	txn, err := sw.BuildTransaction(outputs, nil, feePerByte)
+	if err != nil {
+		return types.Transaction{}, nil, err
+	}
+	return txn, inputIDs(txn), nil
+}
+
+// PayBatch pays each recipient the specified amount in the same manner as
+// Pay, splitting the recipients across as many transactions as needed to stay
+// within the configured maximum outputs per transaction and the maximum block
+// weight. Recipients are assigned to transactions in address order. Each
+// transaction is funded and signed independently and does not depend on the
+// others. If any transaction cannot be built, the inputs of the previous
+// transactions are released and an error is returned.
+func (sw *SingleAddressWallet) PayBatch(recipients map[types.Address]types.Currency, feePerByte types.Currency) (_ []PaymentBatch, err error) {
+	outputs, err := paymentOutputs(recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	// release the inputs of any built transactions if a later one fails
+	var batches []PaymentBatch
+	defer func() {
+		if err != nil {
+			txns := make([]types.Transaction, 0, len(batches))
+			for _, batch := range batches {
+				txns = append(txns, batch.Transaction)
+			}
+			sw.ReleaseInputs(txns, nil)
+		}
+	}()
+
+	size := sw.cfg.MaxOutputsPerTxn
+	if size <= 0 {
+		size = len(outputs)
+	}
+	state := sw.cm.TipState()
+	chunks := slices.Collect(slices.Chunk(outputs, size))
+	for len(chunks) > 0 {
+		chunk := chunks[0]
+		chunks = chunks[1:]
+		txn, err := sw.BuildTransaction(chunk, nil, feePerByte)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build transaction %d: %w", len(batches)+1, err)
+		}
+		// a transaction heavier than a block can never be confirmed, so its
+		// recipients are split across two transactions instead
+		if len(chunk) > 1 && state.TransactionWeight(txn) > state.MaxBlockWeight() {
+			sw.ReleaseInputs([]types.Transaction{txn}, nil)
+			mid := len(chunk) / 2
+			chunks = append([][]types.SiacoinOutput{chunk[:mid], chunk[mid:]}, chunks...)
+			continue
+		}
+		addrs := make([]types.Address, 0, len(chunk))
+		for _, sco := range chunk {
+			addrs = append(addrs, sco.Address)
+		}
+		batches = append(batches, PaymentBatch{
+			Transaction: txn,
+			ToSign:      inputIDs(txn),
+			Recipients:  addrs,
+		})
+	}
+	return batches, nil
+}
+
+// paymentOutputs returns an output for each recipient, ordered by address.
+func paymentOutputs(recipients map[types.Address]types.Currency) ([]types.SiacoinOutput, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+
+	outputs := make([]types.SiacoinOutput, 0, len(recipients))
+	for addr, amount := range recipients {
+		if amount.IsZero() {
+			return nil, fmt.Errorf("amount for recipient %v must be greater than zero", addr)
+		}
+		outputs = append(outputs, types.SiacoinOutput{Address: addr, Value: amount})
+	}
+	sort.Slice(outputs, func(i, j int) bool {
+		return bytes.Compare(outputs[i].Address[:], outputs[j].Address[:]) < 0
+	})
+	return outputs, nil
+}
+
+// inputIDs returns the parent IDs of the transaction's siacoin inputs.
+func inputIDs(txn types.Transaction) []types.Hash256 {
+	ids := make([]types.Hash256, 0, len(txn.SiacoinInputs))
+	for _, sci := range txn.SiacoinInputs {
+		ids = append(ids, types.Hash256(sci.ParentID))
+	}
+	return ids
+}
+
+// Sweep returns a transaction that sends all of the wallet's spendable
+// outputs to dest, minus the fee required at the given fee rate. By default,
+// outputs worth less than the fee to spend them are left behind. The inputs
+// will not be available to future calls to FundTransaction unless
+// ReleaseInputs is called.
+func (sw *SingleAddressWallet) Sweep(dest types.Address, feePerByte types.Currency, opts SweepOptions) (types.Transaction, []types.Hash256, error) {
+	state, err := sw.fundingState()
+	if err != nil {
+		return types.Transaction{}, nil, err
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return types.Transaction{}, nil, err
+	}
+
+	inPool := make(map[types.SiacoinOutputID]bool)
+	for _, txn := range sw.cm.PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			inPool[sci.ParentID] = true
+		}
+	}
+	for _, txn := range sw.cm.V2PoolTransactions() {
+		for _, sci := range txn.SiacoinInputs {
+			inPool[sci.Parent.ID] = true
+		}
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	// the weight of each input is the same, so the cost of spending an
+	// output is the difference between the weight with and without it
+	base := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: dest, Value: types.MaxCurrency}},
+	}
+	inputFee := feePerByte.Mul64(sw.fundedWeight(state, base, make([]types.SiacoinElement, 1)) - sw.fundedWeight(state, base, nil))
+
+	var selected []types.SiacoinElement
+	var inputSum types.Currency
+	for _, sce := range elements {
+		if !sw.canSpend(sce.SiacoinOutput.Address) || sw.isLocked(sce.ID) || inPool[sce.ID] || state.Index.Height < sce.MaturityHeight {
+			continue
+		} else if !opts.IncludeUneconomical && sce.SiacoinOutput.Value.Cmp(inputFee) <= 0 {
+			continue
+		}
+		selected = append(selected, sce.Share())
+		inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+	}
+	if len(selected) == 0 {
+		return types.Transaction{}, nil, fmt.Errorf("%w: no spendable outputs to sweep", ErrNotEnoughFunds)
+	}
+
+	fee := feePerByte.Mul64(sw.fundedWeight(state, base, selected))
+	if inputSum.Cmp(fee) <= 0 {
+		return types.Transaction{}, nil, fmt.Errorf("%w: inputs %v <= txnFee %v", ErrNotEnoughFunds, inputSum.String(), fee.String())
+	}
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: dest, Value: inputSum.Sub(fee)}},
+	}
+	if !fee.IsZero() {
+		txn.MinerFees = []types.Currency{fee}
+	}
+	res, err := sw.addSiacoinInputs(&txn, inputSum, selected, inputSum, "")
+	if err != nil {
+		return types.Transaction{}, nil, err
+	}
+	return txn, res.ToSign, nil
+}
+
+// Forward returns a transaction that spends the output with the given ID to
+// dest, less the transaction fee. The transaction has exactly one input and
+// one output; no change is added. ErrNotFound is returned if the output is
+// not a spendable output of the wallet and ErrNotEnoughFunds if its value
+// does not cover the fee.
+func (sw *SingleAddressWallet) Forward(outputID types.SiacoinOutputID, dest types.Address, feePerByte types.Currency) (types.Transaction, []types.Hash256, error) {
+	state, err := sw.fundingState()
+	if err != nil {
+		return types.Transaction{}, nil, err
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return types.Transaction{}, nil, err
+	}
+
+	i := slices.IndexFunc(elements, func(sce types.SiacoinElement) bool {
+		return sce.ID == outputID && sw.canSpend(sce.SiacoinOutput.Address)
+	})
+	if i == -1 {
+		return types.Transaction{}, nil, fmt.Errorf("output %v: %w", outputID, ErrNotFound)
+	}
+	sce := elements[i]
+	if state.Index.Height < sce.MaturityHeight {
+		return types.Transaction{}, nil, fmt.Errorf("output %v is immature until height %d", outputID, sce.MaturityHeight)
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	if sw.isLocked(sce.ID) || sw.poolSpent()[sce.ID] {
+		return types.Transaction{}, nil, fmt.Errorf("output %v: %w", outputID, ErrOutputLocked)
+	}
+
+	base := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: dest, Value: types.MaxCurrency}},
+	}
+	selected := []types.SiacoinElement{sce.Share()}
+	value := sce.SiacoinOutput.Value
+	fee := feePerByte.Mul64(sw.fundedWeight(state, base, selected))
+	if value.Cmp(fee) <= 0 {
+		return types.Transaction{}, nil, fmt.Errorf("%w: output %v <= txnFee %v", ErrNotEnoughFunds, value.String(), fee.String())
+	}
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: dest, Value: value.Sub(fee)}},
+	}
+	if !fee.IsZero() {
+		txn.MinerFees = []types.Currency{fee}
+	}
+	res, err := sw.addSiacoinInputs(&txn, value, selected, value, "")
+	if err != nil {
+		return types.Transaction{}, nil, err
+	}
+	return txn, res.ToSign, nil
 }
 
-// EventCount returns the total number of events relevant to the wallet.
-func (sw *SingleAddressWallet) EventCount() (uint64, error) {
-	return sw.store.WalletEventCount()
+// SuggestChangelessAmount returns an amount close to near that can be sent
+// without a change output: the value of one or two spendable outputs, less
+// the fee for a transaction spending them to a single recipient at
+// feePerByte. ok is false if no such amount is within 5% of near. Use
+// SuggestChangelessInputs to also get the outputs to fund the transaction
+// with.
+func (sw *SingleAddressWallet) SuggestChangelessAmount(near, feePerByte types.Currency) (amount types.Currency, ok bool) {
+	amount, _, ok, err := sw.SuggestChangelessInputs(near, feePerByte)
+	if err != nil {
+		sw.log.Warn("failed to suggest changeless amount", zap.Error(err))
+		return types.ZeroCurrency, false
+	}
+	return amount, ok
 }
 
-// SpendableOutputs returns a list of spendable siacoin outputs, a spendable
-// output is an unspent output that's not locked, not currently in the
-// transaction pool and that has matured.
-func (sw *SingleAddressWallet) SpendableOutputs() ([]types.SiacoinElement, error) {
-	// fetch outputs from the store
-	utxos, err := sw.store.UnspentSiacoinElements()
+// SuggestChangelessInputs returns the same amount as SuggestChangelessAmount
+// along with the outputs that make it up, so the transaction can be funded
+// with FundTransactionExact, with the difference between their value and
+// amount as the miner fee. ok is false if no amount is within 5% of near; an
+// error is only returned if the wallet's outputs cannot be loaded.
+func (sw *SingleAddressWallet) SuggestChangelessInputs(near, feePerByte types.Currency) (amount types.Currency, inputs []types.SiacoinOutputID, ok bool, err error) {
+	utxos, err := sw.SpendableOutputs()
 	if err != nil {
-		return nil, err
+		return types.ZeroCurrency, nil, false, err
 	}
+	if len(utxos) == 0 {
+		return types.ZeroCurrency, nil, false, nil
+	}
+	slices.SortFunc(utxos, func(a, b types.SiacoinElement) int {
+		return a.SiacoinOutput.Value.Cmp(b.SiacoinOutput.Value)
+	})
 
-	// fetch outputs currently in the pool
-	inPool := make(map[types.SiacoinOutputID]bool)
-	for _, txn := range sw.cm.PoolTransactions() {
-		for _, sci := range txn.SiacoinInputs {
-			inPool[sci.ParentID] = true
+	// the weight only depends on the number of inputs; a placeholder output
+	// value keeps the fee from being underestimated
+	base := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.MaxCurrency}},
+	}
+	cs := sw.cm.TipState()
+	fees := [3]types.Currency{}
+	for n := 1; n <= 2 && n <= len(utxos); n++ {
+		fees[n] = feePerByte.Mul64(sw.weightWith(cs, base, utxos[:n], types.MaxCurrency, types.ZeroCurrency))
+	}
+
+	tolerance := near.Div64(changelessToleranceDivisor)
+	var best, bestDist types.Currency
+	consider := func(sum, fee types.Currency, ids ...types.SiacoinOutputID) {
+		if sum.Cmp(fee) <= 0 {
+			return
+		}
+		candidate := sum.Sub(fee)
+		var dist types.Currency
+		if candidate.Cmp(near) > 0 {
+			dist = candidate.Sub(near)
+		} else {
+			dist = near.Sub(candidate)
+		}
+		if dist.Cmp(tolerance) <= 0 && (inputs == nil || dist.Cmp(bestDist) < 0) {
+			best, bestDist, inputs = candidate, dist, ids
 		}
 	}
 
-	// grab current height
-	state := sw.cm.TipState()
-	bh := state.Index.Height
+	// single outputs
+	for _, sce := range utxos {
+		consider(sce.SiacoinOutput.Value, fees[1], sce.ID)
+	}
+	// pairs of outputs; for each output, only the partners whose sum is
+	// closest to the target need to be considered
+	target, overflow := near.AddWithOverflow(fees[2])
+	if len(utxos) >= 2 && !overflow {
+		for i, sce := range utxos {
+			v := sce.SiacoinOutput.Value
+			if v.Cmp(target) >= 0 {
+				break
+			}
+			rem := target.Sub(v)
+			j := sort.Search(len(utxos), func(j int) bool { return utxos[j].SiacoinOutput.Value.Cmp(rem) >= 0 })
+			for _, k := range []int{j - 2, j - 1, j, j + 1} {
+				if k < 0 || k >= len(utxos) || k == i {
+					continue
+				}
+				consider(v.Add(utxos[k].SiacoinOutput.Value), fees[2], sce.ID, utxos[k].ID)
+			}
+		}
+	}
+	return best, inputs, inputs != nil, nil
+}
+
+// ReplacementFee returns the minimum total miner fee a conflicting
+// transaction would need to plausibly replace original in a node's
+// transaction pool.
+//
+// Sia has no standard replace-by-fee policy, so the estimate uses a common
+// heuristic: the replacement must pay the original's fee, so the node does
+// not lose revenue, plus the cost of relaying a transaction of the same
+// weight at the greater of the original's fee rate and the currently
+// recommended fee rate. Whether a replacement is accepted depends on each
+// node's policy.
+func (sw *SingleAddressWallet) ReplacementFee(original types.Transaction) types.Currency {
+	return sw.replacementFee(minerFees(original), sw.cm.TipState().TransactionWeight(original))
+}
+
+// recommendedFee returns the chain manager's recommended fee rate, or zero if
+// it does not implement FeeEstimator.
+func (sw *SingleAddressWallet) recommendedFee() types.Currency {
+	if fe, ok := sw.cm.(FeeEstimator); ok {
+		return fe.RecommendedFee()
+	}
+	return types.ZeroCurrency
+}
+
+// replacementFee returns the minimum fee of a transaction replacing one of the
+// given weight that paid fee. See ReplacementFee.
+func (sw *SingleAddressWallet) replacementFee(fee types.Currency, weight uint64) types.Currency {
+	feeRate := sw.recommendedFee()
+	if weight > 0 {
+		if rate := fee.Div64(weight); rate.Cmp(feeRate) > 0 {
+			feeRate = rate
+		}
+	}
+	return fee.Add(feeRate.Mul64(weight))
+}
+
+// trackPoolAges records the current height for the wallet's pool
+// transactions that have not been seen before and forgets the transactions
+// that have left the pool.
+func (sw *SingleAddressWallet) trackPoolAges() {
+	txns := sw.cm.PoolTransactions()
+	v2txns := sw.cm.V2PoolTransactions()
 
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
-
-	// filter outputs that are either locked, in the pool or have not yet matured
-	unspent := utxos[:0]
-	for _, sce := range utxos {
-		if sw.isLocked(sce.ID) || inPool[sce.ID] || bh < sce.MaturityHeight {
-			continue
+	inPool := make(map[types.TransactionID]bool, len(txns)+len(v2txns))
+	for _, txn := range txns {
+		if slices.ContainsFunc(txn.SiacoinInputs, func(sci types.SiacoinInput) bool { return sw.canSpend(sci.UnlockConditions.UnlockHash()) }) {
+			inPool[txn.ID()] = true
+		}
+	}
+	for _, txn := range v2txns {
+		if slices.ContainsFunc(txn.SiacoinInputs, func(sci types.V2SiacoinInput) bool { return sw.canSpend(sci.Parent.SiacoinOutput.Address) }) {
+			inPool[txn.ID()] = true
+		}
+	}
+	for id := range sw.poolSeen {
+		if !inPool[id] {
+			delete(sw.poolSeen, id)
+		}
+	}
+	for id := range inPool {
+		if _, ok := sw.poolSeen[id]; !ok {
+			sw.poolSeen[id] = sw.tip.Height
 		}
-		unspent = append(unspent, sce.Copy())
 	}
-	return unspent, nil
 }
 
-func (sw *SingleAddressWallet) selectUTXOs(amount types.Currency, inputs int, useUnconfirmed bool, elements []types.SiacoinElement) ([]types.SiacoinElement, types.Currency, error) {
-	if amount.IsZero() {
-		return nil, types.ZeroCurrency, nil
+// StuckTransactions returns the wallet's pool transactions that have been
+// pending for at least minAgeBlocks blocks, along with a suggested
+// remediation. Transactions with change that can pay for a child transaction
+// are remediated with CPFP; the others must be replaced.
+//
+// The age of a transaction is measured from the first time the wallet saw it
+// in the pool, either while syncing or in a call to StuckTransactions, so
+// transactions are only reported after the wallet has observed them for
+// minAgeBlocks blocks.
+func (sw *SingleAddressWallet) StuckTransactions(minAgeBlocks uint64) ([]StuckTxn, error) {
+	cs, err := sw.tipState()
+	if err != nil {
+		return nil, err
 	}
+	sw.trackPoolAges()
 
-	tpoolSpent := make(map[types.SiacoinOutputID]bool)
-	tpoolUtxos := make(map[types.SiacoinOutputID]types.SiacoinElement)
-	for _, txn := range sw.cm.PoolTransactions() {
-		for _, sci := range txn.SiacoinInputs {
-			tpoolSpent[sci.ParentID] = true
-			delete(tpoolUtxos, sci.ParentID)
+	recommended := sw.recommendedFee()
+	sw.mu.Lock()
+	height := sw.tip.Height
+	seen := maps.Clone(sw.poolSeen)
+	sw.mu.Unlock()
+
+	var stuck []StuckTxn
+	check := func(id types.TransactionID, fee types.Currency, weight uint64, change []types.SiacoinElement) {
+		first, ok := seen[id]
+		// a reorg can move the tip below the height the transaction was
+		// first seen at
+		if !ok || height < first || height-first < minAgeBlocks {
+			return
+		}
+		st := StuckTxn{
+			ID:            id,
+			PendingBlocks: height - first,
+			Remediation:   RemediationReplace,
+			Cost:          sw.replacementFee(fee, weight),
+		}
+		if weight > 0 {
+			st.FeePerByte = fee.Div64(weight)
+		}
+
+		// the child spends the largest change output and pays for the
+		// weight of both transactions at the recommended fee rate
+		if len(change) > 0 {
+			largest := slices.MaxFunc(change, func(a, b types.SiacoinElement) int {
+				return a.SiacoinOutput.Value.Cmp(b.SiacoinOutput.Value)
+			})
+			childWeight := sw.weightWith(cs, types.Transaction{}, []types.SiacoinElement{largest}, types.MaxCurrency, types.MaxCurrency)
+			cost := recommended.Mul64(childWeight)
+			if total := recommended.Mul64(weight + childWeight); total.Cmp(fee.Add(cost)) > 0 {
+				cost = total.Sub(fee)
+			}
+			if largest.SiacoinOutput.Value.Cmp(cost) > 0 {
+				st.Remediation, st.Cost = RemediationCPFP, cost
+			}
 		}
+		stuck = append(stuck, st)
+	}
+
+	for _, txn := range sw.cm.PoolTransactions() {
+		var change []types.SiacoinElement
 		for i, sco := range txn.SiacoinOutputs {
-			tpoolUtxos[txn.SiacoinOutputID(i)] = types.SiacoinElement{
-				ID:            txn.SiacoinOutputID(i),
-				StateElement:  types.StateElement{LeafIndex: types.UnassignedLeafIndex},
-				SiacoinOutput: sco,
+			if sw.canSpend(sco.Address) {
+				change = append(change, types.SiacoinElement{ID: txn.SiacoinOutputID(i), SiacoinOutput: sco})
 			}
 		}
+		check(txn.ID(), minerFees(txn), cs.TransactionWeight(txn), change)
 	}
 	for _, txn := range sw.cm.V2PoolTransactions() {
-		for _, sci := range txn.SiacoinInputs {
-			tpoolSpent[sci.Parent.ID] = true
-			delete(tpoolUtxos, sci.Parent.ID)
-		}
-		for i := range txn.SiacoinOutputs {
-			sce := txn.EphemeralSiacoinOutput(i)
-			tpoolUtxos[sce.ID] = sce.Move()
+		var change []types.SiacoinElement
+		for i, sco := range txn.SiacoinOutputs {
+			if sw.canSpend(sco.Address) {
+				change = append(change, txn.EphemeralSiacoinOutput(i))
+			}
 		}
+		check(txn.ID(), txn.MinerFee, cs.V2TransactionWeight(txn), change)
 	}
+	return stuck, nil
+}
 
-	// remove immature, locked and spent outputs
-	cs := sw.cm.TipState()
-	utxos := make([]types.SiacoinElement, 0, len(elements))
-	var usedSum types.Currency
-	var immatureSum types.Currency
+// UpdateProofs returns copies of the elements with their Merkle proofs updated
+// from basis, the chain index the proofs are currently valid for, to the chain
+// manager's current tip. The returned state is the state the updated proofs
+// are valid for; it may trail the tip if the chain advances during the call.
+// An error is returned if any of the elements were created by a block that
+// has since been reverted. The chain manager must implement UpdateProvider.
+func (sw *SingleAddressWallet) UpdateProofs(basis types.ChainIndex, elements []types.SiacoinElement) ([]types.SiacoinElement, consensus.State, error) {
+	up, ok := sw.cm.(UpdateProvider)
+	if !ok {
+		return nil, consensus.State{}, ErrChainUnsupported
+	}
+	updated := make([]types.SiacoinElement, 0, len(elements))
 	for _, sce := range elements {
-		if used := sw.isLocked(sce.ID) || tpoolSpent[sce.ID]; used {
-			usedSum = usedSum.Add(sce.SiacoinOutput.Value)
-			continue
-		} else if immature := cs.Index.Height < sce.MaturityHeight; immature {
-			immatureSum = immatureSum.Add(sce.SiacoinOutput.Value)
-			continue
+		if sce.StateElement.LeafIndex == types.UnassignedLeafIndex {
+			return nil, consensus.State{}, fmt.Errorf("cannot update proof of ephemeral element %v", sce.ID)
 		}
-		utxos = append(utxos, sce.Share())
+		updated = append(updated, sce.Copy())
 	}
 
-	// sort by value, descending
-	sort.Slice(utxos, func(i, j int) bool {
-		return utxos[i].SiacoinOutput.Value.Cmp(utxos[j].SiacoinOutput.Value) > 0
-	})
+	var cs consensus.State
+	for {
+		reverted, applied, err := up.UpdatesSince(basis, proofUpdateBatchSize)
+		if err != nil {
+			return nil, consensus.State{}, fmt.Errorf("failed to get updates since %v: %w", basis, err)
+		} else if len(reverted) == 0 && len(applied) == 0 {
+			if cs.Index == basis {
+				return updated, cs, nil
+			}
+			tip := sw.cm.TipState()
+			if tip.Index != basis {
+				return nil, consensus.State{}, fmt.Errorf("no updates from %v to chain tip %v", basis, tip.Index)
+			}
+			return updated, tip, nil
+		}
 
-	var unconfirmedUTXOs []types.SiacoinElement
-	var unconfirmedSum types.Currency
-	if useUnconfirmed {
-		for _, sce := range tpoolUtxos {
-			if sce.SiacoinOutput.Address != sw.addr || sw.isLocked(sce.ID) {
-				continue
+		for _, cru := range reverted {
+			for i := range updated {
+				if updated[i].StateElement.LeafIndex >= cru.State.Elements.NumLeaves {
+					return nil, consensus.State{}, fmt.Errorf("element %v was reverted", updated[i].ID)
+				}
+				cru.UpdateElementProof(&updated[i].StateElement)
 			}
-			unconfirmedUTXOs = append(unconfirmedUTXOs, sce.Share())
-			unconfirmedSum = unconfirmedSum.Add(sce.SiacoinOutput.Value)
+			basis, cs = cru.State.Index, cru.State
+		}
+		for _, cau := range applied {
+			for i := range updated {
+				cau.UpdateElementProof(&updated[i].StateElement)
+			}
+			basis, cs = cau.State.Index, cau.State
 		}
 	}
+}
 
-	// sort by value, descending
-	sort.Slice(unconfirmedUTXOs, func(i, j int) bool {
-		return unconfirmedUTXOs[i].SiacoinOutput.Value.Cmp(unconfirmedUTXOs[j].SiacoinOutput.Value) > 0
-	})
+// unspentLeafHash returns the accumulator leaf hash of an unspent siacoin
+// element. It mirrors the unexported leaf hashing in core's consensus package;
+// TestAccumulatorContains checks that the two agree.
+func unspentLeafHash(sce types.SiacoinElement) types.Hash256 {
+	h := types.NewHasher()
+	h.WriteDistinguisher("leaf/siacoin")
+	sce.ID.EncodeTo(h.E)
+	types.V2SiacoinOutput(sce.SiacoinOutput).EncodeTo(h.E)
+	h.E.WriteUint64(sce.MaturityHeight)
+	elemHash := h.Sum()
 
-	// fund the transaction using the largest utxos first
-	var selected []types.SiacoinElement
-	var inputSum types.Currency
-	for i, sce := range utxos {
-		if inputSum.Cmp(amount) >= 0 {
-			utxos = utxos[i:]
-			break
-		}
-		selected = append(selected, sce.Share())
-		inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+	buf := make([]byte, 1+32+8+1)
+	buf[0] = 0x00 // leaf hash prefix
+	copy(buf[1:], elemHash[:])
+	binary.LittleEndian.PutUint64(buf[33:], sce.StateElement.LeafIndex)
+	// buf[41] is the spent flag, which is zero for unspent elements
+	return types.HashBytes(buf)
+}
+
+// accumulatorContains returns true if the accumulator contains sce as an
+// unspent element.
+func accumulatorContains(acc consensus.ElementAccumulator, sce types.SiacoinElement) bool {
+	proof := sce.StateElement.MerkleProof
+	if sce.StateElement.LeafIndex == types.UnassignedLeafIndex || len(proof) >= len(acc.Trees) || acc.NumLeaves&(1<<len(proof)) == 0 {
+		return false
 	}
 
-	if inputSum.Cmp(amount) < 0 && useUnconfirmed {
-		// try adding unconfirmed utxos.
-		for _, sce := range unconfirmedUTXOs {
-			selected = append(selected, sce.Share())
-			inputSum = inputSum.Add(sce.SiacoinOutput.Value)
-			if inputSum.Cmp(amount) >= 0 {
-				break
-			}
+	buf := make([]byte, 1+32+32)
+	buf[0] = 0x01 // node hash prefix
+	root := unspentLeafHash(sce)
+	for i, h := range proof {
+		if sce.StateElement.LeafIndex&(1<<i) == 0 {
+			copy(buf[1:], root[:])
+			copy(buf[33:], h[:])
+		} else {
+			copy(buf[1:], h[:])
+			copy(buf[33:], root[:])
 		}
+		root = types.HashBytes(buf)
+	}
+	return acc.Trees[len(proof)] == root
+}
 
-		if inputSum.Cmp(amount) < 0 {
-			// still not enough funds
-			return nil, types.ZeroCurrency, fmt.Errorf("%w: inputs %v < needed %v (used: %v immature: %v unconfirmed: %v)", ErrNotEnoughFunds, inputSum.String(), amount.String(), usedSum.String(), immatureSum.String(), unconfirmedSum.String())
-		}
-	} else if inputSum.Cmp(amount) < 0 {
-		return nil, types.ZeroCurrency, fmt.Errorf("%w: inputs %v < needed %v (used: %v immature: %v", ErrNotEnoughFunds, inputSum.String(), amount.String(), usedSum.String(), immatureSum.String())
+// Verify checks each of the store's unspent siacoin outputs against the chain
+// manager's accumulator and returns the IDs of any outputs the chain does not
+// recognize as unspent. An output whose proof is only stale is rebuilt from
+// the block that created it and is not reported. The store must implement
+// OutputIndexStore and be synced to the chain manager's tip, and the chain
+// manager must implement UpdateProvider.
+func (sw *SingleAddressWallet) Verify(ctx context.Context) ([]types.SiacoinOutputID, error) {
+	up, ok := sw.cm.(UpdateProvider)
+	if !ok {
+		return nil, ErrChainUnsupported
+	}
+	is, ok := sw.store.(OutputIndexStore)
+	if !ok {
+		return nil, ErrStoreUnsupported
 	}
 
-	// check if remaining utxos should be defragged
-	txnInputs := inputs + len(selected)
-	if len(utxos) > sw.cfg.DefragThreshold && txnInputs < sw.cfg.MaxInputsForDefrag {
-		// add the smallest utxos to the transaction
-		defraggable := utxos
-		if len(defraggable) > sw.cfg.MaxDefragUTXOs {
-			defraggable = defraggable[len(defraggable)-sw.cfg.MaxDefragUTXOs:]
+	cs, err := sw.tipState()
+	if err != nil {
+		return nil, err
+	}
+
+	utxos, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+	tip, err := sw.storeTip()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallet tip: %w", err)
+	} else if tip != cs.Index {
+		return nil, fmt.Errorf("wallet tip %v does not match chain tip %v", tip, cs.Index)
+	}
+
+	var orphaned []types.SiacoinOutputID
+	for _, sce := range utxos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		} else if accumulatorContains(cs.Elements, sce) {
+			continue
 		}
-		for i := len(defraggable) - 1; i >= 0; i-- {
-			if txnInputs >= sw.cfg.MaxInputsForDefrag {
-				break
-			}
 
-			sce := &defraggable[i]
-			selected = append(selected, sce.Share())
-			inputSum = inputSum.Add(sce.SiacoinOutput.Value)
-			txnInputs++
+		// the stored proof may only be stale
+		_, ok, err := sw.rebuildProof(up, is, cs, sce.ID)
+		if errors.Is(err, ErrNotFound) {
+			continue // spent since the outputs were loaded
+		} else if err != nil {
+			return nil, err
+		} else if !ok {
+			orphaned = append(orphaned, sce.ID)
 		}
 	}
-	return selected, inputSum, nil
+
+	// the proofs are only valid for the state they were checked against
+	if current := sw.cm.TipState().Index; current != cs.Index {
+		return nil, fmt.Errorf("chain tip changed from %v to %v during verification", cs.Index, current)
+	}
+	return orphaned, nil
 }
 
-// FundTransaction adds siacoin inputs worth at least amount to the provided
-// transaction. If necessary, a change output will also be added. The inputs
-// will not be available to future calls to FundTransaction unless ReleaseInputs
-// is called.
-func (sw *SingleAddressWallet) FundTransaction(txn *types.Transaction, amount types.Currency, useUnconfirmed bool) ([]types.Hash256, error) {
-	if amount.IsZero() {
-		return nil, nil
+// Repair removes any outputs reported by Verify from the store and returns
+// their IDs. Outputs with stale proofs are kept. The store must implement
+// OutputRemoverStore in addition to Verify's requirements.
+func (sw *SingleAddressWallet) Repair(ctx context.Context) ([]types.SiacoinOutputID, error) {
+	rs, ok := sw.store.(OutputRemoverStore)
+	if !ok {
+		return nil, ErrStoreUnsupported
 	}
 
-	elements, err := sw.store.UnspentSiacoinElements()
+	orphaned, err := sw.Verify(ctx)
 	if err != nil {
 		return nil, err
+	} else if len(orphaned) == 0 {
+		return nil, nil
+	} else if err := rs.RemoveWalletSiacoinElements(orphaned); err != nil {
+		return nil, fmt.Errorf("failed to remove orphaned outputs: %w", err)
 	}
+	return orphaned, nil
+}
 
-	sw.mu.Lock()
-	defer sw.mu.Unlock()
+// RefreshProofs rebuilds the Merkle proofs of any of the store's unspent
+// siacoin outputs that the chain manager's accumulator does not recognize and
+// returns their IDs. Each proof is rebuilt from the block that created the
+// output. Outputs whose block is no longer on the best chain are left for
+// Repair. The store must implement OutputIndexStore and ProofStore and be
+// synced to the chain manager's tip, and the chain manager must implement
+// UpdateProvider.
+func (sw *SingleAddressWallet) RefreshProofs(ctx context.Context) ([]types.SiacoinOutputID, error) {
+	up, ok := sw.cm.(UpdateProvider)
+	if !ok {
+		return nil, ErrChainUnsupported
+	}
+	is, ok := sw.store.(OutputIndexStore)
+	if !ok {
+		return nil, ErrStoreUnsupported
+	}
+	ps, ok := sw.store.(ProofStore)
+	if !ok {
+		return nil, ErrStoreUnsupported
+	}
 
-	selected, inputSum, err := sw.selectUTXOs(amount, len(txn.SiacoinInputs), useUnconfirmed, elements)
+	cs, err := sw.tipState()
 	if err != nil {
 		return nil, err
 	}
+	utxos, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unspent outputs: %w", err)
+	}
+	tip, err := sw.storeTip()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wallet tip: %w", err)
+	} else if tip != cs.Index {
+		return nil, fmt.Errorf("wallet tip %v does not match chain tip %v", tip, cs.Index)
+	}
 
-	// add a change output if necessary
-	if inputSum.Cmp(amount) > 0 {
-		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
-			Value:   inputSum.Sub(amount),
-			Address: sw.addr,
-		})
+	var refreshed []types.SiacoinElement
+	for _, sce := range utxos {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		} else if accumulatorContains(cs.Elements, sce) {
+			continue
+		}
+
+		updated, ok, err := sw.rebuildProof(up, is, cs, sce.ID)
+		if errors.Is(err, ErrNotFound) {
+			continue // spent since the outputs were loaded
+		} else if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		refreshed = append(refreshed, updated)
+	}
+	if len(refreshed) == 0 {
+		return nil, nil
+	} else if err := ps.SetWalletSiacoinElementProofs(cs.Index, refreshed); err != nil {
+		return nil, fmt.Errorf("failed to set proofs: %w", err)
 	}
 
-	toSign := make([]types.Hash256, len(selected))
-	for i, sce := range selected {
-		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
-			ParentID:         sce.ID,
-			UnlockConditions: types.StandardUnlockConditions(sw.priv.PublicKey()),
-		})
-		toSign[i] = types.Hash256(sce.ID)
-		sw.locked[sce.ID] = time.Now().Add(sw.cfg.ReservationDuration)
+	ids := make([]types.SiacoinOutputID, 0, len(refreshed))
+	for _, sce := range refreshed {
+		ids = append(ids, sce.ID)
+	}
+	return ids, nil
+}
+
+// rebuildProof returns the unspent siacoin element with the given ID with a
+// proof rebuilt from the block that created it and valid for cs. It returns
+// false if the block is no longer on the best chain or the element is not
+// unspent in cs, and ErrNotFound if the element is not in the store.
+func (sw *SingleAddressWallet) rebuildProof(up UpdateProvider, is OutputIndexStore, cs consensus.State, id types.SiacoinOutputID) (types.SiacoinElement, bool, error) {
+	index, err := is.WalletSiacoinElementIndex(id)
+	if errors.Is(err, ErrNotFound) {
+		return types.SiacoinElement{}, false, err
+	} else if err != nil {
+		return types.SiacoinElement{}, false, fmt.Errorf("failed to get index of output %v: %w", id, err)
+	}
+	created, ok, err := sw.createdElement(up, index, id)
+	if err != nil || !ok {
+		return types.SiacoinElement{}, false, err
+	}
+	updated, us, err := sw.UpdateProofs(index, []types.SiacoinElement{created})
+	if err != nil {
+		return types.SiacoinElement{}, false, fmt.Errorf("failed to update proof of output %v: %w", id, err)
+	} else if us.Index != cs.Index {
+		return types.SiacoinElement{}, false, fmt.Errorf("chain tip changed from %v to %v during proof update", cs.Index, us.Index)
+	} else if !accumulatorContains(cs.Elements, updated[0]) {
+		return types.SiacoinElement{}, false, nil // spent on the best chain
 	}
+	return updated[0], true, nil
+}
 
-	return toSign, nil
+// createdElement returns the siacoin element with the given ID as created by
+// the block at index, with a proof valid for that block's state. It returns
+// false if the block is no longer on the best chain or did not create the
+// element.
+func (sw *SingleAddressWallet) createdElement(up UpdateProvider, index types.ChainIndex, id types.SiacoinOutputID) (types.SiacoinElement, bool, error) {
+	var parent types.ChainIndex
+	if index.Height > 0 {
+		var ok bool
+		parent, ok = sw.cm.BestIndex(index.Height - 1)
+		if !ok {
+			return types.SiacoinElement{}, false, nil
+		}
+	}
+	_, applied, err := up.UpdatesSince(parent, 1)
+	if err != nil {
+		return types.SiacoinElement{}, false, fmt.Errorf("failed to get update for %v: %w", index, err)
+	} else if len(applied) == 0 || applied[0].State.Index != index {
+		return types.SiacoinElement{}, false, nil
+	}
+	for _, sced := range applied[0].SiacoinElementDiffs() {
+		if sced.SiacoinElement.ID == id && sced.Created && !sced.Spent {
+			return sced.SiacoinElement.Copy(), true, nil
+		}
+	}
+	return types.SiacoinElement{}, false, nil
 }
 
-// SignTransaction adds a signature to each of the specified inputs.
-func (sw *SingleAddressWallet) SignTransaction(txn *types.Transaction, toSign []types.Hash256, cf types.CoveredFields) {
-	sw.mu.Lock()
-	defer sw.mu.Unlock()
+// Maintain performs the wallet's periodic maintenance: it releases expired
+// reservations, runs ConsistencyCheck and, if the store is consistent,
+// refreshes stale proofs with RefreshProofs. An inconsistent store is
+// reported rather than returned as an error. Proofs are not refreshed if the
+// store or chain manager does not support it. Maintain is safe to call
+// concurrently with the wallet's other methods.
+func (sw *SingleAddressWallet) Maintain(ctx context.Context) (MaintenanceReport, error) {
+	var report MaintenanceReport
+	report.ReservationsCleared = len(sw.ReleaseExpiredReservations())
 
-	state := sw.cm.TipState()
+	if err := ctx.Err(); err != nil {
+		return report, err
+	} else if err := sw.ConsistencyCheck(); errors.Is(err, ErrTipMismatch) {
+		report.Inconsistency = err
+		return report, nil
+	} else if err != nil {
+		return report, fmt.Errorf("failed to check consistency: %w", err)
+	}
 
-	for _, id := range toSign {
-		var h types.Hash256
-		if cf.WholeTransaction {
-			h = state.WholeSigHash(*txn, id, 0, 0, cf.Signatures)
-		} else {
-			h = state.PartialSigHash(*txn, cf)
-		}
-		sig := sw.priv.SignHash(h)
-		txn.Signatures = append(txn.Signatures, types.TransactionSignature{
-			ParentID:       id,
-			CoveredFields:  cf,
-			PublicKeyIndex: 0,
-			Signature:      sig[:],
-		})
+	if tip, err := sw.storeTip(); err != nil {
+		return report, fmt.Errorf("failed to get wallet tip: %w", err)
+	} else if tip != sw.cm.TipState().Index {
+		// the store is on the best chain but has not caught up to its tip;
+		// its proofs are refreshed by the remaining chain updates
+		return report, nil
+	}
+
+	refreshed, err := sw.RefreshProofs(ctx)
+	if err != nil && !errors.Is(err, ErrStoreUnsupported) && !errors.Is(err, ErrChainUnsupported) {
+		return report, fmt.Errorf("failed to refresh proofs: %w", err)
 	}
+	report.ProofsRefreshed = len(refreshed)
+	return report, nil
 }
 
 // FundV2Transaction adds siacoin inputs worth at least amount to the provided
@@ -413,45 +4017,67 @@ func (sw *SingleAddressWallet) SignTransaction(txn *types.Transaction, toSign []
 //
 // The returned index should be used as the basis for AddV2PoolTransactions.
 func (sw *SingleAddressWallet) FundV2Transaction(txn *types.V2Transaction, amount types.Currency, useUnconfirmed bool) (types.ChainIndex, []int, error) {
+	res, err := sw.FundV2TransactionDetailed(txn, amount, useUnconfirmed)
+	if err != nil {
+		return types.ChainIndex{}, nil, err
+	}
+	return res.Basis, res.ToSign, nil
+}
+
+// FundV2TransactionDetailed funds the transaction in the same manner as
+// FundV2Transaction. The returned result includes the index of the change
+// output, if one was added.
+func (sw *SingleAddressWallet) FundV2TransactionDetailed(txn *types.V2Transaction, amount types.Currency, useUnconfirmed bool) (FundV2Result, error) {
 	if amount.IsZero() {
-		return sw.tip, nil, nil
+		return FundV2Result{Basis: sw.Tip(), ChangeIndex: -1}, nil
+	} else if _, err := sw.fundingState(); err != nil {
+		return FundV2Result{}, err
 	}
 
 	// fetch outputs from the store
-	elements, err := sw.store.UnspentSiacoinElements()
+	elements, err := sw.unspentSiacoinElements()
 	if err != nil {
-		return types.ChainIndex{}, nil, err
+		return FundV2Result{}, err
 	}
 
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
 
-	selected, inputSum, err := sw.selectUTXOs(amount, len(txn.SiacoinInputs), useUnconfirmed, elements)
+	selected, inputSum, err := sw.selectUnconflictedUTXOs(amount, len(txn.SiacoinInputs), unconfirmedPolicy(useUnconfirmed), types.MaxCurrency, types.ZeroCurrency, elements)
 	if err != nil {
-		return types.ChainIndex{}, nil, err
+		return FundV2Result{}, err
+	} else if err := sw.checkReservationLimit(len(selected)); err != nil {
+		return FundV2Result{}, err
 	}
 
 	// add a change output if necessary
+	res := FundV2Result{ChangeIndex: -1}
 	if inputSum.Cmp(amount) > 0 {
-		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
+		txn.SiacoinOutputs, res.ChangeIndex, err = sw.insertChange(txn.SiacoinOutputs, types.SiacoinOutput{
 			Value:   inputSum.Sub(amount),
 			Address: sw.addr,
 		})
+		if err != nil {
+			return FundV2Result{}, err
+		}
+	} else if sw.cfg.CanonicalOutputs {
+		sortOutputs(txn.SiacoinOutputs)
 	}
 
-	toSign := make([]int, 0, len(selected))
+	res.ToSign = make([]int, 0, len(selected))
 	for _, sce := range selected {
-		toSign = append(toSign, len(txn.SiacoinInputs))
+		res.ToSign = append(res.ToSign, len(txn.SiacoinInputs))
 		txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
 			Parent: sce.Copy(),
 		})
-		sw.locked[sce.ID] = time.Now().Add(sw.cfg.ReservationDuration)
 	}
-
-	return sw.tip, toSign, nil
+	sw.reserve(selected, "")
+	res.Basis = sw.tip
+	return res, nil
 }
 
-// SignV2Inputs adds a signature to each of the specified siacoin inputs.
+// SignV2Inputs adds a signature to each of the specified siacoin inputs. The
+// sign approver set with WithSignApprover is not called for v2 transactions.
 func (sw *SingleAddressWallet) SignV2Inputs(txn *types.V2Transaction, toSign []int) {
 	if len(toSign) == 0 {
 		return
@@ -460,12 +4086,12 @@ func (sw *SingleAddressWallet) SignV2Inputs(txn *types.V2Transaction, toSign []i
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
 
-	policy := sw.SpendPolicy()
 	sigHash := sw.cm.TipState().InputSigHash(*txn)
 	for _, i := range toSign {
+		addr := txn.SiacoinInputs[i].Parent.SiacoinOutput.Address
 		txn.SiacoinInputs[i].SatisfiedPolicy = types.SatisfiedPolicy{
-			Policy:     policy,
-			Signatures: []types.Signature{sw.SignHash(sigHash)},
+			Policy:     types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(sw.unlockConditionsFor(addr))},
+			Signatures: []types.Signature{sw.keyFor(addr).SignHash(sigHash)},
 		}
 	}
 }
@@ -479,7 +4105,7 @@ func (sw *SingleAddressWallet) Tip() types.ChainIndex {
 
 // SpendPolicy returns the wallet's default spend policy.
 func (sw *SingleAddressWallet) SpendPolicy() types.SpendPolicy {
-	return types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(sw.UnlockConditions())}
+	return types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(sw.unlockConditionsFor(sw.addr))}
 }
 
 // SignHash signs the hash with the wallet's private key.
@@ -490,7 +4116,7 @@ func (sw *SingleAddressWallet) SignHash(h types.Hash256) types.Signature {
 // UnconfirmedEvents returns all unconfirmed transactions relevant to the
 // wallet.
 func (sw *SingleAddressWallet) UnconfirmedEvents() (annotated []Event, err error) {
-	confirmed, err := sw.store.UnspentSiacoinElements()
+	confirmed, err := sw.unspentSiacoinElements()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get unspent outputs: %w", err)
 	}
@@ -503,7 +4129,8 @@ func (sw *SingleAddressWallet) UnconfirmedEvents() (annotated []Event, err error
 	index := types.ChainIndex{
 		Height: sw.cm.TipState().Index.Height + 1,
 	}
-	timestamp := time.Now().Truncate(time.Second)
+	timestamp := sw.cfg.Clock().Truncate(time.Second)
+	tracked := sw.trackedAddresses()
 
 	addEvent := func(id types.Hash256, eventType string, data EventData) {
 		ev := Event{
@@ -513,10 +4140,11 @@ func (sw *SingleAddressWallet) UnconfirmedEvents() (annotated []Event, err error
 			Timestamp:      timestamp,
 			Type:           eventType,
 			Data:           data,
-			Relevant:       []types.Address{sw.addr},
+			Relevant:       relevantAddresses(data, tracked),
 		}
+		ev.SelfTransfer = ev.isSelfTransfer(sw.canSpend)
 
-		if ev.SiacoinInflow().Equals(ev.SiacoinOutflow()) {
+		if !ev.SelfTransfer && ev.SiacoinInflow().Equals(ev.SiacoinOutflow()) {
 			// ignore events that don't affect the wallet
 			return
 		}
@@ -541,7 +4169,7 @@ func (sw *SingleAddressWallet) UnconfirmedEvents() (annotated []Event, err error
 
 		var inflow types.Currency
 		for i, so := range txn.SiacoinOutputs {
-			if so.Address == sw.addr {
+			if tracked[so.Address] {
 				inflow = inflow.Add(so.Value)
 				utxos[txn.SiacoinOutputID(i)] = types.SiacoinElement{
 					ID:            txn.SiacoinOutputID(i),
@@ -561,14 +4189,14 @@ func (sw *SingleAddressWallet) UnconfirmedEvents() (annotated []Event, err error
 	for _, txn := range sw.cm.V2PoolTransactions() {
 		var inflow, outflow types.Currency
 		for _, sci := range txn.SiacoinInputs {
-			if sci.Parent.SiacoinOutput.Address != sw.addr {
+			if !tracked[sci.Parent.SiacoinOutput.Address] {
 				continue
 			}
 			outflow = outflow.Add(sci.Parent.SiacoinOutput.Value)
 		}
 
 		for _, sco := range txn.SiacoinOutputs {
-			if sco.Address != sw.addr {
+			if !tracked[sco.Address] {
 				continue
 			}
 			inflow = inflow.Add(sco.Value)
@@ -602,6 +4230,9 @@ func (sw *SingleAddressWallet) selectRedistributeUTXOs(bh uint64, outputs int, a
 	// unused, matured and has the same value
 	utxos := make([]types.SiacoinElement, 0, len(elements))
 	for _, sce := range elements {
+		if !sw.canSpend(sce.SiacoinOutput.Address) {
+			continue // watch-only outputs cannot be spent
+		}
 		inUse := sw.isLocked(sce.ID) || inPool[sce.ID]
 		matured := bh >= sce.MaturityHeight
 		sameValue := sce.SiacoinOutput.Value.Equals(amount)
@@ -623,15 +4254,119 @@ func (sw *SingleAddressWallet) selectRedistributeUTXOs(bh uint64, outputs int, a
 	return utxos, outputs, nil
 }
 
+// checkRedistributeAmount returns ErrBelowDustThreshold if outputs worth
+// amount would be dust.
+func (sw *SingleAddressWallet) checkRedistributeAmount(amount types.Currency) error {
+	if dust := sw.cfg.DustThreshold; amount.Cmp(dust) < 0 {
+		return fmt.Errorf("%w: output value %v < threshold %v", ErrBelowDustThreshold, amount, dust)
+	}
+	return nil
+}
+
+// A packCandidate is an output that can be selected by packInputs, with the
+// fee of spending it and its value net of that fee.
+type packCandidate struct {
+	sce types.SiacoinElement
+	fee types.Currency
+	net types.Currency
+}
+
+// packCandidates returns the candidates for packInputs, sorted by net value,
+// descending. The fee of each output is computed once. Outputs worth less than
+// their fee are omitted, since spending them would reduce the funds available.
+func packCandidates(utxos []types.SiacoinElement, inputFee func(types.SiacoinElement) types.Currency) []packCandidate {
+	cands := make([]packCandidate, 0, len(utxos))
+	for _, sce := range utxos {
+		fee := inputFee(sce)
+		if sce.SiacoinOutput.Value.Cmp(fee) <= 0 {
+			continue
+		}
+		cands = append(cands, packCandidate{sce: sce, fee: fee, net: sce.SiacoinOutput.Value.Sub(fee)})
+	}
+	slices.SortStableFunc(cands, func(a, b packCandidate) int { return b.net.Cmp(a.net) })
+	return cands
+}
+
+// packInputs selects inputs from cands, which must be sorted by net value,
+// descending, worth more than target plus the fee of each selected input.
+// Whenever a single output covers the remainder, the output with the smallest
+// net value that does is chosen; otherwise the largest output is added. This
+// best-fit selection leaves larger outputs for later transactions, minimizing
+// the number of inputs consumed across a batch of redistribution
+// transactions. It returns the selected inputs, the sum of their fees, and the
+// remaining candidates in their original order. If the candidates are
+// insufficient, all of them are selected.
+func packInputs(cands []packCandidate, target types.Currency) (inputs []types.SiacoinElement, fees types.Currency, rest []packCandidate) {
+	var sum types.Currency // net value of the selected inputs
+	for len(cands) > 0 {
+		remainder := target.Sub(sum)
+		// the candidates before i cover the remainder; the last of them is
+		// the smallest that does
+		i := sort.Search(len(cands), func(i int) bool { return cands[i].net.Cmp(remainder) <= 0 })
+		if i > 0 {
+			inputs = append(inputs, cands[i-1].sce)
+			fees = fees.Add(cands[i-1].fee)
+			return inputs, fees, slices.Delete(cands, i-1, i)
+		}
+
+		inputs = append(inputs, cands[0].sce)
+		fees = fees.Add(cands[0].fee)
+		sum = sum.Add(cands[0].net)
+		cands = cands[1:]
+	}
+	return inputs, fees, nil
+}
+
 // Redistribute returns a transaction that redistributes money in the wallet by
 // selecting a minimal set of inputs to cover the creation of the requested
 // outputs. It also returns a list of output IDs that need to be signed.
+//
+// The amount must be at least the wallet's dust threshold, see
+// WithDustThreshold; otherwise the outputs would cost more to spend than they
+// are worth and ErrBelowDustThreshold is returned.
 func (sw *SingleAddressWallet) Redistribute(outputs int, amount, feePerByte types.Currency) (txns []types.Transaction, toSign [][]types.Hash256, err error) {
-	state := sw.cm.TipState()
+	res, err := sw.RedistributeDetailed(outputs, amount, feePerByte)
+	return res.Transactions, res.ToSign, err
+}
+
+// RedistributeDetailed redistributes the wallet's outputs in the same manner
+// as Redistribute. The returned result includes the index of each
+// transaction's change output.
+func (sw *SingleAddressWallet) RedistributeDetailed(outputs int, amount, feePerByte types.Currency) (RedistributeResult, error) {
+	return sw.redistribute(outputs, amount, feePerByte, true)
+}
+
+// SimulateRedistribute returns the plan Redistribute would follow for the
+// same arguments without reserving any outputs, e.g. to show the cost of a
+// redistribution before the user confirms it. A subsequent call to
+// Redistribute creates the planned transactions, provided the wallet's
+// outputs and reservations do not change in between.
+func (sw *SingleAddressWallet) SimulateRedistribute(outputs int, amount, feePerByte types.Currency) (RedistributePlan, error) {
+	res, err := sw.redistribute(outputs, amount, feePerByte, false)
+	if err != nil {
+		return RedistributePlan{}, err
+	}
+	plan := RedistributePlan{Transactions: res.Transactions, ChangeIndices: res.ChangeIndices}
+	for _, txn := range res.Transactions {
+		plan.Inputs += len(txn.SiacoinInputs)
+		plan.TotalFee = plan.TotalFee.Add(minerFees(txn))
+	}
+	return plan, nil
+}
+
+// redistribute implements Redistribute. If reserve is false, the inputs of
+// the returned transactions are not reserved.
+func (sw *SingleAddressWallet) redistribute(outputs int, amount, feePerByte types.Currency, reserve bool) (RedistributeResult, error) {
+	state, err := sw.fundingState()
+	if err != nil {
+		return RedistributeResult{}, err
+	} else if err := sw.checkRedistributeAmount(amount); err != nil {
+		return RedistributeResult{}, err
+	}
 
-	elements, err := sw.store.UnspentSiacoinElements()
+	elements, err := sw.unspentSiacoinElements()
 	if err != nil {
-		return nil, nil, err
+		return RedistributeResult{}, err
 	}
 
 	sw.mu.Lock()
@@ -639,67 +4374,148 @@ func (sw *SingleAddressWallet) Redistribute(outputs int, amount, feePerByte type
 
 	utxos, outputs, err := sw.selectRedistributeUTXOs(state.Index.Height, outputs, amount, elements)
 	if err != nil {
-		return nil, nil, err
+		return RedistributeResult{}, err
 	}
 
 	// return early if we don't have to defrag at all
 	if outputs <= 0 {
-		return nil, nil, nil
+		return RedistributeResult{}, nil
 	}
+	return sw.buildRedistribution(state, slices.Repeat([]types.Currency{amount}, outputs), utxos, feePerByte, true, reserve)
+}
 
-	// in case of an error we need to free all inputs
-	defer func() {
-		if err != nil {
-			for _, ids := range toSign {
-				for _, id := range ids {
-					delete(sw.locked, types.SiacoinOutputID(id))
-				}
-			}
+// RedistributeTiered is like Redistribute but creates outputs of several
+// different values at once. For each value in targets, it ensures that the
+// wallet holds the corresponding number of unused outputs of that value,
+// reusing existing outputs where possible. It also returns the output IDs that
+// need to be signed for each transaction. Each value must be at least the
+// wallet's dust threshold.
+func (sw *SingleAddressWallet) RedistributeTiered(targets map[types.Currency]int, feePerByte types.Currency) (txns []types.Transaction, toSign [][]types.Hash256, err error) {
+	res, err := sw.RedistributeTieredDetailed(targets, feePerByte)
+	return res.Transactions, res.ToSign, err
+}
+
+// RedistributeTieredDetailed redistributes the wallet's outputs in the same
+// manner as RedistributeTiered. The returned result includes the index of each
+// transaction's change output.
+func (sw *SingleAddressWallet) RedistributeTieredDetailed(targets map[types.Currency]int, feePerByte types.Currency) (RedistributeResult, error) {
+	state, err := sw.fundingState()
+	if err != nil {
+		return RedistributeResult{}, err
+	}
+	for value := range targets {
+		if err := sw.checkRedistributeAmount(value); err != nil {
+			return RedistributeResult{}, err
 		}
-	}()
+	}
+
+	elements, err := sw.unspentSiacoinElements()
+	if err != nil {
+		return RedistributeResult{}, err
+	}
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	// adjust the number of desired outputs for any unused, matured output
+	// that already has one of the target values
+	remaining := make(map[types.Currency]int, len(targets))
+	for value, n := range targets {
+		if value.IsZero() {
+			return RedistributeResult{}, errors.New("target value must be non-zero")
+		} else if n > 0 {
+			remaining[value] = n
+		}
+	}
+	inPool := sw.poolSpent()
+	utxos := make([]types.SiacoinElement, 0, len(elements))
+	for _, sce := range elements {
+		if !sw.canSpend(sce.SiacoinOutput.Address) {
+			continue // watch-only outputs cannot be spent
+		} else if sw.isLocked(sce.ID) || inPool[sce.ID] || state.Index.Height < sce.MaturityHeight {
+			continue
+		}
+
+		// outputs of a target value are never used as inputs
+		if n, ok := targets[sce.SiacoinOutput.Value]; ok && n > 0 {
+			remaining[sce.SiacoinOutput.Value]--
+			continue
+		}
+		utxos = append(utxos, sce.Share())
+	}
+
+	// build the list of outputs to create, smallest value first
+	var wanted []types.Currency
+	for value, n := range remaining {
+		for i := 0; i < n; i++ {
+			wanted = append(wanted, value)
+		}
+	}
+	if len(wanted) == 0 {
+		return RedistributeResult{}, nil
+	}
+	slices.SortFunc(wanted, func(a, b types.Currency) int { return a.Cmp(b) })
 
 	// desc sort
 	sort.Slice(utxos, func(i, j int) bool {
 		return utxos[i].SiacoinOutput.Value.Cmp(utxos[j].SiacoinOutput.Value) > 0
 	})
+	return sw.buildRedistribution(state, wanted, utxos, feePerByte, false, true)
+}
+
+// buildRedistribution builds transactions creating an output worth each value
+// in wanted, at most redistributeBatchSize per transaction, funded from utxos,
+// which must be sorted by value, descending. If partial is true, running out
+// of funds after at least one transaction has been built is not an error; the
+// remaining outputs are not created. If reserve is false, the inputs of the
+// returned transactions are not reserved. This method must be called whilst
+// holding the mutex lock.
+func (sw *SingleAddressWallet) buildRedistribution(state consensus.State, wanted []types.Currency, utxos []types.SiacoinElement, feePerByte types.Currency, partial, reserve bool) (res RedistributeResult, err error) {
+	// in case of an error we need to free all inputs. The reserved inputs
+	// are tracked separately, since the results are cleared on return.
+	var reserved []types.SiacoinOutputID
+	defer func() {
+		if err != nil {
+			sw.release(reserved)
+		}
+	}()
+
+	// planned is the number of inputs selected, but not reserved, by earlier
+	// iterations when simulating
+	var planned int
+
+	feePerInput := feePerByte.Mul64(bytesPerInput)
+	cands := packCandidates(utxos, func(types.SiacoinElement) types.Currency { return feePerInput })
 
 	// prepare defrag transactions
-	for outputs > 0 {
+	for len(wanted) > 0 {
 		var txn types.Transaction
-		for i := 0; i < outputs && i < redistributeBatchSize; i++ {
+		var want types.Currency
+		for i := 0; i < len(wanted) && i < redistributeBatchSize; i++ {
 			txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
-				Value:   amount,
+				Value:   wanted[i],
 				Address: sw.addr,
 			})
+			want = want.Add(wanted[i])
 		}
-		outputs -= len(txn.SiacoinOutputs)
+		wanted = wanted[len(txn.SiacoinOutputs):]
 
 		// estimate the fees
 		outputFees := feePerByte.Mul64(state.TransactionWeight(txn))
-		feePerInput := feePerByte.Mul64(bytesPerInput)
-
-		// collect outputs that cover the total amount
-		var inputs []types.SiacoinElement
-		want := amount.Mul64(uint64(len(txn.SiacoinOutputs)))
-		for _, sce := range utxos {
-			inputs = append(inputs, sce.Share())
-			fee := feePerInput.Mul64(uint64(len(inputs))).Add(outputFees)
-			if SumOutputs(inputs).Cmp(want.Add(fee)) > 0 {
-				break
-			}
-		}
 
-		// remove used inputs from utxos
-		utxos = utxos[len(inputs):]
+		// collect outputs that cover the total amount and remove them from
+		// the candidates
+		inputs, inputFees, rest := packInputs(cands, want.Add(outputFees))
+		cands = rest
 
 		// not enough outputs found
-		fee := feePerInput.Mul64(uint64(len(inputs))).Add(outputFees)
+		fee := inputFees.Add(outputFees)
 		if sumOut := SumOutputs(inputs); sumOut.Cmp(want.Add(fee)) < 0 {
-			if len(txns) > 0 {
+			if partial && len(res.Transactions) > 0 {
 				// consider redistributing successful if we could generate at least one txn
 				break
 			}
-			return nil, nil, fmt.Errorf("%w: inputs %v < needed %v + txnFee %v", ErrNotEnoughFunds, sumOut.String(), want.String(), fee.String())
+			return RedistributeResult{}, fmt.Errorf("%w: inputs %v < needed %v + txnFee %v", ErrNotEnoughFunds, sumOut.String(), want.String(), fee.String())
 		}
 
 		// set the miner fee
@@ -708,12 +4524,20 @@ func (sw *SingleAddressWallet) Redistribute(outputs int, amount, feePerByte type
 		}
 
 		// add the change output
+		changeIndex := -1
 		change := SumOutputs(inputs).Sub(want.Add(fee))
 		if !change.IsZero() {
-			txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
+			txn.SiacoinOutputs, changeIndex, err = sw.insertChange(txn.SiacoinOutputs, types.SiacoinOutput{
 				Value:   change,
 				Address: sw.addr,
 			})
+			if err != nil {
+				return RedistributeResult{}, err
+			}
+		}
+
+		if err := sw.checkReservationLimit(planned + len(inputs)); err != nil {
+			return RedistributeResult{}, err
 		}
 
 		// add the inputs
@@ -722,26 +4546,47 @@ func (sw *SingleAddressWallet) Redistribute(outputs int, amount, feePerByte type
 			toSignTxn = append(toSignTxn, types.Hash256(sce.ID))
 			txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
 				ParentID:         sce.ID,
-				UnlockConditions: types.StandardUnlockConditions(sw.priv.PublicKey()),
+				UnlockConditions: sw.unlockConditionsFor(sce.SiacoinOutput.Address),
 			})
-			sw.locked[sce.ID] = time.Now().Add(sw.cfg.ReservationDuration)
 		}
-		txns = append(txns, txn)
-		toSign = append(toSign, toSignTxn)
+		if reserve {
+			sw.reserve(inputs, "")
+			for _, sce := range inputs {
+				reserved = append(reserved, sce.ID)
+			}
+		} else {
+			planned += len(inputs)
+		}
+		res.Transactions = append(res.Transactions, txn)
+		res.ToSign = append(res.ToSign, toSignTxn)
+		res.ChangeIndices = append(res.ChangeIndices, changeIndex)
 	}
-
 	return
 }
 
 // RedistributeV2 returns a transaction that redistributes money in the wallet
 // by selecting a minimal set of inputs to cover the creation of the requested
-// outputs. It also returns a list of output IDs that need to be signed.
+// outputs. It also returns a list of output IDs that need to be signed. As with
+// Redistribute, the amount must be at least the wallet's dust threshold.
 func (sw *SingleAddressWallet) RedistributeV2(outputs int, amount, feePerByte types.Currency) (txns []types.V2Transaction, toSign [][]int, err error) {
-	state := sw.cm.TipState()
+	res, err := sw.RedistributeV2Detailed(outputs, amount, feePerByte)
+	return res.Transactions, res.ToSign, err
+}
+
+// RedistributeV2Detailed redistributes the wallet's outputs in the same manner
+// as RedistributeV2. The returned result includes the index of each
+// transaction's change output.
+func (sw *SingleAddressWallet) RedistributeV2Detailed(outputs int, amount, feePerByte types.Currency) (res RedistributeV2Result, err error) {
+	state, err := sw.fundingState()
+	if err != nil {
+		return RedistributeV2Result{}, err
+	} else if err := sw.checkRedistributeAmount(amount); err != nil {
+		return RedistributeV2Result{}, err
+	}
 
-	elements, err := sw.store.UnspentSiacoinElements()
+	elements, err := sw.unspentSiacoinElements()
 	if err != nil {
-		return nil, nil, err
+		return RedistributeV2Result{}, err
 	}
 
 	sw.mu.Lock()
@@ -749,25 +4594,28 @@ func (sw *SingleAddressWallet) RedistributeV2(outputs int, amount, feePerByte ty
 
 	utxos, outputs, err := sw.selectRedistributeUTXOs(state.Index.Height, outputs, amount, elements)
 	if err != nil {
-		return nil, nil, err
+		return RedistributeV2Result{}, err
 	}
 
 	// return early if we don't have to defrag at all
 	if outputs <= 0 {
-		return nil, nil, nil
+		return RedistributeV2Result{}, nil
 	}
 
-	// in case of an error we need to free all inputs
+	// in case of an error we need to free all inputs. The reserved inputs
+	// are tracked separately, since the results are cleared on return.
+	var reserved []types.SiacoinOutputID
 	defer func() {
 		if err != nil {
-			for txnIdx, toSignTxn := range toSign {
-				for i := range toSignTxn {
-					delete(sw.locked, txns[txnIdx].SiacoinInputs[i].Parent.ID)
-				}
-			}
+			sw.release(reserved)
 		}
 	}()
 
+	// the fee of a v2 input depends on the size of its proof
+	cands := packCandidates(utxos, func(sce types.SiacoinElement) types.Currency {
+		return feePerByte.Mul64(sw.v2InputWeight(state, sce))
+	})
+
 	// prepare defrag transactions
 	for outputs > 0 {
 		var txn types.V2Transaction
@@ -779,32 +4627,29 @@ func (sw *SingleAddressWallet) RedistributeV2(outputs int, amount, feePerByte ty
 		}
 		outputs -= len(txn.SiacoinOutputs)
 
-		// estimate the fees
-		outputFees := feePerByte.Mul64(state.V2TransactionWeight(txn))
-		feePerInput := feePerByte.Mul64(bytesPerInput)
+		// estimate the fees using the v2 weight of the outputs, including a
+		// possible change output, and of a signed v2 input
+		outputFees := feePerByte.Mul64(state.V2TransactionWeight(types.V2Transaction{
+			SiacoinOutputs: append(slices.Clone(txn.SiacoinOutputs), types.SiacoinOutput{Address: sw.addr}),
+		}))
 
-		// collect outputs that cover the total amount
-		var inputs []types.SiacoinElement
+		// collect outputs that cover the total amount and remove them from
+		// utxos
 		want := amount.Mul64(uint64(len(txn.SiacoinOutputs)))
-		for _, sce := range utxos {
-			inputs = append(inputs, sce.Copy())
-			fee := feePerInput.Mul64(uint64(len(inputs))).Add(outputFees)
-			if SumOutputs(inputs).Cmp(want.Add(fee)) > 0 {
-				break
-			}
+		inputs, inputFees, rest := packInputs(cands, want.Add(outputFees))
+		cands = rest
+		for i := range inputs {
+			inputs[i] = inputs[i].Copy()
 		}
 
-		// remove used inputs from utxos
-		utxos = utxos[len(inputs):]
-
 		// not enough outputs found
-		fee := feePerInput.Mul64(uint64(len(inputs))).Add(outputFees)
+		fee := inputFees.Add(outputFees)
 		if sumOut := SumOutputs(inputs); sumOut.Cmp(want.Add(fee)) < 0 {
-			if len(txns) > 0 {
+			if len(res.Transactions) > 0 {
 				// consider redistributing successful if we could generate at least one txn
 				break
 			}
-			return nil, nil, fmt.Errorf("%w: inputs %v < needed %v + txnFee %v", ErrNotEnoughFunds, sumOut.String(), want.String(), fee.String())
+			return RedistributeV2Result{}, fmt.Errorf("%w: inputs %v < needed %v + txnFee %v", ErrNotEnoughFunds, sumOut.String(), want.String(), fee.String())
 		}
 
 		// set the miner fee
@@ -813,12 +4658,20 @@ func (sw *SingleAddressWallet) RedistributeV2(outputs int, amount, feePerByte ty
 		}
 
 		// add the change output
+		changeIndex := -1
 		change := SumOutputs(inputs).Sub(want.Add(fee))
 		if !change.IsZero() {
-			txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
+			txn.SiacoinOutputs, changeIndex, err = sw.insertChange(txn.SiacoinOutputs, types.SiacoinOutput{
 				Value:   change,
 				Address: sw.addr,
 			})
+			if err != nil {
+				return RedistributeV2Result{}, err
+			}
+		}
+
+		if err := sw.checkReservationLimit(len(inputs)); err != nil {
+			return RedistributeV2Result{}, err
 		}
 
 		// add the inputs
@@ -828,36 +4681,288 @@ func (sw *SingleAddressWallet) RedistributeV2(outputs int, amount, feePerByte ty
 			txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
 				Parent: sce.Move(),
 			})
-			sw.locked[sce.ID] = time.Now().Add(sw.cfg.ReservationDuration)
 		}
-		txns = append(txns, txn)
-		toSign = append(toSign, toSignTxn)
+
+		// the estimate above covers the signed v2 weight of the transaction,
+		// so a lower fee is a bug in the estimate
+		if minFee := feePerByte.Mul64(sw.signedV2Weight(state, txn)); txn.MinerFee.Cmp(minFee) < 0 {
+			if sw.cfg.StrictValidation {
+				panic(fmt.Sprintf("wallet: fee %v < v2 minimum %v", txn.MinerFee, minFee)) // developer error
+			}
+			return RedistributeV2Result{}, fmt.Errorf("invariant violated: fee %v < v2 minimum %v", txn.MinerFee, minFee)
+		}
+		sw.reserve(inputs, "")
+		for _, sce := range inputs {
+			reserved = append(reserved, sce.ID)
+		}
+		res.Transactions = append(res.Transactions, txn)
+		res.ToSign = append(res.ToSign, toSignTxn)
+		res.ChangeIndices = append(res.ChangeIndices, changeIndex)
 	}
 	return
 }
 
+// v2InputWeight returns the weight a signed v2 input spending sce adds to a
+// transaction.
+func (sw *SingleAddressWallet) v2InputWeight(cs consensus.State, sce types.SiacoinElement) uint64 {
+	addr := sce.SiacoinOutput.Address
+	return cs.V2TransactionWeight(types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent: sce,
+			SatisfiedPolicy: types.SatisfiedPolicy{
+				Policy:     types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(sw.unlockConditionsFor(addr))},
+				Signatures: make([]types.Signature, 1),
+			},
+		}},
+	})
+}
+
+// signedV2Weight returns the v2 weight of txn once its unsigned siacoin
+// inputs have been signed by the wallet.
+func (sw *SingleAddressWallet) signedV2Weight(cs consensus.State, txn types.V2Transaction) uint64 {
+	txn.SiacoinInputs = slices.Clone(txn.SiacoinInputs)
+	for i, sci := range txn.SiacoinInputs {
+		if len(sci.SatisfiedPolicy.Signatures) != 0 {
+			continue
+		}
+		addr := sci.Parent.SiacoinOutput.Address
+		txn.SiacoinInputs[i].SatisfiedPolicy = types.SatisfiedPolicy{
+			Policy:     types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(sw.unlockConditionsFor(addr))},
+			Signatures: make([]types.Signature, 1),
+		}
+	}
+	return cs.V2TransactionWeight(txn)
+}
+
 // ReleaseInputs is a helper function that releases the inputs of txn for use in
 // other transactions. It should only be called on transactions that are invalid
 // or will never be broadcast.
 func (sw *SingleAddressWallet) ReleaseInputs(txns []types.Transaction, v2txns []types.V2Transaction) {
-	sw.mu.Lock()
-	defer sw.mu.Unlock()
+	var ids []types.Hash256
 	for _, txn := range txns {
 		for _, in := range txn.SiacoinInputs {
-			delete(sw.locked, in.ParentID)
+			ids = append(ids, types.Hash256(in.ParentID))
 		}
 	}
 	for _, txn := range v2txns {
 		for _, in := range txn.SiacoinInputs {
-			delete(sw.locked, in.Parent.ID)
+			ids = append(ids, types.Hash256(in.Parent.ID))
 		}
 	}
+	sw.ReleaseOutputs(ids...)
+}
+
+// ReleaseOutputs releases the reservations of the outputs with the given IDs,
+// leaving any other reservations in place. It is useful when only some of a
+// funded transaction's inputs are replaced. IDs that are not reserved are
+// ignored.
+func (sw *SingleAddressWallet) ReleaseOutputs(ids ...types.Hash256) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	outputIDs := make([]types.SiacoinOutputID, 0, len(ids))
+	for _, id := range ids {
+		outputIDs = append(outputIDs, types.SiacoinOutputID(id))
+	}
+	sw.release(outputIDs)
+}
+
+// ReservationLog returns the most recent reservation events, oldest first.
+// The log is empty unless it was enabled with WithReservationLogSize.
+func (sw *SingleAddressWallet) ReservationLog() []ReservationEvent {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	log := make([]ReservationEvent, 0, len(sw.reservationLog))
+	log = append(log, sw.reservationLog[sw.reservationLogNext:]...)
+	return append(log, sw.reservationLog[:sw.reservationLogNext]...)
+}
+
+// logReservation adds an event to the reservation log. This method must be
+// called whilst holding the mutex lock.
+func (sw *SingleAddressWallet) logReservation(typ string, ids []types.SiacoinOutputID, tag string) {
+	if sw.cfg.ReservationLogSize <= 0 || len(ids) == 0 {
+		return
+	}
+	ev := ReservationEvent{
+		Type:      typ,
+		Timestamp: sw.cfg.Clock(),
+		IDs:       ids,
+		Tag:       tag,
+	}
+	if len(sw.reservationLog) < sw.cfg.ReservationLogSize {
+		sw.reservationLog = append(sw.reservationLog, ev)
+		return
+	}
+	sw.reservationLog[sw.reservationLogNext] = ev
+	sw.reservationLogNext = (sw.reservationLogNext + 1) % len(sw.reservationLog)
 }
 
-// isLocked returns true if the siacoin output with given id is locked, this
+// reserve locks the elements for the configured reservation duration. This
 // method must be called whilst holding the mutex lock.
+func (sw *SingleAddressWallet) reserve(elements []types.SiacoinElement, tag string) {
+	expiry := sw.cfg.Clock().Add(sw.cfg.ReservationDuration)
+	ids := make([]types.SiacoinOutputID, 0, len(elements))
+	for _, sce := range elements {
+		sw.locked[sce.ID] = expiry
+		if tag != "" {
+			sw.tags[sce.ID] = tag
+		}
+		ids = append(ids, sce.ID)
+	}
+	sw.logReservation(ReservationEventReserved, ids, tag)
+}
+
+// checkReservationLimit returns ErrTooManyReservations if reserving n more
+// outputs would exceed the configured maximum. Expired reservations are not
+// counted. This method must be called whilst holding the mutex lock.
+func (sw *SingleAddressWallet) checkReservationLimit(n int) error {
+	if sw.cfg.MaxReservations <= 0 {
+		return nil
+	}
+
+	now := sw.cfg.Clock()
+	reserved := len(sw.pending)
+	for _, expiry := range sw.locked {
+		if now.Before(expiry) {
+			reserved++
+		}
+	}
+	if reserved+n > sw.cfg.MaxReservations {
+		return fmt.Errorf("%d outputs already reserved, reserving %d more would exceed the limit of %d; unused inputs should be released with ReleaseInputs: %w", reserved, n, sw.cfg.MaxReservations, ErrTooManyReservations)
+	}
+	return nil
+}
+
+// release unlocks the outputs with the given IDs. This method must be called
+// whilst holding the mutex lock.
+func (sw *SingleAddressWallet) release(ids []types.SiacoinOutputID) {
+	released := make([]types.SiacoinOutputID, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := sw.locked[id]; ok {
+			delete(sw.locked, id)
+			delete(sw.tags, id)
+			released = append(released, id)
+		} else if sw.pending[id] {
+			delete(sw.pending, id)
+			delete(sw.tags, id)
+			released = append(released, id)
+		}
+	}
+	sw.logReservation(ReservationEventReleased, released, "")
+}
+
+// isLocked returns true if the siacoin output with the given id is locked or
+// has a pending reservation. An expired reservation is removed when it is
+// checked. This method must be called whilst holding the mutex lock.
 func (sw *SingleAddressWallet) isLocked(id types.SiacoinOutputID) bool {
-	return time.Now().Before(sw.locked[id])
+	if sw.pending[id] {
+		return true
+	}
+	expiry, ok := sw.locked[id]
+	if !ok {
+		return false
+	} else if sw.cfg.Clock().Before(expiry) {
+		return true
+	}
+	delete(sw.locked, id)
+	delete(sw.tags, id)
+	sw.logReservation(ReservationEventExpired, []types.SiacoinOutputID{id}, "")
+	return false
+}
+
+// sweepReservations removes expired reservations every interval until the
+// wallet is closed.
+func (sw *SingleAddressWallet) sweepReservations(interval time.Duration) {
+	defer sw.wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-sw.closed:
+			return
+		case <-t.C:
+		}
+
+		sw.ReleaseExpiredReservations()
+	}
+}
+
+// ReleaseExpiredReservations releases any reservations that have expired and
+// returns the IDs of the released outputs. Expired reservations are also
+// released lazily when outputs are selected and periodically if
+// WithReservationSweepInterval is set.
+func (sw *SingleAddressWallet) ReleaseExpiredReservations() []types.SiacoinOutputID {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.cfg.Clock()
+	var expired []types.SiacoinOutputID
+	for id, expiry := range sw.locked {
+		if !now.Before(expiry) {
+			delete(sw.locked, id)
+			delete(sw.tags, id)
+			expired = append(expired, id)
+		}
+	}
+	if len(expired) > 0 {
+		sw.logReservation(ReservationEventExpired, expired, "")
+	}
+	return expired
+}
+
+// reconcilePoolReservations converts the reservations of outputs spent by a
+// pool transaction to pending reservations, which do not expire. Pending
+// reservations whose transaction has left the pool are released, unless the
+// wallet is behind the chain manager: the transaction may have been
+// confirmed, in which case the reservation is removed once the block is
+// applied.
+func (sw *SingleAddressWallet) reconcilePoolReservations() {
+	spent := sw.poolSpent()
+	tip := sw.cm.TipState().Index
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	var pending []types.SiacoinOutputID
+	for id := range sw.locked {
+		if spent[id] {
+			delete(sw.locked, id)
+			sw.pending[id] = true
+			pending = append(pending, id)
+		}
+	}
+	if len(pending) > 0 {
+		sw.logReservation(ReservationEventPending, pending, "")
+	}
+
+	if sw.tip != tip {
+		return
+	}
+	var evicted []types.SiacoinOutputID
+	for id := range sw.pending {
+		if !spent[id] {
+			delete(sw.pending, id)
+			delete(sw.tags, id)
+			evicted = append(evicted, id)
+		}
+	}
+	if len(evicted) > 0 {
+		sw.logReservation(ReservationEventReleased, evicted, "")
+	}
+}
+
+// SiafundClaimValue returns the siacoins a siafund element would claim if it
+// were spent in the given state. The claim is the growth of the siafund tax
+// revenue since the element was created, divided evenly among all siafunds,
+// matching the consensus rules: the per-siafund share is rounded down before
+// being multiplied by the element's value. Zero is returned if the element's
+// claim start exceeds the state's revenue.
+func SiafundClaimValue(element types.SiafundElement, state consensus.State) types.Currency {
+	if state.SiafundTaxRevenue.Cmp(element.ClaimStart) < 0 {
+		return types.ZeroCurrency
+	}
+	return state.SiafundTaxRevenue.Sub(element.ClaimStart).Div64(state.SiafundCount()).Mul64(element.SiafundOutput.Value)
 }
 
 // IsRelevantTransaction returns true if the v1 transaction is relevant to the
@@ -925,6 +5030,46 @@ func ExplicitCoveredFields(txn types.Transaction) (cf types.CoveredFields) {
 	return
 }
 
+// UnlockConditionsFromPublicKey returns the unlock conditions a wallet with
+// the corresponding private key uses for its inputs.
+func UnlockConditionsFromPublicKey(pk types.PublicKey) types.UnlockConditions {
+	return types.StandardUnlockConditions(pk)
+}
+
+// AddressFromPublicKey returns the address of a wallet with the corresponding
+// private key.
+func AddressFromPublicKey(pk types.PublicKey) types.Address {
+	return UnlockConditionsFromPublicKey(pk).UnlockHash()
+}
+
+// PreviewID returns the ID the transaction will have once it is signed.
+//
+// A v1 transaction's ID is computed from every field except its signatures,
+// so adding signatures never changes it, regardless of the covered fields
+// they use. The preview stays valid as long as no other field is modified
+// after it is taken. Signing with WholeTransaction covered fields guarantees
+// this, since any later modification would invalidate the signatures. With
+// partial covered fields, parties may still append uncovered inputs, outputs
+// or fees after signing, and any such change produces a different ID.
+func PreviewID(txn types.Transaction) types.TransactionID {
+	return txn.ID()
+}
+
+// StateID returns a deterministic identifier for the consensus state. Two
+// states have the same ID if and only if they have the same network, chain
+// index and accumulated state, so callers building several transactions
+// can compare IDs to ensure they all used an identical state, e.g. when
+// sharing sighashes or proofs.
+func StateID(state consensus.State) types.Hash256 {
+	h := types.NewHasher()
+	h.WriteDistinguisher("wallet/state")
+	if state.Network != nil {
+		h.E.WriteString(state.Network.Name)
+	}
+	state.EncodeTo(h.E)
+	return h.Sum()
+}
+
 // SumOutputs returns the total value of the supplied outputs.
 func SumOutputs(outputs []types.SiacoinElement) (sum types.Currency) {
 	for _, o := range outputs {
@@ -933,15 +5078,45 @@ func SumOutputs(outputs []types.SiacoinElement) (sum types.Currency) {
 	return
 }
 
+// checkStoreAddress reconciles the address recorded by the store with the
+// wallet's address. A fresh store is initialized with addr.
+func checkStoreAddress(as AddressStore, addr types.Address) error {
+	recorded, err := as.WalletAddress()
+	if errors.Is(err, ErrNotFound) {
+		if err := as.SetWalletAddress(addr); err != nil {
+			return fmt.Errorf("failed to set wallet address: %w", err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get wallet address: %w", err)
+	} else if recorded != addr {
+		return fmt.Errorf("store was created for address %v, not %v: %w", recorded, addr, ErrDifferentSeed)
+	}
+	return nil
+}
+
 // NewSingleAddressWallet returns a new SingleAddressWallet using the provided
 // private key and store.
 func NewSingleAddressWallet(priv types.PrivateKey, cm ChainManager, store SingleAddressStore, opts ...Option) (*SingleAddressWallet, error) {
+	return newSingleAddressWallet(priv, UnlockConditionsFromPublicKey(priv.PublicKey()), cm, store, opts...)
+}
+
+// newSingleAddressWallet returns a new SingleAddressWallet for the address of
+// uc. priv may be empty if the wallet is never used for signing.
+func newSingleAddressWallet(priv types.PrivateKey, uc types.UnlockConditions, cm ChainManager, store SingleAddressStore, opts ...Option) (*SingleAddressWallet, error) {
 	cfg := config{
-		DefragThreshold:     30,
-		MaxInputsForDefrag:  30,
-		MaxDefragUTXOs:      10,
-		ReservationDuration: 3 * time.Hour,
-		Log:                 zap.NewNop(),
+		DefragThreshold:       30,
+		MaxInputsForDefrag:    30,
+		MaxDefragUTXOs:        10,
+		AntiFragmentThreshold: 20,
+		ReservationDuration:   3 * time.Hour,
+		MaxReservations:       defaultMaxReservations,
+		MaxFeeMultiplier:      10,
+		ChangePosition:        ChangePositionLast,
+		StreamBufferSize:      100,
+		Clock:                 time.Now,
+		RNG:                   frand.New(),
+		Log:                   zap.NewNop(),
 	}
 
 	for _, opt := range opts {
@@ -953,8 +5128,18 @@ func NewSingleAddressWallet(priv types.PrivateKey, cm ChainManager, store Single
 		return nil, fmt.Errorf("failed to get wallet tip: %w", err)
 	}
 
+	if as, ok := store.(AddressStore); ok && !cfg.SkipAddressCheck {
+		if err := checkStoreAddress(as, uc.UnlockHash()); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := cm.(PoolNotifier); cfg.PoolReservations && !ok {
+		return nil, errors.New("pool reservations require a chain manager that implements PoolNotifier")
+	}
 	sw := &SingleAddressWallet{
 		priv: priv,
+		addr: uc.UnlockHash(),
+		uc:   uc,
 
 		store: store,
 		cm:    cm,
@@ -962,9 +5147,28 @@ func NewSingleAddressWallet(priv types.PrivateKey, cm ChainManager, store Single
 		cfg: cfg,
 		log: cfg.Log,
 
-		addr:   types.StandardUnlockHash(priv.PublicKey()),
-		tip:    tip,
-		locked: make(map[types.SiacoinOutputID]time.Time),
+		closed:  make(chan struct{}),
+		streams: make(map[*txnStream]struct{}),
+
+		tip:      tip,
+		locked:   make(map[types.SiacoinOutputID]time.Time),
+		pending:  make(map[types.SiacoinOutputID]bool),
+		tags:     make(map[types.SiacoinOutputID]string),
+		poolSeen: make(map[types.TransactionID]uint64),
+		watched:  make(map[types.Address]bool),
+		keys:     make(map[types.Address]types.PrivateKey, len(cfg.AdditionalKeys)),
+	}
+	for _, key := range cfg.AdditionalKeys {
+		if addr := AddressFromPublicKey(key.PublicKey()); addr != sw.addr {
+			sw.keys[addr] = key
+		}
+	}
+	if cfg.PoolReservations {
+		sw.cancelPoolReservations = cm.(PoolNotifier).OnPoolChange(sw.reconcilePoolReservations)
+	}
+	if cfg.ReservationSweepInterval > 0 {
+		sw.wg.Add(1)
+		go sw.sweepReservations(cfg.ReservationSweepInterval)
 	}
 	return sw, nil
 }
@@ -0,0 +1,181 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils"
+	"go.sia.tech/coreutils/chain"
+)
+
+func TestInsertChange(t *testing.T) {
+	outputs := []types.SiacoinOutput{
+		{Address: types.Address{1}, Value: types.Siacoins(3)},
+		{Address: types.Address{2}, Value: types.Siacoins(1)},
+	}
+	change := types.SiacoinOutput{Address: types.Address{3}, Value: types.Siacoins(2)}
+
+	tests := []struct {
+		cfg   config
+		index int
+	}{
+		{config{ChangePosition: ChangePositionLast}, 2},
+		{config{ChangePosition: ChangePositionFirst}, 0},
+		{config{ChangePosition: 1}, 1},
+		{config{ChangePosition: 5}, 2}, // past the end
+		{config{CanonicalOutputs: true}, 1},
+	}
+	for _, test := range tests {
+		sw := &SingleAddressWallet{cfg: test.cfg}
+		inserted, i, err := sw.insertChange(append([]types.SiacoinOutput(nil), outputs...), change)
+		if err != nil {
+			t.Fatal(err)
+		} else if i != test.index {
+			t.Fatalf("expected change at index %v, got %v", test.index, i)
+		} else if len(inserted) != 3 || inserted[i] != change {
+			t.Fatalf("expected change output at index %v, got %v", i, inserted)
+		}
+	}
+}
+
+func TestInsertChangeZero(t *testing.T) {
+	outputs := []types.SiacoinOutput{{Address: types.Address{1}, Value: types.Siacoins(1)}}
+	zero := types.SiacoinOutput{Address: types.Address{2}}
+
+	// a zero-value change output is an error
+	sw := &SingleAddressWallet{}
+	if inserted, i, err := sw.insertChange(outputs, zero); err == nil {
+		t.Fatal("expected an error for a zero-value change output")
+	} else if inserted != nil || i != -1 {
+		t.Fatalf("expected no outputs and index -1, got %v and %v", inserted, i)
+	}
+
+	// with strict validation, it panics
+	sw = &SingleAddressWallet{cfg: config{StrictValidation: true}}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a zero-value change output")
+		}
+	}()
+	sw.insertChange(outputs, zero)
+}
+
+func TestAccumulatorContains(t *testing.T) {
+	n, genesis := chain.TestnetZen()
+	n.InitialTarget = types.BlockID{0xFF}
+	n.MaturityDelay = 5
+	n.HardforkDevAddr.Height = 1
+	n.HardforkTax.Height = 1
+	n.HardforkStorageProof.Height = 1
+	n.HardforkOak.Height = 1
+	n.HardforkASIC.Height = 1
+	n.HardforkFoundation.Height = 1
+	n.HardforkV2.AllowHeight = 1
+	n.HardforkV2.RequireHeight = 1
+	store, genesisState, err := chain.NewDBStore(chain.NewMemDB(), n, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(store, genesisState)
+
+	pk := types.GeneratePrivateKey()
+	policy := types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(types.StandardUnlockConditions(pk.PublicKey()))}
+	addr := policy.Address()
+	mine := func(addr types.Address, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if block, found := coreutils.MineBlock(cm, addr, 5*time.Second); !found {
+				t.Fatal("failed to mine block")
+			} else if err := cm.AddBlocks([]types.Block{block}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// track the proofs of the elements created for addr
+	var elements []types.SiacoinElement
+	basis := cm.Tip()
+	sync := func() {
+		t.Helper()
+		_, applied, err := cm.UpdatesSince(basis, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cau := range applied {
+			for i := range elements {
+				cau.UpdateElementProof(&elements[i].StateElement)
+			}
+			for _, sced := range cau.SiacoinElementDiffs() {
+				if sced.SiacoinElement.SiacoinOutput.Address == addr && sced.Created && !sced.Spent {
+					elements = append(elements, sced.SiacoinElement.Copy())
+				}
+			}
+			basis = cau.State.Index
+		}
+	}
+	mine(addr, 3)
+	mine(types.VoidAddress, int(n.MaturityDelay))
+	sync()
+	if len(elements) != 3 {
+		t.Fatalf("expected 3 elements, got %v", len(elements))
+	}
+
+	spendTxn := func(cs consensus.State, sce types.SiacoinElement) types.V2Transaction {
+		txn := types.V2Transaction{
+			SiacoinInputs:  []types.V2SiacoinInput{{Parent: sce.Copy(), SatisfiedPolicy: types.SatisfiedPolicy{Policy: policy}}},
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: sce.SiacoinOutput.Value}},
+		}
+		txn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{pk.SignHash(cs.InputSigHash(txn))}
+		return txn
+	}
+
+	// the leaf hash must match core's: an element is contained exactly when
+	// consensus accepts a transaction spending it
+	cs := cm.TipState()
+	for _, sce := range elements {
+		tampered := sce.Copy()
+		tampered.SiacoinOutput.Value = tampered.SiacoinOutput.Value.Sub(types.NewCurrency64(1))
+		if err := consensus.ValidateV2Transaction(consensus.NewMidState(cs), spendTxn(cs, sce)); err != nil {
+			t.Fatal(err)
+		} else if !accumulatorContains(cs.Elements, sce) {
+			t.Fatalf("expected element %v to be contained", sce.ID)
+		} else if err := consensus.ValidateV2Transaction(consensus.NewMidState(cs), spendTxn(cs, tampered)); err == nil {
+			t.Fatal("expected consensus to reject the tampered element")
+		} else if accumulatorContains(cs.Elements, tampered) {
+			t.Fatalf("expected tampered element %v not to be contained", sce.ID)
+		}
+	}
+
+	// a spent element is not contained
+	if _, err := cm.AddV2PoolTransactions(cs.Index, []types.V2Transaction{spendTxn(cs, elements[0])}); err != nil {
+		t.Fatal(err)
+	}
+	mine(types.VoidAddress, 1)
+	sync()
+	cs = cm.TipState()
+	if accumulatorContains(cs.Elements, elements[0]) {
+		t.Fatal("expected the spent element not to be contained")
+	}
+	for _, sce := range elements[1:] {
+		if !accumulatorContains(cs.Elements, sce) {
+			t.Fatalf("expected element %v to be contained", sce.ID)
+		}
+	}
+}
+
+func TestStreamSeenLimit(t *testing.T) {
+	s := &txnStream{seen: make(map[types.Hash256]bool)}
+	id := func(i int) types.Hash256 { return types.Hash256{byte(i), byte(i >> 8), byte(i >> 16)} }
+	for i := 0; i < streamSeenLimit+10; i++ {
+		s.markSeen(id(i))
+	}
+	if len(s.seen) != streamSeenLimit || len(s.seenOrder) != streamSeenLimit {
+		t.Fatalf("expected %v seen IDs, got %v and %v", streamSeenLimit, len(s.seen), len(s.seenOrder))
+	} else if s.seen[id(0)] {
+		t.Fatal("expected the oldest ID to be forgotten")
+	} else if !s.seen[id(streamSeenLimit+9)] {
+		t.Fatal("expected the newest ID to be remembered")
+	}
+}
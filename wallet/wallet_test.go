@@ -1,10 +1,17 @@
 package wallet_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math/bits"
 	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,6 +23,7 @@ import (
 	"go.sia.tech/coreutils/wallet"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
+	"lukechampine.com/frand"
 )
 
 func syncDB(cm *chain.Manager, store *testutil.EphemeralWalletStore, w *wallet.SingleAddressWallet) error {
@@ -41,6 +49,35 @@ func syncDB(cm *chain.Manager, store *testutil.EphemeralWalletStore, w *wallet.S
 	}
 }
 
+// newTestWallet returns a wallet with a random key, backed by an ephemeral
+// store and an in-memory chain manager. The wallet is closed when the test
+// ends.
+func newTestWallet(tb testing.TB, opts ...wallet.Option) (*chain.Manager, *testutil.EphemeralWalletStore, *wallet.SingleAddressWallet) {
+	return newTestWalletWithKey(tb, types.GeneratePrivateKey(), opts...)
+}
+
+// newTestWalletWithKey returns a wallet for pk in the same manner as
+// newTestWallet.
+func newTestWalletWithKey(tb testing.TB, pk types.PrivateKey, opts ...wallet.Option) (*chain.Manager, *testutil.EphemeralWalletStore, *wallet.SingleAddressWallet) {
+	tb.Helper()
+
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+	ws := testutil.NewEphemeralWalletStore()
+
+	l := zaptest.NewLogger(tb)
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, append([]wallet.Option{wallet.WithLogger(l.Named("wallet"))}, opts...)...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { w.Close() })
+	return cm, ws, w
+}
+
 func mineAndSync(t *testing.T, cm *chain.Manager, ws *testutil.EphemeralWalletStore, w *wallet.SingleAddressWallet, address types.Address, n uint64) {
 	t.Helper()
 
@@ -277,7 +314,9 @@ func TestWallet(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true})
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
 
 	// check that wallet now has no spendable balance
 	assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, types.ZeroCurrency)
@@ -304,13 +343,13 @@ func TestWallet(t *testing.T) {
 	// check that the balance was confirmed and the other values reset
 	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
 
-	// check that the transaction event was not created since it has no
-	// effect on the wallet's balance
+	// check that the transaction was recorded as a self-transfer even
+	// though it has no effect on the wallet's balance
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 1 {
-		t.Fatalf("expected 1 transactions, got %v", count)
+	} else if count != 2 {
+		t.Fatalf("expected 2 transactions, got %v", count)
 	}
 
 	// send all the outputs to the burn address individually
@@ -324,7 +363,9 @@ func TestWallet(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		w.SignTransaction(&sent[i], toSign, types.CoveredFields{WholeTransaction: true})
+		if err := w.SignTransaction(&sent[i], toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
 	}
 
 	// add the transactions to the pool
@@ -333,20 +374,20 @@ func TestWallet(t *testing.T) {
 	}
 	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
 
-	// check that the wallet now has 21 transactions: the initial payout
-	// transaction and 20 void transactions
+	// check that the wallet now has 22 transactions: the initial payout
+	// transaction, the self-transfer and 20 void transactions
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 21 {
-		t.Fatalf("expected 21 transactions, got %v", count)
+	} else if count != 22 {
+		t.Fatalf("expected 22 transactions, got %v", count)
 	}
 
 	// check that all the wallet balances have reset
 	assertBalance(t, w, types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency)
 
 	// check that the paginated transactions are in the proper order
-	events, err := w.Events(0, 20) // limit of 20 to exclude the original payout
+	events, err := w.Events(0, 20) // limit of 20 to exclude the self-transfer and the original payout
 	if err != nil {
 		t.Fatal(err)
 	} else if len(events) != 20 {
@@ -377,9 +418,11 @@ func TestWalletUnconfirmed(t *testing.T) {
 
 	// create chain manager and subscribe the wallet
 	cm := chain.NewManager(cs, tipState)
-	// create wallet
+	// create wallet with a fixed clock so reservations and event timestamps
+	// don't depend on the test's running time
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 	l := zaptest.NewLogger(t)
-	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")))
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")), wallet.WithClock(func() time.Time { return now }))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -405,7 +448,9 @@ func TestWalletUnconfirmed(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true})
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
 
 	// check that wallet now has no spendable balance
 	assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, types.ZeroCurrency)
@@ -421,6 +466,8 @@ func TestWalletUnconfirmed(t *testing.T) {
 		t.Fatal(err)
 	} else if len(poolTxns) != 1 {
 		t.Fatal("expected 1 unconfirmed transaction")
+	} else if !poolTxns[0].Timestamp.Equal(now) {
+		t.Fatalf("expected timestamp %v, got %v", now, poolTxns[0].Timestamp)
 	}
 
 	txn2 := types.Transaction{
@@ -439,7 +486,9 @@ func TestWalletUnconfirmed(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.SignTransaction(&txn2, toSign, types.CoveredFields{WholeTransaction: true})
+	if err := w.SignTransaction(&txn2, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
 
 	// broadcast the transaction
 	if _, err := cm.AddPoolTransactions([]types.Transaction{txn, txn2}); err != nil {
@@ -500,7 +549,9 @@ func TestWalletRedistribute(t *testing.T) {
 		}
 
 		for i := 0; i < len(txns); i++ {
-			w.SignTransaction(&txns[i], toSign[i], types.CoveredFields{WholeTransaction: true})
+			if err := w.SignTransaction(&txns[i], toSign[i], types.CoveredFields{WholeTransaction: true}); err != nil {
+				t.Fatal(err)
+			}
 		}
 		if _, err := cm.AddPoolTransactions(txns); err != nil {
 			return fmt.Errorf("failed to add transactions to pool: %w", err)
@@ -676,6 +727,67 @@ func TestWalletRedistributeV2(t *testing.T) {
 	}
 }
 
+func TestRedistributeV2Weight(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.Network()
+	network.HardforkV2.AllowHeight = 1 // allow V2 transactions from the start
+	cs, tipState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, tipState)
+	// create wallet
+	l := zaptest.NewLogger(t)
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, cm.TipState().MaturityHeight()-1)
+
+	feePerByte := types.NewCurrency64(1000)
+	res, err := w.RedistributeV2Detailed(3, types.Siacoins(75e3), feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txns, toSign := res.Transactions, res.ToSign
+	if len(txns) != 1 {
+		t.Fatalf("expected 1 transaction, got %v", len(txns))
+	}
+	txn := txns[0]
+	if i := res.ChangeIndices[0]; i != 3 || txn.SiacoinOutputs[i].Value.Equals(types.Siacoins(75e3)) {
+		t.Fatalf("expected change output at index 3, got %v", i)
+	}
+	w.SignV2Inputs(&txn, toSign[0])
+
+	// the fee should be exactly the v2 weight of the signed transaction,
+	// which includes a change output
+	if len(txn.SiacoinOutputs) != 4 {
+		t.Fatalf("expected 4 outputs, got %v", len(txn.SiacoinOutputs))
+	} else if expected := feePerByte.Mul64(cm.TipState().V2TransactionWeight(txn)); !txn.MinerFee.Equals(expected) {
+		t.Fatalf("expected fee %v, got %v", expected, txn.MinerFee)
+	}
+
+	// the v1 per-input estimate underestimates the weight of a v2 input
+	v1Estimate := feePerByte.Mul64(cm.TipState().V2TransactionWeight(types.V2Transaction{SiacoinOutputs: txn.SiacoinOutputs}) + 241*uint64(len(txn.SiacoinInputs)))
+	if txn.MinerFee.Cmp(v1Estimate) <= 0 {
+		t.Fatalf("expected fee %v to exceed v1 estimate %v", txn.MinerFee, v1Estimate)
+	}
+
+	if _, err := cm.AddV2PoolTransactions(cm.Tip(), []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestReorg(t *testing.T) {
 	// create wallet store
 	pk := types.GeneratePrivateKey()
@@ -768,7 +880,9 @@ func TestReorg(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true})
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
 
 	// check that wallet now has no spendable balance
 	assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, types.ZeroCurrency)
@@ -797,12 +911,12 @@ func TestReorg(t *testing.T) {
 	// check that the balance was confirmed and the other values reset
 	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
 
-	// check that the wallet still has a single event
+	// check that the self-transfer was recorded
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 1 {
-		t.Fatalf("expected 1 transactions, got %v", count)
+	} else if count != 2 {
+		t.Fatalf("expected 2 transactions, got %v", count)
 	}
 
 	txn2 := types.Transaction{
@@ -814,7 +928,9 @@ func TestReorg(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.SignTransaction(&txn2, toSign, types.CoveredFields{WholeTransaction: true})
+	if err := w.SignTransaction(&txn2, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
 	// release the inputs to construct a double spend
 	w.ReleaseInputs([]types.Transaction{txn2}, nil)
 
@@ -827,7 +943,9 @@ func TestReorg(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.SignTransaction(&txn1, toSign, types.CoveredFields{WholeTransaction: true})
+	if err := w.SignTransaction(&txn1, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
 
 	// add the first transaction to the pool
 	if _, err := cm.AddPoolTransactions([]types.Transaction{txn1}); err != nil {
@@ -835,13 +953,13 @@ func TestReorg(t *testing.T) {
 	}
 	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
 
-	// check that the wallet now has 2 transactions: the initial payout
-	// and a void transaction
+	// check that the wallet now has 3 transactions: the initial payout,
+	// the self-transfer and a void transaction
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 2 {
-		t.Fatalf("expected 2 transactions, got %v", count)
+	} else if count != 3 {
+		t.Fatalf("expected 3 transactions, got %v", count)
 	}
 	assertEvent(t, w, types.Hash256(txn1.ID()), wallet.EventTypeV1Transaction, types.ZeroCurrency, initialReward.Div64(2), cm.Tip().Height)
 	assertBalance(t, w, initialReward.Div64(2), initialReward.Div64(2), types.ZeroCurrency, types.ZeroCurrency)
@@ -884,8 +1002,8 @@ func TestReorg(t *testing.T) {
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 2 {
-		t.Fatalf("expected 1 transactions, got %v", count)
+	} else if count != 3 {
+		t.Fatalf("expected 3 transactions, got %v", count)
 	}
 	assertEvent(t, w, types.Hash256(txn2.ID()), wallet.EventTypeV1Transaction, types.ZeroCurrency, initialReward, cm.Tip().Height)
 }
@@ -982,7 +1100,9 @@ func TestWalletV2(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true})
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
 
 	// check that wallet now has no spendable balance
 	assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, types.ZeroCurrency)
@@ -1010,13 +1130,13 @@ func TestWalletV2(t *testing.T) {
 	// check that the balance was confirmed and the other values reset
 	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
 
-	// check that the wallet still has a single event since the transaction
-	// does not affect the wallet's balance
+	// check that the transaction was recorded as a self-transfer even
+	// though it does not affect the wallet's balance
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 1 {
-		t.Fatalf("expected 1 transactions, got %v", count)
+	} else if count != 2 {
+		t.Fatalf("expected 2 transactions, got %v", count)
 	}
 
 	// mine until the v2 require height
@@ -1059,8 +1179,8 @@ func TestWalletV2(t *testing.T) {
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 2 {
-		t.Fatalf("expected 2 events, got %v", count)
+	} else if count != 3 {
+		t.Fatalf("expected 3 events, got %v", count)
 	}
 
 	inflow, outflow := v2TransactionValues(t, v2Txn, w.Address())
@@ -1191,12 +1311,12 @@ func TestReorgV2(t *testing.T) {
 	// check that the balance was confirmed and the other values reset
 	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
 
-	// check that the wallet has a single event
+	// check that the self-transfer was recorded
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 1 {
-		t.Fatalf("expected 1 transactions, got %v", count)
+	} else if count != 2 {
+		t.Fatalf("expected 2 transactions, got %v", count)
 	}
 
 	txn2 := types.V2Transaction{
@@ -1230,13 +1350,13 @@ func TestReorgV2(t *testing.T) {
 	}
 	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
 
-	// check that the wallet now has 2 transactions: the initial payout
-	// transaction and a void transaction
+	// check that the wallet now has 3 transactions: the initial payout
+	// transaction, the self-transfer and a void transaction
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 2 {
-		t.Fatalf("expected 2 transactions, got %v", count)
+	} else if count != 3 {
+		t.Fatalf("expected 3 transactions, got %v", count)
 	}
 	assertEvent(t, w, types.Hash256(txn1.ID()), wallet.EventTypeV2Transaction, types.ZeroCurrency, initialReward.Div64(2), cm.Tip().Height)
 	assertBalance(t, w, initialReward.Div64(2), initialReward.Div64(2), types.ZeroCurrency, types.ZeroCurrency)
@@ -1294,18 +1414,18 @@ func TestReorgV2(t *testing.T) {
 	// all balances should now be zero
 	assertBalance(t, w, types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency, types.ZeroCurrency)
 
-	// check that the wallet is back to two events
+	// check that the wallet is back to three events
 	count, err = w.EventCount()
 	if err != nil {
 		t.Fatal(err)
-	} else if count != 2 {
-		t.Fatalf("expected 2 transactions, got %v", count)
+	} else if count != 3 {
+		t.Fatalf("expected 3 transactions, got %v", count)
 	}
 
 	events, err = w.Events(0, 100)
 	if err != nil {
 		t.Fatal(err)
-	} else if len(events) != 2 {
+	} else if len(events) != 3 {
 		t.Fatalf("expected 3 transactions, got %v", len(events))
 	} else if events[0].ID != types.Hash256(txn2.ID()) { // new transaction first
 		t.Fatalf("expected transaction %v, got %v", txn2.ID(), events[0].ID)
@@ -1457,7 +1577,9 @@ func TestSingleAddressWalletEventTypes(t *testing.T) {
 		if err != nil {
 			t.Fatal("fund transaction", err)
 		}
-		wm.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true})
+		if err := wm.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
 		// calculate inflow and outflow before broadcasting
 		inflow, outflow := transactionValues(t, wm, txn, wm.Address())
 		// broadcast the transaction
@@ -1497,7 +1619,9 @@ func TestSingleAddressWalletEventTypes(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		wm.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true})
+		if err := wm.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
 
 		// broadcast the transaction
 		if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
@@ -1886,3 +2010,7318 @@ func TestV2TxPoolRace(t *testing.T) {
 		t.Fatal("expected ephemeral output to be replaced")
 	}
 }
+
+func TestBuildTransaction(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	var err error
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, cm.TipState().MaturityHeight()-1)
+
+	initialReward := cm.TipState().BlockReward()
+	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+
+	// try to build a transaction that exceeds the wallet's balance
+	feePerByte := types.Siacoins(1).Div64(1000)
+	_, err = w.BuildTransaction([]types.SiacoinOutput{
+		{Address: types.VoidAddress, Value: initialReward},
+	}, nil, feePerByte)
+	if !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	}
+	// no inputs should have been reserved
+	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+
+	sendAmount := types.Siacoins(1000)
+	txn, err := w.BuildTransaction([]types.SiacoinOutput{
+		{Address: types.VoidAddress, Value: sendAmount},
+	}, [][]byte{[]byte("hello, world!")}, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.MinerFees) != 1 {
+		t.Fatalf("expected 1 miner fee, got %v", len(txn.MinerFees))
+	} else if len(txn.Signatures) != len(txn.SiacoinInputs) {
+		t.Fatalf("expected %v signatures, got %v", len(txn.SiacoinInputs), len(txn.Signatures))
+	}
+
+	// the fee should cover the final weight of the transaction
+	fee := txn.MinerFees[0]
+	if minFee := feePerByte.Mul64(cm.TipState().TransactionWeight(txn)); fee.Cmp(minFee) < 0 {
+		t.Fatalf("expected fee of at least %v, got %v", minFee, fee)
+	}
+
+	// the inputs should be reserved
+	assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	expected := initialReward.Sub(sendAmount).Sub(fee)
+	assertBalance(t, w, expected, expected, types.ZeroCurrency, types.ZeroCurrency)
+}
+
+func TestBuildTransactionRelease(t *testing.T) {
+	errRejected := errors.New("rejected")
+	cm, ws, w := newTestWallet(t, wallet.WithSignApprover(func(types.Transaction) error { return errRejected }))
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, cm.TipState().MaturityHeight()-1)
+
+	initialReward := cm.TipState().BlockReward()
+	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+
+	// the inputs are funded, but signing fails
+	_, err := w.BuildTransaction([]types.SiacoinOutput{
+		{Address: types.VoidAddress, Value: types.Siacoins(1000)},
+	}, nil, types.Siacoins(1).Div64(1000))
+	if !errors.Is(err, errRejected) {
+		t.Fatalf("expected approver error, got %v", err)
+	}
+	// the inputs should have been released
+	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+}
+
+func TestChangePosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		position wallet.ChangePosition
+		index    int
+	}{
+		{"last", wallet.ChangePositionLast, 2},
+		{"first", wallet.ChangePositionFirst, 0},
+		{"explicit", wallet.ChangePosition(1), 1},
+		{"out of range", wallet.ChangePosition(10), 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cm, ws, w := newTestWallet(t, wallet.WithChangePosition(test.position))
+
+			// fund the wallet
+			mineAndSync(t, cm, ws, w, w.Address(), 1)
+			mineAndSync(t, cm, ws, w, types.VoidAddress, cm.TipState().MaturityHeight()-1)
+
+			txn := types.Transaction{
+				SiacoinOutputs: []types.SiacoinOutput{
+					{Address: types.VoidAddress, Value: types.Siacoins(100)},
+					{Address: types.VoidAddress, Value: types.Siacoins(200)},
+				},
+			}
+			res, err := w.FundTransactionDetailed(&txn, types.Siacoins(300), false)
+			if err != nil {
+				t.Fatal(err)
+			} else if res.ChangeIndex != test.index {
+				t.Fatalf("expected change index %v, got %v", test.index, res.ChangeIndex)
+			} else if len(txn.SiacoinOutputs) != 3 {
+				t.Fatalf("expected 3 outputs, got %v", len(txn.SiacoinOutputs))
+			} else if change := txn.SiacoinOutputs[res.ChangeIndex]; change.Address != w.Address() {
+				t.Fatalf("expected change output at index %v", res.ChangeIndex)
+			}
+			w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+			// funding with a fee reports the change output as well
+			txn = types.Transaction{
+				SiacoinOutputs: []types.SiacoinOutput{
+					{Address: types.VoidAddress, Value: types.Siacoins(100)},
+					{Address: types.VoidAddress, Value: types.Siacoins(200)},
+				},
+			}
+			res, err = w.FundTransactionWithFeeDetailed(&txn, types.Siacoins(300), types.NewCurrency64(1), false)
+			if err != nil {
+				t.Fatal(err)
+			} else if res.ChangeIndex != test.index {
+				t.Fatalf("expected change index %v, got %v", test.index, res.ChangeIndex)
+			} else if change := txn.SiacoinOutputs[res.ChangeIndex]; change.Address != w.Address() {
+				t.Fatalf("expected change output at index %v", res.ChangeIndex)
+			}
+			w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+			// redistribute into outputs and check the change output of each
+			// transaction
+			rres, err := w.RedistributeDetailed(3, types.Siacoins(1000), types.ZeroCurrency)
+			if err != nil {
+				t.Fatal(err)
+			}
+			txns := rres.Transactions
+			if len(txns) != 1 {
+				t.Fatalf("expected 1 transaction, got %v", len(txns))
+			}
+			expected := int(test.position)
+			if expected < 0 || expected >= len(txns[0].SiacoinOutputs) {
+				expected = len(txns[0].SiacoinOutputs) - 1
+			}
+			if rres.ChangeIndices[0] != expected {
+				t.Fatalf("expected change index %v, got %v", expected, rres.ChangeIndices[0])
+			}
+			for i, sco := range txns[0].SiacoinOutputs {
+				if isChange := !sco.Value.Equals(types.Siacoins(1000)); isChange != (i == expected) {
+					t.Fatalf("expected change output at index %v", expected)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectionModeOldestFirst(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWalletWithKey(t, pk, wallet.WithSelectionMode(wallet.SelectionModeOldestFirst))
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, cm.TipState().MaturityHeight()-1)
+
+	// burn most of the payout, leaving a small output
+	initialReward := cm.TipState().BlockReward()
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: w.Address(), Value: types.Siacoins(10)},
+			{Address: types.VoidAddress, Value: initialReward.Sub(types.Siacoins(10))},
+		},
+	}
+	toSign, err := w.FundTransaction(&txn, initialReward, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	oldest := txn.SiacoinOutputID(0)
+
+	// mine a newer, larger payout
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	spendable, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(spendable) != 2 {
+		t.Fatalf("expected 2 spendable outputs, got %v", len(spendable))
+	}
+
+	// the oldest output should be selected even though it is smaller
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.VoidAddress, Value: types.Siacoins(5)},
+		},
+	}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(5), false); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 1 || txn.SiacoinInputs[0].ParentID != oldest {
+		t.Fatalf("expected the oldest output to be selected")
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// a wallet using the default mode should select the largest output
+	w2, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.VoidAddress, Value: types.Siacoins(5)},
+		},
+	}
+	if _, err := w2.FundTransaction(&txn, types.Siacoins(5), false); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 1 || txn.SiacoinInputs[0].ParentID == oldest {
+		t.Fatalf("expected the largest output to be selected")
+	}
+}
+
+func TestHasValidSignatures(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// fund the wallet with two payouts
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fund a transaction that requires both outputs
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.VoidAddress, Value: balance.Spendable},
+		},
+	}
+	toSign, err := w.FundTransaction(&txn, balance.Spendable, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(toSign) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(toSign))
+	}
+
+	// no signatures yet
+	if signed, err := w.HasValidSignatures(txn); err != nil {
+		t.Fatal(err)
+	} else if len(signed) != 0 {
+		t.Fatalf("expected no signed inputs, got %v", len(signed))
+	}
+
+	// sign only the first input
+	if err := w.SignTransaction(&txn, toSign[:1], types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+	if signed, err := w.HasValidSignatures(txn); err != nil {
+		t.Fatal(err)
+	} else if len(signed) != 1 || signed[0] != toSign[0] {
+		t.Fatalf("expected input %v to be signed, got %v", toSign[0], signed)
+	}
+
+	// tampering with the signature should be detected
+	tampered := txn
+	tampered.Signatures = []types.TransactionSignature{txn.Signatures[0]}
+	tampered.Signatures[0].Signature = append([]byte(nil), txn.Signatures[0].Signature...)
+	tampered.Signatures[0].Signature[0] ^= 0xFF
+	if _, err := w.HasValidSignatures(tampered); err == nil {
+		t.Fatal("expected invalid signature error")
+	}
+
+	// sign the remaining input
+	if err := w.SignTransaction(&txn, toSign[1:], types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+	if signed, err := w.HasValidSignatures(txn); err != nil {
+		t.Fatal(err)
+	} else if len(signed) != 2 {
+		t.Fatalf("expected 2 signed inputs, got %v", len(signed))
+	}
+
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	const dustOutputs = 5
+	dustValue := types.Siacoins(1).Div64(10)
+	feePerByte := types.Siacoins(1).Div64(1000)
+
+	tests := []struct {
+		name   string
+		opts   wallet.SweepOptions
+		inputs int
+	}{
+		{"economical", wallet.SweepOptions{}, 1},
+		{"uneconomical", wallet.SweepOptions{IncludeUneconomical: true}, 1 + dustOutputs},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cm, ws, w := newTestWallet(t)
+			network := cm.TipState().Network
+
+			// fund the wallet
+			mineAndSync(t, cm, ws, w, w.Address(), 1)
+			mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+			// create dust outputs that cost more to spend than they are worth
+			var txn types.Transaction
+			for i := 0; i < dustOutputs; i++ {
+				txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
+					Address: w.Address(),
+					Value:   dustValue,
+				})
+			}
+			toSign, err := w.FundTransaction(&txn, dustValue.Mul64(dustOutputs), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+				t.Fatal(err)
+			}
+			mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+			balance, err := w.Balance()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dest := types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey())
+			sweep, toSign, err := w.Sweep(dest, feePerByte, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(sweep.SiacoinInputs) != test.inputs {
+				t.Fatalf("expected %v inputs, got %v", test.inputs, len(sweep.SiacoinInputs))
+			} else if len(sweep.SiacoinOutputs) != 1 || sweep.SiacoinOutputs[0].Address != dest {
+				t.Fatalf("expected a single output to %v, got %v", dest, sweep.SiacoinOutputs)
+			} else if len(sweep.MinerFees) != 1 {
+				t.Fatalf("expected 1 miner fee, got %v", len(sweep.MinerFees))
+			}
+
+			// the inputs should be fully spent
+			var swept types.Currency
+			if test.opts.IncludeUneconomical {
+				swept = balance.Spendable
+			} else {
+				swept = balance.Spendable.Sub(dustValue.Mul64(dustOutputs))
+			}
+			if !sweep.SiacoinOutputs[0].Value.Add(sweep.MinerFees[0]).Equals(swept) {
+				t.Fatalf("expected output plus fee to equal %v, got %v", swept, sweep.SiacoinOutputs[0].Value.Add(sweep.MinerFees[0]))
+			}
+
+			if err := w.SignTransaction(&sweep, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+				t.Fatal(err)
+			}
+			if fee, minFee := sweep.MinerFees[0], feePerByte.Mul64(cm.TipState().TransactionWeight(sweep)); fee.Cmp(minFee) < 0 {
+				t.Fatalf("expected fee of at least %v, got %v", minFee, fee)
+			}
+			if _, err := cm.AddPoolTransactions([]types.Transaction{sweep}); err != nil {
+				t.Fatal(err)
+			}
+			mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+			expected := balance.Spendable.Sub(swept)
+			assertBalance(t, w, expected, expected, types.ZeroCurrency, types.ZeroCurrency)
+
+			// sweeping only dust should fail unless uneconomical outputs are
+			// included
+			if !test.opts.IncludeUneconomical {
+				if _, _, err := w.Sweep(dest, feePerByte, wallet.SweepOptions{}); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+					t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchAddress(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	watchAddr := types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey())
+	w.AddWatchAddress(watchAddr)
+
+	// fund both the wallet and the watched address
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, watchAddr, 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// the watched output is reported separately, since the wallet cannot
+	// spend it
+	reward := cm.TipState().BlockReward()
+	assertWatched := func(expected types.Currency) {
+		t.Helper()
+		if balance, err := w.Balance(); err != nil {
+			t.Fatal(err)
+		} else if !balance.Watched.Equals(expected) {
+			t.Fatalf("expected %v watched balance, got %v", expected, balance.Watched)
+		}
+	}
+	assertBalance(t, w, reward, reward, types.ZeroCurrency, types.ZeroCurrency)
+	assertWatched(reward)
+
+	spendable, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(spendable) != 1 || spendable[0].SiacoinOutput.Address != w.Address() {
+		t.Fatalf("expected 1 spendable output, got %v", spendable)
+	}
+
+	// both payouts should be recorded as events
+	events, err := w.Events(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", len(events))
+	}
+	for _, ev := range events {
+		if len(ev.Relevant) != 1 {
+			t.Fatalf("expected 1 relevant address, got %v", ev.Relevant)
+		}
+	}
+
+	// funding more than the wallet's own balance should fail
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.VoidAddress, Value: reward.Add(types.Siacoins(1))},
+		},
+	}
+	if _, err := w.FundTransaction(&txn, reward.Add(types.Siacoins(1)), false); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	}
+
+	// funding should only use the wallet's own outputs
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.VoidAddress, Value: types.Siacoins(100)},
+		},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sci := range txn.SiacoinInputs {
+		if sci.UnlockConditions.UnlockHash() != w.Address() {
+			t.Fatalf("expected input to be owned by %v, got %v", w.Address(), sci.UnlockConditions.UnlockHash())
+		}
+	}
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	expected := reward.Sub(types.Siacoins(100))
+	assertBalance(t, w, expected, expected, types.ZeroCurrency, types.ZeroCurrency)
+	assertWatched(reward)
+
+	// outputs sent to the watched address after it is removed should not be
+	// tracked
+	w.RemoveWatchAddress(watchAddr)
+	mineAndSync(t, cm, ws, w, watchAddr, 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	assertBalance(t, w, expected, expected, types.ZeroCurrency, types.ZeroCurrency)
+	assertWatched(reward)
+}
+
+// zeroChainManager is a ChainManager that has not loaded a chain state.
+type zeroChainManager struct{}
+
+func (zeroChainManager) TipState() consensus.State                 { return consensus.State{} }
+func (zeroChainManager) BestIndex(uint64) (types.ChainIndex, bool) { return types.ChainIndex{}, false }
+func (zeroChainManager) PoolTransactions() []types.Transaction     { return nil }
+func (zeroChainManager) V2PoolTransactions() []types.V2Transaction { return nil }
+func (zeroChainManager) OnReorg(func(types.ChainIndex)) func()     { return func() {} }
+
+func TestChainNotReady(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	w, err := wallet.NewSingleAddressWallet(pk, zeroChainManager{}, ws, wallet.WithLogger(zaptest.NewLogger(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err := w.Balance(); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	} else if _, err := w.SpendableOutputs(); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	} else if _, _, err := w.UnconfirmedTotals(); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	}
+
+	var txn types.Transaction
+	if _, err := w.FundTransaction(&txn, types.Siacoins(1), false); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	} else if _, err := w.FundTransactionWithFee(&txn, types.Siacoins(1), types.NewCurrency64(1), false); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	} else if _, err := w.BuildTransaction([]types.SiacoinOutput{{Value: types.Siacoins(1)}}, nil, types.NewCurrency64(1)); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	} else if _, _, err := w.Sweep(types.VoidAddress, types.NewCurrency64(1), wallet.SweepOptions{}); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	}
+
+	var v2txn types.V2Transaction
+	if _, _, err := w.FundV2Transaction(&v2txn, types.Siacoins(1), false); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	} else if _, _, err := w.Redistribute(1, types.Siacoins(1), types.NewCurrency64(1)); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	} else if _, _, err := w.RedistributeV2(1, types.Siacoins(1), types.NewCurrency64(1)); !errors.Is(err, wallet.ErrChainNotReady) {
+		t.Fatalf("expected ErrChainNotReady, got %v", err)
+	}
+}
+
+// pagedStore hides any optional interfaces implemented by the wrapped store.
+type pagedStore struct {
+	wallet.SingleAddressStore
+}
+
+func TestExportEvents(t *testing.T) {
+	const n = 5000
+
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+
+	// add a large number of synthetic events
+	addr := types.StandardUnlockHash(pk.PublicKey())
+	events := make([]wallet.Event, n)
+	for i := range events {
+		events[i] = wallet.Event{
+			ID:             frand.Entropy256(),
+			Index:          types.ChainIndex{Height: uint64(i / 10)},
+			Type:           wallet.EventTypeMinerPayout,
+			MaturityHeight: uint64(i / 10),
+			Data: wallet.EventPayout{
+				SiacoinElement: types.SiacoinElement{
+					ID:            types.SiacoinOutputID(frand.Entropy256()),
+					SiacoinOutput: types.SiacoinOutput{Address: addr, Value: types.Siacoins(uint32(i + 1))},
+				},
+			},
+			Relevant: []types.Address{addr},
+		}
+	}
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		return tx.WalletApplyIndex(types.ChainIndex{Height: 1}, nil, nil, events, time.Now())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := ws.WalletEvents(0, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, store := range []wallet.SingleAddressStore{ws, pagedStore{ws}} {
+		w, err := wallet.NewSingleAddressWallet(pk, cm, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		var buf bytes.Buffer
+		if err := w.ExportEvents(context.Background(), &buf); err != nil {
+			t.Fatal(err)
+		}
+
+		dec := json.NewDecoder(&buf)
+		var i int
+		for ; dec.More(); i++ {
+			var ev wallet.Event
+			if err := dec.Decode(&ev); err != nil {
+				t.Fatal(err)
+			} else if i >= n {
+				t.Fatalf("expected %v events, got more", n)
+			} else if ev.ID != expected[i].ID {
+				t.Fatalf("event %v: expected %v, got %v", i, expected[i].ID, ev.ID)
+			}
+		}
+		if i != n {
+			t.Fatalf("expected %v events, got %v", n, i)
+		}
+
+		// exporting should stop when the context is canceled
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := w.ExportEvents(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	}
+}
+
+func TestFundTransactionMinerFees(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+	var err error
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// checkFunded asserts that the transaction's inputs exactly cover its
+	// outputs and miner fees
+	checkFunded := func(t *testing.T, txn types.Transaction) {
+		t.Helper()
+
+		utxos, err := w.UnspentSiacoinElements()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[types.SiacoinOutputID]types.Currency)
+		for _, sce := range utxos {
+			values[sce.ID] = sce.SiacoinOutput.Value
+		}
+
+		var inputSum, outputSum types.Currency
+		for _, sci := range txn.SiacoinInputs {
+			inputSum = inputSum.Add(values[sci.ParentID])
+		}
+		for _, sco := range txn.SiacoinOutputs {
+			outputSum = outputSum.Add(sco.Value)
+		}
+		for _, fee := range txn.MinerFees {
+			outputSum = outputSum.Add(fee)
+		}
+		if !inputSum.Equals(outputSum) {
+			t.Fatalf("expected inputs %v to equal outputs plus fees %v", inputSum, outputSum)
+		}
+	}
+
+	sendAmount := types.Siacoins(1000)
+	presetFee := types.Siacoins(1)
+
+	// pre-set a fee and fund only the payment
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: sendAmount}},
+		MinerFees:      []types.Currency{presetFee},
+	}
+	toSign, err := w.FundTransaction(&txn, sendAmount, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFunded(t, txn)
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pre-set fee should be funded in addition to the calculated fee
+	feePerByte := types.Siacoins(1).Div64(1000)
+	txn2 := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: sendAmount}},
+		MinerFees:      []types.Currency{presetFee},
+	}
+	toSign, err = w.FundTransactionWithFee(&txn2, sendAmount, feePerByte, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn2.MinerFees) != 2 {
+		t.Fatalf("expected 2 miner fees, got %v", len(txn2.MinerFees))
+	} else if !txn2.MinerFees[0].Equals(presetFee) {
+		t.Fatalf("expected pre-set fee %v to be unchanged, got %v", presetFee, txn2.MinerFees[0])
+	}
+	checkFunded(t, txn2)
+	if err := w.SignTransaction(&txn2, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkUnlockConditions(b *testing.B) {
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	b.Run("computed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = types.StandardUnlockConditions(pk.PublicKey()).UnlockHash()
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = w.UnlockConditions()
+			_ = w.Address()
+		}
+	})
+}
+
+func TestUnlockConditionsNotShared(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// fund a transaction that requires both outputs
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: balance.Spendable}},
+	}
+	if _, err := w.FundTransaction(&txn, balance.Spendable, false); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(txn.SiacoinInputs))
+	}
+
+	// modifying the unlock conditions of one input must not affect the other
+	// input or the wallet
+	expected := w.UnlockConditions().UnlockHash()
+	txn.SiacoinInputs[0].UnlockConditions.PublicKeys[0].Key[0] ^= 0xFF
+	if uh := txn.SiacoinInputs[1].UnlockConditions.UnlockHash(); uh != expected {
+		t.Fatalf("expected input unlock hash %v, got %v", expected, uh)
+	} else if uh := w.UnlockConditions().UnlockHash(); uh != expected {
+		t.Fatalf("expected wallet unlock hash %v, got %v", expected, uh)
+	} else if addr := w.Address(); addr != expected {
+		t.Fatalf("expected address %v, got %v", expected, addr)
+	}
+}
+
+func TestSignApprover(t *testing.T) {
+	errSpendLimit := errors.New("spend limit exceeded")
+	spendLimit := types.Siacoins(1000)
+
+	pk := types.GeneratePrivateKey()
+	// create wallet with an approver that enforces a spend limit
+	addr := types.StandardUnlockHash(pk.PublicKey())
+	approver := func(txn types.Transaction) error {
+		var spent types.Currency
+		for _, sco := range txn.SiacoinOutputs {
+			if sco.Address != addr {
+				spent = spent.Add(sco.Value)
+			}
+		}
+		if spent.Cmp(spendLimit) > 0 {
+			return errSpendLimit
+		}
+		return nil
+	}
+	cm, ws, w := newTestWalletWithKey(t, pk, wallet.WithSignApprover(approver))
+	network := cm.TipState().Network
+	var err error
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// a transaction exceeding the limit should be rejected
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: spendLimit.Add(types.Siacoins(1))}},
+	}
+	toSign, err := w.FundTransaction(&txn, txn.SiacoinOutputs[0].Value, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); !errors.Is(err, errSpendLimit) {
+		t.Fatalf("expected spend limit error, got %v", err)
+	} else if len(txn.Signatures) != 0 {
+		t.Fatalf("expected no signatures, got %v", len(txn.Signatures))
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// a transaction within the limit should be signed
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: spendLimit}},
+	}
+	toSign, err = w.FundTransaction(&txn, spendLimit, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the approver only applies to v1 transactions; v2 inputs are signed
+	// regardless of the spend limit
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2txn := types.V2Transaction{
+		SiacoinInputs:  []types.V2SiacoinInput{{Parent: utxos[0]}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: utxos[0].SiacoinOutput.Value}},
+	}
+	w.SignV2Inputs(&v2txn, []int{0})
+	if len(v2txn.SiacoinInputs[0].SatisfiedPolicy.Signatures) != 1 {
+		t.Fatal("expected the v2 input to be signed")
+	}
+}
+
+func TestUpdateProofs(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.V2Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, genesisState)
+	// create wallet
+	l := zaptest.NewLogger(t)
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// take a snapshot of the wallet's outputs
+	basis := cm.Tip()
+	stale, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(stale) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(stale))
+	}
+
+	// validate checks that a transaction spending the element is valid in
+	// the given state
+	validate := func(cs consensus.State, sce types.SiacoinElement) error {
+		txn := types.V2Transaction{
+			SiacoinInputs: []types.V2SiacoinInput{{
+				Parent:          sce.Copy(),
+				SatisfiedPolicy: types.SatisfiedPolicy{Policy: w.SpendPolicy()},
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: sce.SiacoinOutput.Value}},
+		}
+		txn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{pk.SignHash(cs.InputSigHash(txn))}
+		return consensus.ValidateV2Transaction(consensus.NewMidState(cs), txn)
+	}
+
+	if err := validate(cm.TipState(), stale[0]); err != nil {
+		t.Fatalf("expected snapshot to be valid at the basis: %v", err)
+	}
+
+	// mine more blocks, making the snapshot's proof stale
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 10)
+	if err := validate(cm.TipState(), stale[0]); err == nil {
+		t.Fatal("expected stale proof to be invalid")
+	}
+
+	updated, state, err := w.UpdateProofs(basis, stale)
+	if err != nil {
+		t.Fatal(err)
+	} else if state.Index != cm.Tip() {
+		t.Fatalf("expected state %v, got %v", cm.Tip(), state.Index)
+	} else if len(updated) != 1 {
+		t.Fatalf("expected 1 element, got %v", len(updated))
+	} else if err := validate(state, updated[0]); err != nil {
+		t.Fatalf("expected updated proof to be valid: %v", err)
+	}
+
+	// the input should not have been modified
+	if err := validate(state, stale[0]); err == nil {
+		t.Fatal("expected input proof to be unchanged")
+	}
+
+	// the updated proof should match the one maintained by the store
+	current, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if !slices.Equal(current[0].StateElement.MerkleProof, updated[0].StateElement.MerkleProof) {
+		t.Fatal("expected updated proof to match the store")
+	}
+
+	// a chain manager that returns no updates while its tip differs from the
+	// basis is an error
+	w2, err := wallet.NewSingleAddressWallet(pk, noUpdatesChainManager{cm}, ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	if _, _, err := w2.UpdateProofs(basis, stale); err == nil {
+		t.Fatal("expected an error when the chain manager has no updates")
+	}
+
+	// a chain manager without UpdatesSince does not support updating proofs
+	w3, err := wallet.NewSingleAddressWallet(pk, zeroChainManager{}, ws)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w3.Close()
+	if _, _, err := w3.UpdateProofs(basis, stale); !errors.Is(err, wallet.ErrChainUnsupported) {
+		t.Fatalf("expected ErrChainUnsupported, got %v", err)
+	} else if _, err := w3.RefreshProofs(context.Background()); !errors.Is(err, wallet.ErrChainUnsupported) {
+		t.Fatalf("expected ErrChainUnsupported, got %v", err)
+	}
+}
+
+// noUpdatesChainManager is a chain manager that never returns any updates.
+type noUpdatesChainManager struct {
+	*chain.Manager
+}
+
+func (noUpdatesChainManager) UpdatesSince(types.ChainIndex, int) ([]chain.RevertUpdate, []chain.ApplyUpdate, error) {
+	return nil, nil, nil
+}
+
+func TestSpendableChange(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			cm, ws, w := newTestWallet(t, wallet.WithSpendableChange(enabled))
+			network := cm.TipState().Network
+
+			// fund the wallet
+			mineAndSync(t, cm, ws, w, w.Address(), 1)
+			mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+			initialReward := cm.TipState().BlockReward()
+			assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+
+			// send some of the wallet's funds back to itself
+			sendAmount := types.Siacoins(1000)
+			txn := types.Transaction{
+				SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: sendAmount}},
+			}
+			toSign, err := w.FundTransaction(&txn, sendAmount, false)
+			if err != nil {
+				t.Fatal(err)
+			} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+				t.Fatal(err)
+			} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+				t.Fatal(err)
+			}
+
+			if enabled {
+				// the balance should be unaffected by the self-send
+				assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+			} else {
+				assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, initialReward)
+			}
+
+			// outputs paying the wallet from transactions spending other
+			// addresses' outputs should still be unconfirmed
+			other := types.GeneratePrivateKey()
+			mineAndSync(t, cm, ws, w, types.StandardUnlockHash(other.PublicKey()), 1)
+			mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+			var sce types.SiacoinElement
+			_, applied, err := cm.UpdatesSince(types.ChainIndex{}, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, cau := range applied {
+				for _, sced := range cau.SiacoinElementDiffs() {
+					if sced.SiacoinElement.SiacoinOutput.Address == types.StandardUnlockHash(other.PublicKey()) && !sced.Spent {
+						sce = sced.SiacoinElement.Copy()
+					}
+				}
+			}
+			received := types.Transaction{
+				SiacoinInputs: []types.SiacoinInput{{
+					ParentID:         sce.ID,
+					UnlockConditions: types.StandardUnlockConditions(other.PublicKey()),
+				}},
+				SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: sce.SiacoinOutput.Value}},
+			}
+			sig := other.SignHash(cm.TipState().WholeSigHash(received, types.Hash256(sce.ID), 0, 0, nil))
+			received.Signatures = []types.TransactionSignature{{
+				ParentID:      types.Hash256(sce.ID),
+				CoveredFields: types.CoveredFields{WholeTransaction: true},
+				Signature:     sig[:],
+			}}
+			if _, err := cm.AddPoolTransactions([]types.Transaction{received}); err != nil {
+				t.Fatal(err)
+			}
+
+			balance, err := w.Balance()
+			if err != nil {
+				t.Fatal(err)
+			} else if !balance.Unconfirmed.Equals(sce.SiacoinOutput.Value) {
+				t.Fatalf("expected unconfirmed balance %v, got %v", sce.SiacoinOutput.Value, balance.Unconfirmed)
+			}
+		})
+	}
+}
+
+func TestBurn(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	initialReward := cm.TipState().BlockReward()
+
+	if _, _, err := w.Burn(types.ZeroCurrency, types.ZeroCurrency); err == nil {
+		t.Fatal("expected error burning zero coins")
+	}
+
+	burnAmount := types.Siacoins(1000)
+	feePerByte := types.Siacoins(1).Div64(1000)
+	txn, inputs, err := w.Burn(burnAmount, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(inputs) != len(txn.SiacoinInputs) {
+		t.Fatalf("expected %v inputs, got %v", len(txn.SiacoinInputs), len(inputs))
+	} else if txn.SiacoinOutputs[0].Address != types.VoidAddress || !txn.SiacoinOutputs[0].Value.Equals(burnAmount) {
+		t.Fatalf("expected %v to be burned, got %v to %v", burnAmount, txn.SiacoinOutputs[0].Value, txn.SiacoinOutputs[0].Address)
+	}
+
+	// the inputs should be reserved
+	assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	expected := initialReward.Sub(burnAmount).Sub(txn.MinerFees[0])
+	assertBalance(t, w, expected, expected, types.ZeroCurrency, types.ZeroCurrency)
+}
+
+func TestEventsPage(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+
+	// create 5 events
+	mineAndSync(t, cm, ws, w, w.Address(), 5)
+
+	tests := []struct {
+		offset, limit int
+		n             int
+		hasMore       bool
+	}{
+		{0, 2, 2, true},
+		{2, 2, 2, true},
+		{4, 2, 1, false},
+		{0, 5, 5, false},
+		{0, 10, 5, false},
+		{10, 2, 0, false},
+	}
+	for _, test := range tests {
+		page, err := w.EventsPage(test.offset, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(page.Events) != test.n {
+			t.Fatalf("offset %v, limit %v: expected %v events, got %v", test.offset, test.limit, test.n, len(page.Events))
+		} else if page.Total != 5 {
+			t.Fatalf("offset %v, limit %v: expected total 5, got %v", test.offset, test.limit, page.Total)
+		} else if page.HasMore != test.hasMore {
+			t.Fatalf("offset %v, limit %v: expected hasMore %v, got %v", test.offset, test.limit, test.hasMore, page.HasMore)
+		}
+	}
+}
+
+func TestReservationExpiry(t *testing.T) {
+	// create wallet with a controllable clock
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	cm, ws, w := newTestWallet(t, wallet.WithClock(clock), wallet.WithReservationDuration(time.Hour))
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	initialReward := cm.TipState().BlockReward()
+	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+
+	// reserve the wallet's only output
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1000)}},
+	}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(1000), false); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+
+	// the reservation should hold until it expires
+	now = now.Add(time.Hour - time.Second)
+	assertBalance(t, w, types.ZeroCurrency, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+	if _, err := w.FundTransaction(&types.Transaction{}, types.Siacoins(1000), false); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	}
+
+	// after the reservation expires the output should be spendable again
+	now = now.Add(time.Second)
+	assertBalance(t, w, initialReward, initialReward, types.ZeroCurrency, types.ZeroCurrency)
+	if spendable, err := w.SpendableOutputs(); err != nil {
+		t.Fatal(err)
+	} else if len(spendable) != 1 {
+		t.Fatalf("expected 1 spendable output, got %v", len(spendable))
+	}
+}
+
+func TestPay(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	initialReward := cm.TipState().BlockReward()
+
+	if _, _, err := w.Pay(nil, types.ZeroCurrency); err == nil {
+		t.Fatal("expected error paying no recipients")
+	} else if _, _, err := w.Pay(map[types.Address]types.Currency{types.VoidAddress: types.ZeroCurrency}, types.ZeroCurrency); err == nil {
+		t.Fatal("expected error paying zero amount")
+	}
+
+	// pay multiple recipients, including the wallet itself
+	recipients := map[types.Address]types.Currency{
+		types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey()): types.Siacoins(100),
+		types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey()): types.Siacoins(200),
+		w.Address(): types.Siacoins(300),
+	}
+	feePerByte := types.Siacoins(1).Div64(1000)
+	txn, inputs, err := w.Pay(recipients, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(inputs) != len(txn.SiacoinInputs) {
+		t.Fatalf("expected %v inputs, got %v", len(txn.SiacoinInputs), len(inputs))
+	} else if len(txn.SiacoinOutputs) != len(recipients)+1 {
+		t.Fatalf("expected %v outputs, got %v", len(recipients)+1, len(txn.SiacoinOutputs))
+	}
+
+	// each recipient should receive exactly one output for the requested
+	// amount, in address order
+	for i, sco := range txn.SiacoinOutputs[:len(recipients)] {
+		if !sco.Value.Equals(recipients[sco.Address]) {
+			t.Fatalf("expected output %v to pay %v to %v, got %v", i, recipients[sco.Address], sco.Address, sco.Value)
+		} else if i > 0 && bytes.Compare(txn.SiacoinOutputs[i-1].Address[:], sco.Address[:]) >= 0 {
+			t.Fatal("expected outputs to be sorted by address")
+		}
+	}
+
+	// the fee should cover the final weight of the transaction
+	fee := txn.MinerFees[0]
+	if minFee := feePerByte.Mul64(cm.TipState().TransactionWeight(txn)); fee.Cmp(minFee) < 0 {
+		t.Fatalf("expected fee of at least %v, got %v", minFee, fee)
+	}
+
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	// only the external payments and the fee should leave the wallet
+	expected := initialReward.Sub(types.Siacoins(300)).Sub(fee)
+	assertBalance(t, w, expected, expected, types.ZeroCurrency, types.ZeroCurrency)
+}
+
+func TestVerify(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+	var err error
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// a consistent store should have no orphaned outputs
+	if orphaned, err := w.Verify(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if len(orphaned) != 0 {
+		t.Fatalf("expected no orphaned outputs, got %v", orphaned)
+	}
+
+	utxos, err := w.UnspentSiacoinElements()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// inject a phantom output that the chain does not know about
+	phantom := utxos[0].Copy()
+	phantom.ID = types.SiacoinOutputID(frand.Entropy256())
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		return tx.WalletApplyIndex(cm.Tip(), []types.SiacoinElement{phantom}, nil, nil, time.Now())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphaned, err := w.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(orphaned) != 1 || orphaned[0] != phantom.ID {
+		t.Fatalf("expected phantom output %v to be orphaned, got %v", phantom.ID, orphaned)
+	}
+
+	// repairing should remove the phantom output
+	if removed, err := w.Repair(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if len(removed) != 1 || removed[0] != phantom.ID {
+		t.Fatalf("expected phantom output %v to be removed, got %v", phantom.ID, removed)
+	} else if orphaned, err := w.Verify(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if len(orphaned) != 0 {
+		t.Fatalf("expected no orphaned outputs, got %v", orphaned)
+	}
+
+	// an output with a stale proof is not orphaned, and repairing keeps it
+	utxos, err = ws.UnspentSiacoinElements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := utxos[0].Copy()
+	stale.StateElement.MerkleProof[0] = types.Hash256{}
+	if err := ws.SetWalletSiacoinElementProofs(cm.Tip(), []types.SiacoinElement{stale}); err != nil {
+		t.Fatal(err)
+	} else if orphaned, err := w.Verify(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if len(orphaned) != 0 {
+		t.Fatalf("expected no orphaned outputs, got %v", orphaned)
+	} else if removed, err := w.Repair(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if len(removed) != 0 {
+		t.Fatalf("expected no outputs to be removed, got %v", removed)
+	}
+	utxos, err = ws.UnspentSiacoinElements()
+	if err != nil {
+		t.Fatal(err)
+	} else if !slices.ContainsFunc(utxos, func(sce types.SiacoinElement) bool { return sce.ID == stale.ID }) {
+		t.Fatalf("expected output %v to be kept", stale.ID)
+	}
+
+	// a store that is behind the chain cannot be verified
+	testutil.MineBlocks(t, cm, types.VoidAddress, 1)
+	if _, err := w.Verify(context.Background()); err == nil {
+		t.Fatal("expected error verifying unsynced store")
+	}
+
+	// stores that cannot remove outputs cannot be repaired
+	w2, err := wallet.NewSingleAddressWallet(pk, cm, pagedStore{ws})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	if _, err := w2.Repair(context.Background()); !errors.Is(err, wallet.ErrStoreUnsupported) {
+		t.Fatalf("expected ErrStoreUnsupported, got %v", err)
+	}
+}
+
+func TestSelectionModePrivacy(t *testing.T) {
+	// create wallet with a deterministic source of randomness
+	rng := frand.NewCustom(make([]byte, 32), 1024, 12)
+	cm, ws, w := newTestWallet(t, wallet.WithSelectionMode(wallet.SelectionModePrivacy), wallet.WithRNG(rng))
+	network := cm.TipState().Network
+
+	// fund the wallet with 10 outputs
+	mineAndSync(t, cm, ws, w, w.Address(), 10)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// the amount requires at least two outputs
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var largest types.Currency
+	for _, sce := range utxos {
+		if sce.SiacoinOutput.Value.Cmp(largest) > 0 {
+			largest = sce.SiacoinOutput.Value
+		}
+	}
+	amount := largest.Add(types.Siacoins(1))
+
+	counts := make(map[int]int)
+	for i := 0; i < 50; i++ {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+		}
+		if _, err := w.FundTransaction(&txn, amount, false); err != nil {
+			t.Fatal(err)
+		}
+		n := len(txn.SiacoinInputs)
+		if n < 2 || n > 5 {
+			t.Fatalf("expected between 2 and 5 inputs, got %v", n)
+		}
+		counts[n]++
+		w.ReleaseInputs([]types.Transaction{txn}, nil)
+	}
+	if len(counts) < 2 {
+		t.Fatalf("expected the number of inputs to vary, got %v", counts)
+	}
+}
+
+func TestTransactionReference(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	const reference = "invoice-1234"
+	txn, err := w.BuildTransactionWithReference([]types.SiacoinOutput{
+		{Address: types.VoidAddress, Value: types.Siacoins(1000)},
+	}, nil, types.Siacoins(1).Div64(1000), reference)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	if ref, err := w.TransactionReference(txn.ID()); err != nil {
+		t.Fatal(err)
+	} else if ref != reference {
+		t.Fatalf("expected reference %q, got %q", reference, ref)
+	} else if _, err := w.TransactionReference(types.TransactionID{1}); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// the reference should be included in the transaction's event
+	events, err := w.Events(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, ev := range events {
+		if ev.ID == types.Hash256(txn.ID()) {
+			found = true
+			if ev.Reference != reference {
+				t.Fatalf("expected event reference %q, got %q", reference, ev.Reference)
+			}
+		} else if ev.Reference != "" {
+			t.Fatalf("expected no reference for event %v, got %q", ev.ID, ev.Reference)
+		}
+	}
+	if !found {
+		t.Fatal("transaction event not found")
+	}
+
+	// stores without reference support should return ErrStoreUnsupported
+	w2, err := wallet.NewSingleAddressWallet(pk, cm, pagedStore{ws})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	if err := w2.SetTransactionReference(txn.ID(), reference); !errors.Is(err, wallet.ErrStoreUnsupported) {
+		t.Fatalf("expected ErrStoreUnsupported, got %v", err)
+	}
+}
+
+func TestReplacementFee(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// pool a transaction paying well above the recommended fee
+	feePerByte := cm.RecommendedFee().Mul64(10)
+	txn, _, err := w.Burn(types.Siacoins(1000), feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	pooled := cm.PoolTransactions()
+	if len(pooled) != 1 {
+		t.Fatalf("expected 1 pooled transaction, got %v", len(pooled))
+	}
+
+	// the replacement must pay the original fee plus the same fee again at
+	// the original's rate
+	weight := cm.TipState().TransactionWeight(pooled[0])
+	fee := pooled[0].MinerFees[0]
+	expected := fee.Add(fee.Div64(weight).Mul64(weight))
+	if replacement := w.ReplacementFee(pooled[0]); !replacement.Equals(expected) {
+		t.Fatalf("expected replacement fee %v, got %v", expected, replacement)
+	}
+
+	// a transaction without fees must pay at least the recommended rate
+	free := pooled[0]
+	free.MinerFees = nil
+	expected = cm.RecommendedFee().Mul64(cm.TipState().TransactionWeight(free))
+	if replacement := w.ReplacementFee(free); !replacement.Equals(expected) {
+		t.Fatalf("expected replacement fee %v, got %v", expected, replacement)
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	_, _, w := newTestWallet(t)
+
+	if addrs := w.Addresses(); len(addrs) != 1 || addrs[0] != w.Address() {
+		t.Fatalf("expected only the wallet address, got %v", addrs)
+	}
+
+	watch1 := types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey())
+	watch2 := types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey())
+	w.AddWatchAddress(watch1)
+	w.AddWatchAddress(watch2)
+	w.AddWatchAddress(w.Address()) // should be ignored
+
+	addrs := w.Addresses()
+	if len(addrs) != 3 {
+		t.Fatalf("expected 3 addresses, got %v", len(addrs))
+	} else if addrs[0] != w.Address() {
+		t.Fatalf("expected wallet address first, got %v", addrs[0])
+	} else if !slices.Contains(addrs, watch1) || !slices.Contains(addrs, watch2) {
+		t.Fatalf("expected watched addresses to be included, got %v", addrs)
+	}
+
+	w.RemoveWatchAddress(watch1)
+	if addrs := w.Addresses(); len(addrs) != 2 || slices.Contains(addrs, watch1) {
+		t.Fatalf("expected removed address to be excluded, got %v", addrs)
+	}
+}
+
+// racingChainManager wraps a chain manager and injects additional pool
+// transactions, simulating transactions entering the pool while the wallet is
+// selecting outputs.
+type racingChainManager struct {
+	*chain.Manager
+
+	calls    int
+	conflict func(call int) []types.Transaction
+}
+
+func (cm *racingChainManager) PoolTransactions() []types.Transaction {
+	cm.calls++
+	return append(cm.Manager.PoolTransactions(), cm.conflict(cm.calls)...)
+}
+
+func TestFundOutputConflict(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, genesisState)
+	rcm := &racingChainManager{
+		Manager:  cm,
+		conflict: func(int) []types.Transaction { return nil },
+	}
+	// create wallet
+	l := zaptest.NewLogger(t)
+	w, err := wallet.NewSingleAddressWallet(pk, rcm, ws, wallet.WithLogger(l.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// fund the wallet with several outputs
+	mineAndSync(t, cm, ws, w, w.Address(), 5)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	spendTxn := func(ids ...types.SiacoinOutputID) types.Transaction {
+		var txn types.Transaction
+		for _, id := range ids {
+			txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{ParentID: id})
+		}
+		return txn
+	}
+
+	// determine which output is selected first
+	var txn types.Transaction
+	if _, err := w.FundTransaction(&txn, types.Siacoins(1), false); err != nil {
+		t.Fatal(err)
+	}
+	first := txn.SiacoinInputs[0].ParentID
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// a pool transaction spending the first output arrives after selection
+	// has started
+	rcm.calls = 0
+	rcm.conflict = func(call int) []types.Transaction {
+		if call == 1 {
+			return nil
+		}
+		return []types.Transaction{spendTxn(first)}
+	}
+	txn = types.Transaction{}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(1), false); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 1 {
+		t.Fatalf("expected 1 input, got %v", len(txn.SiacoinInputs))
+	} else if txn.SiacoinInputs[0].ParentID == first {
+		t.Fatal("expected the conflicting output to be excluded")
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// if every selection is spent before it can be reserved, the wallet
+	// should give up
+	rcm.conflict = func(int) []types.Transaction { return nil }
+	sces, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]types.SiacoinOutputID, 0, len(sces))
+	for _, sce := range sces {
+		ids = append(ids, sce.ID)
+	}
+	rcm.conflict = func(call int) []types.Transaction {
+		if call%2 == 1 {
+			return nil
+		}
+		return []types.Transaction{spendTxn(ids...)}
+	}
+	rcm.calls = 0
+	txn = types.Transaction{}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(1), false); !errors.Is(err, wallet.ErrOutputConflict) {
+		t.Fatalf("expected ErrOutputConflict, got %v", err)
+	} else if len(txn.SiacoinInputs) != 0 {
+		t.Fatalf("expected no inputs to be added, got %v", len(txn.SiacoinInputs))
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	assertConfirmations := func(id types.SiacoinOutputID, expected uint64) {
+		t.Helper()
+		n, err := w.Confirmations(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if n != expected {
+			t.Fatalf("expected %v confirmations, got %v", expected, n)
+		}
+	}
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	sces, err := w.UnspentSiacoinElements()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sces) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(sces))
+	}
+	payout := sces[0].ID
+	assertConfirmations(payout, 1)
+
+	// each block should add a confirmation
+	for i := uint64(2); i <= 5; i++ {
+		mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+		assertConfirmations(payout, i)
+	}
+
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	assertConfirmations(payout, 5+network.MaturityDelay)
+
+	// outputs in the pool have no confirmations
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	change := txn.SiacoinOutputID(1)
+	assertConfirmations(change, 0)
+
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	assertConfirmations(change, 1)
+
+	// spent and unknown outputs are not found
+	if _, err := w.Confirmations(payout); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	} else if _, err := w.Confirmations(types.SiacoinOutputID(frand.Entropy256())); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCanonicalOutputOrdering(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithChangePosition(wallet.ChangePositionFirst), wallet.WithCanonicalOutputOrdering(true))
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	reward := cm.TipState().BlockReward()
+	large := reward.Div64(4).Mul64(3)
+	addr1 := types.Address{1}
+	addr2 := types.Address{2}
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.VoidAddress, Value: large},
+			{Address: addr2, Value: types.Siacoins(100)},
+			{Address: addr1, Value: types.Siacoins(100)},
+		},
+	}
+	amount := large.Add(types.Siacoins(200))
+	res, err := w.FundTransactionDetailed(&txn, amount, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the change output is smaller than the large output, so it should be
+	// placed between the payments regardless of the change position
+	expected := []types.SiacoinOutput{
+		{Address: addr1, Value: types.Siacoins(100)},
+		{Address: addr2, Value: types.Siacoins(100)},
+		{Address: w.Address(), Value: reward.Sub(amount)},
+		{Address: types.VoidAddress, Value: large},
+	}
+	if res.ChangeIndex != 2 {
+		t.Fatalf("expected change index 2, got %v", res.ChangeIndex)
+	} else if !slices.Equal(txn.SiacoinOutputs, expected) {
+		t.Fatalf("expected outputs %v, got %v", expected, txn.SiacoinOutputs)
+	}
+}
+
+func TestReservationLog(t *testing.T) {
+	// create wallet with a controllable clock
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	cm, ws, w := newTestWallet(t, wallet.WithClock(clock), wallet.WithReservationDuration(time.Hour), wallet.WithReservationLogSize(3))
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	assertEvent := func(ev wallet.ReservationEvent, typ string, ids []types.SiacoinOutputID, tag string, timestamp time.Time) {
+		t.Helper()
+		if ev.Type != typ {
+			t.Fatalf("expected %q event, got %q", typ, ev.Type)
+		} else if !slices.Equal(ev.IDs, ids) {
+			t.Fatalf("expected ids %v, got %v", ids, ev.IDs)
+		} else if ev.Tag != tag {
+			t.Fatalf("expected tag %q, got %q", tag, ev.Tag)
+		} else if !ev.Timestamp.Equal(timestamp) {
+			t.Fatalf("expected timestamp %v, got %v", timestamp, ev.Timestamp)
+		}
+	}
+
+	if log := w.ReservationLog(); len(log) != 0 {
+		t.Fatalf("expected empty log, got %v", log)
+	}
+
+	// reserve and release the wallet's output
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1000)}},
+	}
+	toSign, err := w.FundTransactionWithTag(&txn, types.Siacoins(1000), false, "payment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []types.SiacoinOutputID{types.SiacoinOutputID(toSign[0])}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+	// releasing again should not be recorded
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	log := w.ReservationLog()
+	if len(log) != 2 {
+		t.Fatalf("expected 2 events, got %v", len(log))
+	}
+	assertEvent(log[0], wallet.ReservationEventReserved, ids, "payment", now)
+	assertEvent(log[1], wallet.ReservationEventReleased, ids, "", now)
+
+	// reserve the output again and let the reservation expire
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1000)}},
+	}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(1000), false); err != nil {
+		t.Fatal(err)
+	}
+	start := now
+	now = now.Add(time.Hour)
+	if _, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the log should only keep the three most recent events
+	log = w.ReservationLog()
+	if len(log) != 3 {
+		t.Fatalf("expected 3 events, got %v", len(log))
+	}
+	assertEvent(log[0], wallet.ReservationEventReleased, ids, "", start)
+	assertEvent(log[1], wallet.ReservationEventReserved, ids, "", start)
+	assertEvent(log[2], wallet.ReservationEventExpired, ids, "", now)
+}
+
+func TestFundTransactionWithFeeConvergence(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// fund the wallet with two outputs
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	sces, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sces) != 2 {
+		t.Fatalf("expected 2 outputs, got %v", len(sces))
+	}
+	largest := sces[0].SiacoinOutput.Value
+	if v := sces[1].SiacoinOutput.Value; v.Cmp(largest) > 0 {
+		largest = v
+	}
+
+	feePerByte := types.Siacoins(1).Div64(1000)
+	newTxn := func(amount types.Currency) types.Transaction {
+		return types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+		}
+	}
+
+	// determine the fee of a transaction funded by the largest output
+	txn := newTxn(types.Siacoins(1))
+	if _, err := w.FundTransactionWithFee(&txn, types.Siacoins(1), feePerByte, false); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 1 {
+		t.Fatalf("expected 1 input, got %v", len(txn.SiacoinInputs))
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+	singleInputFee := txn.MinerFees[0]
+
+	// the amount is covered by the largest output, but the fee pushes the
+	// transaction over the boundary, requiring a second input
+	amount := largest.Sub(singleInputFee).Add(types.NewCurrency64(1))
+	txn = newTxn(amount)
+	toSign, err := w.FundTransactionWithFee(&txn, amount, feePerByte, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(txn.SiacoinInputs))
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the fee should cover the final weight of the signed transaction
+	// without overpaying by more than a couple of bytes
+	required := feePerByte.Mul64(cm.TipState().TransactionWeight(txn))
+	if fee := txn.MinerFees[0]; fee.Cmp(required) < 0 {
+		t.Fatalf("expected fee of at least %v, got %v", required, fee)
+	} else if fee.Sub(required).Cmp(feePerByte.Mul64(2)) > 0 {
+		t.Fatalf("expected fee close to %v, got %v", required, fee)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChainUpdateHook(t *testing.T) {
+
+	var reverted, applied []types.ChainIndex
+	var hookErr error
+	hook := func(rus []chain.RevertUpdate, aus []chain.ApplyUpdate) error {
+		for _, cru := range rus {
+			reverted = append(reverted, types.ChainIndex{ID: cru.Block.ID(), Height: cru.State.Index.Height + 1})
+		}
+		for _, cau := range aus {
+			applied = append(applied, cau.State.Index)
+		}
+		return hookErr
+	}
+
+	cm, ws, w := newTestWallet(t, wallet.WithChainUpdateHook(hook))
+
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	rollbackState := cm.TipState()
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 2)
+
+	// the hook should have received every applied block, including the
+	// genesis block
+	var expected []types.ChainIndex
+	for height := uint64(0); height <= cm.Tip().Height; height++ {
+		index, ok := cm.BestIndex(height)
+		if !ok {
+			t.Fatalf("missing index for height %v", height)
+		}
+		expected = append(expected, index)
+	}
+	if len(reverted) != 0 {
+		t.Fatalf("expected no reverted blocks, got %v", reverted)
+	} else if !slices.Equal(applied, expected) {
+		t.Fatalf("expected applied blocks %v, got %v", expected, applied)
+	}
+
+	// reorg the last two blocks; the hook's error should not prevent the
+	// wallet from syncing
+	hookErr = errors.New("hook failed")
+	expectedReverted := []types.ChainIndex{expected[5], expected[4]}
+	reverted, applied = nil, nil
+
+	var reorgBlocks []types.Block
+	state := rollbackState
+	for i := 0; i < 3; i++ {
+		b := types.Block{
+			ParentID:     state.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: types.Address{1}, Value: state.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(state, &b, time.Second) {
+			t.Fatal("failed to find nonce")
+		}
+		reorgBlocks = append(reorgBlocks, b)
+		state.Index.Height++
+		state.Index.ID = b.ID()
+	}
+	if err := cm.AddBlocks(reorgBlocks); err != nil {
+		t.Fatal(err)
+	} else if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedApplied := make([]types.ChainIndex, 0, len(reorgBlocks))
+	for i, b := range reorgBlocks {
+		expectedApplied = append(expectedApplied, types.ChainIndex{ID: b.ID(), Height: rollbackState.Index.Height + uint64(i) + 1})
+	}
+	if !slices.Equal(reverted, expectedReverted) {
+		t.Fatalf("expected reverted blocks %v, got %v", expectedReverted, reverted)
+	} else if !slices.Equal(applied, expectedApplied) {
+		t.Fatalf("expected applied blocks %v, got %v", expectedApplied, applied)
+	} else if tip, err := ws.Tip(); err != nil {
+		t.Fatal(err)
+	} else if tip != cm.Tip() {
+		t.Fatalf("expected store tip %v, got %v", cm.Tip(), tip)
+	}
+
+	// an update that fails to commit is not passed to the hook
+	reverted, applied = nil, nil
+	if block, found := coreutils.MineBlock(cm, types.VoidAddress, 5*time.Second); !found {
+		t.Fatal("failed to mine block")
+	} else if err := cm.AddBlocks([]types.Block{block}); err != nil {
+		t.Fatal(err)
+	}
+	tip, err := ws.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crus, caus, err := cm.UpdatesSince(tip, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCommit := errors.New("commit failed")
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		if err := w.UpdateChainState(tx, crus, caus); err != nil {
+			return err
+		}
+		return errCommit
+	})
+	if !errors.Is(err, errCommit) {
+		t.Fatalf("expected the commit to fail, got %v", err)
+	} else if len(reverted) != 0 || len(applied) != 0 {
+		t.Fatalf("expected the hook not to be called, got %v reverted and %v applied", reverted, applied)
+	}
+
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	} else if !slices.Equal(applied, []types.ChainIndex{cm.Tip()}) {
+		t.Fatalf("expected applied blocks %v, got %v", []types.ChainIndex{cm.Tip()}, applied)
+	}
+}
+
+func TestSiafundClaimValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		revenue    types.Currency
+		claimStart types.Currency
+		value      uint64
+		expected   types.Currency
+	}{
+		{"single siafund", types.Siacoins(10000), types.ZeroCurrency, 1, types.Siacoins(1)},
+		{"partial claim", types.Siacoins(10000), types.Siacoins(5000), 100, types.Siacoins(50)},
+		{"all siafunds", types.Siacoins(12345), types.Siacoins(345), 10000, types.Siacoins(12000)},
+		{"rounded down", types.NewCurrency64(12345), types.ZeroCurrency, 10000, types.NewCurrency64(10000)},
+		{"no growth", types.Siacoins(10000), types.Siacoins(10000), 100, types.ZeroCurrency},
+		{"no siafunds", types.Siacoins(10000), types.ZeroCurrency, 0, types.ZeroCurrency},
+		{"claim start after revenue", types.Siacoins(100), types.Siacoins(200), 100, types.ZeroCurrency},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sfe := types.SiafundElement{
+				ClaimStart:    test.claimStart,
+				SiafundOutput: types.SiafundOutput{Value: test.value},
+			}
+			cs := consensus.State{SiafundTaxRevenue: test.revenue}
+			if claim := wallet.SiafundClaimValue(sfe, cs); !claim.Equals(test.expected) {
+				t.Fatalf("expected claim %v, got %v", test.expected, claim)
+			}
+		})
+	}
+}
+
+func TestUnconfirmedPolicyIsolated(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	ws2 := testutil.NewEphemeralWalletStore()
+	w2, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws2, wallet.WithLogger(l.Named("sender")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	// fund the wallet with one output and the sender with two
+	mineAndSync(t, cm, ws2, w2, w2.Address(), 2)
+	mineAndSync(t, cm, ws2, w2, w.Address(), 1)
+	mineAndSync(t, cm, ws2, w2, types.VoidAddress, network.MaturityDelay)
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	}
+
+	sces, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sces) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(sces))
+	}
+	confirmedValue := sces[0].SiacoinOutput.Value
+
+	// the sender pays the wallet more than its confirmed balance in an
+	// unconfirmed transaction
+	unconfirmedValue := confirmedValue.Mul64(3).Div64(2)
+	parent := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: unconfirmedValue}},
+	}
+	toSign, err := w2.FundTransaction(&parent, unconfirmedValue, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w2.SignTransaction(&parent, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{parent}); err != nil {
+		t.Fatal(err)
+	}
+	unconfirmedID := parent.SiacoinOutputID(0)
+
+	countInputs := func(txn types.Transaction) (confirmedInputs, unconfirmedInputs int) {
+		for _, sci := range txn.SiacoinInputs {
+			if sci.ParentID == unconfirmedID {
+				unconfirmedInputs++
+			} else {
+				confirmedInputs++
+			}
+		}
+		return
+	}
+
+	// confirmed funds suffice, so no unconfirmed inputs should be used
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	if _, err := w.FundTransactionWithPolicy(&txn, types.Siacoins(100), wallet.UnconfirmedPolicyIsolated); err != nil {
+		t.Fatal(err)
+	} else if c, u := countInputs(txn); c != 1 || u != 0 {
+		t.Fatalf("expected 1 confirmed and 0 unconfirmed inputs, got %v and %v", c, u)
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// the confirmed funds are insufficient, so the transaction should be
+	// funded entirely from unconfirmed outputs
+	amount := confirmedValue.Add(types.Siacoins(1))
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+	}
+	if _, err := w.FundTransactionWithPolicy(&txn, amount, wallet.UnconfirmedPolicyIsolated); err != nil {
+		t.Fatal(err)
+	} else if c, u := countInputs(txn); c != 0 || u != 1 {
+		t.Fatalf("expected 0 confirmed and 1 unconfirmed inputs, got %v and %v", c, u)
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// only a mix of confirmed and unconfirmed outputs covers the amount
+	amount = unconfirmedValue.Add(types.Siacoins(1))
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+	}
+	if _, err := w.FundTransactionWithPolicy(&txn, amount, wallet.UnconfirmedPolicyIsolated); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	} else if _, err := w.FundTransactionWithPolicy(&txn, amount, wallet.UnconfirmedPolicyNever); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	} else if _, err := w.FundTransactionWithPolicy(&txn, amount, wallet.UnconfirmedPolicyOnlyIfNeeded); err != nil {
+		t.Fatal(err)
+	} else if c, u := countInputs(txn); c != 1 || u != 1 {
+		t.Fatalf("expected 1 confirmed and 1 unconfirmed inputs, got %v and %v", c, u)
+	}
+}
+
+func TestSpendableSchedule(t *testing.T) {
+	// create wallet with a fixed clock
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	rec := &storeCallRecorder{calls: make(map[string][]time.Duration)}
+	cm, ws, w := newTestWallet(t, wallet.WithClock(clock), wallet.WithBlockInterval(10*time.Minute), wallet.WithMetricsRecorder(rec))
+	network := cm.TipState().Network
+
+	if schedule, err := w.SpendableSchedule(); err != nil {
+		t.Fatal(err)
+	} else if len(schedule) != 0 {
+		t.Fatalf("expected empty schedule, got %v", schedule)
+	}
+
+	// mine a mature payout
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	spendable := cm.TipState().BlockReward()
+
+	// mine a block with two payouts to the wallet, which should be grouped
+	// into a single tranche
+	state := cm.TipState()
+	b := types.Block{
+		ParentID:  state.Index.ID,
+		Timestamp: types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{
+			{Address: w.Address(), Value: state.BlockReward().Div64(2)},
+			{Address: w.Address(), Value: state.BlockReward().Sub(state.BlockReward().Div64(2))},
+		},
+	}
+	if !coreutils.FindBlockNonce(state, &b, time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := cm.AddBlocks([]types.Block{b}); err != nil {
+		t.Fatal(err)
+	} else if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	}
+	firstReward := state.BlockReward()
+	firstMaturity := state.MaturityHeight()
+
+	// mine another payout that matures one block later
+	secondReward := cm.TipState().BlockReward()
+	secondMaturity := cm.TipState().MaturityHeight()
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+
+	tip := cm.Tip().Height
+	expected := []wallet.SpendableTranche{
+		{Amount: spendable, Height: tip, EstimatedTime: now},
+		{Amount: firstReward, Height: firstMaturity, EstimatedTime: now.Add(time.Duration(firstMaturity-tip) * 10 * time.Minute)},
+		{Amount: secondReward, Height: secondMaturity, EstimatedTime: now.Add(time.Duration(secondMaturity-tip) * 10 * time.Minute)},
+	}
+	before := rec.count(wallet.StoreCallUnspentSiacoinElements)
+	schedule, err := w.SpendableSchedule()
+	if err != nil {
+		t.Fatal(err)
+	} else if n := rec.count(wallet.StoreCallUnspentSiacoinElements) - before; n != 1 {
+		t.Fatalf("expected a single scan of the unspent outputs, got %v", n)
+	} else if len(schedule) != len(expected) {
+		t.Fatalf("expected %v tranches, got %v", len(expected), len(schedule))
+	}
+	for i := range expected {
+		if schedule[i].Height != expected[i].Height || !schedule[i].Amount.Equals(expected[i].Amount) || !schedule[i].EstimatedTime.Equal(expected[i].EstimatedTime) {
+			t.Fatalf("expected tranche %v to be %v, got %v", i, expected[i], schedule[i])
+		}
+	}
+
+	// the tranches should account for the wallet's entire balance
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total types.Currency
+	for _, tranche := range schedule {
+		total = total.Add(tranche.Amount)
+	}
+	if !total.Equals(balance.Spendable.Add(balance.Immature)) {
+		t.Fatalf("expected tranches to total %v, got %v", balance.Spendable.Add(balance.Immature), total)
+	}
+}
+
+func TestFundTransactionConcurrent(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// fund the wallet with many outputs
+	const outputs = 25
+	mineAndSync(t, cm, ws, w, w.Address(), outputs)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// fund transactions concurrently until the wallet runs out of outputs
+	var mu sync.Mutex
+	reserved := make(map[types.SiacoinOutputID]int)
+	var wg sync.WaitGroup
+	errCh := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				txn := types.Transaction{
+					SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1)}},
+				}
+				toSign, err := w.FundTransaction(&txn, types.Siacoins(1), false)
+				if errors.Is(err, wallet.ErrNotEnoughFunds) {
+					return
+				} else if err != nil {
+					errCh <- err
+					return
+				}
+				mu.Lock()
+				for _, id := range toSign {
+					reserved[types.SiacoinOutputID(id)]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatal(err)
+	}
+
+	if len(reserved) != outputs {
+		t.Fatalf("expected %v outputs to be reserved, got %v", outputs, len(reserved))
+	}
+	for id, n := range reserved {
+		if n != 1 {
+			t.Fatalf("output %v was reserved %v times", id, n)
+		}
+	}
+}
+
+func TestExportImportUTXOs(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.V2Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, genesisState)
+	// create wallet
+	l := zaptest.NewLogger(t)
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// export the wallet's outputs through JSON, as a caller would when
+	// moving the snapshot to another machine
+	elements, state, err := w.ExportUTXOs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(elements) != 2 {
+		t.Fatalf("expected 2 elements, got %v", len(elements))
+	} else if state.Index != cm.Tip() {
+		t.Fatalf("expected state %v, got %v", cm.Tip(), state.Index)
+	}
+	buf, err := json.Marshal(struct {
+		Elements []types.SiacoinElement `json:"elements"`
+		State    consensus.State        `json:"state"`
+	}{elements, state})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot struct {
+		Elements []types.SiacoinElement `json:"elements"`
+		State    consensus.State        `json:"state"`
+	}
+	if err := json.Unmarshal(buf, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// importing outputs that belong to another key should fail
+	if _, err := wallet.ImportUTXOs(types.GeneratePrivateKey(), snapshot.Elements, snapshot.State); err == nil {
+		t.Fatal("expected error importing outputs with a different key")
+	}
+
+	offline, err := wallet.ImportUTXOs(pk, snapshot.Elements, snapshot.State, wallet.WithLogger(l.Named("offline")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer offline.Close()
+
+	balance, err := offline.Balance()
+	if err != nil {
+		t.Fatal(err)
+	} else if expected, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	} else if !balance.Spendable.Equals(expected.Spendable) {
+		t.Fatalf("expected spendable balance %v, got %v", expected.Spendable, balance.Spendable)
+	}
+
+	// build and sign a transaction offline
+	amount := snapshot.Elements[0].SiacoinOutput.Value.Add(types.Siacoins(1))
+	txn := types.V2Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+	}
+	basis, toSign, err := offline.FundV2Transaction(&txn, amount, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if basis != state.Index {
+		t.Fatalf("expected basis %v, got %v", state.Index, basis)
+	} else if len(toSign) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(toSign))
+	}
+	offline.SignV2Inputs(&txn, toSign)
+
+	// the transaction should be valid on the live chain
+	if err := consensus.ValidateV2Transaction(consensus.NewMidState(cm.TipState()), txn); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddV2PoolTransactions(basis, []types.V2Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPayBatch(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithMaxOutputsPerTransaction(100))
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	recipients := make(map[types.Address]types.Currency)
+	for i := 0; i < 300; i++ {
+		recipients[types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey())] = types.Siacoins(uint32(i + 1))
+	}
+
+	if _, _, err := w.Pay(recipients, types.ZeroCurrency); err == nil {
+		t.Fatal("expected Pay to reject more recipients than the limit")
+	}
+
+	feePerByte := types.Siacoins(1).Div64(1000)
+	batches, err := w.PayBatch(recipients, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(batches) != 3 {
+		t.Fatalf("expected 3 transactions, got %v", len(batches))
+	}
+
+	paid := make(map[types.Address]bool)
+	spent := make(map[types.SiacoinOutputID]bool)
+	for i, batch := range batches {
+		txn := batch.Transaction
+		if len(batch.Recipients) != 100 {
+			t.Fatalf("expected 100 recipients in transaction %v, got %v", i, len(batch.Recipients))
+		} else if len(batch.ToSign) != len(txn.SiacoinInputs) {
+			t.Fatalf("expected %v inputs to sign, got %v", len(txn.SiacoinInputs), len(batch.ToSign))
+		}
+
+		for j, addr := range batch.Recipients {
+			if paid[addr] {
+				t.Fatalf("recipient %v paid twice", addr)
+			} else if txn.SiacoinOutputs[j].Address != addr || !txn.SiacoinOutputs[j].Value.Equals(recipients[addr]) {
+				t.Fatalf("expected output %v to pay %v to %v, got %v", j, recipients[addr], addr, txn.SiacoinOutputs[j])
+			}
+			paid[addr] = true
+		}
+
+		// each transaction should be funded by its own confirmed inputs
+		for _, sci := range txn.SiacoinInputs {
+			if spent[sci.ParentID] {
+				t.Fatalf("input %v spent by multiple transactions", sci.ParentID)
+			}
+			spent[sci.ParentID] = true
+		}
+		if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+			t.Fatalf("failed to add transaction %v: %v", i, err)
+		}
+	}
+	if len(paid) != len(recipients) {
+		t.Fatalf("expected %v recipients to be paid, got %v", len(recipients), len(paid))
+	}
+
+	// the pool transactions should confirm
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	if n := len(cm.PoolTransactions()); n != 0 {
+		t.Fatalf("expected empty pool, got %v transactions", n)
+	}
+}
+
+func TestPayBatchSplit(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithMaxOutputsPerTransaction(1))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second transaction cannot be funded, so nothing is returned and the
+	// inputs of the first are released
+	amount := balance.Spendable.Mul64(3).Div64(4)
+	batches, err := w.PayBatch(map[types.Address]types.Currency{
+		{1}: amount,
+		{2}: amount,
+	}, types.ZeroCurrency)
+	if !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	} else if batches != nil {
+		t.Fatalf("expected no transactions, got %v", len(batches))
+	}
+	assertBalance(t, w, balance.Spendable, balance.Confirmed, balance.Immature, balance.Unconfirmed)
+
+	// recipients that would make a transaction heavier than a block are
+	// split across transactions, even without an output limit
+	cm, ws, w = newTestWallet(t)
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	recipients := make(map[types.Address]types.Currency)
+	for i := 0; i < 50000; i++ {
+		recipients[types.Address{byte(i), byte(i >> 8), byte(i >> 16)}] = types.Siacoins(1)
+	}
+	batches, err = w.PayBatch(recipients, types.ZeroCurrency)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(batches) != 2 {
+		t.Fatalf("expected 2 transactions, got %v", len(batches))
+	}
+	state := cm.TipState()
+	var paid int
+	for i, batch := range batches {
+		if weight := state.TransactionWeight(batch.Transaction); weight > state.MaxBlockWeight() {
+			t.Fatalf("transaction %v has weight %v, exceeding the block limit %v", i, weight, state.MaxBlockWeight())
+		}
+		paid += len(batch.Recipients)
+	}
+	if paid != len(recipients) {
+		t.Fatalf("expected %v recipients to be paid, got %v", len(recipients), paid)
+	}
+}
+
+func TestHasTransactedWith(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+	var err error
+
+	ws2 := testutil.NewEphemeralWalletStore()
+	sender, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws2, wallet.WithLogger(l.Named("sender")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	// fund both wallets
+	mineAndSync(t, cm, ws2, sender, sender.Address(), 1)
+	mineAndSync(t, cm, ws2, sender, w.Address(), 1)
+	mineAndSync(t, cm, ws2, sender, types.VoidAddress, network.MaturityDelay)
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	}
+
+	// receive a payment from the sender
+	txn, err := sender.BuildTransaction([]types.SiacoinOutput{{Address: w.Address(), Value: types.Siacoins(100)}}, nil, types.ZeroCurrency)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// make a payment to an external address
+	recipient := types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey())
+	txn, err = w.BuildTransaction([]types.SiacoinOutput{{Address: recipient, Value: types.Siacoins(100)}}, nil, types.ZeroCurrency)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	assertTransactedWith := func(addr types.Address, expected bool) {
+		t.Helper()
+		ok, err := w.HasTransactedWith(addr)
+		if err != nil {
+			t.Fatal(err)
+		} else if ok != expected {
+			t.Fatalf("expected HasTransactedWith(%v) to be %v", addr, expected)
+		}
+	}
+	assertTransactedWith(sender.Address(), true)
+	assertTransactedWith(recipient, true)
+	assertTransactedWith(types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey()), false)
+}
+
+func TestStrictValidationExactAmount(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithStrictValidation(true))
+	network := cm.TipState().Network
+
+	// fund the wallet with two outputs
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 2 {
+		t.Fatalf("expected 2 outputs, got %v", len(utxos))
+	}
+
+	// funding exactly the value of an output must not add a change output
+	for _, sce := range utxos {
+		value := sce.SiacoinOutput.Value
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: value}},
+		}
+		res, err := w.FundTransactionDetailed(&txn, value, false)
+		if err != nil {
+			t.Fatal(err)
+		} else if res.ChangeIndex != -1 {
+			t.Fatalf("expected no change output, got index %v", res.ChangeIndex)
+		} else if len(txn.SiacoinOutputs) != 1 {
+			t.Fatalf("expected 1 output, got %v", len(txn.SiacoinOutputs))
+		}
+		w.ReleaseInputs([]types.Transaction{txn}, nil)
+	}
+
+	// the same applies to v2 transactions
+	value := utxos[0].SiacoinOutput.Value
+	v2txn := types.V2Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: value}},
+	}
+	if _, _, err := w.FundV2Transaction(&v2txn, value, false); err != nil {
+		t.Fatal(err)
+	} else if len(v2txn.SiacoinOutputs) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(v2txn.SiacoinOutputs))
+	}
+	w.ReleaseInputs(nil, []types.V2Transaction{v2txn})
+
+	// sweeping spends every output without change
+	txn, _, err := w.Sweep(types.VoidAddress, types.ZeroCurrency, wallet.SweepOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinOutputs) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(txn.SiacoinOutputs))
+	}
+}
+
+func TestRedistributeTiered(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+	var err error
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	countOutputs := func() map[types.Currency]int {
+		t.Helper()
+		utxos, err := w.SpendableOutputs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := make(map[types.Currency]int)
+		for _, sce := range utxos {
+			counts[sce.SiacoinOutput.Value]++
+		}
+		return counts
+	}
+
+	targets := map[types.Currency]int{
+		types.Siacoins(100): 3,
+		types.Siacoins(500): 2,
+	}
+	txns, toSign, err := w.RedistributeTiered(targets, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 1 {
+		t.Fatalf("expected 1 transaction, got %v", len(txns))
+	}
+	for i := range txns {
+		if err := w.SignTransaction(&txns[i], toSign[i], types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cm.AddPoolTransactions(txns); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	counts := countOutputs()
+	for value, n := range targets {
+		if counts[value] != n {
+			t.Fatalf("expected %v outputs of %v, got %v", n, value, counts[value])
+		}
+	}
+
+	// existing outputs are reused, so only the missing ones are created
+	targets[types.Siacoins(100)] = 4
+	txns, toSign, err = w.RedistributeTiered(targets, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 1 {
+		t.Fatalf("expected 1 transaction, got %v", len(txns))
+	}
+	var created int
+	for _, sco := range txns[0].SiacoinOutputs {
+		if _, ok := targets[sco.Value]; ok {
+			created++
+		}
+	}
+	if created != 1 {
+		t.Fatalf("expected 1 new target output, got %v", created)
+	}
+	if err := w.SignTransaction(&txns[0], toSign[0], types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions(txns); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	counts = countOutputs()
+	for value, n := range targets {
+		if counts[value] != n {
+			t.Fatalf("expected %v outputs of %v, got %v", n, value, counts[value])
+		}
+	}
+
+	// the targets are already met
+	txns, _, err = w.RedistributeTiered(targets, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 0 {
+		t.Fatalf("expected no transactions, got %v", len(txns))
+	}
+}
+
+type storeCallRecorder struct {
+	mu       sync.Mutex
+	calls    map[string][]time.Duration
+	balances []wallet.BalanceBreakdown
+}
+
+func (r *storeCallRecorder) RecordStoreCall(method string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[method] = append(r.calls[method], d)
+}
+
+func (r *storeCallRecorder) RecordBalance(b wallet.BalanceBreakdown) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.balances = append(r.balances, b)
+}
+
+func (r *storeCallRecorder) lastBalance() (wallet.BalanceBreakdown, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.balances) == 0 {
+		return wallet.BalanceBreakdown{}, 0
+	}
+	return r.balances[len(r.balances)-1], len(r.balances)
+}
+
+func (r *storeCallRecorder) count(method string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.calls[method])
+}
+
+func TestMetricsRecorder(t *testing.T) {
+	rec := &storeCallRecorder{calls: make(map[string][]time.Duration)}
+	cm, ws, w := newTestWallet(t, wallet.WithMetricsRecorder(rec))
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	before := rec.count(wallet.StoreCallUnspentSiacoinElements)
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(100), false); err != nil {
+		t.Fatal(err)
+	} else if n := rec.count(wallet.StoreCallUnspentSiacoinElements); n <= before {
+		t.Fatalf("expected a timing sample for %s, got %v", wallet.StoreCallUnspentSiacoinElements, n-before)
+	}
+
+	if _, err := w.Events(0, 100); err != nil {
+		t.Fatal(err)
+	} else if n := rec.count(wallet.StoreCallWalletEvents); n == 0 {
+		t.Fatalf("expected a timing sample for %s", wallet.StoreCallWalletEvents)
+	}
+}
+
+func TestFundTransactionInclude(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+	var err error
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// create a small output
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: types.Siacoins(10)}},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(10), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	small := txn.SiacoinOutputID(0)
+
+	// force the small output to be spent and top up from the larger ones
+	amount := types.Siacoins(100)
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+	}
+	toSign, err = w.FundTransactionInclude(&txn, amount, false, []types.SiacoinOutputID{small})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(txn.SiacoinInputs))
+	} else if !slices.Contains(toSign, types.Hash256(small)) {
+		t.Fatal("expected the small output to be included")
+	} else if len(txn.SiacoinOutputs) != 2 {
+		t.Fatalf("expected a change output, got %v outputs", len(txn.SiacoinOutputs))
+	}
+
+	// the output is now locked
+	txn2 := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1)}},
+	}
+	if _, err := w.FundTransactionInclude(&txn2, types.Siacoins(1), false, []types.SiacoinOutputID{small}); !errors.Is(err, wallet.ErrOutputLocked) {
+		t.Fatalf("expected ErrOutputLocked, got %v", err)
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// the small output covers the amount on its own
+	txn2 = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1)}},
+	}
+	if toSign, err := w.FundTransactionInclude(&txn2, types.Siacoins(1), false, []types.SiacoinOutputID{small}); err != nil {
+		t.Fatal(err)
+	} else if len(toSign) != 1 || toSign[0] != types.Hash256(small) {
+		t.Fatalf("expected only the small output to be spent, got %v", toSign)
+	}
+	w.ReleaseInputs([]types.Transaction{txn2}, nil)
+
+	// unknown outputs are rejected
+	txn2 = types.Transaction{}
+	if _, err := w.FundTransactionInclude(&txn2, types.Siacoins(1), false, []types.SiacoinOutputID{{1}}); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestReservationSweep(t *testing.T) {
+	// create wallet with a controllable clock. The clock is read by the
+	// sweeper goroutine, so it must be safe for concurrent use.
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	cm, ws, w := newTestWallet(t, wallet.WithClock(clock), wallet.WithReservationDuration(time.Hour), wallet.WithReservationLogSize(10), wallet.WithReservationSweepInterval(10*time.Millisecond))
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasExpired := func() bool {
+		for _, ev := range w.ReservationLog() {
+			if ev.Type == wallet.ReservationEventExpired {
+				return ev.IDs[0] == types.SiacoinOutputID(toSign[0])
+			}
+		}
+		return false
+	}
+
+	// the reservation has not expired yet
+	time.Sleep(50 * time.Millisecond)
+	if hasExpired() {
+		t.Fatal("expected the reservation to be active")
+	}
+
+	// advance the clock past the reservation duration and wait for the
+	// sweeper to remove the reservation without any funding call
+	mu.Lock()
+	now = now.Add(2 * time.Hour)
+	mu.Unlock()
+	for i := 0; ; i++ {
+		if hasExpired() {
+			break
+		} else if i == 100 {
+			t.Fatal("expected the reservation to be swept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// closing the wallet stops the sweeper
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFundOutputs(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	for _, sce := range utxos {
+		values[sce.ID] = sce.SiacoinOutput.Value
+	}
+
+	// the caller only adds the recipients
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.Address{1}, Value: types.Siacoins(100)},
+			{Address: types.Address{2}, Value: types.Siacoins(200)},
+		},
+	}
+	feePerByte := types.Siacoins(1).Div64(1000)
+	toSign, err := w.FundOutputs(&txn, feePerByte, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.MinerFees) != 1 {
+		t.Fatalf("expected 1 miner fee, got %v", len(txn.MinerFees))
+	} else if len(txn.SiacoinOutputs) != 3 {
+		t.Fatalf("expected a change output, got %v outputs", len(txn.SiacoinOutputs))
+	}
+	fee := txn.MinerFees[0]
+
+	// the inputs cover exactly the recipients plus the fee, with the rest
+	// returned as change
+	var inputSum types.Currency
+	for _, sci := range txn.SiacoinInputs {
+		inputSum = inputSum.Add(values[sci.ParentID])
+	}
+	change := txn.SiacoinOutputs[2].Value
+	if want := types.Siacoins(300).Add(fee); !inputSum.Sub(change).Equals(want) {
+		t.Fatalf("expected inputs less change to be %v, got %v", want, inputSum.Sub(change))
+	}
+
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if required := feePerByte.Mul64(cm.TipState().TransactionWeight(txn)); fee.Cmp(required) < 0 {
+		t.Fatalf("expected fee of at least %v, got %v", required, fee)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// inputs already present are credited against the amount
+	var unused types.SiacoinElement
+	for _, sce := range utxos {
+		if sce.ID != txn.SiacoinInputs[0].ParentID {
+			unused = sce
+		}
+	}
+	txn = types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: unused.ID, UnlockConditions: types.StandardUnlockConditions(pk.PublicKey())}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.Address{1}, Value: types.Siacoins(100)},
+		},
+	}
+	if toSign, err := w.FundOutputs(&txn, feePerByte, false); err != nil {
+		t.Fatal(err)
+	} else if len(toSign) != 0 || len(txn.SiacoinInputs) != 1 {
+		t.Fatalf("expected no additional inputs, got %v", len(toSign))
+	} else if change := txn.SiacoinOutputs[1].Value; !change.Equals(unused.SiacoinOutput.Value.Sub(types.Siacoins(100)).Sub(txn.MinerFees[0])) {
+		t.Fatalf("unexpected change %v", change)
+	}
+
+	// the value of unknown inputs cannot be credited
+	txn = types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: types.SiacoinOutputID{1}}},
+	}
+	if _, err := w.FundOutputs(&txn, feePerByte, false); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// inputs that only partly cover the amount are not selected again, even
+	// if they would be the first choice
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	utxos, err = w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	largest := utxos[0]
+	for _, sce := range utxos[1:] {
+		if sce.SiacoinOutput.Value.Cmp(largest.SiacoinOutput.Value) > 0 {
+			largest = sce
+		}
+	}
+	txn = types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: largest.ID, UnlockConditions: types.StandardUnlockConditions(pk.PublicKey())}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.Address{1}, Value: largest.SiacoinOutput.Value.Add(types.Siacoins(1))},
+		},
+	}
+	toSign, err = w.FundOutputs(&txn, feePerByte, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(txn.SiacoinInputs))
+	} else if txn.SiacoinInputs[1].ParentID == largest.ID {
+		t.Fatal("expected the existing input not to be selected again")
+	}
+	// the existing input is not reserved, so it is signed by the caller
+	toSign = append(toSign, types.Hash256(largest.ID))
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type injectedStore struct {
+	wallet.SingleAddressStore
+	extra []types.SiacoinElement
+}
+
+func (s *injectedStore) UnspentSiacoinElements() ([]types.SiacoinElement, error) {
+	elements, err := s.SingleAddressStore.UnspentSiacoinElements()
+	if err != nil {
+		return nil, err
+	}
+	return append(elements, s.extra...), nil
+}
+
+func TestSuspiciousOutputs(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, genesisState)
+	// create wallet
+	l := zaptest.NewLogger(t)
+	store := &injectedStore{SingleAddressStore: ws}
+	w, err := wallet.NewSingleAddressWallet(pk, cm, store, wallet.WithLogger(l.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// mine a block to the wallet; the payout is immature but plausible
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+
+	suspicious, err := w.SuspiciousOutputs(network.MaturityDelay)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(suspicious) != 0 {
+		t.Fatalf("expected no suspicious outputs, got %v", len(suspicious))
+	}
+
+	// inject an output that will not mature for a very long time
+	tip := cm.Tip()
+	bad := types.SiacoinElement{
+		ID:             types.SiacoinOutputID{1},
+		SiacoinOutput:  types.SiacoinOutput{Address: w.Address(), Value: types.Siacoins(1)},
+		MaturityHeight: tip.Height + 10*network.MaturityDelay,
+	}
+	store.extra = append(store.extra, bad)
+
+	suspicious, err = w.SuspiciousOutputs(network.MaturityDelay)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(suspicious) != 1 {
+		t.Fatalf("expected 1 suspicious output, got %v", len(suspicious))
+	} else if suspicious[0].ID != bad.ID {
+		t.Fatalf("expected output %v, got %v", bad.ID, suspicious[0].ID)
+	}
+}
+
+func TestCoveredFieldsBuilder(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	res, err := w.FundTransactionDetailed(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cover the inputs and the wallet's own change output only
+	cf, err := wallet.NewCoveredFields(txn).CoverAllInputs().CoverOutputs(res.ChangeIndex, res.ChangeIndex).Build()
+	if err != nil {
+		t.Fatal(err)
+	} else if cf.WholeTransaction {
+		t.Fatal("expected partial covered fields")
+	} else if !slices.Equal(cf.SiacoinInputs, []uint64{0}) || !slices.Equal(cf.SiacoinOutputs, []uint64{uint64(res.ChangeIndex)}) {
+		t.Fatalf("unexpected covered fields %+v", cf)
+	}
+	if err := w.SignTransaction(&txn, res.ToSign, cf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the uncovered output can be modified without invalidating the signature
+	modified := txn
+	modified.SiacoinOutputs = slices.Clone(txn.SiacoinOutputs)
+	modified.SiacoinOutputs[1-res.ChangeIndex].Address = types.Address{1}
+	sigHash := cm.TipState().PartialSigHash(modified, cf)
+	if !pk.PublicKey().VerifyHash(sigHash, types.Signature(txn.Signatures[0].Signature)) {
+		t.Fatal("expected the signature to remain valid")
+	}
+
+	// the signed transaction is valid
+	if _, err := cm.AddPoolTransactions([]types.Transaction{modified}); err != nil {
+		t.Fatal(err)
+	}
+
+	// out of range indices are rejected
+	if _, err := wallet.NewCoveredFields(txn).CoverOutputs(len(txn.SiacoinOutputs)).Build(); err == nil {
+		t.Fatal("expected an error for an out of range index")
+	}
+}
+
+func TestSetClaimAddresses(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	_, _, w := newTestWalletWithKey(t, pk)
+
+	uc := types.StandardUnlockConditions(pk.PublicKey())
+	newTxn := func() types.Transaction {
+		return types.Transaction{
+			SiafundInputs: []types.SiafundInput{
+				{ParentID: types.SiafundOutputID{1}, UnlockConditions: uc},
+				{ParentID: types.SiafundOutputID{2}, UnlockConditions: uc, ClaimAddress: types.Address{2}},
+			},
+		}
+	}
+	toSign := []types.Hash256{{1}, {2}}
+
+	// signing an input without a claim address is rejected
+	txn := newTxn()
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err == nil {
+		t.Fatal("expected an error for a missing claim address")
+	}
+
+	// claims route to the wallet by default
+	w.SetClaimAddresses(&txn, types.VoidAddress)
+	if txn.SiafundInputs[0].ClaimAddress != w.Address() {
+		t.Fatalf("expected claim address %v, got %v", w.Address(), txn.SiafundInputs[0].ClaimAddress)
+	} else if txn.SiafundInputs[1].ClaimAddress != (types.Address{2}) {
+		t.Fatalf("expected existing claim address to be kept, got %v", txn.SiafundInputs[1].ClaimAddress)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an override routes every claim elsewhere
+	txn = newTxn()
+	override := types.Address{3}
+	w.SetClaimAddresses(&txn, override)
+	for i, sfi := range txn.SiafundInputs {
+		if sfi.ClaimAddress != override {
+			t.Fatalf("input %d: expected claim address %v, got %v", i, override, sfi.ClaimAddress)
+		}
+	}
+}
+
+func TestTransactionStream(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+	var err error
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	stream, cancel := w.TransactionStream()
+	defer cancel()
+
+	// payouts are not transactions, so nothing should be buffered
+	select {
+	case ev := <-stream:
+		t.Fatalf("unexpected event %v", ev.Type)
+	default:
+	}
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction is delivered when it enters the pool
+	select {
+	case ev := <-stream:
+		if ev.ID != types.Hash256(txn.ID()) {
+			t.Fatalf("expected event %v, got %v", txn.ID(), ev.ID)
+		} else if ev.Type != wallet.EventTypeV1Transaction {
+			t.Fatalf("expected type %v, got %v", wallet.EventTypeV1Transaction, ev.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the transaction to be delivered")
+	}
+
+	// confirming the transaction does not deliver it again
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	select {
+	case ev := <-stream:
+		t.Fatalf("unexpected event %v", ev.ID)
+	default:
+	}
+
+	// a new stream only sees transactions that appear after it was opened
+	stream2, cancel2 := w.TransactionStream()
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	toSign, err = w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	}
+	// mine the transaction directly so it is only seen in a block
+	state := cm.TipState()
+	b := types.Block{
+		ParentID:     state.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: state.BlockReward()}},
+		Transactions: []types.Transaction{txn},
+	}
+	if !coreutils.FindBlockNonce(state, &b, time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := cm.AddBlocks([]types.Block{b}); err != nil {
+		t.Fatal(err)
+	} else if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []<-chan wallet.Event{stream, stream2} {
+		select {
+		case ev := <-s:
+			if ev.ID != types.Hash256(txn.ID()) {
+				t.Fatalf("expected event %v, got %v", txn.ID(), ev.ID)
+			}
+		default:
+			t.Fatal("expected the confirmed transaction to be delivered")
+		}
+		select {
+		case ev := <-s:
+			t.Fatalf("unexpected event %v", ev.ID)
+		default:
+		}
+	}
+
+	// closing the stream closes the channel
+	cancel2()
+	if _, ok := <-stream2; ok {
+		t.Fatal("expected the stream to be closed")
+	}
+}
+
+func TestTransactionStreamFull(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithTransactionStreamBuffer(1, false))
+	network := cm.TipState().Network
+
+	// fund the wallet with three outputs
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	fund := func() types.Transaction {
+		t.Helper()
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+		}
+		toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
+		return txn
+	}
+
+	// a stream that falls behind is closed rather than blocking the pool
+	stream, cancel := w.TransactionStream()
+	defer cancel()
+	txn1, txn2 := fund(), fund()
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn1, txn2}); err != nil {
+		t.Fatal(err)
+	}
+	if ev, ok := <-stream; !ok {
+		t.Fatal("expected the buffered event to be delivered")
+	} else if ev.ID != types.Hash256(txn1.ID()) && ev.ID != types.Hash256(txn2.ID()) {
+		t.Fatalf("unexpected event %v", ev.ID)
+	} else if _, ok := <-stream; ok {
+		t.Fatal("expected the full stream to be closed")
+	}
+
+	// confirmed transactions are only published once the update commits
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	stream2, cancel2 := w.TransactionStream()
+	defer cancel2()
+	txn3 := fund()
+	state := cm.TipState()
+	b := types.Block{
+		ParentID:     state.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: state.BlockReward()}},
+		Transactions: []types.Transaction{txn3},
+	}
+	if !coreutils.FindBlockNonce(state, &b, time.Second) {
+		t.Fatal("failed to find nonce")
+	} else if err := cm.AddBlocks([]types.Block{b}); err != nil {
+		t.Fatal(err)
+	}
+	tip, err := ws.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, applied, err := cm.UpdatesSince(tip, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCommit := errors.New("commit failed")
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		if err := w.UpdateChainState(tx, reverted, applied); err != nil {
+			return err
+		}
+		return errCommit
+	})
+	if !errors.Is(err, errCommit) {
+		t.Fatalf("expected the commit to fail, got %v", err)
+	}
+	select {
+	case ev := <-stream2:
+		t.Fatalf("unexpected event %v before the update committed", ev.ID)
+	default:
+	}
+
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ev := <-stream2:
+		if ev.ID != types.Hash256(txn3.ID()) {
+			t.Fatalf("expected event %v, got %v", txn3.ID(), ev.ID)
+		}
+	default:
+		t.Fatal("expected the confirmed transaction to be delivered")
+	}
+}
+
+func TestForward(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// mine two payouts; only the first one matures
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay-1)
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+
+	utxos, err := ws.UnspentSiacoinElements()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 2 {
+		t.Fatalf("expected 2 outputs, got %v", len(utxos))
+	}
+	matured, immature := utxos[0], utxos[1]
+	if matured.MaturityHeight > immature.MaturityHeight {
+		matured, immature = immature, matured
+	}
+
+	dest := types.Address{1}
+	feePerByte := types.Siacoins(1).Div64(1000)
+	if _, _, err := w.Forward(immature.ID, dest, feePerByte); err == nil {
+		t.Fatal("expected an error for an immature output")
+	} else if _, _, err := w.Forward(types.SiacoinOutputID{1}, dest, feePerByte); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	} else if _, _, err := w.Forward(matured.ID, dest, matured.SiacoinOutput.Value); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	}
+
+	txn, toSign, err := w.Forward(matured.ID, dest, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 1 || txn.SiacoinInputs[0].ParentID != matured.ID {
+		t.Fatalf("expected a single input spending %v, got %v", matured.ID, txn.SiacoinInputs)
+	} else if len(txn.SiacoinOutputs) != 1 || txn.SiacoinOutputs[0].Address != dest {
+		t.Fatalf("expected a single output to %v, got %v", dest, txn.SiacoinOutputs)
+	} else if len(txn.MinerFees) != 1 {
+		t.Fatalf("expected 1 miner fee, got %v", len(txn.MinerFees))
+	} else if !txn.SiacoinOutputs[0].Value.Add(txn.MinerFees[0]).Equals(matured.SiacoinOutput.Value) {
+		t.Fatal("expected the output and fee to sum to the input value")
+	}
+
+	// the output is reserved
+	if _, _, err := w.Forward(matured.ID, dest, feePerByte); !errors.Is(err, wallet.ErrOutputLocked) {
+		t.Fatalf("expected ErrOutputLocked, got %v", err)
+	}
+
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if required := feePerByte.Mul64(cm.TipState().TransactionWeight(txn)); txn.MinerFees[0].Cmp(required) < 0 {
+		t.Fatalf("expected fee of at least %v, got %v", required, txn.MinerFees[0])
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFragmentationMetrics(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, genesisState)
+	// create wallet
+	l := zaptest.NewLogger(t)
+	store := &injectedStore{SingleAddressStore: ws}
+	w, err := wallet.NewSingleAddressWallet(pk, cm, store, wallet.WithLogger(l.Named("wallet")), wallet.WithDustThreshold(types.Siacoins(10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if m, err := w.FragmentationMetrics(); err != nil {
+		t.Fatal(err)
+	} else if m != (wallet.FragMetrics{}) {
+		t.Fatalf("expected empty metrics, got %+v", m)
+	}
+
+	// inject a known set of outputs
+	for i, sc := range []uint32{100, 2, 200, 1, 3} {
+		store.extra = append(store.extra, types.SiacoinElement{
+			ID:            types.SiacoinOutputID{byte(i + 1)},
+			SiacoinOutput: types.SiacoinOutput{Address: w.Address(), Value: types.Siacoins(sc)},
+		})
+	}
+	// outputs of other addresses are ignored
+	store.extra = append(store.extra, types.SiacoinElement{
+		ID:            types.SiacoinOutputID{10},
+		SiacoinOutput: types.SiacoinOutput{Address: types.VoidAddress, Value: types.Siacoins(1)},
+	})
+
+	m, err := w.FragmentationMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := wallet.FragMetrics{
+		SpendableOutputs: 5,
+		DustOutputs:      3,
+		MedianValue:      types.Siacoins(3),
+		DustRatio:        0.6,
+	}
+	if m != expected {
+		t.Fatalf("expected %+v, got %+v", expected, m)
+	}
+
+	// the median of an even number of outputs is the mean of the middle two
+	store.extra = store.extra[1:]
+	if m, err := w.FragmentationMetrics(); err != nil {
+		t.Fatal(err)
+	} else if !m.MedianValue.Equals(types.Siacoins(5).Div64(2)) {
+		t.Fatalf("expected median %v, got %v", types.Siacoins(5).Div64(2), m.MedianValue)
+	}
+}
+
+func TestSelectionModeAntiFragment(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithSelectionMode(wallet.SelectionModeAntiFragment), wallet.WithAntiFragmentThreshold(2), wallet.WithDefragThreshold(100))
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// fragment the wallet
+	txns, toSign, err := w.Redistribute(20, types.Siacoins(1000), types.ZeroCurrency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range txns {
+		if err := w.SignTransaction(&txns[i], toSign[i], types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cm.AddPoolTransactions(txns); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	countOutputs := func() int {
+		t.Helper()
+		utxos, err := w.SpendableOutputs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(utxos)
+	}
+
+	// each send spends the smallest outputs, so the number of outputs
+	// decreases
+	prev := countOutputs()
+	for i := 0; i < 4; i++ {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1500)}},
+		}
+		toSign, err := w.FundTransaction(&txn, types.Siacoins(1500), false)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+			t.Fatal(err)
+		}
+		mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+		n := countOutputs()
+		if n >= prev {
+			t.Fatalf("send %d: expected fewer than %d outputs, got %d", i, prev, n)
+		}
+		prev = n
+	}
+}
+
+func TestTransactionEffect(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+	genesisState := cm.TipState()
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	if _, _, _, err := w.TransactionEffect(types.TransactionID{1}); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// send part of the payout to another address, with change
+	reward := genesisState.BlockReward()
+	amount, minerFee := types.Siacoins(100), types.Siacoins(1)
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+		MinerFees:      []types.Currency{minerFee},
+	}
+	toSign, err := w.FundTransaction(&txn, amount, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEffect := func() {
+		t.Helper()
+		inflow, outflow, fee, err := w.TransactionEffect(txn.ID())
+		if err != nil {
+			t.Fatal(err)
+		} else if !outflow.Equals(reward) {
+			t.Fatalf("expected outflow %v, got %v", reward, outflow)
+		} else if change := reward.Sub(amount).Sub(minerFee); !inflow.Equals(change) {
+			t.Fatalf("expected inflow %v, got %v", change, inflow)
+		} else if !fee.Equals(minerFee) {
+			t.Fatalf("expected fee %v, got %v", minerFee, fee)
+		}
+	}
+
+	// the transaction is found in the pool
+	assertEffect()
+
+	// and in the wallet's history once confirmed
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	if len(cm.PoolTransactions()) != 0 {
+		t.Fatal("expected the pool to be empty")
+	}
+	assertEffect()
+}
+
+// stalePoolChainManager is a chain manager whose pool includes transactions
+// that have already been confirmed.
+type stalePoolChainManager struct {
+	*chain.Manager
+	txns []types.Transaction
+}
+
+func (cm *stalePoolChainManager) PoolTransactions() []types.Transaction {
+	return append(cm.Manager.PoolTransactions(), cm.txns...)
+}
+
+func TestBalanceConcurrentUpdates(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+	genesisState := cm.TipState()
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	total := genesisState.BlockReward()
+
+	// repeatedly send funds back to the wallet while the balance is read.
+	// The wallet's total value never changes, so no balance can exceed it.
+	done := make(chan struct{})
+	errCh := make(chan error, 1)
+	var last types.Transaction
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			txn := types.Transaction{
+				SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: types.Siacoins(100)}},
+			}
+			toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+			if err != nil {
+				errCh <- err
+				return
+			} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+				errCh <- err
+				return
+			} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+				errCh <- err
+				return
+			}
+			last = txn
+
+			b, ok := coreutils.MineBlock(cm, types.VoidAddress, time.Second)
+			if !ok {
+				errCh <- errors.New("failed to mine block")
+				return
+			} else if err := cm.AddBlocks([]types.Block{b}); err != nil {
+				errCh <- err
+				return
+			} else if err := syncDB(cm, ws, w); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			select {
+			case err := <-errCh:
+				t.Fatal(err)
+			default:
+			}
+			assertBalance(t, w, total, total, types.ZeroCurrency, types.ZeroCurrency)
+
+			// a pool that still contains a confirmed transaction does not
+			// double count its outputs
+			stale := &stalePoolChainManager{Manager: cm, txns: []types.Transaction{last}}
+			w2, err := wallet.NewSingleAddressWallet(pk, stale, ws)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w2.Close()
+			assertBalance(t, w2, total, total, types.ZeroCurrency, types.ZeroCurrency)
+			return
+		default:
+		}
+
+		balance, err := w.Balance()
+		if err != nil {
+			t.Fatal(err)
+		} else if balance.Confirmed.Add(balance.Immature).Cmp(total) > 0 {
+			t.Fatalf("confirmed balance %v exceeds total %v", balance.Confirmed, total)
+		} else if balance.Spendable.Add(balance.Unconfirmed).Cmp(total) > 0 {
+			t.Fatalf("spendable %v and unconfirmed %v balance exceed total %v", balance.Spendable, balance.Unconfirmed, total)
+		}
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// build a transaction with a large amount of arbitrary data
+	data := frand.Bytes(1000)
+	feePerByte := types.Siacoins(1).Div64(1000)
+	txn, err := w.BuildTransaction([]types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}}, [][]byte{data}, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := wallet.EncodedSize(txn)
+	if size != int(cm.TipState().TransactionWeight(txn)) {
+		t.Fatalf("expected encoded size %v to match the weight %v", size, cm.TipState().TransactionWeight(txn))
+	}
+
+	// an estimate based on the constant input size ignores the arbitrary
+	// data and would underpay the fee
+	estimate := 241 * len(txn.SiacoinInputs)
+	if size <= estimate+len(data) {
+		t.Fatalf("expected encoded size %v to exceed the estimate %v plus the data", size, estimate)
+	}
+
+	// the fee is based on the measured size. Encoding the final fee may
+	// add a few bytes compared to the fee the size was measured with.
+	fee := txn.MinerFees[0]
+	if lower := feePerByte.Mul64(uint64(size)); fee.Cmp(lower) < 0 {
+		t.Fatalf("expected fee of at least %v, got %v", lower, fee)
+	} else if upper := feePerByte.Mul64(uint64(size + 8)); fee.Cmp(upper) > 0 {
+		t.Fatalf("expected fee of at most %v, got %v", upper, fee)
+	}
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayEvents(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+	var err error
+
+	// create a mix of payouts and transactions
+	mineAndSync(t, cm, ws, w, w.Address(), 5)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	for i := 0; i < 3; i++ {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+		}
+		toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+			t.Fatal(err)
+		}
+		mineAndSync(t, cm, ws, w, w.Address(), 1)
+	}
+
+	replay := func(from types.ChainIndex) (events []wallet.Event) {
+		t.Helper()
+		err := w.ReplayEvents(context.Background(), from, func(ev wallet.Event) error {
+			events = append(events, ev)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	ids := func(events []wallet.Event) (ids []types.Hash256) {
+		for _, ev := range events {
+			ids = append(ids, ev.ID)
+		}
+		return
+	}
+
+	all := replay(types.ChainIndex{})
+	if count, err := w.EventCount(); err != nil {
+		t.Fatal(err)
+	} else if uint64(len(all)) != count {
+		t.Fatalf("expected %v events, got %v", count, len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Index.Height < all[i-1].Index.Height {
+			t.Fatalf("event %d at height %v replayed after height %v", i, all[i].Index.Height, all[i-1].Index.Height)
+		}
+	}
+	if !slices.Equal(ids(all), ids(replay(types.ChainIndex{}))) {
+		t.Fatal("expected identical replays")
+	}
+
+	// replaying from a midpoint yields the matching suffix
+	mid, ok := cm.BestIndex(cm.Tip().Height - 2)
+	if !ok {
+		t.Fatal("failed to get index")
+	}
+	suffix := replay(mid)
+	i := slices.IndexFunc(all, func(ev wallet.Event) bool { return ev.Index.Height >= mid.Height })
+	if len(suffix) == 0 || !slices.Equal(ids(suffix), ids(all[i:])) {
+		t.Fatalf("expected %v events from height %v, got %v", len(all[i:]), mid.Height, len(suffix))
+	} else if !slices.Equal(ids(suffix), ids(replay(mid))) {
+		t.Fatal("expected identical replays")
+	}
+
+	// an index that is not on the best chain is rejected
+	if err := w.ReplayEvents(context.Background(), types.ChainIndex{Height: mid.Height, ID: types.BlockID{1}}, func(wallet.Event) error { return nil }); err == nil {
+		t.Fatal("expected an error for an index not on the best chain")
+	}
+
+	// handler errors stop the replay
+	handlerErr := errors.New("stop")
+	var calls int
+	err = w.ReplayEvents(context.Background(), types.ChainIndex{}, func(wallet.Event) error {
+		calls++
+		return handlerErr
+	})
+	if !errors.Is(err, handlerErr) {
+		t.Fatalf("expected handler error, got %v", err)
+	} else if calls != 1 {
+		t.Fatalf("expected 1 call, got %v", calls)
+	}
+
+	// a sync during the replay does not change the replayed events
+	var replayed []wallet.Event
+	err = w.ReplayEvents(context.Background(), types.ChainIndex{}, func(ev wallet.Event) error {
+		if len(replayed) == 0 {
+			mineAndSync(t, cm, ws, w, w.Address(), 1)
+		}
+		replayed = append(replayed, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if !slices.Equal(ids(replayed), ids(all)) {
+		t.Fatalf("expected %v events, got %v", len(all), len(replayed))
+	}
+
+	// stores that cannot stream events in chain order are not supported
+	w2, err := wallet.NewSingleAddressWallet(pk, cm, pagedStore{ws})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	if err := w2.ReplayEvents(context.Background(), types.ChainIndex{}, func(wallet.Event) error { return nil }); !errors.Is(err, wallet.ErrStoreUnsupported) {
+		t.Fatalf("expected %v, got %v", wallet.ErrStoreUnsupported, err)
+	}
+}
+
+func TestStoreAddressCheck(t *testing.T) {
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+	l := zaptest.NewLogger(t)
+
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// a fresh store records the wallet's address
+	if _, err := ws.WalletAddress(); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if addr, err := ws.WalletAddress(); err != nil {
+		t.Fatal(err)
+	} else if addr != w.Address() {
+		t.Fatalf("expected address %v, got %v", w.Address(), addr)
+	}
+
+	// reopening with the same key succeeds
+	w, err = wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// opening with a different key fails
+	_, err = wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws, wallet.WithLogger(l.Named("wallet")))
+	if !errors.Is(err, wallet.ErrDifferentSeed) {
+		t.Fatalf("expected ErrDifferentSeed, got %v", err)
+	}
+
+	// unless the check is disabled
+	w, err = wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws, wallet.WithLogger(l.Named("wallet")), wallet.WithAddressCheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if addr, err := ws.WalletAddress(); err != nil {
+		t.Fatal(err)
+	} else if addr != types.StandardUnlockHash(pk.PublicKey()) {
+		t.Fatal("expected recorded address to be unchanged")
+	}
+}
+
+func TestSuggestChangelessAmount(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	feePerByte := types.Siacoins(1).Div64(1000)
+	if _, ok := w.SuggestChangelessAmount(types.Siacoins(100), feePerByte); ok {
+		t.Fatal("expected no changeless amount for an empty wallet")
+	} else if _, inputs, ok, err := w.SuggestChangelessInputs(types.Siacoins(100), feePerByte); err != nil {
+		t.Fatal(err)
+	} else if ok || len(inputs) != 0 {
+		t.Fatal("expected no changeless inputs for an empty wallet")
+	}
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(utxos))
+	}
+	value := utxos[0].SiacoinOutput.Value
+
+	// a target slightly below the output's value is matched by spending it
+	amount, ok := w.SuggestChangelessAmount(value.Sub(types.Siacoins(10)), feePerByte)
+	if !ok {
+		t.Fatal("expected a changeless amount")
+	} else if amount.Cmp(value) >= 0 {
+		t.Fatalf("expected amount %v to be less than the output value %v", amount, value)
+	}
+	inputAmount, inputs, ok, err := w.SuggestChangelessInputs(value.Sub(types.Siacoins(10)), feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected changeless inputs")
+	} else if !inputAmount.Equals(amount) {
+		t.Fatalf("expected amount %v, got %v", amount, inputAmount)
+	} else if len(inputs) != 1 || inputs[0] != utxos[0].ID {
+		t.Fatalf("expected output %v to be suggested, got %v", utxos[0].ID, inputs)
+	}
+	fee := value.Sub(amount)
+
+	// funding the suggested amount plus the fee with the suggested outputs
+	// must not add change, and the fee must cover the transaction's weight
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+		MinerFees:      []types.Currency{fee},
+	}
+	toSign, err := w.FundTransactionExact(&txn, inputs, types.VoidAddress)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinOutputs) != 1 {
+		t.Fatalf("expected no change output, got %v outputs", len(txn.SiacoinOutputs))
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if minFee := feePerByte.Mul64(uint64(wallet.EncodedSize(txn))); fee.Cmp(minFee) < 0 {
+		t.Fatalf("expected fee %v to cover %v", fee, minFee)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// the only output is now spent in the pool
+	if _, ok := w.SuggestChangelessAmount(amount, feePerByte); ok {
+		t.Fatal("expected no changeless amount")
+	}
+
+	// store errors are returned by SuggestChangelessInputs, and reported as
+	// no suggestion by SuggestChangelessAmount
+	ws2 := &unavailableStore{SingleAddressStore: testutil.NewEphemeralWalletStore()}
+	w2, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	ws2.fail = true
+	if _, _, _, err := w2.SuggestChangelessInputs(amount, feePerByte); !errors.Is(err, errUnavailable) {
+		t.Fatalf("expected store error, got %v", err)
+	} else if _, ok := w2.SuggestChangelessAmount(amount, feePerByte); ok {
+		t.Fatal("expected no changeless amount")
+	}
+}
+
+var errUnavailable = errors.New("store unavailable")
+
+// unavailableStore fails to return the wallet's outputs once fail is set.
+type unavailableStore struct {
+	wallet.SingleAddressStore
+	fail bool
+}
+
+func (s *unavailableStore) UnspentSiacoinElements() ([]types.SiacoinElement, error) {
+	if s.fail {
+		return nil, errUnavailable
+	}
+	return s.SingleAddressStore.UnspentSiacoinElements()
+}
+
+func TestSuggestChangelessAmountPairs(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 2 {
+		t.Fatalf("expected 2 outputs, got %v", len(utxos))
+	}
+	total := utxos[0].SiacoinOutput.Value.Add(utxos[1].SiacoinOutput.Value)
+
+	// only both outputs together are close to the target
+	amount, inputs, ok, err := w.SuggestChangelessInputs(total, types.ZeroCurrency)
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok || len(inputs) != 2 {
+		t.Fatalf("expected 2 outputs, got %v", len(inputs))
+	} else if !amount.Equals(total) {
+		t.Fatalf("expected %v, got %v", total, amount)
+	}
+
+	// a target far from any combination is rejected
+	if _, ok := w.SuggestChangelessAmount(total.Mul64(3), types.ZeroCurrency); ok {
+		t.Fatal("expected no changeless amount")
+	}
+}
+
+func TestMetricsRecorderBalance(t *testing.T) {
+	rec := &storeCallRecorder{calls: make(map[string][]time.Duration)}
+	cm, ws, w := newTestWallet(t, wallet.WithMetricsRecorder(rec))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	bb, n := rec.lastBalance()
+	if n == 0 {
+		t.Fatal("expected a balance update")
+	} else if bb.Immature.IsZero() || bb.UTXOs != 1 {
+		t.Fatalf("expected 1 immature output, got %+v", bb)
+	}
+
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// lock an output and check the recorded balance matches the wallet's
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(100), false); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb, _ = rec.lastBalance()
+	if bb.Balance != balance {
+		t.Fatalf("expected balance %+v, got %+v", balance, bb.Balance)
+	} else if bb.UTXOs != 1 {
+		t.Fatalf("expected 1 output, got %v", bb.UTXOs)
+	} else if !bb.Spendable.IsZero() || bb.Confirmed.IsZero() {
+		t.Fatalf("expected a locked confirmed balance, got %+v", bb)
+	}
+	// the store's totals are used instead of loading its outputs
+	if n := rec.count(wallet.StoreCallWalletBalance); n == 0 {
+		t.Fatalf("expected a timing sample for %s", wallet.StoreCallWalletBalance)
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// the balance is recorded at the height of the committed update rather
+	// than the chain manager's tip
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	for i := uint64(0); i < network.MaturityDelay; i++ {
+		if block, found := coreutils.MineBlock(cm, types.VoidAddress, 5*time.Second); !found {
+			t.Fatal("failed to mine block")
+		} else if err := cm.AddBlocks([]types.Block{block}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tip, err := ws.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, applied, err := cm.UpdatesSince(tip, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		return w.UpdateChainState(tx, reverted, applied)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bb, _ := rec.lastBalance(); bb.Immature.IsZero() {
+		t.Fatalf("expected the new payout to be immature at the store's height, got %+v", bb)
+	}
+}
+
+// callRecorder is a MetricsRecorder that does not record balances.
+type callRecorder struct{}
+
+func (callRecorder) RecordStoreCall(string, time.Duration) {}
+
+func TestMetricsRecorderWithoutBalance(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithMetricsRecorder(callRecorder{}))
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	if _, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequireSynced(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithRequireSynced(2))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 5)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// mine blocks without syncing the store
+	mine := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if block, found := coreutils.MineBlock(cm, types.VoidAddress, 5*time.Second); !found {
+				t.Fatal("failed to mine block")
+			} else if err := cm.AddBlocks([]types.Block{block}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	fund := func() error {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+		}
+		if _, err := w.FundTransaction(&txn, types.Siacoins(100), false); err != nil {
+			return err
+		}
+		w.ReleaseInputs([]types.Transaction{txn}, nil)
+		return nil
+	}
+
+	// a lag within the limit is allowed
+	mine(2)
+	if err := fund(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a lag beyond the limit is refused
+	mine(1)
+	if err := fund(); !errors.Is(err, wallet.ErrNotSynced) {
+		t.Fatalf("expected ErrNotSynced, got %v", err)
+	} else if _, err := w.BuildTransaction([]types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}}, nil, types.NewCurrency64(1)); !errors.Is(err, wallet.ErrNotSynced) {
+		t.Fatalf("expected ErrNotSynced, got %v", err)
+	} else if _, _, err := w.Redistribute(2, types.Siacoins(100), types.NewCurrency64(1)); !errors.Is(err, wallet.ErrNotSynced) {
+		t.Fatalf("expected ErrNotSynced, got %v", err)
+	} else if _, _, err := w.FundV2Transaction(&types.V2Transaction{}, types.Siacoins(100), false); !errors.Is(err, wallet.ErrNotSynced) {
+		t.Fatalf("expected ErrNotSynced, got %v", err)
+	}
+
+	// syncing the store allows funding again
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	} else if err := fund(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeeHistory(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// miner payouts are not transactions funded by the wallet
+	if points, err := w.FeeHistory(0, 100); err != nil {
+		t.Fatal(err)
+	} else if len(points) != 0 {
+		t.Fatalf("expected no fees, got %v", len(points))
+	}
+
+	// send siacoins to a second wallet
+	pk2 := types.GeneratePrivateKey()
+	ws2 := testutil.NewEphemeralWalletStore()
+	w2, err := wallet.NewSingleAddressWallet(pk2, cm, ws2, wallet.WithLogger(l.Named("wallet2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	feePerByte := types.NewCurrency64(10)
+	var sent []types.Transaction
+	for i := 0; i < 2; i++ {
+		txn, err := w.BuildTransaction([]types.SiacoinOutput{{Address: w2.Address(), Value: types.Siacoins(100)}}, nil, feePerByte)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+			t.Fatal(err)
+		}
+		mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+		sent = append(sent, txn)
+	}
+	if err := syncDB(cm, ws2, w2); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := w.FeeHistory(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(points) != len(sent) {
+		t.Fatalf("expected %v fees, got %v", len(sent), len(points))
+	}
+	// most recent first
+	for i, fp := range points {
+		txn := sent[len(sent)-1-i]
+		if fp.ID != txn.ID() {
+			t.Fatalf("expected transaction %v, got %v", txn.ID(), fp.ID)
+		} else if !fp.Fee.Equals(txn.MinerFees[0]) {
+			t.Fatalf("expected fee %v, got %v", txn.MinerFees[0], fp.Fee)
+		} else if fp.FeePerByte.Cmp(feePerByte) < 0 {
+			t.Fatalf("expected fee per byte of at least %v, got %v", feePerByte, fp.FeePerByte)
+		} else if rate := fp.Fee.Div64(cm.TipState().TransactionWeight(txn)); !fp.FeePerByte.Equals(rate) {
+			t.Fatalf("expected fee per byte %v, got %v", rate, fp.FeePerByte)
+		} else if fp.Height == 0 {
+			t.Fatal("expected a confirmation height")
+		}
+	}
+
+	if page, err := w.FeeHistory(1, 100); err != nil {
+		t.Fatal(err)
+	} else if len(page) != 1 || page[0] != points[1] {
+		t.Fatalf("expected the second fee, got %v", page)
+	}
+
+	// the receiving wallet did not pay any fees
+	if points, err := w2.FeeHistory(0, 100); err != nil {
+		t.Fatal(err)
+	} else if len(points) != 0 {
+		t.Fatalf("expected no fees for received payments, got %v", len(points))
+	}
+}
+
+func TestFundTransactionWithUnconfirmedCap(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	ws2 := testutil.NewEphemeralWalletStore()
+	w2, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws2, wallet.WithLogger(l.Named("sender")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	// fund the wallet with one output and the sender with two
+	mineAndSync(t, cm, ws2, w2, w2.Address(), 2)
+	mineAndSync(t, cm, ws2, w2, w.Address(), 1)
+	mineAndSync(t, cm, ws2, w2, types.VoidAddress, network.MaturityDelay)
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	}
+
+	sces, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sces) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(sces))
+	}
+	confirmedValue := sces[0].SiacoinOutput.Value
+
+	// the sender pays the wallet in an unconfirmed transaction
+	unconfirmedValue := types.Siacoins(1000)
+	parent := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: unconfirmedValue}},
+	}
+	toSign, err := w2.FundTransaction(&parent, unconfirmedValue, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w2.SignTransaction(&parent, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{parent}); err != nil {
+		t.Fatal(err)
+	}
+	unconfirmedID := parent.SiacoinOutputID(0)
+
+	// the shortfall is small, but covering it requires the whole
+	// unconfirmed output
+	amount := confirmedValue.Add(types.Siacoins(1))
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+	}
+	if _, err := w.FundTransactionWithUnconfirmedCap(&txn, amount, unconfirmedValue.Sub(types.Siacoins(1))); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	} else if len(txn.SiacoinInputs) != 0 {
+		t.Fatal("expected no inputs to be added")
+	}
+
+	// a sufficient cap allows the unconfirmed output to be used
+	if _, err := w.FundTransactionWithUnconfirmedCap(&txn, amount, unconfirmedValue); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(txn.SiacoinInputs))
+	} else if txn.SiacoinInputs[0].ParentID != sces[0].ID || txn.SiacoinInputs[1].ParentID != unconfirmedID {
+		t.Fatal("expected the confirmed output to be spent before the unconfirmed output")
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// no unconfirmed outputs are needed when confirmed outputs suffice
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	if _, err := w.FundTransactionWithUnconfirmedCap(&txn, types.Siacoins(100), types.ZeroCurrency); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 1 || txn.SiacoinInputs[0].ParentID != sces[0].ID {
+		t.Fatal("expected only the confirmed output to be spent")
+	}
+}
+
+func TestAddressFromPublicKey(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(zaptest.NewLogger(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if addr := wallet.AddressFromPublicKey(pk.PublicKey()); addr != w.Address() {
+		t.Fatalf("expected address %v, got %v", w.Address(), addr)
+	} else if uc := wallet.UnlockConditionsFromPublicKey(pk.PublicKey()); uc.UnlockHash() != w.UnlockConditions().UnlockHash() {
+		t.Fatalf("expected unlock conditions %v, got %v", w.UnlockConditions(), uc)
+	}
+}
+
+func TestRedistributeDustThreshold(t *testing.T) {
+	threshold := types.Siacoins(100)
+	cm, ws, w := newTestWallet(t, wallet.WithDustThreshold(threshold))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// outputs below the threshold are rejected
+	below := threshold.Sub(types.NewCurrency64(1))
+	if _, _, err := w.Redistribute(5, below, types.NewCurrency64(1)); !errors.Is(err, wallet.ErrBelowDustThreshold) {
+		t.Fatalf("expected ErrBelowDustThreshold, got %v", err)
+	} else if _, _, err := w.RedistributeV2(5, below, types.NewCurrency64(1)); !errors.Is(err, wallet.ErrBelowDustThreshold) {
+		t.Fatalf("expected ErrBelowDustThreshold, got %v", err)
+	} else if _, _, err := w.RedistributeTiered(map[types.Currency]int{threshold: 1, below: 1}, types.NewCurrency64(1)); !errors.Is(err, wallet.ErrBelowDustThreshold) {
+		t.Fatalf("expected ErrBelowDustThreshold, got %v", err)
+	}
+
+	// outputs just above the threshold are created
+	above := threshold.Add(types.NewCurrency64(1))
+	txns, _, err := w.Redistribute(5, above, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 1 {
+		t.Fatalf("expected 1 transaction, got %v", len(txns))
+	}
+	var created int
+	for _, sco := range txns[0].SiacoinOutputs {
+		if sco.Value.Equals(above) {
+			created++
+		}
+	}
+	if created != 5 {
+		t.Fatalf("expected 5 outputs, got %v", created)
+	}
+
+	// without a configured threshold, no amount is considered dust
+	cm, ws, w = newTestWallet(t)
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	if _, _, err := w.Redistribute(5, types.NewCurrency64(1), types.NewCurrency64(1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutputSource(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	ws2 := testutil.NewEphemeralWalletStore()
+	w2, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws2, wallet.WithLogger(l.Named("sender")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	// the wallet receives a miner payout and the sender funds itself
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, w2.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	if err := syncDB(cm, ws2, w2); err != nil {
+		t.Fatal(err)
+	}
+
+	payouts, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(payouts) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(payouts))
+	}
+
+	// the sender pays the wallet
+	txn, err := w2.BuildTransaction([]types.SiacoinOutput{{Address: w.Address(), Value: types.Siacoins(100)}}, nil, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	if source, err := w.OutputSource(payouts[0].ID); err != nil {
+		t.Fatal(err)
+	} else if source != wallet.EventTypeMinerPayout {
+		t.Fatalf("expected source %q, got %q", wallet.EventTypeMinerPayout, source)
+	}
+
+	if source, err := w.OutputSource(txn.SiacoinOutputID(0)); err != nil {
+		t.Fatal(err)
+	} else if source != wallet.EventTypeV1Transaction {
+		t.Fatalf("expected source %q, got %q", wallet.EventTypeV1Transaction, source)
+	}
+
+	if _, err := w.OutputSource(types.SiacoinOutputID{1}); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// controlledPoolChainManager is a chain manager whose pool is set by the
+// test. Pool change subscribers are notified when the pool is set.
+type controlledPoolChainManager struct {
+	*chain.Manager
+
+	mu   sync.Mutex
+	txns []types.Transaction
+	fns  []func()
+}
+
+func (cm *controlledPoolChainManager) PoolTransactions() []types.Transaction {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return append([]types.Transaction(nil), cm.txns...)
+}
+
+func (cm *controlledPoolChainManager) V2PoolTransactions() []types.V2Transaction {
+	return nil
+}
+
+func (cm *controlledPoolChainManager) OnPoolChange(fn func()) func() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.fns = append(cm.fns, fn)
+	return func() {}
+}
+
+func (cm *controlledPoolChainManager) setPool(txns ...types.Transaction) {
+	cm.mu.Lock()
+	cm.txns = txns
+	fns := append([]func(){}, cm.fns...)
+	cm.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+func TestPoolReservations(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, genesisState)
+	pool := &controlledPoolChainManager{Manager: cm}
+	// create wallet
+	l := zaptest.NewLogger(t)
+	w, err := wallet.NewSingleAddressWallet(pk, pool, ws, wallet.WithLogger(l.Named("wallet")), wallet.WithPoolReservations(true), wallet.WithReservationLogSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	fund := func() types.Transaction {
+		t.Helper()
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+		}
+		toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
+		return txn
+	}
+	lastEvent := func(id types.SiacoinOutputID) string {
+		t.Helper()
+		var typ string
+		for _, ev := range w.ReservationLog() {
+			if slices.Contains(ev.IDs, id) {
+				typ = ev.Type
+			}
+		}
+		return typ
+	}
+	spendable := func(id types.SiacoinOutputID) bool {
+		t.Helper()
+		utxos, err := w.SpendableOutputs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return slices.ContainsFunc(utxos, func(sce types.SiacoinElement) bool { return sce.ID == id })
+	}
+
+	// a transaction that is evicted from the pool releases its inputs
+	evicted := fund()
+	evictedID := evicted.SiacoinInputs[0].ParentID
+	pool.setPool(evicted)
+	if typ := lastEvent(evictedID); typ != wallet.ReservationEventPending {
+		t.Fatalf("expected a %q event, got %q", wallet.ReservationEventPending, typ)
+	}
+	pool.setPool()
+	if typ := lastEvent(evictedID); typ != wallet.ReservationEventReleased {
+		t.Fatalf("expected a %q event, got %q", wallet.ReservationEventReleased, typ)
+	} else if !spendable(evictedID) {
+		t.Fatal("expected the evicted transaction's input to be spendable")
+	}
+
+	// a transaction that is confirmed does not release its inputs, even if
+	// the pool changes before the wallet applies the block
+	confirmed := fund()
+	confirmedID := confirmed.SiacoinInputs[0].ParentID
+	if _, err := cm.AddPoolTransactions([]types.Transaction{confirmed}); err != nil {
+		t.Fatal(err)
+	}
+	pool.setPool(confirmed)
+	if typ := lastEvent(confirmedID); typ != wallet.ReservationEventPending {
+		t.Fatalf("expected a %q event, got %q", wallet.ReservationEventPending, typ)
+	}
+	if block, found := coreutils.MineBlock(cm, types.VoidAddress, 5*time.Second); !found {
+		t.Fatal("failed to mine block")
+	} else if err := cm.AddBlocks([]types.Block{block}); err != nil {
+		t.Fatal(err)
+	}
+	pool.setPool()
+	if typ := lastEvent(confirmedID); typ != wallet.ReservationEventPending {
+		t.Fatalf("expected the reservation to remain pending, got %q", typ)
+	} else if spendable(confirmedID) {
+		t.Fatal("expected the confirmed transaction's input to be unavailable")
+	}
+
+	// an update that fails to commit does not release the reservation
+	tip, err := ws.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, applied, err := cm.UpdatesSince(tip, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCommit := errors.New("commit failed")
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		if err := w.UpdateChainState(tx, reverted, applied); err != nil {
+			return err
+		}
+		return errCommit
+	})
+	if !errors.Is(err, errCommit) {
+		t.Fatalf("expected the commit to fail, got %v", err)
+	} else if typ := lastEvent(confirmedID); typ != wallet.ReservationEventPending {
+		t.Fatalf("expected the reservation to remain pending after a failed commit, got %q", typ)
+	} else if spendable(confirmedID) {
+		t.Fatal("expected the confirmed transaction's input to be unavailable")
+	}
+
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	}
+	pool.setPool()
+	if typ := lastEvent(confirmedID); typ != wallet.ReservationEventPending {
+		t.Fatalf("expected no release of a confirmed input, got %q", typ)
+	} else if spendable(confirmedID) {
+		t.Fatal("expected the confirmed transaction's input to be spent")
+	}
+}
+
+func TestPoolReservationsRequireNotifier(t *testing.T) {
+	_, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), zeroChainManager{}, testutil.NewEphemeralWalletStore(), wallet.WithPoolReservations(true))
+	if err == nil {
+		t.Fatal("expected an error for a chain manager that does not implement PoolNotifier")
+	}
+}
+
+func TestSpendableExcludingTag(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := balance.Spendable
+
+	reserve := func(tag string) types.Currency {
+		t.Helper()
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+		}
+		if _, err := w.FundTransactionWithTag(&txn, types.Siacoins(100), false, tag); err != nil {
+			t.Fatal(err)
+		} else if len(txn.SiacoinInputs) != 1 {
+			t.Fatalf("expected 1 input, got %v", len(txn.SiacoinInputs))
+		}
+		utxos, err := ws.UnspentSiacoinElements()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sce := range utxos {
+			if sce.ID == txn.SiacoinInputs[0].ParentID {
+				return sce.SiacoinOutput.Value
+			}
+		}
+		t.Fatal("input not found")
+		return types.ZeroCurrency
+	}
+	reservedA := reserve("a")
+	reservedB := reserve("b")
+
+	// each flow sees its own reservations as available
+	tests := []struct {
+		tag  string
+		want types.Currency
+	}{
+		{"a", total.Sub(reservedB)},
+		{"b", total.Sub(reservedA)},
+		{"c", total.Sub(reservedA).Sub(reservedB)},
+	}
+	for _, tt := range tests {
+		if spendable, err := w.SpendableExcludingTag(tt.tag); err != nil {
+			t.Fatal(err)
+		} else if !spendable.Equals(tt.want) {
+			t.Fatalf("tag %q: expected %v spendable, got %v", tt.tag, tt.want, spendable)
+		}
+	}
+
+	// the wallet's balance excludes all reservations
+	if balance, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	} else if !balance.Spendable.Equals(total.Sub(reservedA).Sub(reservedB)) {
+		t.Fatalf("expected %v spendable, got %v", total.Sub(reservedA).Sub(reservedB), balance.Spendable)
+	}
+}
+
+func TestOfflineWallet(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	elements, state, err := w.ExportUTXOs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the funding wallet only knows the public key
+	if _, err := wallet.NewOfflineWallet(types.GeneratePrivateKey().PublicKey(), elements, state); err == nil {
+		t.Fatal("expected error importing outputs with a different key")
+	}
+	funder, err := wallet.NewOfflineWallet(pk.PublicKey(), elements, state, wallet.WithLogger(l.Named("funder")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer funder.Close()
+
+	if funder.Address() != w.Address() {
+		t.Fatalf("expected address %v, got %v", w.Address(), funder.Address())
+	} else if balance, err := funder.Balance(); err != nil {
+		t.Fatal(err)
+	} else if expected, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	} else if !balance.Spendable.Equals(expected.Spendable) {
+		t.Fatalf("expected spendable balance %v, got %v", expected.Spendable, balance.Spendable)
+	}
+
+	amount := elements[0].SiacoinOutput.Value.Add(types.Siacoins(1))
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+		MinerFees:      []types.Currency{types.Siacoins(1)},
+	}
+	toSign, err := funder.FundTransaction(&txn, amount)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(toSign) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(toSign))
+	} else if len(txn.Signatures) != 0 {
+		t.Fatal("expected an unsigned transaction")
+	}
+
+	// the transaction is signed by a separate instance holding the key
+	signer, err := wallet.NewOfflineWallet(pk.PublicKey(), elements, state, wallet.WithLogger(l.Named("signer")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+
+	if err := signer.SignExported(&txn, toSign, types.GeneratePrivateKey()); err == nil {
+		t.Fatal("expected error signing with a different key")
+	} else if err := signer.SignExported(&txn, toSign, pk); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction should be valid on the live chain
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	if _, _, _, err := w.TransactionEffect(txn.ID()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelfTransferEvents(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// consolidate the wallet's outputs into a single output
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := wallet.SumOutputs(utxos)
+	fee := types.Siacoins(1)
+	consolidation := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: total.Sub(fee)}},
+		MinerFees:      []types.Currency{fee},
+	}
+	toSign, err := w.FundTransaction(&consolidation, total.Sub(fee), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&consolidation, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{consolidation}); err != nil {
+		t.Fatal(err)
+	}
+
+	findEvent := func(events []wallet.Event, id types.TransactionID) wallet.Event {
+		t.Helper()
+		for _, ev := range events {
+			if ev.ID == types.Hash256(id) {
+				return ev
+			}
+		}
+		t.Fatalf("event %v not found", id)
+		return wallet.Event{}
+	}
+
+	// the unconfirmed event is flagged
+	unconfirmed, err := w.UnconfirmedEvents()
+	if err != nil {
+		t.Fatal(err)
+	} else if ev := findEvent(unconfirmed, consolidation.ID()); !ev.SelfTransfer {
+		t.Fatal("expected the unconfirmed consolidation to be a self-transfer")
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	// a payment to another address is not a self-transfer
+	payment, err := w.BuildTransaction([]types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}}, nil, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{payment}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	events, err := w.Events(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := findEvent(events, consolidation.ID())
+	if !ev.SelfTransfer {
+		t.Fatal("expected the consolidation to be a self-transfer")
+	} else if ev.Type != wallet.EventTypeV1Transaction {
+		t.Fatalf("expected type %q, got %q", wallet.EventTypeV1Transaction, ev.Type)
+	} else if findEvent(events, payment.ID()).SelfTransfer {
+		t.Fatal("expected the payment not to be a self-transfer")
+	}
+	for _, ev := range events {
+		if ev.Type == wallet.EventTypeMinerPayout && ev.SelfTransfer {
+			t.Fatal("expected miner payouts not to be self-transfers")
+		}
+	}
+
+	// a zero-fee consolidation is still recorded as a self-transfer
+	utxos, err = w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	total = wallet.SumOutputs(utxos)
+	free := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: total}},
+	}
+	toSign, err = w.FundTransaction(&free, total, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&free, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{free}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	// a payment to a watched address is not a self-transfer
+	watched := types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey())
+	w.AddWatchAddress(watched)
+	watchPayment, err := w.BuildTransaction([]types.SiacoinOutput{{Address: watched, Value: types.Siacoins(100)}}, nil, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{watchPayment}); err != nil {
+		t.Fatal(err)
+	}
+	unconfirmed, err = w.UnconfirmedEvents()
+	if err != nil {
+		t.Fatal(err)
+	} else if findEvent(unconfirmed, watchPayment.ID()).SelfTransfer {
+		t.Fatal("expected the unconfirmed watched payment not to be a self-transfer")
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	events, err = w.Events(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if !findEvent(events, free.ID()).SelfTransfer {
+		t.Fatal("expected the zero-fee consolidation to be a self-transfer")
+	} else if findEvent(events, watchPayment.ID()).SelfTransfer {
+		t.Fatal("expected the watched payment not to be a self-transfer")
+	}
+
+	// the flag is not part of the binary encoding; the wallet derives it for
+	// events read from the store
+	w2, err := wallet.NewSingleAddressWallet(pk, cm, &encodingStore{SingleAddressStore: ws})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	events, err = w2.Events(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if !findEvent(events, consolidation.ID()).SelfTransfer {
+		t.Fatal("expected the decoded consolidation to be a self-transfer")
+	} else if findEvent(events, payment.ID()).SelfTransfer {
+		t.Fatal("expected the decoded payment not to be a self-transfer")
+	}
+}
+
+// encodingStore returns the events of the underlying store after a round
+// trip through their binary encoding.
+type encodingStore struct {
+	wallet.SingleAddressStore
+}
+
+func (s *encodingStore) WalletEvents(offset, limit int) ([]wallet.Event, error) {
+	events, err := s.SingleAddressStore.WalletEvents(offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		var buf bytes.Buffer
+		e := types.NewEncoder(&buf)
+		events[i].EncodeTo(e)
+		e.Flush()
+		events[i] = wallet.Event{}
+		events[i].DecodeFrom(types.NewBufDecoder(buf.Bytes()))
+	}
+	return events, nil
+}
+
+func TestMaxReservations(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithMaxReservations(3))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 5)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// each payout exceeds the funded amount, so each call reserves one output
+	fund := func() (types.Transaction, error) {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1)}},
+		}
+		_, err := w.FundTransaction(&txn, types.Siacoins(1), false)
+		return txn, err
+	}
+
+	var funded []types.Transaction
+	for i := 0; i < 3; i++ {
+		txn, err := fund()
+		if err != nil {
+			t.Fatal(err)
+		}
+		funded = append(funded, txn)
+	}
+
+	if _, err := fund(); !errors.Is(err, wallet.ErrTooManyReservations) {
+		t.Fatalf("expected ErrTooManyReservations, got %v", err)
+	} else if _, _, err := w.Redistribute(2, types.Siacoins(1), types.NewCurrency64(1)); !errors.Is(err, wallet.ErrTooManyReservations) {
+		t.Fatalf("expected ErrTooManyReservations, got %v", err)
+	} else if _, _, err := w.FundV2Transaction(&types.V2Transaction{}, types.Siacoins(1), false); !errors.Is(err, wallet.ErrTooManyReservations) {
+		t.Fatalf("expected ErrTooManyReservations, got %v", err)
+	}
+
+	// releasing a reservation makes room for another
+	w.ReleaseInputs(funded[:1], nil)
+	if _, err := fund(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreviewID(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preview := wallet.PreviewID(txn)
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if len(txn.Signatures) == 0 {
+		t.Fatal("expected signatures")
+	} else if txn.ID() != preview {
+		t.Fatalf("expected ID %v, got %v", preview, txn.ID())
+	}
+
+	// the ID of the confirmed transaction matches the preview
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	events, err := w.Events(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(events) != 1 || events[0].ID != types.Hash256(preview) {
+		t.Fatalf("expected event %v, got %v", preview, events)
+	}
+}
+
+func TestFundAndFee(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	for _, sce := range utxos {
+		values[sce.ID] = sce.SiacoinOutput.Value
+	}
+
+	// the caller adds the recipients and an existing miner fee
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.Address{1}, Value: types.Siacoins(100)},
+			{Address: types.Address{2}, Value: types.Siacoins(200)},
+		},
+		MinerFees: []types.Currency{types.Siacoins(1)},
+	}
+	feePerByte := types.Siacoins(1).Div64(1000)
+	toSign, fee, err := w.FundAndFee(&txn, feePerByte, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.MinerFees) != 2 || !txn.MinerFees[1].Equals(fee) {
+		t.Fatalf("expected the fee %v to be appended, got %v", fee, txn.MinerFees)
+	} else if len(txn.SiacoinOutputs) != 3 || txn.SiacoinOutputs[2].Address != w.Address() {
+		t.Fatalf("expected a change output, got %v", txn.SiacoinOutputs)
+	}
+
+	// the inputs cover the recipients, the existing fee and the computed fee,
+	// with the rest returned as change
+	var inputSum types.Currency
+	for _, sci := range txn.SiacoinInputs {
+		inputSum = inputSum.Add(values[sci.ParentID])
+	}
+	change := txn.SiacoinOutputs[2].Value
+	if want := types.Siacoins(301).Add(fee); !inputSum.Sub(change).Equals(want) {
+		t.Fatalf("expected inputs less change to be %v, got %v", want, inputSum.Sub(change))
+	}
+
+	// the fee covers the weight of the signed transaction
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if required := feePerByte.Mul64(cm.TipState().TransactionWeight(txn)); fee.Cmp(required) < 0 {
+		t.Fatalf("expected fee of at least %v, got %v", required, fee)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReleaseOutputs(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// reserve all three outputs
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 3 {
+		t.Fatalf("expected 3 outputs, got %v", len(utxos))
+	}
+	total := wallet.SumOutputs(utxos)
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: total}},
+	}
+	toSign, err := w.FundTransaction(&txn, total, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(toSign) != 3 {
+		t.Fatalf("expected 3 inputs, got %v", len(toSign))
+	}
+
+	// release only the first input
+	w.ReleaseOutputs(toSign[0])
+
+	isLocked := func(id types.Hash256) bool {
+		t.Helper()
+		var txn types.Transaction
+		_, err := w.FundTransactionInclude(&txn, types.Siacoins(1), false, []types.SiacoinOutputID{types.SiacoinOutputID(id)})
+		if errors.Is(err, wallet.ErrOutputLocked) {
+			return true
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		w.ReleaseInputs([]types.Transaction{txn}, nil)
+		return false
+	}
+	if isLocked(toSign[0]) {
+		t.Fatal("expected the released output to be unlocked")
+	}
+	for _, id := range toSign[1:] {
+		if !isLocked(id) {
+			t.Fatalf("expected output %v to remain locked", id)
+		}
+	}
+}
+
+func TestSpendableAfterFeeBudget(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	} else if balance.Spendable.IsZero() {
+		t.Fatal("expected a spendable balance")
+	}
+
+	budget := types.Siacoins(10)
+	if spendable, err := w.SpendableAfterFeeBudget(budget); err != nil {
+		t.Fatal(err)
+	} else if want := balance.Spendable.Sub(budget); !spendable.Equals(want) {
+		t.Fatalf("expected %v, got %v", want, spendable)
+	}
+
+	// a budget exceeding the spendable balance leaves nothing to spend
+	if spendable, err := w.SpendableAfterFeeBudget(balance.Spendable.Add(types.Siacoins(1))); err != nil {
+		t.Fatal(err)
+	} else if !spendable.IsZero() {
+		t.Fatalf("expected zero, got %v", spendable)
+	}
+}
+
+func TestFoundationSubsidy(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store with the wallet as the foundation address
+	network, genesis := testutil.Network()
+	network.HardforkFoundation.PrimaryAddress = types.StandardUnlockHash(pk.PublicKey())
+	network.HardforkFoundation.FailsafeAddress = types.VoidAddress
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, genesisState)
+	// create wallet
+	l := zaptest.NewLogger(t)
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// the block at the hardfork height pays the initial subsidy
+	subsidy, ok := cm.TipState().FoundationSubsidy()
+	if !ok {
+		t.Fatal("expected a foundation subsidy")
+	} else if subsidy.Address != w.Address() {
+		t.Fatalf("expected subsidy address %v, got %v", w.Address(), subsidy.Address)
+	} else if height := cm.Tip().Height + 1; height != network.HardforkFoundation.Height {
+		t.Fatalf("expected next height %v, got %v", network.HardforkFoundation.Height, height)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	events, err := w.Events(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %v", len(events))
+	} else if events[0].Type != wallet.EventTypeFoundationSubsidy {
+		t.Fatalf("expected type %q, got %q", wallet.EventTypeFoundationSubsidy, events[0].Type)
+	} else if !events[0].SiacoinInflow().Equals(subsidy.Value) {
+		t.Fatalf("expected inflow %v, got %v", subsidy.Value, events[0].SiacoinInflow())
+	}
+
+	// the subsidy is immature until the maturity height
+	assertBalance(t, w, types.ZeroCurrency, types.ZeroCurrency, subsidy.Value, types.ZeroCurrency)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	assertBalance(t, w, subsidy.Value, subsidy.Value, types.ZeroCurrency, types.ZeroCurrency)
+}
+
+func TestReserveLargeOutputs(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithReserveLargeOutputs(3, types.Siacoins(1000)))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// split the payout into two large outputs, three mid-sized outputs and
+	// the change, which is also large
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: w.Address(), Value: types.Siacoins(10000)},
+			{Address: w.Address(), Value: types.Siacoins(10000)},
+			{Address: w.Address(), Value: types.Siacoins(100)},
+			{Address: w.Address(), Value: types.Siacoins(100)},
+			{Address: w.Address(), Value: types.Siacoins(100)},
+		},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(20300), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 6 {
+		t.Fatalf("expected 6 outputs, got %v", len(utxos))
+	}
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	for _, sce := range utxos {
+		values[sce.ID] = sce.SiacoinOutput.Value
+	}
+
+	// a small payment is funded from the mid-sized outputs
+	small := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(150)}},
+	}
+	if _, err := w.FundTransaction(&small, types.Siacoins(150), false); err != nil {
+		t.Fatal(err)
+	} else if len(small.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(small.SiacoinInputs))
+	}
+	for _, sci := range small.SiacoinInputs {
+		if !values[sci.ParentID].Equals(types.Siacoins(100)) {
+			t.Fatalf("expected a mid-sized input, got %v", values[sci.ParentID])
+		}
+	}
+	w.ReleaseInputs([]types.Transaction{small}, nil)
+
+	// a large payment must dip into the reserve
+	large := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(5000)}},
+	}
+	if _, err := w.FundTransaction(&large, types.Siacoins(5000), false); err != nil {
+		t.Fatal(err)
+	}
+	var reserveUsed bool
+	for _, sci := range large.SiacoinInputs {
+		if values[sci.ParentID].Cmp(types.Siacoins(1000)) >= 0 {
+			reserveUsed = true
+		}
+	}
+	if !reserveUsed {
+		t.Fatal("expected the large payment to use a reserved output")
+	}
+}
+
+func TestFundWarnings(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithDustThreshold(types.Siacoins(10)))
+	network := cm.TipState().Network
+	var err error
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	assertWarnings := func(res wallet.FundResult, expected ...wallet.FundWarning) {
+		t.Helper()
+		if len(res.Warnings) != len(expected) {
+			t.Fatalf("expected warnings %v, got %v", expected, res.Warnings)
+		}
+		for i := range expected {
+			if res.Warnings[i] != expected[i] {
+				t.Fatalf("expected warnings %v, got %v", expected, res.Warnings)
+			}
+		}
+	}
+
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// funding nearly the entire output produces no warnings
+	var txn types.Transaction
+	res, err := w.FundTransactionDetailed(&txn, balance.Spendable.Sub(types.Siacoins(1)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWarnings(res)
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// split off dust outputs, which leaves a large change output
+	split := types.Transaction{
+		MinerFees: []types.Currency{types.Siacoins(1)},
+	}
+	for i := 0; i < 35; i++ {
+		split.SiacoinOutputs = append(split.SiacoinOutputs, types.SiacoinOutput{Address: w.Address(), Value: types.Siacoins(1)})
+	}
+	res, err = w.FundTransactionDetailed(&split, types.Siacoins(35), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWarnings(res, wallet.FundWarningLargeChange)
+	if err := w.SignTransaction(&split, res.ToSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{split}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the only spendable outputs are unconfirmed
+	txn = types.Transaction{}
+	res, err = w.FundTransactionDetailed(&txn, types.Siacoins(1000), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWarnings(res, wallet.FundWarningLargeChange, wallet.FundWarningUsedUnconfirmed)
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// once confirmed, the dust outputs exceed the defrag threshold and are
+	// pulled into the next transaction
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	txn = types.Transaction{}
+	res, err = w.FundTransactionDetailed(&txn, types.Siacoins(1000), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertWarnings(res, wallet.FundWarningLargeChange, wallet.FundWarningUsedDust, wallet.FundWarningDefragged)
+}
+
+func TestOverview(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// leave a transaction in the pool so the balance has an unconfirmed part
+	txn, err := w.BuildTransaction([]types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}}, nil, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	overview, err := w.Overview(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := w.Events(0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case overview.Address != w.Address():
+		t.Fatalf("expected address %v, got %v", w.Address(), overview.Address)
+	case overview.Tip != w.Tip():
+		t.Fatalf("expected tip %v, got %v", w.Tip(), overview.Tip)
+	case overview.Balance != balance:
+		t.Fatalf("expected balance %v, got %v", balance, overview.Balance)
+	case overview.UTXOs != len(utxos)+1: // one output is spent in the pool
+		t.Fatalf("expected %v utxos, got %v", len(utxos)+1, overview.UTXOs)
+	case len(overview.Events) != len(events):
+		t.Fatalf("expected %v events, got %v", len(events), len(overview.Events))
+	}
+	for i := range events {
+		if overview.Events[i].ID != events[i].ID {
+			t.Fatalf("expected event %v, got %v", events[i].ID, overview.Events[i].ID)
+		}
+	}
+
+	// the JSON field names are stable
+	buf, err := json.Marshal(overview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"address", "tip", "balance", "utxos", "events"} {
+		if _, ok := fields[name]; !ok {
+			t.Fatalf("expected field %q in %s", name, buf)
+		}
+	}
+	if len(fields) != 5 {
+		t.Fatalf("expected 5 fields, got %s", buf)
+	}
+}
+
+// A syncingStore calls onScan each time the wallet loads its unspent
+// outputs, simulating a sync that races with the caller.
+type syncingStore struct {
+	*testutil.EphemeralWalletStore
+	onScan func()
+}
+
+func (s *syncingStore) UnspentSiacoinElements() ([]types.SiacoinElement, error) {
+	if s.onScan != nil {
+		s.onScan()
+	}
+	return s.EphemeralWalletStore.UnspentSiacoinElements()
+}
+
+func TestOverviewTipChange(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	ss := &syncingStore{EphemeralWalletStore: ws}
+	w2, err := wallet.NewSingleAddressWallet(pk, cm, ss)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	// a sync during the first attempt causes the overview to be assembled
+	// again at the new tip
+	var scans int
+	ss.onScan = func() {
+		scans++
+		if scans == 1 {
+			mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+		}
+	}
+	overview, err := w2.Overview(2)
+	if err != nil {
+		t.Fatal(err)
+	} else if scans != 2 {
+		t.Fatalf("expected 2 scans, got %v", scans)
+	} else if overview.Tip != cm.Tip() {
+		t.Fatalf("expected tip %v, got %v", cm.Tip(), overview.Tip)
+	}
+
+	// a tip that keeps changing is an error
+	ss.onScan = func() { mineAndSync(t, cm, ws, w, types.VoidAddress, 1) }
+	if _, err := w2.Overview(2); err == nil {
+		t.Fatal("expected an error when the tip keeps changing")
+	}
+}
+
+func TestUnconfirmedDependentBalance(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	if balance, err := w.UnconfirmedDependentBalance(); err != nil {
+		t.Fatal(err)
+	} else if !balance.IsZero() {
+		t.Fatalf("expected zero, got %v", balance)
+	}
+
+	// build a chain of unconfirmed transactions, each spending the change of
+	// its parent
+	var pool []types.Transaction
+	for i := 0; i < 3; i++ {
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{
+				{Address: w.Address(), Value: types.Siacoins(100)},
+				{Address: types.VoidAddress, Value: types.Siacoins(10)},
+			},
+		}
+		toSign, err := w.FundTransaction(&txn, types.Siacoins(110), i > 0)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
+		pool = append(pool, txn)
+		// the parents are passed along with the transaction
+		if _, err := cm.AddPoolTransactions(pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// sum the outputs to the wallet that are not spent by another pool
+	// transaction
+	spent := make(map[types.SiacoinOutputID]bool)
+	for _, txn := range pool {
+		for _, sci := range txn.SiacoinInputs {
+			spent[sci.ParentID] = true
+		}
+	}
+	var expected types.Currency
+	for _, txn := range pool {
+		for i, sco := range txn.SiacoinOutputs {
+			if sco.Address == w.Address() && !spent[txn.SiacoinOutputID(i)] {
+				expected = expected.Add(sco.Value)
+			}
+		}
+	}
+
+	if balance, err := w.UnconfirmedDependentBalance(); err != nil {
+		t.Fatal(err)
+	} else if !balance.Equals(expected) {
+		t.Fatalf("expected %v, got %v", expected, balance)
+	}
+
+	// once confirmed, the outputs no longer depend on the pool
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	if balance, err := w.UnconfirmedDependentBalance(); err != nil {
+		t.Fatal(err)
+	} else if !balance.IsZero() {
+		t.Fatalf("expected zero, got %v", balance)
+	}
+}
+
+func TestFundTransactionExact(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 3 {
+		t.Fatalf("expected 3 outputs, got %v", len(utxos))
+	}
+	// use the outputs in the reverse of the order they were returned
+	inputs := []types.SiacoinOutputID{utxos[2].ID, utxos[0].ID}
+	inputSum := utxos[2].SiacoinOutput.Value.Add(utxos[0].SiacoinOutput.Value)
+
+	changeAddr := types.Address{1}
+	build := func() (types.Transaction, []types.Hash256) {
+		t.Helper()
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+			MinerFees:      []types.Currency{types.Siacoins(1)},
+		}
+		toSign, err := w.FundTransactionExact(&txn, inputs, changeAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return txn, toSign
+	}
+
+	txn, toSign := build()
+	if len(txn.SiacoinInputs) != len(inputs) {
+		t.Fatalf("expected %v inputs, got %v", len(inputs), len(txn.SiacoinInputs))
+	}
+	for i, id := range inputs {
+		if txn.SiacoinInputs[i].ParentID != id || toSign[i] != types.Hash256(id) {
+			t.Fatalf("expected input %v to be %v, got %v", i, id, txn.SiacoinInputs[i].ParentID)
+		}
+	}
+	if len(txn.SiacoinOutputs) != 2 {
+		t.Fatalf("expected a change output, got %v", txn.SiacoinOutputs)
+	} else if change := txn.SiacoinOutputs[1]; change.Address != changeAddr || !change.Value.Equals(inputSum.Sub(types.Siacoins(101))) {
+		t.Fatalf("unexpected change output %v", change)
+	}
+
+	// the reserved inputs cannot be used again
+	var locked types.Transaction
+	if _, err := w.FundTransactionExact(&locked, inputs[:1], types.VoidAddress); !errors.Is(err, wallet.ErrOutputLocked) {
+		t.Fatalf("expected ErrOutputLocked, got %v", err)
+	}
+
+	// funding the same transaction again with the same inputs produces the
+	// same ID
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+	txn2, toSign2 := build()
+	if txn.ID() != txn2.ID() {
+		t.Fatalf("expected ID %v, got %v", txn.ID(), txn2.ID())
+	}
+	w.ReleaseInputs([]types.Transaction{txn2}, nil)
+
+	// unknown and insufficient inputs are rejected
+	if _, err := w.FundTransactionExact(&types.Transaction{}, []types.SiacoinOutputID{{1}}, types.VoidAddress); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	tooLarge := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: inputSum.Add(types.Siacoins(1))}},
+	}
+	if _, err := w.FundTransactionExact(&tooLarge, inputs, types.VoidAddress); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	}
+
+	// file contract payouts are funded
+	contract := types.Transaction{
+		FileContracts: []types.FileContract{{Payout: types.Siacoins(100)}},
+	}
+	if _, err := w.FundTransactionExact(&contract, inputs, changeAddr); err != nil {
+		t.Fatal(err)
+	} else if len(contract.SiacoinOutputs) != 1 || !contract.SiacoinOutputs[0].Value.Equals(inputSum.Sub(types.Siacoins(100))) {
+		t.Fatalf("expected change of %v, got %v", inputSum.Sub(types.Siacoins(100)), contract.SiacoinOutputs)
+	}
+	w.ReleaseInputs([]types.Transaction{contract}, nil)
+
+	// inputs already present are credited against the amount and cannot be
+	// listed again
+	existing := utxos[1]
+	credited := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: existing.ID, UnlockConditions: w.UnlockConditions()}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: existing.SiacoinOutput.Value.Add(types.Siacoins(100))}},
+	}
+	if _, err := w.FundTransactionExact(&credited, []types.SiacoinOutputID{existing.ID}, changeAddr); !errors.Is(err, wallet.ErrOutputLocked) {
+		t.Fatalf("expected ErrOutputLocked, got %v", err)
+	} else if _, err := w.FundTransactionExact(&credited, inputs, changeAddr); err != nil {
+		t.Fatal(err)
+	} else if change := credited.SiacoinOutputs[1]; !change.Value.Equals(inputSum.Sub(types.Siacoins(100))) {
+		t.Fatalf("expected change of %v, got %v", inputSum.Sub(types.Siacoins(100)), change.Value)
+	}
+	w.ReleaseInputs([]types.Transaction{credited}, nil)
+
+	// the transaction is valid
+	if err := w.SignTransaction(&txn2, toSign2, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the change output is placed according to the wallet's change position
+	cm, ws, w = newTestWallet(t, wallet.WithChangePosition(wallet.ChangePositionFirst))
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	utxos, err = w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	if _, err := w.FundTransactionExact(&first, []types.SiacoinOutputID{utxos[0].ID}, changeAddr); err != nil {
+		t.Fatal(err)
+	} else if len(first.SiacoinOutputs) != 2 || first.SiacoinOutputs[0].Address != changeAddr {
+		t.Fatalf("expected the change output first, got %v", first.SiacoinOutputs)
+	}
+}
+
+func TestLiquidity(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// nothing is available
+	if now, soon, at, err := w.Liquidity(); err != nil {
+		t.Fatal(err)
+	} else if !now.IsZero() || !soon.IsZero() || at != 0 {
+		t.Fatalf("expected no liquidity, got %v, %v at %v", now, soon, at)
+	}
+
+	// mine a payout that matures and two that are still maturing
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := w.Events(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the newest event is the payout that matures last, so the earlier of
+	// the two maturing payouts matures one block before it
+	next := events[0].MaturityHeight - 1
+	maturing, err := w.Events(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if maturing[0].MaturityHeight != next {
+		t.Fatalf("expected maturity height %v, got %v", next, maturing[0].MaturityHeight)
+	}
+	soonValue := maturing[0].SiacoinInflow()
+
+	now, soon, at, err := w.Liquidity()
+	if err != nil {
+		t.Fatal(err)
+	} else if !now.Equals(balance.Spendable) || !now.Equals(wallet.SumOutputs(utxos)) {
+		t.Fatalf("expected %v available now, got %v", balance.Spendable, now)
+	} else if !soon.Equals(soonValue) {
+		t.Fatalf("expected %v available soon, got %v", soonValue, soon)
+	} else if at != next {
+		t.Fatalf("expected soon height %v, got %v", next, at)
+	}
+}
+
+func TestAdditionalKeys(t *testing.T) {
+	// create wallet store
+	oldKey := types.GeneratePrivateKey()
+	newKey := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, genesisState)
+	// create a wallet for the new key that still spends from the old key
+	l := zaptest.NewLogger(t)
+	w, err := wallet.NewSingleAddressWallet(newKey, cm, ws, wallet.WithLogger(l.Named("wallet")), wallet.WithAdditionalKeys(oldKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	oldAddr := types.StandardUnlockHash(oldKey.PublicKey())
+	if addrs := w.Addresses(); len(addrs) != 2 || addrs[0] != w.Address() || addrs[1] != oldAddr {
+		t.Fatalf("expected addresses [%v %v], got %v", w.Address(), oldAddr, addrs)
+	}
+
+	// split the funds across both keys
+	mineAndSync(t, cm, ws, w, oldAddr, 1)
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 2 {
+		t.Fatalf("expected 2 outputs, got %v", len(utxos))
+	}
+	total := wallet.SumOutputs(utxos)
+
+	// a payment larger than either output must spend both
+	amount := total.Sub(types.Siacoins(1))
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+	}
+	toSign, err := w.FundTransaction(&txn, amount, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(txn.SiacoinInputs))
+	}
+	addrs := make(map[types.Address]bool)
+	for _, sci := range txn.SiacoinInputs {
+		addrs[sci.UnlockConditions.UnlockHash()] = true
+	}
+	if !addrs[oldAddr] || !addrs[w.Address()] {
+		t.Fatalf("expected inputs from both addresses, got %v", addrs)
+	} else if change := txn.SiacoinOutputs[len(txn.SiacoinOutputs)-1]; change.Address != w.Address() {
+		t.Fatalf("expected change to the primary address, got %v", change.Address)
+	}
+
+	// each input is signed with the matching key
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if signed, err := w.HasValidSignatures(txn); err != nil {
+		t.Fatal(err)
+	} else if len(signed) != 2 {
+		t.Fatalf("expected 2 signed inputs, got %v", len(signed))
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	assertBalance(t, w, types.Siacoins(1), types.Siacoins(1), types.ZeroCurrency, types.ZeroCurrency)
+}
+
+func TestAdditionalKeysSpend(t *testing.T) {
+	oldKey := types.GeneratePrivateKey()
+	cm, ws, w := newTestWallet(t, wallet.WithAdditionalKeys(oldKey), wallet.WithSpendableChange(true))
+	network := cm.TipState().Network
+
+	// fund only the additional key
+	oldAddr := types.StandardUnlockHash(oldKey.PublicKey())
+	mineAndSync(t, cm, ws, w, oldAddr, 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 2 {
+		t.Fatalf("expected 2 outputs, got %v", len(utxos))
+	}
+
+	// redistribution spends the additional key's outputs
+	feePerByte := types.NewCurrency64(1)
+	txns, toSign, err := w.Redistribute(2, types.Siacoins(1000), feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 1 {
+		t.Fatalf("expected 1 transaction, got %v", len(txns))
+	} else if err := w.SignTransaction(&txns[0], toSign[0], types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions(txns); err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining types.SiacoinElement
+	for _, sce := range utxos {
+		if sce.ID != txns[0].SiacoinInputs[0].ParentID {
+			remaining = sce
+		}
+	}
+
+	// the outputs of a transaction spending only the additional key are the
+	// wallet's own change, so they are spendable
+	var created types.Currency
+	for _, sco := range txns[0].SiacoinOutputs {
+		created = created.Add(sco.Value)
+	}
+	assertBalance(t, w, created.Add(remaining.SiacoinOutput.Value), wallet.SumOutputs(utxos), types.ZeroCurrency, types.ZeroCurrency)
+
+	// the other output can be forwarded
+	txn, toSignFwd, err := w.Forward(remaining.ID, types.VoidAddress, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if txn.SiacoinInputs[0].UnlockConditions.UnlockHash() != oldAddr {
+		t.Fatalf("expected input from %v, got %v", oldAddr, txn.SiacoinInputs[0].UnlockConditions.UnlockHash())
+	} else if err := w.SignTransaction(&txn, toSignFwd, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	fee := types.Siacoins(1)
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+		MinerFees:      []types.Currency{fee},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	events, err := w.Events(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", len(events))
+	}
+
+	var buf bytes.Buffer
+	if err := w.ExportCSV(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(records) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %v", records)
+	}
+
+	header := []string{"timestamp", "id", "type", "height", "inflow", "outflow", "fee"}
+	if !slices.Equal(records[0], header) {
+		t.Fatalf("expected header %v, got %v", header, records[0])
+	}
+
+	// the rows are in the same order as Events
+	payout, spend := events[1], events[0]
+	expected := [][]string{
+		{
+			spend.Timestamp.UTC().Format(time.RFC3339),
+			spend.ID.String(),
+			wallet.EventTypeV1Transaction,
+			fmt.Sprint(spend.Index.Height),
+			spend.SiacoinInflow().ExactString(),
+			spend.SiacoinOutflow().ExactString(),
+			fee.ExactString(),
+		},
+		{
+			payout.Timestamp.UTC().Format(time.RFC3339),
+			payout.ID.String(),
+			wallet.EventTypeMinerPayout,
+			fmt.Sprint(payout.Index.Height),
+			payout.SiacoinInflow().ExactString(),
+			"0",
+			"0",
+		},
+	}
+	for i, row := range expected {
+		if !slices.Equal(records[i+1], row) {
+			t.Fatalf("expected row %v to be %v, got %v", i+1, row, records[i+1])
+		}
+	}
+}
+
+func TestSendWithPriority(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithMaxFeeMultiplier(3))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	outputs := []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}}
+	recommended := cm.RecommendedFee()
+	if recommended.IsZero() {
+		t.Fatal("expected a non-zero recommended fee")
+	}
+
+	feeRate := func(multiplier float64) types.Currency {
+		t.Helper()
+		txn, err := w.SendWithPriority(outputs, multiplier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+		weight := cm.TipState().TransactionWeight(txn)
+		return txn.MinerFees[0].Div64(weight)
+	}
+
+	// the effective fee rate scales with the multiplier
+	normal, double := feeRate(1), feeRate(2)
+	if normal.Cmp(recommended) < 0 {
+		t.Fatalf("expected a fee rate of at least %v, got %v", recommended, normal)
+	} else if double.Cmp(recommended.Mul64(2)) < 0 {
+		t.Fatalf("expected a fee rate of at least %v, got %v", recommended.Mul64(2), double)
+	} else if double.Cmp(normal) <= 0 {
+		t.Fatalf("expected the doubled fee rate %v to exceed %v", double, normal)
+	}
+
+	// multipliers outside of [1, cap] are rejected
+	for _, m := range []float64{0.5, 3.5} {
+		if _, err := w.SendWithPriority(outputs, m); err == nil {
+			t.Fatalf("expected multiplier %v to be rejected", m)
+		}
+	}
+	if _, err := w.SendWithPriority(outputs, 3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStuckTransactions(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// mineEmpty mines blocks that do not include the pool's transactions
+	mineEmpty := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			cs := cm.TipState()
+			b := types.Block{
+				ParentID:     cs.Index.ID,
+				Timestamp:    types.CurrentTimestamp(),
+				MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+			}
+			if !coreutils.FindBlockNonce(cs, &b, 5*time.Second) {
+				t.Fatal("failed to mine block")
+			} else if err := cm.AddBlocks([]types.Block{b}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := syncDB(cm, ws, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// add an underpriced transaction to the pool
+	fee := types.NewCurrency64(1)
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+		MinerFees:      []types.Currency{fee},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction is not stuck yet
+	if stuck, err := w.StuckTransactions(3); err != nil {
+		t.Fatal(err)
+	} else if len(stuck) != 0 {
+		t.Fatalf("expected no stuck transactions, got %v", stuck)
+	}
+
+	mineEmpty(3)
+	stuck, err := w.StuckTransactions(3)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(stuck) != 1 {
+		t.Fatalf("expected 1 stuck transaction, got %v", len(stuck))
+	}
+	st := stuck[0]
+	weight := cm.TipState().TransactionWeight(txn)
+	switch {
+	case st.ID != txn.ID():
+		t.Fatalf("expected transaction %v, got %v", txn.ID(), st.ID)
+	case st.PendingBlocks != 3:
+		t.Fatalf("expected 3 pending blocks, got %v", st.PendingBlocks)
+	case !st.FeePerByte.Equals(fee.Div64(weight)):
+		t.Fatalf("expected fee rate %v, got %v", fee.Div64(weight), st.FeePerByte)
+	case st.Remediation != wallet.RemediationCPFP:
+		t.Fatalf("expected remediation %q, got %q", wallet.RemediationCPFP, st.Remediation)
+	case st.Cost.Cmp(cm.RecommendedFee().Mul64(weight).Sub(fee)) <= 0:
+		t.Fatalf("expected the CPFP cost %v to cover the parent's weight", st.Cost)
+	}
+
+	// once confirmed, the transaction is no longer reported
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	if stuck, err := w.StuckTransactions(0); err != nil {
+		t.Fatal(err)
+	} else if len(stuck) != 0 {
+		t.Fatalf("expected no stuck transactions, got %v", stuck)
+	}
+}
+
+func TestStuckTransactionsReorg(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// copy all but the last block to a second chain manager to build a fork
+	network2, genesis := testutil.Network()
+	store2, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network2, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm2 := chain.NewManager(store2, genesisState)
+	for height := uint64(1); height < cm.Tip().Height; height++ {
+		index, _ := cm.BestIndex(height)
+		if b, ok := cm.Block(index.ID); !ok {
+			t.Fatalf("missing block %v", index)
+		} else if err := cm2.AddBlocks([]types.Block{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// mineEmpty mines blocks on cm paying addr that do not include the
+	// pool's transactions and returns them
+	mineEmpty := func(cm *chain.Manager, addr types.Address, n int) (blocks []types.Block) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			cs := cm.TipState()
+			b := types.Block{
+				ParentID:     cs.Index.ID,
+				Timestamp:    types.CurrentTimestamp(),
+				MinerPayouts: []types.SiacoinOutput{{Address: addr, Value: cs.BlockReward()}},
+			}
+			if !coreutils.FindBlockNonce(cs, &b, 5*time.Second) {
+				t.Fatal("failed to mine block")
+			} else if err := cm.AddBlocks([]types.Block{b}); err != nil {
+				t.Fatal(err)
+			}
+			blocks = append(blocks, b)
+		}
+		return
+	}
+
+	// the transaction is first seen two blocks after the fork point
+	txn, err := w.BuildTransaction([]types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}}, nil, types.NewCurrency64(1))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineEmpty(cm, types.VoidAddress, 1)
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	} else if stuck, err := w.StuckTransactions(1); err != nil {
+		t.Fatal(err)
+	} else if len(stuck) != 0 {
+		t.Fatalf("expected no stuck transactions, got %v", len(stuck))
+	}
+
+	// reorg to a longer fork, but only revert the wallet's blocks
+	if err := cm.AddBlocks(mineEmpty(cm2, types.AnyoneCanSpend().Address(), 3)); err != nil {
+		t.Fatal(err)
+	}
+	tip, err := ws.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, _, err := cm.UpdatesSince(tip, 10)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(reverted) != 2 {
+		t.Fatalf("expected 2 reverted blocks, got %v", len(reverted))
+	}
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		return w.UpdateChainState(tx, reverted, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the wallet's tip is now below the height the transaction was first
+	// seen at, which must not be reported as a large age
+	if len(cm.PoolTransactions()) != 1 {
+		t.Fatalf("expected the transaction to remain in the pool, got %v", len(cm.PoolTransactions()))
+	} else if stuck, err := w.StuckTransactions(1); err != nil {
+		t.Fatal(err)
+	} else if len(stuck) != 0 {
+		t.Fatalf("expected no stuck transactions, got %v", len(stuck))
+	}
+}
+
+func TestFundTransactionMinValue(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// split the payout into two small outputs, one large output and the
+	// change, which is the largest
+	split := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: w.Address(), Value: types.Siacoins(10)},
+			{Address: w.Address(), Value: types.Siacoins(20)},
+			{Address: w.Address(), Value: types.Siacoins(400)},
+		},
+	}
+	toSign, err := w.FundTransaction(&split, types.Siacoins(430), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&split, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{split}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 4 {
+		t.Fatalf("expected 4 outputs, got %v", len(utxos))
+	}
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	for _, sce := range utxos {
+		values[sce.ID] = sce.SiacoinOutput.Value
+	}
+	total := wallet.SumOutputs(utxos)
+	large := total.Sub(types.Siacoins(30))
+
+	// only the two largest outputs may be used, so funding all of their
+	// value requires both of them
+	minValue := types.Siacoins(100)
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: large}},
+	}
+	if _, err := w.FundTransactionMinValue(&txn, large, false, minValue); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(txn.SiacoinInputs))
+	}
+	for _, sci := range txn.SiacoinInputs {
+		if values[sci.ParentID].Cmp(minValue) < 0 {
+			t.Fatalf("expected inputs worth at least %v, got %v", minValue, values[sci.ParentID])
+		}
+	}
+	w.ReleaseInputs([]types.Transaction{txn}, nil)
+
+	// the small outputs cannot make up a shortfall
+	txn = types.Transaction{}
+	if _, err := w.FundTransactionMinValue(&txn, large.Add(types.Siacoins(1)), false, minValue); !errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected ErrNotEnoughFunds, got %v", err)
+	} else if _, err := w.FundTransaction(&txn, large.Add(types.Siacoins(1)), false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeeShare(t *testing.T) {
+	// create chain store
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+
+	// create a wallet for each party
+	l := zaptest.NewLogger(t)
+	pk1, pk2 := types.GeneratePrivateKey(), types.GeneratePrivateKey()
+	ws1, ws2 := testutil.NewEphemeralWalletStore(), testutil.NewEphemeralWalletStore()
+	w1, err := wallet.NewSingleAddressWallet(pk1, cm, ws1, wallet.WithLogger(l.Named("wallet1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w1.Close()
+	w2, err := wallet.NewSingleAddressWallet(pk2, cm, ws2, wallet.WithLogger(l.Named("wallet2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	mineAndSync(t, cm, ws1, w1, w1.Address(), 1)
+	mineAndSync(t, cm, ws1, w1, w2.Address(), 1)
+	mineAndSync(t, cm, ws1, w1, types.VoidAddress, network.MaturityDelay)
+	if err := syncDB(cm, ws2, w2); err != nil {
+		t.Fatal(err)
+	}
+
+	// each party funds an equal payment and contributes half of the fee
+	fee := types.Siacoins(1)
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(200)}},
+	}
+	toSign1, err := w1.FundTransaction(&txn, types.Siacoins(100).Add(fee.Div64(2)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toSign2, err := w2.FundTransaction(&txn, types.Siacoins(100).Add(fee.Div64(2)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.MinerFees = []types.Currency{fee}
+	cf := types.CoveredFields{WholeTransaction: true}
+	if err := w1.SignTransaction(&txn, toSign1, cf); err != nil {
+		t.Fatal(err)
+	} else if err := w2.SignTransaction(&txn, toSign2, cf); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	share1, err := wallet.FeeShare(txn, toSign1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share2, err := wallet.FeeShare(txn, toSign2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both parties contributed one input and one signature of equal weight
+	if !share1.Equals(share2) {
+		t.Fatalf("expected equal shares, got %v and %v", share1, share2)
+	}
+	// the inputs and signatures make up most, but not all, of the weight
+	all, err := wallet.FeeShare(txn, append(toSign1, toSign2...))
+	if err != nil {
+		t.Fatal(err)
+	} else if !all.Equals(share1.Add(share2)) && !all.Equals(share1.Add(share2).Add(types.NewCurrency64(1))) {
+		t.Fatalf("expected the combined share %v to equal the sum of the shares %v", all, share1.Add(share2))
+	} else if all.Cmp(fee.Div64(2)) <= 0 || all.Cmp(fee) >= 0 {
+		t.Fatalf("expected the combined share %v to be between half and all of the fee", all)
+	}
+
+	if _, err := wallet.FeeShare(txn, []types.Hash256{{1}}); !errors.Is(err, wallet.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestUnconfirmedTotals(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+	var err error
+
+	// create a second wallet to send from
+	ws2 := testutil.NewEphemeralWalletStore()
+	w2, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws2, wallet.WithLogger(l.Named("sender")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws2, w2, w2.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	syncDB(cm, ws2, w2)
+
+	assertTotals := func(inflow, outflow types.Currency) {
+		t.Helper()
+		if in, out, err := w.UnconfirmedTotals(); err != nil {
+			t.Fatal(err)
+		} else if !in.Equals(inflow) {
+			t.Fatalf("expected inflow %v, got %v", inflow, in)
+		} else if !out.Equals(outflow) {
+			t.Fatalf("expected outflow %v, got %v", outflow, out)
+		}
+	}
+	assertTotals(types.ZeroCurrency, types.ZeroCurrency)
+
+	// receive a payment from the second wallet
+	receive := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: types.Siacoins(100)}},
+	}
+	toSign, err := w2.FundTransaction(&receive, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w2.SignTransaction(&receive, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{receive}); err != nil {
+		t.Fatal(err)
+	}
+	assertTotals(types.Siacoins(100), types.ZeroCurrency)
+
+	// send to self, paying a fee
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(utxos) != 1 {
+		t.Fatalf("expected 1 output, got %v", len(utxos))
+	}
+	input := utxos[0].SiacoinOutput.Value
+	fee := types.Siacoins(1)
+	self := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: types.Siacoins(100)}},
+		MinerFees:      []types.Currency{fee},
+	}
+	toSign, err = w.FundTransaction(&self, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&self, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{self}); err != nil {
+		t.Fatal(err)
+	}
+	assertTotals(types.Siacoins(100).Add(input).Sub(fee), input)
+
+	// spending unconfirmed outputs in a child transaction doesn't count the
+	// intermediate outputs
+	child := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: w.Address(), Value: types.Siacoins(50)}},
+		MinerFees:      []types.Currency{fee},
+	}
+	toSign, err = w.FundTransaction(&child, types.Siacoins(50), true)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&child, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{receive, self, child}); err != nil {
+		t.Fatal(err)
+	}
+	assertTotals(types.Siacoins(100).Add(input).Sub(fee.Mul64(2)), input)
+
+	// once confirmed, there is nothing pending
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	assertTotals(types.ZeroCurrency, types.ZeroCurrency)
+
+	// payments to watch-only addresses are not counted, matching Balance
+	if err := syncDB(cm, ws2, w2); err != nil {
+		t.Fatal(err)
+	}
+	watched := types.StandardUnlockConditions(types.GeneratePrivateKey().PublicKey()).UnlockHash()
+	w.AddWatchAddress(watched)
+	watch := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: watched, Value: types.Siacoins(10)}},
+	}
+	toSign, err = w2.FundTransaction(&watch, types.Siacoins(10), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w2.SignTransaction(&watch, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{watch}); err != nil {
+		t.Fatal(err)
+	}
+	assertTotals(types.ZeroCurrency, types.ZeroCurrency)
+}
+
+func TestUnconfirmedTotalsPoolOrder(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+	pool := &controlledPoolChainManager{Manager: cm}
+	ws := testutil.NewEphemeralWalletStore()
+	w, err := wallet.NewSingleAddressWallet(pk, pool, ws, wallet.WithLogger(zaptest.NewLogger(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	fund := func(useUnconfirmed bool) types.Transaction {
+		t.Helper()
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+		}
+		toSign, err := w.FundTransaction(&txn, types.Siacoins(100), useUnconfirmed)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
+		return txn
+	}
+	parent := fund(false)
+	pool.setPool(parent)
+	child := fund(true)
+	if child.SiacoinInputs[0].ParentID != parent.SiacoinOutputID(1) {
+		t.Fatal("expected the child to spend the parent's change")
+	}
+
+	// the totals do not depend on the order of the pool
+	pool.setPool(parent, child)
+	inflow, outflow, err := w.UnconfirmedTotals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.setPool(child, parent)
+	if in, out, err := w.UnconfirmedTotals(); err != nil {
+		t.Fatal(err)
+	} else if !in.Equals(inflow) || !out.Equals(outflow) {
+		t.Fatalf("expected totals %v and %v, got %v and %v", inflow, outflow, in, out)
+	} else if !in.Equals(child.SiacoinOutputs[1].Value) {
+		t.Fatalf("expected inflow %v, got %v", child.SiacoinOutputs[1].Value, in)
+	}
+}
+
+func TestStateID(t *testing.T) {
+	// create chain store
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+
+	// the id is stable within a tip
+	id := wallet.StateID(cm.TipState())
+	if id2 := wallet.StateID(cm.TipState()); id != id2 {
+		t.Fatalf("expected stable id %v, got %v", id, id2)
+	}
+
+	// the id changes when the tip advances
+	testutil.MineBlocks(t, cm, types.VoidAddress, 1)
+	advanced := wallet.StateID(cm.TipState())
+	if advanced == id {
+		t.Fatal("expected id to change when the tip advances")
+	} else if advanced != wallet.StateID(cm.TipState()) {
+		t.Fatal("expected stable id after advancing")
+	}
+
+	// a state on a different network has a different id
+	other := cm.TipState()
+	n := *other.Network
+	n.Name = "other"
+	other.Network = &n
+	if wallet.StateID(other) == advanced {
+		t.Fatal("expected different id for a different network")
+	}
+}
+
+func TestSendFeeFromRecipient(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	for _, sce := range utxos {
+		values[sce.ID] = sce.SiacoinOutput.Value
+	}
+
+	// a fee larger than the amount is rejected and the inputs are released
+	feePerByte := types.Siacoins(1).Div64(1000)
+	if _, err := w.SendFeeFromRecipient(types.VoidAddress, types.NewCurrency64(1), feePerByte); err == nil {
+		t.Fatal("expected error when the fee exceeds the amount")
+	} else if _, err := w.SendFeeFromRecipient(types.VoidAddress, types.NewCurrency64(1), feePerByte); err == nil || errors.Is(err, wallet.ErrNotEnoughFunds) {
+		t.Fatalf("expected inputs to be released, got %v", err)
+	}
+
+	dest := types.StandardUnlockHash(types.GeneratePrivateKey().PublicKey())
+	amount := types.Siacoins(100)
+	txn, err := w.SendFeeFromRecipient(dest, amount, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.MinerFees) != 1 {
+		t.Fatalf("expected 1 miner fee, got %v", len(txn.MinerFees))
+	}
+	fee := txn.MinerFees[0]
+	if minFee := feePerByte.Mul64(uint64(wallet.EncodedSize(txn))); fee.Cmp(minFee) < 0 {
+		t.Fatalf("expected fee of at least %v, got %v", minFee, fee)
+	}
+
+	// the recipient pays the fee
+	var received, change types.Currency
+	for _, sco := range txn.SiacoinOutputs {
+		switch sco.Address {
+		case dest:
+			received = received.Add(sco.Value)
+		case w.Address():
+			change = change.Add(sco.Value)
+		}
+	}
+	if !received.Equals(amount.Sub(fee)) {
+		t.Fatalf("expected recipient to receive %v, got %v", amount.Sub(fee), received)
+	}
+
+	// the wallet's total outflow is exactly amount
+	var spent types.Currency
+	for _, sci := range txn.SiacoinInputs {
+		spent = spent.Add(values[sci.ParentID])
+	}
+	if outflow := spent.Sub(change); !outflow.Equals(amount) {
+		t.Fatalf("expected outflow %v, got %v", amount, outflow)
+	}
+
+	if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	// the fee is deducted from the recipient even if the change output is
+	// placed first
+	cm, ws, w = newTestWallet(t, wallet.WithChangePosition(wallet.ChangePositionFirst))
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	txn, err = w.SendFeeFromRecipient(dest, amount, feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinOutputs) != 2 || txn.SiacoinOutputs[0].Address != w.Address() {
+		t.Fatalf("expected the change output first, got %v", txn.SiacoinOutputs)
+	} else if sco := txn.SiacoinOutputs[1]; sco.Address != dest || !sco.Value.Equals(amount.Sub(txn.MinerFees[0])) {
+		t.Fatalf("expected recipient to receive %v, got %v", amount.Sub(txn.MinerFees[0]), sco.Value)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReorgStats(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cm, ws, w := newTestWallet(t, wallet.WithClock(func() time.Time { return now }))
+
+	mineAndSync(t, cm, ws, w, w.Address(), 10)
+	if stats := w.ReorgStats(); stats != (wallet.ReorgStatistics{}) {
+		t.Fatalf("expected no reorgs, got %+v", stats)
+	}
+
+	// reorg replaces the last depth blocks with depth+1 new blocks. The
+	// payout address distinguishes the new blocks from any earlier fork.
+	reorg := func(depth uint64, split bool) {
+		t.Helper()
+		index, ok := cm.BestIndex(cm.Tip().Height - depth)
+		if !ok {
+			t.Fatal("missing fork index")
+		}
+		state, ok := cm.State(index.ID)
+		if !ok {
+			t.Fatal("missing fork state")
+		}
+		var blocks []types.Block
+		for i := uint64(0); i <= depth; i++ {
+			b := types.Block{
+				ParentID:     state.Index.ID,
+				Timestamp:    types.CurrentTimestamp(),
+				MinerPayouts: []types.SiacoinOutput{{Address: types.Address{byte(depth)}, Value: state.BlockReward()}},
+			}
+			if !coreutils.FindBlockNonce(state, &b, time.Second) {
+				t.Fatal("failed to find nonce")
+			}
+			blocks = append(blocks, b)
+			state.Index.Height++
+			state.Index.ID = b.ID()
+		}
+		if err := cm.AddBlocks(blocks); err != nil {
+			t.Fatal(err)
+		}
+		if !split {
+			if err := syncDB(cm, ws, w); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+
+		// revert one block per update before applying the new blocks
+		tip, err := ws.Tip()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reverted, applied, err := cm.UpdatesSince(tip, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		update := func(reverted []chain.RevertUpdate, applied []chain.ApplyUpdate) {
+			t.Helper()
+			err := ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+				return w.UpdateChainState(tx, reverted, applied)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, cru := range reverted {
+			update([]chain.RevertUpdate{cru}, nil)
+		}
+		update(nil, applied)
+	}
+
+	reorg(1, false)
+	deepest := now.Add(time.Hour)
+	now = deepest
+	reorg(3, true)
+	now = now.Add(time.Hour)
+	reorg(2, false)
+
+	expected := wallet.ReorgStatistics{
+		Count:     3,
+		MaxDepth:  3,
+		MeanDepth: 2,
+		DeepestAt: deepest,
+	}
+	if stats := w.ReorgStats(); stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+
+	// applying blocks without reverting any is not a reorg
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 5)
+	if stats := w.ReorgStats(); stats != expected {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestConfirmationIndex(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(100)}},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(100), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unconfirmed transaction has no index
+	if _, ok, err := w.ConfirmationIndex(txn.ID()); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected unconfirmed transaction to have no index")
+	}
+
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+	confirmedAt := cm.Tip()
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 3)
+
+	if index, ok, err := w.ConfirmationIndex(txn.ID()); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected confirmed transaction to have an index")
+	} else if index != confirmedAt {
+		t.Fatalf("expected index %v, got %v", confirmedAt, index)
+	}
+
+	// unknown transactions have no index
+	if _, ok, err := w.ConfirmationIndex(types.TransactionID{1}); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("expected unknown transaction to have no index")
+	}
+}
+
+func TestCheckSignatures(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 2)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// fund a transaction with both of the wallet's outputs
+	amount := cm.TipState().BlockReward().Add(types.Siacoins(1))
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+	}
+	toSign, err := w.FundTransaction(&txn, amount, false)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(toSign) != 2 {
+		t.Fatalf("expected 2 inputs, got %v", len(toSign))
+	}
+
+	// an unsigned transaction is rejected
+	if err := w.CheckSignatures(txn); err == nil {
+		t.Fatal("expected error for unsigned inputs")
+	}
+
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if err := w.CheckSignatures(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(txn *types.Transaction)
+	}{
+		{"truncated", func(txn *types.Transaction) {
+			txn.Signatures[0].Signature = txn.Signatures[0].Signature[:63]
+		}},
+		{"zero nonce", func(txn *types.Transaction) {
+			copy(txn.Signatures[0].Signature[:32], make([]byte, 32))
+		}},
+		{"reused nonce", func(txn *types.Transaction) {
+			copy(txn.Signatures[1].Signature[:32], txn.Signatures[0].Signature[:32])
+		}},
+		{"invalid", func(txn *types.Transaction) {
+			txn.Signatures[0].Signature[63] ^= 1
+		}},
+		{"key index", func(txn *types.Transaction) {
+			txn.Signatures[0].PublicKeyIndex = 1
+		}},
+		{"unknown parent", func(txn *types.Transaction) {
+			txn.Signatures[0].ParentID = types.Hash256{1}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			malformed := txn
+			malformed.Signatures = make([]types.TransactionSignature, len(txn.Signatures))
+			for i, sig := range txn.Signatures {
+				malformed.Signatures[i] = sig
+				malformed.Signatures[i].Signature = append([]byte(nil), sig.Signature...)
+			}
+			test.modify(&malformed)
+			if err := w.CheckSignatures(malformed); err == nil {
+				t.Fatal("expected malformed signature to be rejected")
+			}
+		})
+	}
+}
+
+func TestFundTransactionPreferSource(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	// create two customers that pay the wallet
+	ws1 := testutil.NewEphemeralWalletStore()
+	c1, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws1, wallet.WithLogger(l.Named("customer1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	ws2 := testutil.NewEphemeralWalletStore()
+	c2, err := wallet.NewSingleAddressWallet(types.GeneratePrivateKey(), cm, ws2, wallet.WithLogger(l.Named("customer2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws1, c1, c1.Address(), 1)
+	mineAndSync(t, cm, ws2, c2, c2.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	syncDB(cm, ws1, c1)
+	syncDB(cm, ws2, c2)
+
+	// each customer pays the wallet with two outputs
+	pay := func(c *wallet.SingleAddressWallet, values ...types.Currency) {
+		t.Helper()
+		var txn types.Transaction
+		var amount types.Currency
+		for _, v := range values {
+			txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{Address: w.Address(), Value: v})
+			amount = amount.Add(v)
+		}
+		toSign, err := c.FundTransaction(&txn, amount, false)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := c.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pay(c1, types.Siacoins(100), types.Siacoins(200))
+	pay(c2, types.Siacoins(300), types.Siacoins(400))
+	mineAndSync(t, cm, ws, w, types.VoidAddress, 1)
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[types.SiacoinOutputID]types.Currency)
+	for _, sce := range utxos {
+		values[sce.ID] = sce.SiacoinOutput.Value
+	}
+
+	fund := func(amount types.Currency, preferFrom types.Address) []types.Currency {
+		t.Helper()
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+		}
+		if _, err := w.FundTransactionPreferSource(&txn, amount, preferFrom, false); err != nil {
+			t.Fatal(err)
+		}
+		defer w.ReleaseInputs([]types.Transaction{txn}, nil)
+		var spent []types.Currency
+		for _, sci := range txn.SiacoinInputs {
+			spent = append(spent, values[sci.ParentID])
+		}
+		return spent
+	}
+
+	// the customer's outputs are preferred, largest first, even though the
+	// wallet's miner payout is larger
+	if spent := fund(types.Siacoins(150), c1.Address()); len(spent) != 1 || !spent[0].Equals(types.Siacoins(200)) {
+		t.Fatalf("expected the customer's 200 SC output, got %v", spent)
+	} else if spent := fund(types.Siacoins(250), c1.Address()); len(spent) != 2 || !spent[0].Equals(types.Siacoins(200)) || !spent[1].Equals(types.Siacoins(100)) {
+		t.Fatalf("expected both of the customer's outputs, got %v", spent)
+	} else if spent := fund(types.Siacoins(50), c2.Address()); len(spent) != 1 || !spent[0].Equals(types.Siacoins(400)) {
+		t.Fatalf("expected the other customer's 400 SC output, got %v", spent)
+	}
+
+	// the remainder is funded from the other outputs
+	if spent := fund(types.Siacoins(500), c1.Address()); len(spent) != 3 || !spent[0].Equals(types.Siacoins(200)) || !spent[1].Equals(types.Siacoins(100)) {
+		t.Fatalf("expected the customer's outputs to be spent first, got %v", spent)
+	}
+}
+
+func TestConsolidationAdvice(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithConsolidationAdvice(100))
+	network := cm.TipState().Network
+
+	// fund the wallet with a single large output
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	fund := func(amount types.Currency) wallet.FundResult {
+		t.Helper()
+		txn := types.Transaction{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: amount}},
+		}
+		res, err := w.FundTransactionDetailed(&txn, amount, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.ReleaseInputs([]types.Transaction{txn}, nil)
+		return res
+	}
+
+	// a small payment from the huge output suggests consolidation
+	if res := fund(types.Siacoins(10)); !slices.Contains(res.Warnings, wallet.FundWarningSuggestConsolidation) {
+		t.Fatalf("expected consolidation suggestion, got %v", res.Warnings)
+	}
+
+	// a payment within the ratio does not
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := fund(balance.Spendable.Div64(50)); slices.Contains(res.Warnings, wallet.FundWarningSuggestConsolidation) {
+		t.Fatalf("expected no consolidation suggestion, got %v", res.Warnings)
+	}
+}
+
+// fragmentWallet spends the wallet's single spendable output into outputs of
+// the given values, burning the remainder, and confirms the transaction.
+func fragmentWallet(tb testing.TB, cm *chain.Manager, ws *testutil.EphemeralWalletStore, w *wallet.SingleAddressWallet, values []types.Currency) {
+	tb.Helper()
+
+	utxos, err := w.SpendableOutputs()
+	if err != nil {
+		tb.Fatal(err)
+	} else if len(utxos) != 1 {
+		tb.Fatalf("expected 1 output, got %v", len(utxos))
+	}
+
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: utxos[0].ID, UnlockConditions: w.UnlockConditions()}},
+	}
+	var sum types.Currency
+	for _, v := range values {
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{Address: w.Address(), Value: v})
+		sum = sum.Add(v)
+	}
+	txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{Address: types.VoidAddress, Value: utxos[0].SiacoinOutput.Value.Sub(sum)})
+	if err := w.SignTransaction(&txn, []types.Hash256{types.Hash256(utxos[0].ID)}, types.CoveredFields{WholeTransaction: true}); err != nil {
+		tb.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		tb.Fatal(err)
+	}
+	testutil.MineBlocks(tb, cm, types.VoidAddress, 1)
+	if err := syncDB(cm, ws, w); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestRedistributeBestFit(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	// fragment the wallet
+	values := []types.Currency{types.Siacoins(90), types.Siacoins(80), types.Siacoins(20), types.Siacoins(15), types.Siacoins(15)}
+	for i := 0; i < 12; i++ {
+		values = append(values, types.Siacoins(5))
+	}
+	fragmentWallet(t, cm, ws, w, values)
+
+	// redistribute into two transactions of ten 10 SC outputs. Selecting
+	// the largest outputs first would spend 90 and 80 SC on the first
+	// transaction, leaving 14 small outputs for the second, for a total of
+	// 16 inputs. Best-fit selection spends 90 and 15 SC on the first and 80,
+	// 20 and 5 SC on the second.
+	txns, toSign, err := w.Redistribute(20, types.Siacoins(10), types.ZeroCurrency)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 2 {
+		t.Fatalf("expected 2 transactions, got %v", len(txns))
+	}
+	var inputs int
+	for i := range txns {
+		inputs += len(txns[i].SiacoinInputs)
+		if err := w.SignTransaction(&txns[i], toSign[i], types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inputs != 5 {
+		t.Fatalf("expected 5 inputs, got %v", inputs)
+	} else if _, err := cm.AddPoolTransactions(txns); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkRedistribute(b *testing.B) {
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+	network, genesis := testutil.Network()
+	cs, genesisState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cm := chain.NewManager(cs, genesisState)
+
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	testutil.MineBlocks(b, cm, w.Address(), 1)
+	testutil.MineBlocks(b, cm, types.VoidAddress, int(network.MaturityDelay))
+	if err := syncDB(cm, ws, w); err != nil {
+		b.Fatal(err)
+	}
+
+	// fragment the wallet into outputs of varying value
+	values := make([]types.Currency, 500)
+	for i := range values {
+		values[i] = types.Siacoins(uint32(1 + i%50))
+	}
+	fragmentWallet(b, cm, ws, w, values)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	var inputs int
+	for i := 0; i < b.N; i++ {
+		txns, _, err := w.Redistribute(100, types.Siacoins(50), types.NewCurrency64(1))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, txn := range txns {
+			inputs += len(txn.SiacoinInputs)
+		}
+		w.ReleaseInputs(txns, nil)
+	}
+	b.ReportMetric(float64(inputs)/float64(b.N), "inputs/op")
+}
+
+func TestConsistencyCheck(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithRequireSynced(5))
+	network := cm.TipState().Network
+
+	if err := w.ConsistencyCheck(); err != nil {
+		t.Fatal(err)
+	}
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	if err := w.ConsistencyCheck(); err != nil {
+		t.Fatal(err)
+	}
+
+	// replace the tip with a longer fork without syncing the store. The
+	// store's tip has the same height as a block on the best chain, but a
+	// different ID.
+	storeTip := cm.Tip()
+	parent, ok := cm.BestIndex(storeTip.Height - 1)
+	if !ok {
+		t.Fatal("missing parent index")
+	}
+	state, ok := cm.State(parent.ID)
+	if !ok {
+		t.Fatal("missing parent state")
+	}
+	var fork []types.Block
+	for i := 0; i < 2; i++ {
+		b := types.Block{
+			ParentID:     state.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			MinerPayouts: []types.SiacoinOutput{{Address: types.Address{1}, Value: state.BlockReward()}},
+		}
+		if !coreutils.FindBlockNonce(state, &b, time.Second) {
+			t.Fatal("failed to find nonce")
+		}
+		fork = append(fork, b)
+		state.Index.Height++
+		state.Index.ID = b.ID()
+	}
+	if err := cm.AddBlocks(fork); err != nil {
+		t.Fatal(err)
+	} else if index, ok := cm.BestIndex(storeTip.Height); !ok || index.ID == storeTip.ID {
+		t.Fatal("expected the best chain to replace the store's tip")
+	}
+
+	// a height-only comparison would consider the store synced
+	if err := w.ConsistencyCheck(); !errors.Is(err, wallet.ErrTipMismatch) {
+		t.Fatalf("expected ErrTipMismatch, got %v", err)
+	}
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1)}},
+	}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(1), false); !errors.Is(err, wallet.ErrNotSynced) || !errors.Is(err, wallet.ErrTipMismatch) {
+		t.Fatalf("expected ErrNotSynced, got %v", err)
+	}
+
+	// processing the reorg restores consistency
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	} else if err := w.ConsistencyCheck(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulateRedistribute(t *testing.T) {
+	cm, ws, w := newTestWallet(t, wallet.WithReservationLogSize(100))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+
+	values := make([]types.Currency, 40)
+	for i := range values {
+		values[i] = types.Siacoins(uint32(10 + i))
+	}
+	fragmentWallet(t, cm, ws, w, values)
+
+	before, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feePerByte := types.NewCurrency64(1000)
+	plan, err := w.SimulateRedistribute(25, types.Siacoins(20), feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(plan.Transactions) != 3 {
+		t.Fatalf("expected 3 transactions, got %v", len(plan.Transactions))
+	}
+
+	// simulating does not reserve any outputs
+	if after, err := w.Balance(); err != nil {
+		t.Fatal(err)
+	} else if !after.Spendable.Equals(before.Spendable) {
+		t.Fatalf("expected spendable balance %v, got %v", before.Spendable, after.Spendable)
+	} else if log := w.ReservationLog(); len(log) != 0 {
+		t.Fatalf("expected no reservations, got %v", log)
+	}
+
+	// the plan matches the real redistribution
+	txns, toSign, err := w.Redistribute(25, types.Siacoins(20), feePerByte)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != len(plan.Transactions) {
+		t.Fatalf("expected %v transactions, got %v", len(plan.Transactions), len(txns))
+	}
+	var inputs int
+	var fee types.Currency
+	for i := range txns {
+		if txns[i].ID() != plan.Transactions[i].ID() {
+			t.Fatalf("transaction %d does not match the plan", i)
+		}
+		inputs += len(txns[i].SiacoinInputs)
+		fee = fee.Add(txns[i].MinerFees[0])
+		if err := w.SignTransaction(&txns[i], toSign[i], types.CoveredFields{WholeTransaction: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if inputs != plan.Inputs {
+		t.Fatalf("expected %v inputs, got %v", plan.Inputs, inputs)
+	} else if !fee.Equals(plan.TotalFee) {
+		t.Fatalf("expected total fee %v, got %v", plan.TotalFee, fee)
+	} else if _, err := cm.AddPoolTransactions(txns); err != nil {
+		t.Fatal(err)
+	}
+
+	// once the outputs are reserved, the same plan is no longer possible
+	if plan, err := w.SimulateRedistribute(25, types.Siacoins(20), feePerByte); err == nil && len(plan.Transactions) == 3 && plan.Transactions[0].ID() == txns[0].ID() {
+		t.Fatal("expected the reserved outputs to be excluded from the plan")
+	}
+}
+
+// failingUpdateTx simulates a store that crashes after applying some of the
+// indices in an update.
+type failingUpdateTx struct {
+	wallet.UpdateTx
+	applied, failAfter int
+}
+
+func (tx *failingUpdateTx) WalletApplyIndex(index types.ChainIndex, created, spent []types.SiacoinElement, events []wallet.Event, timestamp time.Time) error {
+	if tx.applied == tx.failAfter {
+		return errors.New("crash")
+	}
+	tx.applied++
+	return tx.UpdateTx.WalletApplyIndex(index, created, spent, events, timestamp)
+}
+
+func TestUpdateChainStateAtomic(t *testing.T) {
+	cm, ws, w := newTestWallet(t)
+	network := cm.TipState().Network
+	var err error
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	balance, err := w.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mine blocks paying the wallet without syncing
+	testutil.MineBlocks(t, cm, w.Address(), 2)
+	tip, err := ws.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := w.Events(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reverted, applied, err := cm.UpdatesSince(tip, 1000)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(applied) != 2 {
+		t.Fatalf("expected 2 applied updates, got %d", len(applied))
+	}
+
+	assertUnchanged := func(t *testing.T) {
+		t.Helper()
+		if storeTip, err := ws.Tip(); err != nil {
+			t.Fatal(err)
+		} else if storeTip != tip {
+			t.Fatalf("expected store tip %v, got %v", tip, storeTip)
+		} else if w.Tip() != tip {
+			t.Fatalf("expected wallet tip %v, got %v", tip, w.Tip())
+		}
+		assertBalance(t, w, balance.Spendable, balance.Confirmed, types.ZeroCurrency, types.ZeroCurrency)
+		if n, err := w.EventCount(); err != nil {
+			t.Fatal(err)
+		} else if n != uint64(len(events)) {
+			t.Fatalf("expected %d events, got %d", len(events), n)
+		}
+	}
+
+	// crash after the first block's elements and events are written
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		return w.UpdateChainState(&failingUpdateTx{UpdateTx: tx, failAfter: 1}, reverted, applied)
+	})
+	if err == nil {
+		t.Fatal("expected update to fail")
+	}
+	assertUnchanged(t)
+
+	// crash after the wallet has processed the whole update, but before the
+	// store commits it
+	err = ws.UpdateChainState(func(tx wallet.UpdateTx) error {
+		if err := w.UpdateChainState(tx, reverted, applied); err != nil {
+			return err
+		}
+		return errors.New("crash")
+	})
+	if err == nil {
+		t.Fatal("expected update to fail")
+	}
+	assertUnchanged(t)
+
+	// recovery reprocesses the same blocks exactly once
+	if err := syncDB(cm, ws, w); err != nil {
+		t.Fatal(err)
+	} else if w.Tip() != cm.Tip() {
+		t.Fatalf("expected wallet tip %v, got %v", cm.Tip(), w.Tip())
+	}
+	if n, err := w.EventCount(); err != nil {
+		t.Fatal(err)
+	} else if n != uint64(len(events))+2 {
+		t.Fatalf("expected %d events, got %d", len(events)+2, n)
+	}
+	var immature types.Currency
+	for _, cau := range applied {
+		immature = immature.Add(cau.Block.MinerPayouts[0].Value)
+	}
+	assertBalance(t, w, balance.Spendable, balance.Confirmed, immature, types.ZeroCurrency)
+}
+
+func TestSpendableOutputCount(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWalletWithKey(t, pk)
+	network := cm.TipState().Network
+
+	// the fallback wallet counts the outputs by loading them
+	w2, err := wallet.NewSingleAddressWallet(pk, cm, pagedStore{ws}, wallet.WithLogger(l.Named("wallet2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	assertCount := func(t *testing.T, w *wallet.SingleAddressWallet, expected int) {
+		t.Helper()
+		utxos, err := w.SpendableOutputs()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(utxos) != expected {
+			t.Fatalf("expected %d spendable outputs, got %d", expected, len(utxos))
+		}
+		n, err := w.SpendableOutputCount()
+		if err != nil {
+			t.Fatal(err)
+		} else if n != len(utxos) {
+			t.Fatalf("expected count %d, got %d", len(utxos), n)
+		}
+	}
+
+	assertCount(t, w, 0)
+	assertCount(t, w2, 0)
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	assertCount(t, w, 1)
+	assertCount(t, w2, 1)
+
+	values := make([]types.Currency, 10)
+	for i := range values {
+		values[i] = types.Siacoins(10)
+	}
+	fragmentWallet(t, cm, ws, w, values)
+	// immature payouts are not spendable
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	assertCount(t, w, 10)
+	assertCount(t, w2, 10)
+
+	// reserved outputs are only excluded by the wallet that reserved them
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(15)}},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(15), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCount(t, w, 8)
+	assertCount(t, w2, 10)
+
+	// outputs spent in the pool are excluded by both
+	if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	assertCount(t, w, 8)
+	assertCount(t, w2, 8)
+}
+
+func TestMaintain(t *testing.T) {
+	pk := types.GeneratePrivateKey()
+	var mu sync.Mutex
+	now := time.Now()
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	l := zaptest.NewLogger(t)
+	cm, ws, w := newTestWalletWithKey(t, pk, wallet.WithClock(clock), wallet.WithReservationDuration(time.Hour))
+	network := cm.TipState().Network
+
+	mineAndSync(t, cm, ws, w, w.Address(), 1)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, network.MaturityDelay)
+	values := make([]types.Currency, 5)
+	for i := range values {
+		values[i] = types.Siacoins(10)
+	}
+	fragmentWallet(t, cm, ws, w, values)
+
+	if report, err := w.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if report != (wallet.MaintenanceReport{}) {
+		t.Fatalf("expected empty report, got %+v", report)
+	}
+
+	// reserve two outputs and let the reservations expire
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(15)}},
+	}
+	if _, err := w.FundTransaction(&txn, types.Siacoins(15), false); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %d", len(txn.SiacoinInputs))
+	}
+	mu.Lock()
+	now = now.Add(2 * time.Hour)
+	mu.Unlock()
+
+	// corrupt the proofs of two outputs
+	utxos, err := ws.UnspentSiacoinElements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := utxos[:2]
+	for i := range stale {
+		stale[i].StateElement.MerkleProof[0] = types.Hash256{}
+	}
+	if err := ws.SetWalletSiacoinElementProofs(cm.Tip(), stale); err != nil {
+		t.Fatal(err)
+	} else if orphaned, err := w.Verify(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if len(orphaned) != 0 {
+		t.Fatalf("expected stale outputs not to be orphaned, got %d", len(orphaned))
+	}
+
+	report, err := w.Maintain(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if report.ReservationsCleared != 2 {
+		t.Fatalf("expected 2 reservations cleared, got %d", report.ReservationsCleared)
+	} else if report.ProofsRefreshed != 2 {
+		t.Fatalf("expected 2 proofs refreshed, got %d", report.ProofsRefreshed)
+	} else if report.Inconsistency != nil {
+		t.Fatalf("expected no inconsistency, got %v", report.Inconsistency)
+	}
+	if orphaned, err := w.Verify(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if len(orphaned) != 0 {
+		t.Fatalf("expected no unrecognized outputs, got %d", len(orphaned))
+	}
+
+	// the refreshed outputs can be spent
+	txn = types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(50)}},
+	}
+	toSign, err := w.FundTransaction(&txn, types.Siacoins(50), false)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.SignTransaction(&txn, toSign, types.CoveredFields{WholeTransaction: true}); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddPoolTransactions([]types.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	if report, err := w.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if report != (wallet.MaintenanceReport{}) {
+		t.Fatalf("expected empty report, got %+v", report)
+	}
+
+	// proofs are not refreshed by a store that does not support it
+	w2, err := wallet.NewSingleAddressWallet(pk, cm, pagedStore{ws}, wallet.WithLogger(l.Named("wallet2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+	if _, err := w2.RefreshProofs(context.Background()); !errors.Is(err, wallet.ErrStoreUnsupported) {
+		t.Fatalf("expected ErrStoreUnsupported, got %v", err)
+	} else if _, err := w2.Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedistributeReleaseOnError(t *testing.T) {
+	// create wallet store
+	pk := types.GeneratePrivateKey()
+	ws := testutil.NewEphemeralWalletStore()
+
+	// create chain store
+	network, genesis := testutil.Network()
+	network.HardforkV2.AllowHeight = 1 // allow V2 transactions from the start
+	cs, tipState, err := chain.NewDBStore(chain.NewMemDB(), network, genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create chain manager and subscribe the wallet
+	cm := chain.NewManager(cs, tipState)
+	// create wallet. Each redistribution transaction below spends one
+	// output, so the second transaction exceeds the reservation limit.
+	l := zaptest.NewLogger(t)
+	w, err := wallet.NewSingleAddressWallet(pk, cm, ws, wallet.WithLogger(l.Named("wallet")), wallet.WithMaxReservations(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// fund the wallet
+	mineAndSync(t, cm, ws, w, w.Address(), 3)
+	mineAndSync(t, cm, ws, w, types.VoidAddress, cm.TipState().MaturityHeight()-1)
+
+	assertUnreserved := func(t *testing.T) {
+		t.Helper()
+		if utxos, err := w.SpendableOutputs(); err != nil {
+			t.Fatal(err)
+		} else if len(utxos) != 3 {
+			t.Fatalf("expected 3 unreserved outputs, got %v", len(utxos))
+		}
+	}
+
+	amount := cm.TipState().BlockReward().Div64(20)
+	feePerByte := types.NewCurrency64(1)
+	if _, _, err := w.Redistribute(20, amount, feePerByte); !errors.Is(err, wallet.ErrTooManyReservations) {
+		t.Fatalf("expected ErrTooManyReservations, got %v", err)
+	}
+	assertUnreserved(t)
+
+	if _, _, err := w.RedistributeV2(20, amount, feePerByte); !errors.Is(err, wallet.ErrTooManyReservations) {
+		t.Fatalf("expected ErrTooManyReservations, got %v", err)
+	}
+	assertUnreserved(t)
+}